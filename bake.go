@@ -0,0 +1,165 @@
+// Copyright 2014 The Azul3D Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tmx
+
+import (
+	"fmt"
+	"image"
+	"image/draw"
+	"path/filepath"
+	"sort"
+
+	"azul3d.org/gfx.v2-unstable"
+)
+
+// The default maximum size of baked textures, see Config.BakeMaxSize.
+const defaultBakeMaxSize = 4096
+
+// BakeLayer composites all of the tiles of the given tile layer into a single
+// image, just as Load would render them (honoring the layer's offset, the
+// render size and fill mode of tilesets, and the flips of tiles). The bounds
+// of the image are in pixels relative to the top-left corner of the map, and
+// cover the layer's bounds (see Layer.Bounds) as well as any tiles which extend
+// past them.
+//
+// The tsImages map is that given to Load, tiles whose tileset image is not in
+// it are omitted. Animated tiles are baked unanimated. The opacity of the layer
+// is not applied, as Load applies it to the baked objects instead (see
+// Config.BakeLayers).
+func BakeLayer(m *Map, l *Layer, tsImages map[string]*image.RGBA) *image.RGBA {
+	type card struct {
+		gid  uint32
+		ts   *Tileset
+		rgba *image.RGBA
+		dst  image.Rectangle
+	}
+	var cards []card
+	b := l.Bounds(m)
+	bounds := image.Rect(b.Min.X*m.TileWidth, b.Min.Y*m.TileHeight, b.Max.X*m.TileWidth, b.Max.Y*m.TileHeight)
+	ox, oy := l.EffectiveOffset()
+	l.EachTile(func(c Coord, gid uint32) {
+		ts, _, _ := m.DecomposeGID(gid)
+		if ts == nil || ts.Image == nil {
+			return
+		}
+		rgba, ok := tsImages[filepath.Base(ts.Image.Source)]
+		if !ok {
+			return
+		}
+
+		// Cards are centered in the area of their cell, see tileCenter.
+		cellWidth, cellHeight := float64(ts.Width), float64(ts.Height)
+		if ts.RenderSize == TileRenderSizeGrid {
+			cellWidth, cellHeight = float64(m.TileWidth), float64(m.TileHeight)
+		}
+		w, h := fitTile(ts, cellWidth, cellHeight)
+		x := float64(c.X*m.TileWidth) + ox + (cellWidth-w)/2
+		y := float64(c.Y*m.TileHeight) + oy + (cellHeight-h)/2
+		dst := image.Rect(int(x), int(y), int(x+w), int(y+h))
+		cards = append(cards, card{gid, ts, rgba, dst})
+		bounds = bounds.Union(dst)
+	})
+
+	// Cards are composited in row-major order, such that overlapping tiles
+	// are drawn over deterministically.
+	sort.SliceStable(cards, func(i, j int) bool {
+		a, b := cards[i].dst.Min, cards[j].dst.Min
+		return a.Y < b.Y || a.Y == b.Y && a.X < b.X
+	})
+	out := image.NewRGBA(bounds)
+	for _, c := range cards {
+		b := c.rgba.Bounds()
+		bakeCard(out, c.dst, c.rgba, m.TilesetRect(c.ts, b.Dx(), b.Dy(), true, c.gid), c.gid)
+	}
+	return out
+}
+
+// bakeCard draws the rectangle src of the given tileset image over the
+// rectangle dst of the image out, scaling it (with nearest neighbour sampling)
+// and applying the flips of the given gid.
+func bakeCard(out *image.RGBA, dst image.Rectangle, rgba *image.RGBA, src image.Rectangle, gid uint32) {
+	flips := Flip(gid & flipFlags)
+	for y := dst.Min.Y; y < dst.Max.Y; y++ {
+		for x := dst.Min.X; x < dst.Max.X; x++ {
+			// Undo the vertical and horizontal flips, and then the diagonal
+			// one, which Tiled applies first.
+			u := (float64(x-dst.Min.X) + 0.5) / float64(dst.Dx())
+			v := (float64(y-dst.Min.Y) + 0.5) / float64(dst.Dy())
+			if flips.Vertical() {
+				v = 1 - v
+			}
+			if flips.Horizontal() {
+				u = 1 - u
+			}
+			if flips.Diagonal() {
+				u, v = v, u
+			}
+			sx := src.Min.X + int(u*float64(src.Dx()))
+			sy := src.Min.Y + int(v*float64(src.Dy()))
+			if !(image.Point{sx, sy}.In(rgba.Bounds())) {
+				continue
+			}
+
+			// Composite the (premultiplied) source over the destination.
+			s := rgba.Pix[rgba.PixOffset(sx, sy):]
+			d := out.Pix[out.PixOffset(x, y):]
+			a := 255 - uint32(s[3])
+			for i := 0; i < 4; i++ {
+				d[i] = uint8(uint32(s[i]) + uint32(d[i])*a/255)
+			}
+		}
+	}
+}
+
+// baked tells if the tile layer with the given name is baked, see
+// Config.BakeLayers.
+func (ld *loader) baked(name string) bool {
+	for _, n := range ld.c.BakeLayers {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+// bakedLayer loads the given tile layer as baked textures, see
+// Config.BakeLayers.
+func (ld *loader) bakedLayer(layer *Layer) {
+	m := ld.m
+	img := BakeLayer(m, layer, ld.tsImages)
+	depth := ld.layerDepth(layer.Index, layer.Properties)
+	maxSize := ld.c.BakeMaxSize
+	if maxSize <= 0 {
+		maxSize = defaultBakeMaxSize
+	}
+
+	texObjects := make(map[string]*gfx.Object)
+	b := img.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y += maxSize {
+		for x := b.Min.X; x < b.Max.X; x += maxSize {
+			r := image.Rect(x, y, x+maxSize, y+maxSize).Intersect(b)
+			chunk := image.NewRGBA(image.Rect(0, 0, r.Dx(), r.Dy()))
+			draw.Draw(chunk, chunk.Bounds(), img, r.Min, draw.Src)
+
+			name := fmt.Sprintf("baked:%d,%d", (x-b.Min.X)/maxSize, (y-b.Min.Y)/maxSize)
+			obj := ld.object(texObjects, layer.Index, layer.Name, layer.Properties, name, chunk)
+			left := float32(r.Min.X)
+			top := float32(m.Height*m.TileHeight - r.Min.Y)
+			appendCard(
+				obj.Meshes[0],
+				left,
+				left+float32(r.Dx()),
+				top-float32(r.Dy()),
+				top,
+				float32(depth), chunk.Bounds(), chunk.Bounds(),
+			)
+			ld.cardAdded(obj.Meshes[0], 0, 0)
+			if ld.c.Metrics != nil {
+				ld.c.Metrics.Vertices += 6
+			}
+		}
+	}
+	ld.layers[layer.Name] = texObjects
+}
@@ -0,0 +1,69 @@
+// Copyright 2014 The Azul3D Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tmx
+
+import "azul3d.org/gfx.v2-unstable"
+
+// BlendMode represents the way that the objects of a layer are blended with
+// what is rendered behind them. Load uses the blend mode named by the
+// conventional "blendmode" custom property of a layer, which is one of
+// "normal", "add", "multiply" or "screen".
+type BlendMode int
+
+const (
+	// Normal (I.e. alpha) blending, the default.
+	BlendNormal BlendMode = iota
+
+	// Additive blending, useful for glow and light layers.
+	BlendAdd
+
+	// Multiplicative blending, useful for shadow layers.
+	BlendMultiply
+
+	// Screen blending, which brightens like additive blending but never
+	// oversaturates.
+	BlendScreen
+)
+
+// blendModeProperty returns the blend mode named by the "blendmode" custom
+// property in the given properties map, or BlendNormal if there is none (or it
+// is not a known blend mode).
+func blendModeProperty(props map[string]string) BlendMode {
+	switch props["blendmode"] {
+	case "add":
+		return BlendAdd
+	case "multiply":
+		return BlendMultiply
+	case "screen":
+		return BlendScreen
+	}
+	return BlendNormal
+}
+
+// apply applies the blend mode to the given object state. Blend modes other
+// than BlendNormal expect colors premultiplied by their alpha (see the
+// PREMULTIPLY shader feature), and do not write depth such that they never
+// hide layers behind them.
+func (b BlendMode) apply(s *gfx.State) {
+	if b == BlendNormal {
+		return
+	}
+	s.AlphaMode = gfx.AlphaBlend
+	s.DepthWrite = false
+	s.Blend = gfx.DefaultBlendState
+	s.Blend.SrcAlpha = gfx.BZero
+	s.Blend.DstAlpha = gfx.BOne
+	switch b {
+	case BlendAdd:
+		s.Blend.SrcRGB = gfx.BOne
+		s.Blend.DstRGB = gfx.BOne
+	case BlendMultiply:
+		s.Blend.SrcRGB = gfx.BDstColor
+		s.Blend.DstRGB = gfx.BOneMinusSrcAlpha
+	case BlendScreen:
+		s.Blend.SrcRGB = gfx.BOne
+		s.Blend.DstRGB = gfx.BOneMinusSrcColor
+	}
+}
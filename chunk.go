@@ -0,0 +1,112 @@
+// Copyright 2014 The Azul3D Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tmx
+
+import (
+	"math"
+	"sort"
+
+	"azul3d.org/gfx.v2-unstable"
+	"azul3d.org/lmath.v1"
+)
+
+// ChunkID identifies a single object (or chunk) of the layers returned by
+// Load, by the name of it's layer and it's name within that layer (for
+// instance "tilesheet.png", or "baked:1,0" for the chunks of baked layers, see
+// Config.BakeLayers).
+type ChunkID struct {
+	Layer, Name string
+}
+
+// Chunk is a single object of the layers returned by Load, and it's bounds.
+type Chunk struct {
+	ID     ChunkID
+	Object *gfx.Object
+
+	// The axis-aligned bounding box of the vertices of the object's meshes,
+	// in world space (object transforms are ignored).
+	Bounds lmath.Rect3
+}
+
+// Chunks holds the bounds of the objects of the layers returned by Load, such
+// that the objects visible to a camera can be found cheaply (see
+// VisibleChunks) for culling them, with any camera system.
+type Chunks struct {
+	chunks []Chunk
+}
+
+// NewChunks returns the chunks of the given layers, as returned by Load. The
+// bounds of the objects are found from their vertices, so they must be found
+// before the meshes are loaded by the renderer and their data freed. Objects
+// without vertices are omitted.
+func NewChunks(layers map[string]map[string]*gfx.Object) *Chunks {
+	c := new(Chunks)
+	for layer, objects := range layers {
+		for name, obj := range objects {
+			inf := math.Inf(1)
+			b := lmath.Rect3{
+				Min: lmath.Vec3{inf, inf, inf},
+				Max: lmath.Vec3{-inf, -inf, -inf},
+			}
+			empty := true
+			obj.RLock()
+			for _, mesh := range obj.Meshes {
+				mesh.RLock()
+				for _, v := range mesh.Vertices {
+					empty = false
+					b.Min.X = math.Min(b.Min.X, float64(v.X))
+					b.Min.Y = math.Min(b.Min.Y, float64(v.Y))
+					b.Min.Z = math.Min(b.Min.Z, float64(v.Z))
+					b.Max.X = math.Max(b.Max.X, float64(v.X))
+					b.Max.Y = math.Max(b.Max.Y, float64(v.Y))
+					b.Max.Z = math.Max(b.Max.Z, float64(v.Z))
+				}
+				mesh.RUnlock()
+			}
+			obj.RUnlock()
+			if !empty {
+				c.chunks = append(c.chunks, Chunk{ChunkID{layer, name}, obj, b})
+			}
+		}
+	}
+	sort.Slice(c.chunks, func(i, j int) bool {
+		a, b := c.chunks[i].ID, c.chunks[j].ID
+		return a.Layer < b.Layer || a.Layer == b.Layer && a.Name < b.Name
+	})
+	return c
+}
+
+// Chunks returns all of the chunks, sorted by their IDs.
+func (c *Chunks) Chunks() []Chunk {
+	return c.chunks
+}
+
+// Chunk returns the chunk with the given ID, or false if there is none.
+func (c *Chunks) Chunk(id ChunkID) (Chunk, bool) {
+	i := sort.Search(len(c.chunks), func(i int) bool {
+		k := c.chunks[i].ID
+		return k.Layer > id.Layer || k.Layer == id.Layer && k.Name >= id.Name
+	})
+	if i < len(c.chunks) && c.chunks[i].ID == id {
+		return c.chunks[i], true
+	}
+	return Chunk{}, false
+}
+
+// VisibleChunks returns the IDs of the chunks whose bounds intersect the given
+// view rectangle in world space (for instance the area shown by an
+// orthographic camera), sorted. Maps lie in the XZ plane, so the depth (Y) of
+// the view is ignored.
+func (c *Chunks) VisibleChunks(view lmath.Rect3) []ChunkID {
+	var ids []ChunkID
+	for _, ch := range c.chunks {
+		b := ch.Bounds
+		if b.Max.X < view.Min.X || b.Min.X > view.Max.X || b.Max.Z < view.Min.Z || b.Min.Z > view.Max.Z {
+			continue
+		}
+		ids = append(ids, ch.ID)
+	}
+	return ids
+}
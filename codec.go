@@ -0,0 +1,96 @@
+// Copyright 2014 Lightpoke. All rights reserved.
+// This source code is subject to the terms and
+// conditions defined in the "License.txt" file.
+
+package tmx
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"sort"
+	"sync"
+)
+
+// Decoder returns a reader which decompresses the given compressed tile data.
+type Decoder func(r io.Reader) (io.ReadCloser, error)
+
+// Encoder returns a writer which compresses tile data written to it into w,
+// using the given compression level (-1 for the default level, see
+// Map.CompressionLevel). Closing the writer must flush it, but not close w.
+type Encoder func(w io.Writer, level int) (io.WriteCloser, error)
+
+// codec is a registered compression.
+type codec struct {
+	dec Decoder
+	enc Encoder
+}
+
+var (
+	codecsLock sync.RWMutex
+	codecs     = map[Compression]codec{
+		ZlibCompression: {
+			dec: func(r io.Reader) (io.ReadCloser, error) {
+				return zlib.NewReader(r)
+			},
+			enc: func(w io.Writer, level int) (io.WriteCloser, error) {
+				return zlib.NewWriterLevel(w, level)
+			},
+		},
+		GzipCompression: {
+			dec: func(r io.Reader) (io.ReadCloser, error) {
+				return gzip.NewReader(r)
+			},
+			enc: func(w io.Writer, level int) (io.WriteCloser, error) {
+				return gzip.NewWriterLevel(w, level)
+			},
+		},
+	}
+)
+
+// RegisterCompression registers the decoder and encoder of the named
+// compression of base64 tile data (as it appears in the compression attribute
+// of TMX files), such that maps using it can be parsed and written. This allows
+// supporting compressions like zstd, or custom (e.g. encrypted) ones, without
+// this package depending on their implementations.
+//
+// Either of them may be nil, in which case data using the compression cannot
+// be decoded (or encoded) and ErrBadCompression is returned. If both are nil
+// the compression is unregistered. The zlib and gzip compressions are
+// registered by default, but may be replaced.
+//
+// It panics if the name is NoCompression. It is safe to call from multiple
+// goroutines, but is usually called from an init function.
+func RegisterCompression(name Compression, dec Decoder, enc Encoder) {
+	if name == NoCompression {
+		panic("tmx: cannot register NoCompression")
+	}
+	codecsLock.Lock()
+	defer codecsLock.Unlock()
+	if dec == nil && enc == nil {
+		delete(codecs, name)
+		return
+	}
+	codecs[name] = codec{dec: dec, enc: enc}
+}
+
+// Compressions returns the names of the registered compressions, sorted.
+func Compressions() []Compression {
+	codecsLock.RLock()
+	defer codecsLock.RUnlock()
+	names := make([]Compression, 0, len(codecs))
+	for name := range codecs {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		return names[i] < names[j]
+	})
+	return names
+}
+
+// lookupCodec returns the registered codec of the given compression.
+func lookupCodec(name Compression) codec {
+	codecsLock.RLock()
+	defer codecsLock.RUnlock()
+	return codecs[name]
+}
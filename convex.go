@@ -0,0 +1,120 @@
+// Copyright 2014 Lightpoke. All rights reserved.
+// This source code is subject to the terms and
+// conditions defined in the "License.txt" file.
+
+package tmx
+
+// ConvexParts splits the given simple (but possibly concave) polygon, like
+// those of ObjectGroup.Outline or Map.Outlines, into convex polygons, as most
+// physics engines require convex shapes. The parts wind the same way as the
+// polygon, collinear points are removed. Polygons with fewer than three
+// points have no parts.
+//
+// The polygon is triangulated by ear clipping, after which neighbouring parts
+// are merged for as long as the result stays convex (the Hertel-Mehlhorn
+// algorithm), which yields at most four times the minimal number of parts.
+// Holes (such as the counter-clockwise outlines of Map.Outlines) are not
+// supported, they are polygons of their own.
+func ConvexParts(poly []Pixel) [][]Pixel {
+	poly = removeCollinear(poly)
+	if len(poly) < 3 {
+		return nil
+	}
+	var area float64
+	for i, p := range poly {
+		q := poly[(i+1)%len(poly)]
+		area += p.X*q.Y - q.X*p.Y
+	}
+	sign := 1.0
+	if area < 0 {
+		sign = -1
+	}
+	if convex(poly, sign) {
+		return [][]Pixel{poly}
+	}
+
+	tris := triangulate(poly)
+	parts := make([][]Pixel, 0, len(tris)/3)
+	for i := 0; i+2 < len(tris); i += 3 {
+		parts = append(parts, []Pixel{tris[i], tris[i+1], tris[i+2]})
+	}
+	for merged := true; merged; {
+		merged = false
+		for i := 0; i < len(parts) && !merged; i++ {
+			for j := i + 1; j < len(parts) && !merged; j++ {
+				p, ok := mergeParts(parts[i], parts[j])
+				if !ok || !convex(p, sign) {
+					continue
+				}
+				parts[i] = removeCollinear(p)
+				parts = append(parts[:j], parts[j+1:]...)
+				merged = true
+			}
+		}
+	}
+	return parts
+}
+
+// mergeParts merges the two given polygons along an edge that they share (in
+// opposite directions, as neighbours of the same winding do), or returns false
+// if they do not share one.
+func mergeParts(a, b []Pixel) ([]Pixel, bool) {
+	for i := range a {
+		p, q := a[i], a[(i+1)%len(a)]
+		for j := range b {
+			if b[j] != q || b[(j+1)%len(b)] != p {
+				continue
+			}
+
+			// Walk around a from q to p, then around the rest of b.
+			merged := make([]Pixel, 0, len(a)+len(b)-2)
+			for k := 1; k <= len(a); k++ {
+				merged = append(merged, a[(i+k)%len(a)])
+			}
+			for k := 2; k < len(b); k++ {
+				merged = append(merged, b[(j+k)%len(b)])
+			}
+			return merged, true
+		}
+	}
+	return nil, false
+}
+
+// convex tells if the given polygon, winding as told by the sign of it's
+// area, is convex. Collinear points are allowed.
+func convex(poly []Pixel, sign float64) bool {
+	for i, b := range poly {
+		a := poly[(i+len(poly)-1)%len(poly)]
+		c := poly[(i+1)%len(poly)]
+		if ((b.X-a.X)*(c.Y-a.Y)-(b.Y-a.Y)*(c.X-a.X))*sign < 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// removeCollinear returns the given polygon without the points that lie on a
+// straight line between their neighbours (or that equal the previous point).
+func removeCollinear(poly []Pixel) []Pixel {
+	unique := make([]Pixel, 0, len(poly))
+	for i, p := range poly {
+		if i == 0 || p != poly[i-1] {
+			unique = append(unique, p)
+		}
+	}
+	for len(unique) > 1 && unique[0] == unique[len(unique)-1] {
+		unique = unique[:len(unique)-1]
+	}
+	poly = unique
+
+	out := make([]Pixel, 0, len(poly))
+	for i, b := range poly {
+		a := poly[(i+len(poly)-1)%len(poly)]
+		c := poly[(i+1)%len(poly)]
+		if (b.X-a.X)*(c.Y-a.Y)-(b.Y-a.Y)*(c.X-a.X) == 0 {
+			continue
+		}
+		out = append(out, b)
+	}
+	return out
+}
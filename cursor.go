@@ -0,0 +1,171 @@
+// Copyright 2014 The Azul3D Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tmx
+
+import (
+	"image"
+	"math"
+
+	"azul3d.org/gfx.v2-unstable"
+)
+
+// CellShape returns the outline, in pixels, of the map cell at the given tile
+// coordinates, honoring the map's orientation (see TileBounds): a rectangle for
+// orthogonal maps, a diamond for isometric and staggered maps, and a hexagon
+// for hexagonal maps. The points are in clockwise order as seen on screen.
+func (m *Map) CellShape(c Coord) []Pixel {
+	r := m.TileBounds(c.X, c.Y)
+	minX, minY := float64(r.Min.X), float64(r.Min.Y)
+	maxX, maxY := float64(r.Max.X), float64(r.Max.Y)
+	cx, cy := (minX+maxX)/2, (minY+maxY)/2
+	switch m.Orientation {
+	case Isometric:
+		return []Pixel{{cx, minY}, {maxX, cy}, {cx, maxY}, {minX, cy}}
+
+	case Staggered, Hexagonal:
+		// Staggered maps are hexagonal maps whose side length is zero, whose
+		// hexagons degenerate into diamonds.
+		p := m.hex()
+		var shape []Pixel
+		if m.StaggerAxis == StaggerAxisX {
+			so := float64(m.TileWidth-p.sideLengthX) / 2
+			shape = []Pixel{{minX + so, minY}, {maxX - so, minY}, {maxX, cy}, {maxX - so, maxY}, {minX + so, maxY}, {minX, cy}}
+		} else {
+			so := float64(m.TileHeight-p.sideLengthY) / 2
+			shape = []Pixel{{cx, minY}, {maxX, minY + so}, {maxX, maxY - so}, {cx, maxY}, {minX, maxY - so}, {minX, minY + so}}
+		}
+		var unique []Pixel
+		for i, pt := range shape {
+			if pt != shape[(i+1)%len(shape)] {
+				unique = append(unique, pt)
+			}
+		}
+		return unique
+
+	default:
+		return []Pixel{{minX, minY}, {maxX, minY}, {maxX, maxY}, {minX, maxY}}
+	}
+}
+
+// TileAtPixel returns the tile coordinates of the map cell under the given
+// pixel, honoring the map's orientation such that picking the diamonds of
+// isometric and staggered maps, and the hexagons of hexagonal maps, is exact
+// (see CellShape). The cell may lie outside of the map's bounds.
+func (m *Map) TileAtPixel(p Pixel) Coord {
+	if m.TileWidth == 0 || m.TileHeight == 0 {
+		return Coord{}
+	}
+	x, y := m.pixelToTile(p.X, p.Y)
+	c := Coord{int(math.Floor(x)), int(math.Floor(y))}
+	if m.Orientation != Staggered && m.Orientation != Hexagonal {
+		return c
+	}
+
+	// The tile coordinates of staggered and hexagonal maps are only
+	// approximate, so the neighbouring cells are candidates too. Pixels in
+	// the gaps left by rounding pick the cell whose center is nearest.
+	best, bestDist := c, math.Inf(1)
+	for dy := -1; dy <= 1; dy++ {
+		for dx := -1; dx <= 1; dx++ {
+			candidate := Coord{c.X + dx, c.Y + dy}
+			shape := m.CellShape(candidate)
+			if polygonContains(shape, p) {
+				return candidate
+			}
+			r := m.TileBounds(candidate.X, candidate.Y)
+			ex := p.X - float64(r.Min.X+r.Max.X)/2
+			ey := p.Y - float64(r.Min.Y+r.Max.Y)/2
+			if d := ex*ex + ey*ey; d < bestDist {
+				best, bestDist = candidate, d
+			}
+		}
+	}
+	return best
+}
+
+// PickCell returns the tile coordinates of the map cell under the given window
+// point, like Pick does, except that cells are laid out according to the
+// map's orientation (see TileAtPixel) rather than orthogonally like Load lays
+// them out. This suits isometric, staggered and hexagonal maps which are
+// rendered, or whose tiles are positioned, in Tiled's pixel space (see
+// PixelToWorld). Layer offsets are not applied.
+//
+// If the point is not above a cell of the map, ok is false.
+func PickCell(m *Map, c *Config, cam *gfx.Camera, bounds image.Rectangle, p image.Point) (tile Coord, ok bool) {
+	if c == nil {
+		c = DefaultConfig()
+	}
+	ray, ok := pickRay(m, c, cam, bounds, p)
+	if !ok || m.TileWidth == 0 || m.TileHeight == 0 {
+		return tile, false
+	}
+	tile = m.TileAtPixel(ray(0))
+	return tile, image.Pt(tile.X, tile.Y).In(m.Bounds())
+}
+
+// CellHighlightMesh returns a mesh filling the shape of the map cell at the
+// given tile coordinates (see CellShape) with the given color, for instance
+// for highlighting the cell under the cursor (see PickCell). It lies in world
+// space like PixelToWorld places the pixels of the map, above all of the
+// layers that Load places.
+//
+// The mesh has texture coordinates for a single texel, see LoadCellHighlight.
+// If c is nil then the default configuration is used.
+func CellHighlightMesh(m *Map, c *Config, cell Coord, color gfx.Color) *gfx.Mesh {
+	if c == nil {
+		c = DefaultConfig()
+	}
+	ld := newLoader(m, c, nil)
+	index := 2 * (len(m.Layers) + len(m.ObjectGroups) + len(m.ImageLayers) + len(m.Groups))
+	depth := float32(ld.layerDepth(index, nil))
+
+	mesh := gfx.NewMesh()
+	for _, p := range triangulate(m.CellShape(cell)) {
+		v := PixelToWorld(m, c, p)
+		mesh.Vertices = append(mesh.Vertices, gfx.Vec3{float32(v.X), depth, float32(v.Z)})
+		mesh.Colors = append(mesh.Colors, color)
+	}
+	mesh.TexCoords = []gfx.TexCoordSet{{
+		Slice: make([]gfx.TexCoord, len(mesh.Vertices)),
+	}}
+	return mesh
+}
+
+// LoadCellHighlight returns an object rendering the mesh returned by
+// CellHighlightMesh, blended over the map. To move the highlight, for instance
+// as the cursor moves, replace it's mesh with a new one.
+//
+// If c is nil then the default configuration is used.
+func LoadCellHighlight(m *Map, c *Config, cell Coord, color gfx.Color) *gfx.Object {
+	if c == nil {
+		c = DefaultConfig()
+	}
+
+	// Highlights are untextured, so we use a single white texel.
+	white := image.NewRGBA(image.Rect(0, 0, 1, 1))
+	copy(white.Pix, []uint8{255, 255, 255, 255})
+	t := gfx.NewTexture()
+	t.Source = white
+	t.Bounds = white.Bounds()
+	t.WrapU = gfx.Clamp
+	t.WrapV = gfx.Clamp
+	t.MinFilter = gfx.Nearest
+	t.MagFilter = gfx.Nearest
+
+	obj := gfx.NewObject()
+	obj.Shader = shaderVariant(featureVertexColor)
+	obj.Meshes = []*gfx.Mesh{CellHighlightMesh(m, c, cell, color)}
+	obj.Textures = []*gfx.Texture{t}
+	obj.State = gfx.NewState()
+	obj.State.FaceCulling = gfx.NoFaceCulling
+	obj.State.AlphaMode = gfx.AlphaBlend
+	if c.DepthOrder {
+		obj.State.DepthCmp = gfx.LessOrEqual
+	}
+	if c.Info != nil {
+		c.Info[obj] = &ObjectInfo{Name: "tmx:highlight"}
+	}
+	return obj
+}
@@ -5,14 +5,13 @@
 package tmx
 
 import (
+	"bufio"
 	"bytes"
-	"compress/gzip"
-	"compress/zlib"
 	"encoding/base64"
 	"encoding/binary"
-	"encoding/csv"
 	"errors"
 	"io"
+	"math"
 	"strconv"
 )
 
@@ -20,26 +19,107 @@ type xmlDataTile struct {
 	Gid uint32 `xml:"gid,attr"`
 }
 
+// xmlChunk is a chunk of the tile data of a layer of an infinite map, which is
+// encoded just like the data of the layer is.
+type xmlChunk struct {
+	X      int           `xml:"x,attr"`
+	Y      int           `xml:"y,attr"`
+	Width  int           `xml:"width,attr"`
+	Height int           `xml:"height,attr"`
+	Data   []byte        `xml:",innerxml"`
+	Tile   []xmlDataTile `xml:"tile"`
+}
+
+// Encoding represents the encoding of the tile data of a layer, as it appears
+// in TMX files.
+type Encoding string
+
+const (
+	// Tiles are stored as plain XML elements (deprecated by Tiled).
+	XMLEncoding Encoding = ""
+
+	// Tiles are stored as comma separated global tile IDs.
+	CSVEncoding Encoding = "csv"
+
+	// Tiles are stored as base64 encoded binary data, which may be compressed
+	// (see Compression).
+	Base64Encoding Encoding = "base64"
+)
+
+// String returns the name of the encoding as it appears in TMX files, or "xml"
+// for plain XML elements.
+func (e Encoding) String() string {
+	if e == XMLEncoding {
+		return "xml"
+	}
+	return string(e)
+}
+
+// Compression represents the compression of the base64 encoded tile data of a
+// layer, as it appears in TMX files. Compressions other than those below may
+// be supported using RegisterCompression.
+type Compression string
+
+const (
+	// Tile data is not compressed.
+	NoCompression Compression = ""
+
+	// Tile data is compressed using zlib.
+	ZlibCompression Compression = "zlib"
+
+	// Tile data is compressed using gzip.
+	GzipCompression Compression = "gzip"
+)
+
+// String returns the name of the compression as it appears in TMX files, or
+// "none" for no compression.
+func (c Compression) String() string {
+	if c == NoCompression {
+		return "none"
+	}
+	return string(c)
+}
+
 type xmlData struct {
 	Data []byte `xml:",innerxml"`
 
 	// base64, csv
-	Encoding string `xml:"encoding,attr"`
+	Encoding Encoding `xml:"encoding,attr"`
 
 	// gzip, zlib
-	Compression string `xml:"compression,attr"`
+	Compression Compression `xml:"compression,attr"`
 
 	Tile []xmlDataTile `xml:"tile"`
+
+	// The chunks of tile data, for infinite maps.
+	Chunks []xmlChunk `xml:"chunk"`
 }
 
 var (
 	// Error representing an unknown encoding method inside of a tmx file
 	ErrBadEncoding = errors.New("tile data encoding type is not supported")
 
-	// Error representing an unknown compression method inside of a tmx file
+	// Error representing an unknown compression method inside of a tmx file,
+	// see RegisterCompression
 	ErrBadCompression = errors.New("tile data compression type is not supported")
+
+	// Error representing tile data whose number of tiles does not match the
+	// size of it's layer (or chunk) inside of a tmx file
+	ErrBadTileCount = errors.New("tile data length does not match the layer size")
 )
 
+// countingReader counts the number of bytes read from the underlying reader.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
 func toCoord(index, width, height int) Coord {
 	if width == 0 {
 		panic("width == 0")
@@ -50,90 +130,173 @@ func toCoord(index, width, height int) Coord {
 	}
 }
 
-func (x xmlData) tiles(width, height int) (map[Coord]uint32, error) {
-	tiles := make(map[Coord]uint32)
+// tiles decodes the tile data, it returns the tiles and the number of bytes of
+// binary tile data that were decoded (I.e. after decompression).
+//
+// If the given tiles map is non-nil, it is cleared and reused. Recoverable
+// problems with the data are passed to the given function, decoding is aborted
+// with the error it returns, if any.
+func (x xmlData) tiles(width, height int, tiles map[Coord]uint32, problem func(err error) error) (map[Coord]uint32, int64, error) {
+	if tiles == nil {
+		tiles = make(map[Coord]uint32)
+	}
+	for c := range tiles {
+		delete(tiles, c)
+	}
+	if len(x.Chunks) == 0 {
+		n, err := x.decode(width, height, Coord{}, tiles, problem)
+		if err != nil {
+			return nil, 0, err
+		}
+		return tiles, n, nil
+	}
+
+	// Infinite maps store their tiles in chunks, which use the encoding and
+	// compression of the layer's data.
+	var decoded int64
+	for _, c := range x.Chunks {
+		if c.Width <= 0 || c.Height <= 0 {
+			return nil, 0, errors.New("tile data chunk has an invalid size")
+		}
+		chunk := xmlData{
+			Data:        c.Data,
+			Encoding:    x.Encoding,
+			Compression: x.Compression,
+			Tile:        c.Tile,
+		}
+		n, err := chunk.decode(c.Width, c.Height, Coord{c.X, c.Y}, tiles, problem)
+		if err != nil {
+			return nil, 0, err
+		}
+		decoded += n
+	}
+	return tiles, decoded, nil
+}
+
+// decode decodes the tile data of a layer or chunk of the given size in tiles,
+// whose top-left tile is at the given origin, into the tiles map. It returns
+// the number of bytes of binary tile data that were decoded.
+//
+// The data should hold either exactly one tile for each cell or no tiles at
+// all (as layers of infinite maps without chunks do), otherwise
+// ErrBadTileCount is passed to problem: tiles past the end of the layer are
+// dropped, and cells past the end of short data are left empty. Binary data
+// holds little-endian global tile IDs, as the TMX format specifies regardless
+// of the platform.
+func (x xmlData) decode(width, height int, origin Coord, tiles map[Coord]uint32, problem func(err error) error) (int64, error) {
+	count := 0
+	if width > 0 && height > 0 {
+		count = width * height
+	}
+	at := func(index int) Coord {
+		c := toCoord(index, width, 0)
+		return Coord{origin.X + c.X, origin.Y + c.Y}
+	}
+	checkCount := func(n int) error {
+		if n != 0 && n != count {
+			return problem(ErrBadTileCount)
+		}
+		return nil
+	}
 	switch x.Encoding {
-	case "":
+	case XMLEncoding:
 		// No encoding, plain XML elements
+		if err := checkCount(len(x.Tile)); err != nil {
+			return 0, err
+		}
 		for coordIndex, xt := range x.Tile {
+			if coordIndex >= count {
+				break
+			}
 			if xt.Gid != 0 {
-				tiles[toCoord(coordIndex, width, height)] = xt.Gid
+				tiles[at(coordIndex)] = xt.Gid
 			}
 		}
 
-	case "csv":
-		buf := bytes.NewBuffer(x.Data)
-		r := csv.NewReader(buf)
-		r.FieldsPerRecord = -1
-		csvLines, err := r.ReadAll()
-		if err != nil {
-			return nil, err
-		}
+	case CSVEncoding:
+		// The values are parsed in place (rather than with encoding/csv) as
+		// to not allocate a string for every single tile.
 		coordIndex := 0
-		for _, line := range csvLines {
-			for _, csvString := range line {
-				if len(csvString) > 0 {
-					gid, err := strconv.ParseUint(csvString, 10, 0)
-					if err != nil {
-						return nil, err
-					}
-					if gid != 0 {
-						tiles[toCoord(coordIndex, width, height)] = uint32(gid)
-					}
-					coordIndex++
+		for data := x.Data; len(data) > 0; {
+			end := bytes.IndexAny(data, ",\r\n")
+			if end == -1 {
+				end = len(data)
+			}
+			field := bytes.TrimSpace(data[:end])
+			if end < len(data) {
+				end++
+			}
+			data = data[end:]
+			if len(field) == 0 {
+				continue
+			}
+			var gid uint64
+			for _, c := range field {
+				if c < '0' || c > '9' {
+					return 0, &strconv.NumError{Func: "ParseUint", Num: string(field), Err: strconv.ErrSyntax}
+				}
+				gid = gid*10 + uint64(c-'0')
+				if gid > math.MaxUint32 {
+					return 0, &strconv.NumError{Func: "ParseUint", Num: string(field), Err: strconv.ErrRange}
 				}
 			}
+			if gid != 0 && coordIndex < count {
+				tiles[at(coordIndex)] = uint32(gid)
+			}
+			coordIndex++
+		}
+		if err := checkCount(coordIndex); err != nil {
+			return 0, err
 		}
 
-	case "base64":
+	case Base64Encoding:
 		data := bytes.Replace(x.Data, []byte(" "), []byte(""), -1)
 		data = bytes.Replace(data, []byte("\r"), []byte(""), -1)
 		data = bytes.Replace(data, []byte("\n"), []byte(""), -1)
 		buf := bytes.NewBuffer(data)
 		decoded := base64.NewDecoder(base64.StdEncoding, buf)
 
-		var decompressed io.Reader
-		switch x.Compression {
-		case "":
-			// No compression
-			decompressed = decoded
-		case "zlib":
-			r, err := zlib.NewReader(decoded)
-			if err != nil {
-				return nil, err
+		var decompressed io.Reader = decoded
+		if x.Compression != NoCompression {
+			dec := lookupCodec(x.Compression).dec
+			if dec == nil {
+				return 0, ErrBadCompression
 			}
-			defer r.Close()
-			decompressed = r
-
-		case "gzip":
-			r, err := gzip.NewReader(decoded)
+			r, err := dec(decoded)
 			if err != nil {
-				return nil, err
+				return 0, err
 			}
 			defer r.Close()
 			decompressed = r
-
-		default:
-			return nil, ErrBadCompression
 		}
-		coordIndex := 0
+		counter := &countingReader{r: decompressed}
+		r := bufio.NewReader(counter)
+
+		var (
+			coordIndex int
+			word       [4]byte
+		)
 		for {
-			var gid uint32
-			err := binary.Read(decompressed, binary.LittleEndian, &gid)
+			_, err := io.ReadFull(r, word[:])
 			if err != nil {
 				if err == io.EOF {
 					break
 				}
-				return nil, err
+				return 0, err
 			}
-			if gid != 0 {
-				tiles[toCoord(coordIndex, width, height)] = gid
+			gid := binary.LittleEndian.Uint32(word[:])
+			if gid != 0 && coordIndex < count {
+				tiles[at(coordIndex)] = gid
 			}
 			coordIndex++
 		}
+		if err := checkCount(coordIndex); err != nil {
+			return 0, err
+		}
+		return counter.n, nil
 
 	default:
-		return nil, ErrBadEncoding
+		return 0, ErrBadEncoding
 	}
-	return tiles, nil
+	return 0, nil
 }
@@ -0,0 +1,184 @@
+// Copyright 2014 The Azul3D Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tmx
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+
+	"azul3d.org/gfx.v2-unstable"
+)
+
+// DebugGrid configures the debug overlay of DebugOverlay and LoadDebugOverlay.
+type DebugGrid struct {
+	// The interval, in cells, at which cells are labeled with their tile
+	// coordinates. If less than one every cell is labeled, which is only
+	// legible with large tiles.
+	Interval int
+
+	// The colors of the gridlines and of the labels (and the scale of the
+	// latter, the glyphs are 3x5 pixels at scale one).
+	Grid, Label color.RGBA
+	Scale       int
+}
+
+// DefaultDebugGrid is a debug overlay labeling every eighth cell, with
+// translucent white gridlines and yellow labels.
+var DefaultDebugGrid = DebugGrid{
+	Interval: 8,
+	Grid:     color.RGBA{255, 255, 255, 96},
+	Label:    color.RGBA{255, 255, 0, 255},
+	Scale:    1,
+}
+
+// debugGlyphs are the 3x5 pixel glyphs of the labels of debug overlays, each
+// row's pixels being the low three bits (the most significant is leftmost).
+var debugGlyphs = map[rune][5]uint8{
+	'0': {7, 5, 5, 5, 7},
+	'1': {2, 6, 2, 2, 7},
+	'2': {7, 1, 7, 4, 7},
+	'3': {7, 1, 3, 1, 7},
+	'4': {5, 5, 7, 1, 1},
+	'5': {7, 4, 7, 1, 7},
+	'6': {7, 4, 7, 5, 7},
+	'7': {7, 1, 1, 1, 1},
+	'8': {7, 5, 7, 5, 7},
+	'9': {7, 5, 7, 1, 7},
+	'-': {0, 0, 7, 0, 0},
+	',': {0, 0, 0, 2, 4},
+}
+
+// DebugOverlay draws the cells of the map (see Map.Bounds and Map.TileBounds)
+// as gridlines, labeled with their tile coordinates ("x,y") at the interval of
+// the given grid, into an image whose bounds are in pixels (and which may thus
+// have negative coordinates for infinite maps). Cells are outlined by their
+// bounding rectangles, or by diamonds for isometric maps.
+//
+// This is invaluable when diagnosing off-by-one placement and orientation
+// bugs, see LoadDebugOverlay for drawing it on top of a loaded map.
+func DebugOverlay(m *Map, g DebugGrid) *image.RGBA {
+	if g.Interval < 1 {
+		g.Interval = 1
+	}
+	if g.Scale < 1 {
+		g.Scale = 1
+	}
+	cells := m.Bounds()
+	var bounds image.Rectangle
+	for y := cells.Min.Y; y < cells.Max.Y; y++ {
+		for x := cells.Min.X; x < cells.Max.X; x++ {
+			bounds = bounds.Union(m.TileBounds(x, y))
+		}
+	}
+	img := image.NewRGBA(bounds)
+
+	line := func(x0, y0, x1, y1 int) {
+		dx, dy := x1-x0, y1-y0
+		n := dx
+		if n < 0 {
+			n = -n
+		}
+		if dy > n {
+			n = dy
+		} else if -dy > n {
+			n = -dy
+		}
+		for i := 0; i <= n; i++ {
+			x, y := x0, y0
+			if n > 0 {
+				x, y = x0+dx*i/n, y0+dy*i/n
+			}
+			img.SetRGBA(x, y, g.Grid)
+		}
+	}
+	for y := cells.Min.Y; y < cells.Max.Y; y++ {
+		for x := cells.Min.X; x < cells.Max.X; x++ {
+			r := m.TileBounds(x, y)
+			if m.Orientation == Isometric {
+				cx, cy := (r.Min.X+r.Max.X)/2, (r.Min.Y+r.Max.Y)/2
+				line(cx, r.Min.Y, r.Max.X-1, cy)
+				line(r.Max.X-1, cy, cx, r.Max.Y-1)
+				line(cx, r.Max.Y-1, r.Min.X, cy)
+				line(r.Min.X, cy, cx, r.Min.Y)
+			} else {
+				line(r.Min.X, r.Min.Y, r.Max.X-1, r.Min.Y)
+				line(r.Min.X, r.Min.Y, r.Min.X, r.Max.Y-1)
+			}
+			if floorDiv(x, g.Interval)*g.Interval != x || floorDiv(y, g.Interval)*g.Interval != y {
+				continue
+			}
+
+			// Labels of isometric cells start at their left corner.
+			lx, ly := r.Min.X+2, r.Min.Y+2
+			if m.Orientation == Isometric {
+				ly = (r.Min.Y+r.Max.Y)/2 - 5*g.Scale/2
+			}
+			for _, ch := range fmt.Sprintf("%d,%d", x, y) {
+				glyph := debugGlyphs[ch]
+				for gy, row := range glyph {
+					for gx := 0; gx < 3; gx++ {
+						if row&(4>>uint(gx)) == 0 {
+							continue
+						}
+						for sy := 0; sy < g.Scale; sy++ {
+							for sx := 0; sx < g.Scale; sx++ {
+								img.SetRGBA(lx+gx*g.Scale+sx, ly+gy*g.Scale+sy, g.Label)
+							}
+						}
+					}
+				}
+				lx += 4 * g.Scale
+			}
+		}
+	}
+	return img
+}
+
+// LoadDebugOverlay loads the debug overlay of the map (see DebugOverlay) as a
+// single textured card drawn on top of all of the map's layers (as loaded by
+// Load with the same configuration).
+//
+// If c is nil then the default configuration is used.
+func LoadDebugOverlay(m *Map, c *Config, g DebugGrid) *gfx.Object {
+	if c == nil {
+		c = DefaultConfig()
+	}
+	ld := newLoader(m, c, nil)
+	img := DebugOverlay(m, g)
+	b := img.Bounds()
+
+	// Textures start at the origin.
+	img.Rect = img.Rect.Sub(b.Min)
+
+	// Above all layers, including those above entities.
+	index := 2 * (len(m.Layers) + len(m.ObjectGroups) + len(m.ImageLayers) + len(m.Groups))
+	depth := ld.layerDepth(index, nil)
+
+	mesh := gfx.NewMesh()
+	left := float32(b.Min.X)
+	top := float32(m.Height*m.TileHeight - b.Min.Y)
+	appendCard(mesh, left, left+float32(b.Dx()), top-float32(b.Dy()), top, float32(depth), img.Rect, img.Rect)
+	ld.origin(mesh, 0)
+
+	t := newTexture(img)
+	t.MinFilter = gfx.Nearest
+	t.MagFilter = gfx.Nearest
+
+	obj := gfx.NewObject()
+	obj.Shader = shaderVariant()
+	obj.Meshes = []*gfx.Mesh{mesh}
+	obj.Textures = []*gfx.Texture{t}
+	obj.State = gfx.NewState()
+	obj.State.FaceCulling = gfx.NoFaceCulling
+	obj.State.AlphaMode = gfx.AlphaBlend
+	if ld.c.DepthOrder {
+		obj.State.DepthCmp = gfx.LessOrEqual
+	}
+	if ld.c.Info != nil {
+		ld.c.Info[obj] = &ObjectInfo{Name: "tmx:debug"}
+	}
+	return obj
+}
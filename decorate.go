@@ -0,0 +1,191 @@
+// Copyright 2014 Lightpoke. All rights reserved.
+// This source code is subject to the terms and
+// conditions defined in the "License.txt" file.
+
+package tmx
+
+import (
+	"image"
+	"math"
+	"math/rand"
+)
+
+// DecorationRule is a single rule for scattering decorations onto a map, see
+// Map.Decorate.
+type DecorationRule struct {
+	// The tiles of the base layer that decorations may be placed onto, whose
+	// flip flags are ignored. If neither these nor WangSet are set, every
+	// cell with a tile matches.
+	Ground []uint32
+
+	// If non-nil, decorations may also be placed onto tiles of the base layer
+	// which are entirely of the given color (a one-based index into the
+	// Colors of the wang set) of this wang set, for instance "grass".
+	WangSet *WangSet
+	Color   int
+
+	// The probability of a matching cell being decorated, between 0 and 1.
+	Density float64
+
+	// The decoration tiles to choose from, weighted by the probability of each
+	// tile (see Tile.Probability) just like Randomize does.
+	Tiles []uint32
+
+	// The minimum distance, in pixels, between the center of a decorated cell
+	// and the bounds of any visible object of the map, such that decorations
+	// keep clear of for instance spawn points and doors.
+	Clearance float64
+}
+
+// Decoration is a single decoration tile placed by Map.Decorate.
+type Decoration struct {
+	Coord Coord
+	Gid   uint32
+}
+
+// Decorate scatters decoration tiles onto the cells of the base layer, l,
+// according to the given rules and returns them (it does not change the map).
+// They can be set on a layer of their own using SetTile, or be turned into
+// tile objects, see Decoration.Object.
+//
+// Cells are visited in row-major order and the rules in the given order, the
+// first rule placing a decoration onto a cell wins. Each matching rule draws
+// from r the same way regardless of the outcome, so the same map and seed
+// always produce the same result.
+func (m *Map) Decorate(l *Layer, rules []DecorationRule, r *rand.Rand) []Decoration {
+	type weighted struct {
+		weights []float64
+		total   float64
+	}
+	choices := make([]weighted, len(rules))
+	for i, rule := range rules {
+		w := &choices[i]
+		w.weights = make([]float64, len(rule.Tiles))
+		for j, gid := range rule.Tiles {
+			w.weights[j] = m.tileProbability(gid)
+			w.total += w.weights[j]
+		}
+	}
+
+	// The bounds of the visible objects, for keeping clear of them.
+	var objects []image.Rectangle
+	for _, g := range m.ObjectGroups {
+		for _, obj := range g.Objects {
+			if obj.Visible {
+				objects = append(objects, g.Bounds(obj))
+			}
+		}
+	}
+	keepsClear := func(x, y int, clearance float64) bool {
+		if clearance <= 0 {
+			return true
+		}
+		cell := m.TileBounds(x, y)
+		cx := float64(cell.Min.X+cell.Max.X) / 2
+		cy := float64(cell.Min.Y+cell.Max.Y) / 2
+		for _, b := range objects {
+			dx := math.Max(math.Max(float64(b.Min.X)-cx, cx-float64(b.Max.X)), 0)
+			dy := math.Max(math.Max(float64(b.Min.Y)-cy, cy-float64(b.Max.Y)), 0)
+			if math.Hypot(dx, dy) < clearance {
+				return false
+			}
+		}
+		return true
+	}
+
+	var decorations []Decoration
+	bounds := l.Bounds(m)
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			c := Coord{x, y}
+			gid := l.Tile(c)
+			if gid == 0 {
+				continue
+			}
+			placed := false
+			for i, rule := range rules {
+				if len(rule.Tiles) == 0 || !m.decorates(rule, gid) {
+					continue
+				}
+				pick := r.Float64()
+				choice := r.Float64()
+				if placed || pick >= rule.Density || !keepsClear(x, y, rule.Clearance) {
+					continue
+				}
+				w := choices[i]
+				n := len(rule.Tiles) - 1
+				if w.total > 0 {
+					choice *= w.total
+					for j, weight := range w.weights {
+						choice -= weight
+						if choice < 0 {
+							n = j
+							break
+						}
+					}
+				} else {
+					n = int(choice * float64(len(rule.Tiles)))
+				}
+				decorations = append(decorations, Decoration{c, rule.Tiles[n]})
+				placed = true
+			}
+		}
+	}
+	return decorations
+}
+
+// decorates tells if the given rule applies to cells of the base layer with
+// the given gid, see Decorate.
+func (m *Map) decorates(rule DecorationRule, gid uint32) bool {
+	if len(rule.Ground) == 0 && rule.WangSet == nil {
+		return true
+	}
+	for _, g := range rule.Ground {
+		if g&^flipFlags == gid&^flipFlags {
+			return true
+		}
+	}
+	if rule.WangSet == nil {
+		return false
+	}
+	ts, id, _ := m.DecomposeGID(gid)
+	if ts == nil {
+		return false
+	}
+	for _, ws := range ts.WangSets {
+		if ws != rule.WangSet {
+			continue
+		}
+		wangID, ok := ws.Tiles[int(id)]
+		if !ok {
+			return false
+		}
+		colored := false
+		for _, color := range wangID {
+			if color != 0 && color != rule.Color {
+				return false
+			}
+			colored = colored || color != 0
+		}
+		return colored
+	}
+	return false
+}
+
+// Object returns a tile object displaying the decoration, whose bottom-left
+// corner is that of it's cell (as tile objects of orthogonal maps are
+// aligned), for adding to an object group instead of a tile layer.
+func (d Decoration) Object(m *Map) *Object {
+	cell := m.TileBounds(d.Coord.X, d.Coord.Y)
+	return &Object{
+		X:                   cell.Min.X,
+		Y:                   cell.Max.Y,
+		Width:               cell.Dx(),
+		Height:              cell.Dy(),
+		Gid:                 d.Gid &^ flipFlags,
+		FlippedHorizontally: d.Gid&FLIPPED_HORIZONTALLY_FLAG != 0,
+		FlippedVertically:   d.Gid&FLIPPED_VERTICALLY_FLAG != 0,
+		FlippedDiagonally:   d.Gid&FLIPPED_DIAGONALLY_FLAG != 0,
+		Visible:             true,
+	}
+}
@@ -0,0 +1,224 @@
+// Copyright 2014 Lightpoke. All rights reserved.
+// This source code is subject to the terms and
+// conditions defined in the "License.txt" file.
+
+package tmx
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// Error representing invalid tile change data, see DecodeTileChanges.
+var ErrBadTileChanges = errors.New("tile change data is invalid")
+
+// The version of the tile change encoding.
+const tileChangesVersion = 1
+
+// TileChange is a single change of a tile of a tile layer.
+type TileChange struct {
+	// The name of the layer, and the coordinates of the tile.
+	Layer string
+	Coord Coord
+
+	// The gid of the tile before and after the change (including flip flags).
+	Old, New uint32
+}
+
+// tileChangeKey identifies the tile of a change.
+type tileChangeKey struct {
+	layer string
+	c     Coord
+}
+
+// TileChanges records the changes made to the tiles of a map, for instance
+// such that multiplayer games with mutable terrain can send them to other
+// players (see EncodeTileChanges). Repeated changes of a tile are coalesced
+// into a single change, and changes which restore a tile to it's original gid
+// are dropped.
+//
+// The zero value is an empty set of changes ready for use. A TileChanges is
+// not safe for use by multiple goroutines at once.
+type TileChanges struct {
+	changes []TileChange
+	index   map[tileChangeKey]int
+}
+
+// Record records a change of the tile at the given coordinates of the named
+// layer from the old to the given gid.
+func (t *TileChanges) Record(layer string, c Coord, old, gid uint32) {
+	k := tileChangeKey{layer, c}
+	if i, ok := t.index[k]; ok {
+		t.changes[i].New = gid
+		return
+	}
+	if t.index == nil {
+		t.index = make(map[tileChangeKey]int)
+	}
+	t.index[k] = len(t.changes)
+	t.changes = append(t.changes, TileChange{layer, c, old, gid})
+}
+
+// SetTile sets the gid at the given coordinates of the layer (see
+// Layer.SetTile) and records the change.
+func (t *TileChanges) SetTile(l *Layer, c Coord, gid uint32) {
+	old := l.Tile(c)
+	l.SetTile(c, gid)
+	t.Record(l.Name, c, old, gid)
+}
+
+// Changes returns the recorded changes, in the order that the tiles were first
+// changed.
+func (t *TileChanges) Changes() []TileChange {
+	var changes []TileChange
+	for _, c := range t.changes {
+		if c.Old != c.New {
+			changes = append(changes, c)
+		}
+	}
+	return changes
+}
+
+// Reset forgets all of the recorded changes, for instance once they were sent.
+func (t *TileChanges) Reset() {
+	t.changes = t.changes[:0]
+	t.index = nil
+}
+
+// zigzag maps signed integers to unsigned ones, such that small negative
+// values stay small.
+func zigzag(v int64) uint64 {
+	return uint64(v<<1) ^ uint64(v>>63)
+}
+
+// unzigzag is the inverse of zigzag.
+func unzigzag(v uint64) int64 {
+	return int64(v>>1) ^ -int64(v&1)
+}
+
+// EncodeTileChanges encodes the given changes compactly, for sending them over
+// the network. Layer names are stored once, and coordinates relative to those
+// of the previous change, so changes of nearby tiles take only a few bytes
+// each.
+func EncodeTileChanges(changes []TileChange) []byte {
+	var buf []byte
+	var tmp [binary.MaxVarintLen64]byte
+	put := func(v uint64) {
+		n := binary.PutUvarint(tmp[:], v)
+		buf = append(buf, tmp[:n]...)
+	}
+	buf = append(buf, tileChangesVersion)
+
+	// The table of layer names.
+	var names []string
+	layers := make(map[string]int)
+	for _, c := range changes {
+		if _, ok := layers[c.Layer]; !ok {
+			layers[c.Layer] = len(names)
+			names = append(names, c.Layer)
+		}
+	}
+	put(uint64(len(names)))
+	for _, name := range names {
+		put(uint64(len(name)))
+		buf = append(buf, name...)
+	}
+
+	put(uint64(len(changes)))
+	var prev Coord
+	for _, c := range changes {
+		put(uint64(layers[c.Layer]))
+		put(zigzag(int64(c.Coord.X - prev.X)))
+		put(zigzag(int64(c.Coord.Y - prev.Y)))
+		put(uint64(c.Old))
+		put(uint64(c.New))
+		prev = c.Coord
+	}
+	return buf
+}
+
+// DecodeTileChanges decodes the changes encoded by EncodeTileChanges. If the
+// data is invalid, ErrBadTileChanges is returned.
+func DecodeTileChanges(data []byte) ([]TileChange, error) {
+	if len(data) == 0 || data[0] != tileChangesVersion {
+		return nil, ErrBadTileChanges
+	}
+	data = data[1:]
+	bad := false
+	get := func() uint64 {
+		v, n := binary.Uvarint(data)
+		if n <= 0 {
+			bad = true
+			return 0
+		}
+		data = data[n:]
+		return v
+	}
+
+	n := get()
+	if bad || n > uint64(len(data)) {
+		return nil, ErrBadTileChanges
+	}
+	names := make([]string, n)
+	for i := range names {
+		size := get()
+		if bad || size > uint64(len(data)) {
+			return nil, ErrBadTileChanges
+		}
+		names[i] = string(data[:size])
+		data = data[size:]
+	}
+
+	n = get()
+	if bad || n > uint64(len(data)) {
+		return nil, ErrBadTileChanges
+	}
+	changes := make([]TileChange, n)
+	var prev Coord
+	for i := range changes {
+		layer := get()
+		dx, dy := unzigzag(get()), unzigzag(get())
+		from, to := get(), get()
+		if bad || layer >= uint64(len(names)) || from > 0xFFFFFFFF || to > 0xFFFFFFFF {
+			return nil, ErrBadTileChanges
+		}
+		prev = Coord{prev.X + int(dx), prev.Y + int(dy)}
+		changes[i] = TileChange{names[layer], prev, uint32(from), uint32(to)}
+	}
+	if len(data) != 0 {
+		return nil, ErrBadTileChanges
+	}
+	return changes, nil
+}
+
+// ApplyTileChanges applies the given changes to the tiles of the map, using
+// Layer.SetTile such that the changed tiles are marked dirty (see
+// RebuildDirty).
+//
+// An error is returned, and no changes are applied, if a layer does not exist
+// or the tile of a change does not have it's old gid (I.e. the map diverged
+// from that which the changes were recorded on).
+func ApplyTileChanges(m *Map, changes []TileChange) error {
+	// The expected gid of each changed tile, as earlier changes apply.
+	current := make(map[tileChangeKey]uint32)
+	for _, c := range changes {
+		l := m.FindLayer(c.Layer)
+		if l == nil {
+			return fmt.Errorf("tile change of unknown layer %q", c.Layer)
+		}
+		k := tileChangeKey{c.Layer, c.Coord}
+		gid, ok := current[k]
+		if !ok {
+			gid = l.Tile(c.Coord)
+		}
+		if gid != c.Old {
+			return fmt.Errorf("layer %q: tile %v is %d, not %d", c.Layer, c.Coord, gid, c.Old)
+		}
+		current[k] = c.New
+	}
+	for _, c := range changes {
+		m.FindLayer(c.Layer).SetTile(c.Coord, c.New)
+	}
+	return nil
+}
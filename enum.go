@@ -0,0 +1,117 @@
+// Copyright 2014 Lightpoke. All rights reserved.
+// This source code is subject to the terms and
+// conditions defined in the "License.txt" file.
+
+package tmx
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// enumValues returns the names of the values held by the given property value
+// of the enum t.
+func (t *PropertyType) enumValues(value string) ([]string, error) {
+	if t.StorageType == "int" {
+		n, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("enum %q: invalid value %q", t.Name, value)
+		}
+		if !t.ValuesAsFlags {
+			if n >= uint64(len(t.Values)) {
+				return nil, fmt.Errorf("enum %q: value %d out of range", t.Name, n)
+			}
+			return []string{t.Values[n]}, nil
+		}
+		var names []string
+		for i := uint(0); n != 0; i++ {
+			if n&1 != 0 {
+				if i >= uint(len(t.Values)) {
+					return nil, fmt.Errorf("enum %q: flag %d out of range", t.Name, i)
+				}
+				names = append(names, t.Values[i])
+			}
+			n >>= 1
+		}
+		return names, nil
+	}
+
+	names := []string{value}
+	if t.ValuesAsFlags {
+		names = nil
+		if len(value) > 0 {
+			names = strings.Split(value, ",")
+		}
+	}
+	for _, name := range names {
+		found := false
+		for _, v := range t.Values {
+			if v == name {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("enum %q: unknown value %q", t.Name, name)
+		}
+	}
+	return names, nil
+}
+
+// DecodeEnum decodes the given property value of the named enum into one of
+// the given Go constants, and stores it in dst. The constants must be a map
+// from the names of the enum's values to the corresponding constants, and dst
+// a pointer to a variable of their type, for instance:
+//  var facing Direction
+//  err := project.DecodeEnum("Direction", obj.Properties["facing"], map[string]Direction{
+//      "north": North,
+//      "south": South,
+//  }, &facing)
+//
+// For enums whose values are flags, the constants of all of the values held
+// by the property are bitwise OR'ed together, so they must be integers.
+//
+// An error is returned if the enum is not known, if the property value is not
+// one of the enum's values (or out of range for enums stored as integers), or
+// if there is no constant for it.
+func (p *Project) DecodeEnum(enum, value string, constants, dst interface{}) error {
+	t := p.FindPropertyType(enum)
+	if t == nil || t.Type != "enum" {
+		return fmt.Errorf("unknown enum %q", enum)
+	}
+	cv, dv := reflect.ValueOf(constants), reflect.ValueOf(dst)
+	if cv.Kind() != reflect.Map || cv.Type().Key().Kind() != reflect.String {
+		return fmt.Errorf("enum %q: constants must be a map with string keys, not %T", enum, constants)
+	}
+	if dv.Kind() != reflect.Ptr || dv.IsNil() || !cv.Type().Elem().AssignableTo(dv.Elem().Type()) {
+		return fmt.Errorf("enum %q: cannot store %v constants in %T", enum, cv.Type().Elem(), dst)
+	}
+	names, err := t.enumValues(value)
+	if err != nil {
+		return err
+	}
+
+	out := reflect.New(dv.Elem().Type()).Elem()
+	for _, name := range names {
+		c := cv.MapIndex(reflect.ValueOf(name).Convert(cv.Type().Key()))
+		if !c.IsValid() {
+			return fmt.Errorf("enum %q: no constant for value %q", enum, name)
+		}
+		if !t.ValuesAsFlags {
+			out.Set(c)
+			continue
+		}
+		switch out.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			out.SetInt(out.Int() | c.Int())
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+			out.SetUint(out.Uint() | c.Uint())
+		default:
+			return fmt.Errorf("enum %q: flags cannot be combined into %v", enum, out.Type())
+		}
+	}
+	dv.Elem().Set(out)
+	return nil
+}
@@ -0,0 +1,145 @@
+// Copyright 2014 The Azul3D Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tmx
+
+import (
+	"time"
+
+	"azul3d.org/gfx.v2-unstable"
+)
+
+// Faders animates the opacity of the objects created by Load for each layer,
+// for instance to fade out the roof of a building when the player walks inside
+// of it.
+//
+// Opacities are relative to the opacity that the layer was loaded with, such
+// that an opacity of one shows the layer as it is in the map and zero hides it
+// entirely. Fading relies on the vertex colors of the objects, which Load
+// enables for all layers when Config.Faders is set.
+//
+// A Faders is not safe for use by multiple goroutines at once.
+type Faders struct {
+	layers map[string]*fader
+}
+
+// fader is the fade of a single layer.
+type fader struct {
+	objects []*gfx.Object
+
+	// The original vertex colors of the object's meshes, once they are known.
+	base [][]gfx.Color
+
+	// The current opacity, the opacity faded from and to, and the progress of
+	// the fade.
+	opacity, from, to float64
+	elapsed, duration time.Duration
+}
+
+// add adds the given object, created for the named layer.
+func (f *Faders) add(layer string, obj *gfx.Object) {
+	if f.layers == nil {
+		f.layers = make(map[string]*fader)
+	}
+	fd, ok := f.layers[layer]
+	if !ok {
+		fd = &fader{opacity: 1, from: 1, to: 1}
+		f.layers[layer] = fd
+	}
+
+	// The vertex colors must stay around for us to modify them.
+	obj.Meshes[0].KeepDataOnLoad = true
+	fd.objects = append(fd.objects, obj)
+	fd.base = append(fd.base, nil)
+}
+
+// Fade starts fading the named layer from it's current opacity to the given
+// one over the given duration, or immediately if the duration is zero.
+// Unknown layers are ignored.
+func (f *Faders) Fade(layer string, opacity float64, d time.Duration) {
+	fd, ok := f.layers[layer]
+	if !ok {
+		return
+	}
+	fd.from, fd.to = fd.opacity, opacity
+	fd.elapsed, fd.duration = 0, d
+	if d <= 0 {
+		fd.opacity = opacity
+		fd.apply()
+	}
+}
+
+// Opacity returns the current opacity of the named layer, or one if it is not
+// known.
+func (f *Faders) Opacity(layer string) float64 {
+	if fd, ok := f.layers[layer]; ok {
+		return fd.opacity
+	}
+	return 1
+}
+
+// Animate advances the fading of all the layers by the given amount of time,
+// typically the time since the last frame.
+func (f *Faders) Animate(dt time.Duration) {
+	for _, fd := range f.layers {
+		if fd.opacity == fd.to {
+			continue
+		}
+		fd.elapsed += dt
+		if fd.elapsed >= fd.duration {
+			fd.opacity = fd.to
+		} else {
+			t := float64(fd.elapsed) / float64(fd.duration)
+			fd.opacity = fd.from + (fd.to-fd.from)*t
+		}
+		fd.apply()
+	}
+}
+
+// rebuilt tells that the meshes of the given objects of the named layer were
+// rebuilt, such that their original vertex colors must be found again, and
+// applies the layer's current opacity to them.
+func (f *Faders) rebuilt(layer string, objects []*gfx.Object) {
+	fd, ok := f.layers[layer]
+	if !ok {
+		return
+	}
+	for _, obj := range objects {
+		for i, o := range fd.objects {
+			if o == obj {
+				fd.base[i] = nil
+			}
+		}
+	}
+	if fd.opacity != 1 {
+		fd.apply()
+	}
+}
+
+// apply updates the vertex colors of the layer's objects to it's current
+// opacity.
+func (fd *fader) apply() {
+	for i, obj := range fd.objects {
+		obj.RLock()
+		if len(obj.Meshes) == 0 {
+			obj.RUnlock()
+			continue
+		}
+		mesh := obj.Meshes[0]
+		obj.RUnlock()
+
+		mesh.Lock()
+		if fd.base[i] == nil {
+			fd.base[i] = append([]gfx.Color(nil), mesh.Colors...)
+		}
+		for j, c := range fd.base[i] {
+			if j < len(mesh.Colors) {
+				c.A *= float32(fd.opacity)
+				mesh.Colors[j] = c
+			}
+		}
+		mesh.ColorsChanged = true
+		mesh.Unlock()
+	}
+}
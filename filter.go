@@ -0,0 +1,74 @@
+// Copyright 2014 The Azul3D Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tmx
+
+import (
+	"fmt"
+
+	"azul3d.org/gfx.v2-unstable"
+)
+
+// TextureFiltering describes how the textures of tileset (and image layer)
+// images are filtered, see Config.Filtering.
+type TextureFiltering struct {
+	// Whether mipmaps are requested for textures, such that they are filtered
+	// smoothly when minified (for instance when zoomed out).
+	Mipmaps bool
+
+	// Whether textures are sampled from their nearest texel (and mipmap, if
+	// any) instead of linearly, which keeps pixel art crisp.
+	Nearest bool
+
+	// The maximum anisotropy, if greater than one textures are sampled up to
+	// this many times along the major axis of each fragment's footprint, which
+	// keeps textures viewed at grazing angles (for instance by perspective
+	// cameras tilted towards the horizon) sharp. The gfx package does not
+	// expose anisotropic filtering, so it is done by the shaders. Objects
+	// using a palette (see Config.Palette) are not filtered anisotropically,
+	// as averaging their indices would not make sense.
+	MaxAnisotropy int
+
+	// The bias added to the level of detail that the mipmaps of textures are
+	// sampled at, negative values sharpen textures and positive ones blur them.
+	LODBias float64
+}
+
+// DefaultFiltering is the default texture filtering, trilinear filtering.
+var DefaultFiltering = TextureFiltering{Mipmaps: true}
+
+// filtering returns the texture filtering of the configuration.
+func (ld *loader) filtering() *TextureFiltering {
+	if ld.c.Filtering != nil {
+		return ld.c.Filtering
+	}
+	return &DefaultFiltering
+}
+
+// apply applies the filtering to the given texture.
+func (f *TextureFiltering) apply(t *gfx.Texture) {
+	switch {
+	case f.Nearest && f.Mipmaps:
+		t.MinFilter, t.MagFilter = gfx.NearestMipmapNearest, gfx.Nearest
+	case f.Nearest:
+		t.MinFilter, t.MagFilter = gfx.Nearest, gfx.Nearest
+	case f.Mipmaps:
+		t.MinFilter, t.MagFilter = gfx.LinearMipmapLinear, gfx.Linear
+	default:
+		t.MinFilter, t.MagFilter = gfx.Linear, gfx.Linear
+	}
+}
+
+// features returns the shader features implementing the filtering, for
+// objects with or without a palette.
+func (f *TextureFiltering) features(palette bool) []string {
+	var features []string
+	if f.MaxAnisotropy > 1 && !palette {
+		features = append(features, fmt.Sprintf("ANISOTROPY %d", f.MaxAnisotropy))
+	}
+	if f.LODBias != 0 {
+		features = append(features, fmt.Sprintf("LOD_BIAS %f", f.LODBias))
+	}
+	return features
+}
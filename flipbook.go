@@ -0,0 +1,123 @@
+// Copyright 2014 The Azul3D Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tmx
+
+import (
+	"fmt"
+	"image"
+	"image/draw"
+	"time"
+
+	"azul3d.org/gfx.v2-unstable"
+)
+
+// Flipbooks animates the animated tiles (see Tile.Animation) of the maps loaded
+// by Load. All of the frames of each animated tile are baked into a dedicated
+// strip texture, such that advancing an animation only updates a single shader
+// input instead of rewriting meshes or texture coordinates each frame.
+//
+// All instances of an animated tile play in sync, like they do in Tiled.
+//
+// A Flipbooks is not safe for use by multiple goroutines at once.
+type Flipbooks struct {
+	books map[flipbookKey]*flipbook
+	order []*flipbook
+}
+
+// flipbookKey identifies an animated tile.
+type flipbookKey struct {
+	ts *Tileset
+	id int
+}
+
+// flipbook is a single animated tile, whose frames are laid out from left to
+// right in a strip image.
+type flipbook struct {
+	name    string
+	frames  []Frame
+	total   time.Duration
+	elapsed time.Duration
+	strip   *image.RGBA
+
+	// Map of shader variants and the copies of them used by this flipbook,
+	// such that each animated tile has it's own shader inputs.
+	shaders map[*gfx.Shader]*gfx.Shader
+}
+
+// book returns the flipbook for the tile with the given gid of the tileset,
+// whose image is the given one, creating it if needed. If the tile is not
+// animated nil is returned.
+func (f *Flipbooks) book(m *Map, ts *Tileset, name string, rgba *image.RGBA, gid uint32) *flipbook {
+	t := m.TilesetTile(ts, gid)
+	if t == nil || len(t.Animation) == 0 {
+		return nil
+	}
+	key := flipbookKey{ts, t.ID}
+	if b, ok := f.books[key]; ok {
+		return b
+	}
+
+	b := &flipbook{
+		name:    fmt.Sprintf("%s#%d", name, t.ID),
+		frames:  t.Animation,
+		strip:   image.NewRGBA(image.Rect(0, 0, ts.Width*len(t.Animation), ts.Height)),
+		shaders: make(map[*gfx.Shader]*gfx.Shader),
+	}
+	for i, frame := range t.Animation {
+		b.total += frame.Duration
+		r := m.TilesetRect(ts, rgba.Bounds().Dx(), rgba.Bounds().Dy(), true, ts.Firstgid+uint32(frame.TileID))
+		dst := image.Rect(i*ts.Width, 0, (i+1)*ts.Width, ts.Height)
+		draw.Draw(b.strip, dst, rgba, r.Min, draw.Src)
+	}
+	if f.books == nil {
+		f.books = make(map[flipbookKey]*flipbook)
+	}
+	f.books[key] = b
+	f.order = append(f.order, b)
+	return b
+}
+
+// shader returns this flipbook's copy of the given shader variant, which must
+// have the FLIPBOOK feature enabled.
+func (b *flipbook) shader(variant *gfx.Shader) *gfx.Shader {
+	if s, ok := b.shaders[variant]; ok {
+		return s
+	}
+	// Keep the inputs of the variant, e.g. those of graded shaders.
+	s := &gfx.Shader{
+		Name:   variant.Name + "/" + b.name,
+		GLSL:   variant.GLSL,
+		Inputs: make(map[string]interface{}, len(variant.Inputs)+1),
+	}
+	for k, v := range variant.Inputs {
+		s.Inputs[k] = v
+	}
+	s.Inputs["FlipbookOffset"] = float32(0)
+	b.shaders[variant] = s
+	return s
+}
+
+// Animate advances the animation of all the animated tiles by the given amount
+// of time, typically the time since the last frame.
+func (f *Flipbooks) Animate(dt time.Duration) {
+	for _, b := range f.order {
+		if b.total <= 0 {
+			continue
+		}
+		b.elapsed = (b.elapsed + dt) % b.total
+
+		// Find the current frame.
+		var frame int
+		for t := b.elapsed; t >= b.frames[frame].Duration; frame++ {
+			t -= b.frames[frame].Duration
+		}
+		offset := float32(frame) / float32(len(b.frames))
+		for _, s := range b.shaders {
+			s.Lock()
+			s.Inputs["FlipbookOffset"] = offset
+			s.Unlock()
+		}
+	}
+}
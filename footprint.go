@@ -0,0 +1,170 @@
+// Copyright 2014 The Azul3D Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tmx
+
+import (
+	"image"
+
+	"azul3d.org/gfx.v2-unstable"
+)
+
+// Estimated sizes, in bytes, of the values stored by maps.
+const (
+	// A string header, and the overhead of a single map entry (it's share of
+	// the buckets and hashes, at the average load factor).
+	stringBytes   = 16
+	mapEntryBytes = 16
+
+	// A Coord key and uint32 gid entry of Layer.Tiles, and a run of
+	// Layer.RLE.
+	tileEntryBytes = 16 + 4 + mapEntryBytes
+	tileRunBytes   = 16
+
+	// An object (excluding it's strings, points, text and properties) and a
+	// point of a polygon or polyline.
+	objectBytes = 192
+	pointBytes  = 16
+
+	// A vertex (or barycentric coordinate), color, texture coordinate and
+	// index of a mesh.
+	vertexBytes   = 12
+	colorBytes    = 16
+	texCoordBytes = 8
+	indexBytes    = 4
+)
+
+// Footprint is an estimate of the memory used by a map (see
+// Map.MemoryFootprint) or by the objects loaded for it (see LayersFootprint),
+// in bytes, for budgeting content.
+type Footprint struct {
+	// The tile storage of tile layers (Layer.Tiles or Layer.RLE).
+	Tiles int64
+
+	// The objects of object groups, including their points and text.
+	Objects int64
+
+	// The custom properties of the map and of everything within it.
+	Properties int64
+
+	// The vertex data of loaded meshes, and the pixel data of the source
+	// images of their textures. Textures shared by several objects are only
+	// counted once.
+	Meshes, Textures int64
+}
+
+// Add returns the sum of both footprints.
+func (f Footprint) Add(g Footprint) Footprint {
+	return Footprint{
+		Tiles:      f.Tiles + g.Tiles,
+		Objects:    f.Objects + g.Objects,
+		Properties: f.Properties + g.Properties,
+		Meshes:     f.Meshes + g.Meshes,
+		Textures:   f.Textures + g.Textures,
+	}
+}
+
+// Total returns the total number of bytes of the footprint.
+func (f Footprint) Total() int64 {
+	return f.Tiles + f.Objects + f.Properties + f.Meshes + f.Textures
+}
+
+// propertiesBytes returns the estimated size of the given properties.
+func propertiesBytes(props map[string]string) int64 {
+	n := int64(0)
+	for k, v := range props {
+		n += 2*stringBytes + mapEntryBytes + int64(len(k)+len(v))
+	}
+	return n
+}
+
+// MemoryFootprint returns an estimate of the memory used by the tiles and
+// properties of this layer, which is useful to catch accidentally huge layers.
+func (l *Layer) MemoryFootprint() Footprint {
+	f := Footprint{Properties: propertiesBytes(l.Properties)}
+	if l.RLE != nil {
+		f.Tiles = int64(len(l.RLE.runs)) * tileRunBytes
+	} else {
+		f.Tiles = int64(len(l.Tiles)) * tileEntryBytes
+	}
+	return f
+}
+
+// MemoryFootprint returns an estimate of the memory used by the tile storage,
+// objects and properties of this map (see Footprint). It is an estimate only,
+// as the exact size of Go's maps and allocations cannot be known.
+func (m *Map) MemoryFootprint() Footprint {
+	f := Footprint{Properties: propertiesBytes(m.Properties)}
+	for _, ts := range m.Tilesets {
+		f.Properties += propertiesBytes(ts.Properties)
+		for _, t := range ts.Tiles {
+			f.Properties += propertiesBytes(t.Properties)
+		}
+	}
+	for _, l := range m.Layers {
+		f = f.Add(l.MemoryFootprint())
+	}
+	for _, g := range m.ObjectGroups {
+		f.Properties += propertiesBytes(g.Properties)
+		for _, o := range g.Objects {
+			f.Properties += propertiesBytes(o.Properties)
+			f.Objects += objectBytes + int64(len(o.Name)+len(o.Class))
+			switch v := o.Value.(type) {
+			case *Polygon:
+				f.Objects += int64(len(v.Points)) * pointBytes
+			case *Polyline:
+				f.Objects += int64(len(v.Points)) * pointBytes
+			case *Text:
+				f.Objects += int64(len(v.Text) + len(v.FontFamily))
+			}
+		}
+	}
+	for _, l := range m.ImageLayers {
+		f.Properties += propertiesBytes(l.Properties)
+	}
+	for _, g := range m.Groups {
+		f.Properties += propertiesBytes(g.Properties)
+	}
+	return f
+}
+
+// LayersFootprint returns an estimate of the memory used by the meshes and
+// textures of the given layers, as returned by Load. Only the data that is
+// still held in memory is counted (I.e. not that of meshes and textures which
+// were loaded by the renderer and then freed).
+func LayersFootprint(layers ...map[string]map[string]*gfx.Object) Footprint {
+	var f Footprint
+	textures := make(map[*gfx.Texture]bool)
+	for _, l := range layers {
+		for _, objects := range l {
+			for _, obj := range objects {
+				obj.RLock()
+				for _, mesh := range obj.Meshes {
+					mesh.RLock()
+					f.Meshes += int64(len(mesh.Vertices)+len(mesh.Bary)) * vertexBytes
+					f.Meshes += int64(len(mesh.Colors)) * colorBytes
+					f.Meshes += int64(len(mesh.Indices)) * indexBytes
+					for _, set := range mesh.TexCoords {
+						f.Meshes += int64(len(set.Slice)) * texCoordBytes
+					}
+					mesh.RUnlock()
+				}
+				for _, t := range obj.Textures {
+					if textures[t] {
+						continue
+					}
+					textures[t] = true
+					if rgba, ok := t.Source.(*image.RGBA); ok {
+						f.Textures += int64(len(rgba.Pix))
+					} else if t.Source != nil {
+						b := t.Source.Bounds()
+						f.Textures += int64(b.Dx()*b.Dy()) * 4
+					}
+				}
+				obj.RUnlock()
+			}
+		}
+	}
+	return f
+}
@@ -0,0 +1,154 @@
+// Copyright 2014 Lightpoke. All rights reserved.
+// This source code is subject to the terms and
+// conditions defined in the "License.txt" file.
+
+package tmx
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"sort"
+)
+
+// GeoJSONConfig represents a configuration used when exporting the geometry
+// of a map as GeoJSON.
+type GeoJSONConfig struct {
+	// Whether or not invisible object groups and objects are exported.
+	Invisible bool
+
+	// Map of tile layer names to functions deciding which of their tiles are
+	// solid. The collision outline of each such layer (see Map.Occluders) is
+	// exported as a single feature.
+	Collision map[string]func(gid uint32) bool
+}
+
+type geoJSONGeometry struct {
+	Type        string      `json:"type"`
+	Coordinates interface{} `json:"coordinates"`
+}
+
+type geoJSONFeature struct {
+	Type       string                 `json:"type"`
+	Geometry   geoJSONGeometry        `json:"geometry"`
+	Properties map[string]interface{} `json:"properties"`
+}
+
+type geoJSONCollection struct {
+	Type     string           `json:"type"`
+	Features []geoJSONFeature `json:"features"`
+}
+
+// geoJSONPoints converts the given points to GeoJSON positions, closing the
+// ring if needed.
+func geoJSONPoints(points []Pixel, closed bool) [][2]float64 {
+	coords := make([][2]float64, 0, len(points)+1)
+	for _, p := range points {
+		coords = append(coords, [2]float64{p.X, p.Y})
+	}
+	if closed && len(points) > 0 {
+		coords = append(coords, coords[0])
+	}
+	return coords
+}
+
+// geoJSONObject returns the GeoJSON feature of the given object of the object
+// group g.
+func geoJSONObject(g *ObjectGroup, obj *Object) geoJSONFeature {
+	f := geoJSONFeature{
+		Type: "Feature",
+		Properties: map[string]interface{}{
+			"name":       obj.Name,
+			"class":      obj.Class,
+			"layer":      g.Name,
+			"properties": obj.Properties,
+		},
+	}
+	points, closed := g.Outline(obj)
+	switch {
+	case len(points) == 0:
+		ox, oy := g.EffectiveOffset()
+		f.Geometry = geoJSONGeometry{"Point", [2]float64{float64(obj.X) + ox, float64(obj.Y) + oy}}
+	case closed:
+		f.Geometry = geoJSONGeometry{"Polygon", [][][2]float64{geoJSONPoints(points, true)}}
+	default:
+		f.Geometry = geoJSONGeometry{"LineString", geoJSONPoints(points, false)}
+	}
+	return f
+}
+
+// WriteGeoJSON writes the geometry of the given map, m, to w as a GeoJSON
+// feature collection, such that external tools can consume it without a TMX
+// parser.
+//
+// Each object of the map's object groups becomes a feature whose geometry is
+// it's outline (see ObjectGroup.Outline): closed outlines are polygons,
+// polylines are line strings and point objects are points. The properties of
+// each feature are the object's "name", "class", the "layer" (I.e. the object
+// group's name) and the object's own "properties".
+//
+// The collision outlines of the tile layers given by the configuration follow,
+// as multi line string features with the "layer" and a "class" of
+// "collision".
+//
+// Coordinates are in pixels in the world space of the map, where +Y is down
+// (just like in Tiled).
+//
+// If the configuration, c, is nil then the default configuration is used (the
+// default configuration is simply the zero value of GeoJSONConfig).
+func WriteGeoJSON(w io.Writer, m *Map, c *GeoJSONConfig) error {
+	if c == nil {
+		c = new(GeoJSONConfig)
+	}
+	fc := geoJSONCollection{Type: "FeatureCollection", Features: []geoJSONFeature{}}
+	for _, g := range m.ObjectGroups {
+		if !c.Invisible && !g.EffectiveVisible() {
+			continue
+		}
+		for _, obj := range g.Objects {
+			if !c.Invisible && !obj.Visible {
+				continue
+			}
+			fc.Features = append(fc.Features, geoJSONObject(g, obj))
+		}
+	}
+
+	// Collision layers are exported in order of their names, such that the
+	// output is deterministic.
+	names := make([]string, 0, len(c.Collision))
+	for name := range c.Collision {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if m.FindLayer(name) == nil {
+			continue
+		}
+		lines := [][][2]float64{}
+		for _, e := range m.Occluders(name, c.Collision[name]) {
+			lines = append(lines, [][2]float64{{e.A.X, e.A.Y}, {e.B.X, e.B.Y}})
+		}
+		fc.Features = append(fc.Features, geoJSONFeature{
+			Type:     "Feature",
+			Geometry: geoJSONGeometry{"MultiLineString", lines},
+			Properties: map[string]interface{}{
+				"class": "collision",
+				"layer": name,
+			},
+		})
+	}
+
+	e := json.NewEncoder(w)
+	e.SetIndent("", " ")
+	return e.Encode(fc)
+}
+
+// MarshalGeoJSON works just like WriteGeoJSON except it returns the GeoJSON
+// data.
+func MarshalGeoJSON(m *Map, c *GeoJSONConfig) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	if err := WriteGeoJSON(buf, m, c); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -13,11 +13,21 @@
 package tmx
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"hash"
 	"image"
 	"image/draw"
 	"io/ioutil"
+	"math"
 	"os"
 	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	"azul3d.org/gfx.v2-unstable"
 	"azul3d.org/lmath.v1"
@@ -29,6 +39,17 @@ var (
 
 attribute vec3 Vertex;
 attribute vec2 TexCoord0;
+#ifdef VERTEX_COLOR
+attribute vec4 Color;
+varying vec4 color;
+#endif
+#ifdef LIGHTMAP
+attribute vec2 TexCoord1;
+varying vec2 tc1;
+#endif
+#ifdef FLIPBOOK
+uniform float FlipbookOffset;
+#endif
 
 uniform mat4 MVP;
 
@@ -37,6 +58,15 @@ varying vec2 tc0;
 void main()
 {
 	tc0 = TexCoord0;
+#ifdef FLIPBOOK
+	tc0.x += FlipbookOffset;
+#endif
+#ifdef VERTEX_COLOR
+	color = Color;
+#endif
+#ifdef LIGHTMAP
+	tc1 = TexCoord1;
+#endif
 	gl_Position = MVP * vec4(Vertex, 1.0);
 }
 `)
@@ -45,13 +75,87 @@ void main()
 #version 120
 
 varying vec2 tc0;
+#ifdef VERTEX_COLOR
+varying vec4 color;
+#endif
+#ifdef LIGHTMAP
+varying vec2 tc1;
+uniform sampler2D Texture1;
+#endif
+#ifdef PALETTE
+#ifdef LIGHTMAP
+uniform sampler2D Texture2;
+#define Palette Texture2
+#else
+uniform sampler2D Texture1;
+#define Palette Texture1
+#endif
+#endif
+
+#ifdef GRADING
+uniform float Saturation;
+uniform float Brightness;
+uniform vec4 Tint;
+#endif
 
 uniform sampler2D Texture0;
 uniform bool BinaryAlpha;
 
+#ifndef LOD_BIAS
+#define LOD_BIAS 0.0
+#endif
+
+vec4 sample0(vec2 tc)
+{
+#ifdef ANISOTROPY
+	// Average up to ANISOTROPY samples along the major axis of the fragment's
+	// footprint, each at the level of detail of it's minor axis.
+	vec2 dx = dFdx(tc);
+	vec2 dy = dFdy(tc);
+	float lx = length(dx);
+	float ly = length(dy);
+	vec2 axis = lx > ly ? dx : dy;
+	float major = max(max(lx, ly), 1e-8);
+	float minor = max(min(lx, ly), 1e-8);
+	float n = clamp(ceil(major / minor), 1.0, float(ANISOTROPY));
+	float bias = log2(max(major / n, minor) / major) + LOD_BIAS;
+	vec4 sum = vec4(0.0);
+	for(int i = 0; i < ANISOTROPY; i++) {
+		if(float(i) >= n) {
+			break;
+		}
+		sum += texture2D(Texture0, tc + axis * ((float(i) + 0.5) / n - 0.5), bias);
+	}
+	return sum / n;
+#else
+	return texture2D(Texture0, tc, LOD_BIAS);
+#endif
+}
+
 void main()
 {
-	gl_FragColor = texture2D(Texture0, tc0);
+	gl_FragColor = sample0(tc0);
+#ifdef PALETTE
+	float index = (gl_FragColor.r * 255.0 + 0.5) / 256.0;
+	gl_FragColor = vec4(1.0, 1.0, 1.0, gl_FragColor.a) * texture2D(Palette, vec2(index, 0.5));
+#endif
+#ifdef SRGB
+	vec3 c = gl_FragColor.rgb;
+	gl_FragColor.rgb = mix(pow((c + 0.055) / 1.055, vec3(2.4)), c / 12.92, step(c, vec3(0.04045)));
+#endif
+#ifdef VERTEX_COLOR
+	gl_FragColor *= color;
+#endif
+#ifdef LIGHTMAP
+	gl_FragColor.rgb *= texture2D(Texture1, tc1).rgb;
+#endif
+#ifdef GRADING
+	float luma = dot(gl_FragColor.rgb, vec3(0.299, 0.587, 0.114));
+	gl_FragColor.rgb = mix(vec3(luma), gl_FragColor.rgb, Saturation) * Brightness + Tint.rgb;
+#endif
+#ifdef PREMULTIPLY
+	gl_FragColor.rgb *= gl_FragColor.a;
+#endif
 	if(BinaryAlpha && gl_FragColor.a < 0.5) {
 		discard;
 	}
@@ -64,14 +168,66 @@ var (
 	Shader                           *gfx.Shader
 )
 
-func init() {
-	Shader = &gfx.Shader{
-		Name: "tmx.Shader",
+// Shader features, which are enabled in shader variants through preprocessor
+// defines of the same name.
+const (
+	featureVertexColor = "VERTEX_COLOR"
+	featureLightmap    = "LIGHTMAP"
+	featurePremultiply = "PREMULTIPLY"
+	featureFlipbook    = "FLIPBOOK"
+	featurePalette     = "PALETTE"
+	featureGrading     = "GRADING"
+	featureSRGB        = "SRGB"
+)
+
+var (
+	shadersLock sync.Mutex
+	shaders     = make(map[string]*gfx.Shader)
+)
+
+// shaderVariant returns the shader with the given features enabled, creating
+// and caching it if needed such that objects using the same features share a
+// single shader.
+func shaderVariant(features ...string) *gfx.Shader {
+	sort.Strings(features)
+	key := strings.Join(features, "+")
+
+	shadersLock.Lock()
+	defer shadersLock.Unlock()
+	if s, ok := shaders[key]; ok {
+		return s
+	}
+
+	// Insert the defines directly after the #version line.
+	var defines bytes.Buffer
+	for _, f := range features {
+		fmt.Fprintf(&defines, "#define %s\n", f)
+	}
+	withDefines := func(src []byte) []byte {
+		i := bytes.Index(src, []byte("#version"))
+		i += bytes.IndexByte(src[i:], '\n') + 1
+		out := make([]byte, 0, len(src)+defines.Len())
+		out = append(out, src[:i]...)
+		out = append(out, defines.Bytes()...)
+		return append(out, src[i:]...)
+	}
+	name := "tmx.Shader"
+	if len(key) > 0 {
+		name += "+" + key
+	}
+	s := &gfx.Shader{
+		Name: name,
 		GLSL: &gfx.GLSLSources{
-			Vertex:   glslVert,
-			Fragment: glslFrag,
+			Vertex:   withDefines(glslVert),
+			Fragment: withDefines(glslFrag),
 		},
 	}
+	shaders[key] = s
+	return s
+}
+
+func init() {
+	Shader = shaderVariant()
 
 	// Setup rotations
 	cw90 = lmath.Mat4FromAxisAngle(
@@ -101,6 +257,20 @@ func init() {
 	)
 }
 
+// texRect returns the texture coordinates of the given rectangle within a
+// texture with the given bounds.
+func texRect(rect, tex image.Rectangle) (u0, v0, u1, v1 float32) {
+	w := float32(tex.Dx())
+	h := float32(tex.Dy())
+	halfTexUnitX := 1.0 / w
+	halfTexUnitY := 1.0 / w
+	u0 = (float32(rect.Min.X) / w) + halfTexUnitX
+	u1 = (float32(rect.Max.X) / w) - halfTexUnitX
+	v0 = (float32(rect.Min.Y) / h) + halfTexUnitY
+	v1 = (float32(rect.Max.Y) / h) - halfTexUnitY
+	return
+}
+
 func appendCard(m *gfx.Mesh, l, r, b, t, depth float32, rect, tex image.Rectangle) {
 	addv := func(x, y float32) {
 		m.Vertices = append(m.Vertices, gfx.Vec3{x, depth, y})
@@ -112,14 +282,7 @@ func appendCard(m *gfx.Mesh, l, r, b, t, depth float32, rect, tex image.Rectangl
 		}
 		m.TexCoords[0].Slice = append(m.TexCoords[0].Slice, gfx.TexCoord{u, v})
 	}
-	w := float32(tex.Dx())
-	h := float32(tex.Dy())
-	halfTexUnitX := 1.0 / w
-	halfTexUnitY := 1.0 / w
-	u0 := (float32(rect.Min.X) / w) + halfTexUnitX
-	u1 := (float32(rect.Max.X) / w) - halfTexUnitX
-	v0 := (float32(rect.Min.Y) / h) + halfTexUnitY
-	v1 := (float32(rect.Max.Y) / h) - halfTexUnitY
+	u0, v0, u1, v1 := texRect(rect, tex)
 
 	// Left triangle.
 	addv(l, t)
@@ -149,173 +312,1539 @@ type Config struct {
 
 	// The value to offset each individual tile from one another on the Y axis.
 	TileOffset float64
+
+	// If true, TileOffset is not used and instead all of the tiles of a layer
+	// share the layer's depth, relying on the less-or-equal depth test and
+	// the order of the tiles within their mesh to draw later tiles on top.
+	//
+	// This avoids the depth precision problems of accumulating TileOffset over
+	// maps with hundreds of thousands of tiles, at the cost of overlapping
+	// tiles of a single layer whose tilesets differ (and thus are in different
+	// objects) drawing in an undefined order relative to each other.
+	DepthOrder bool
+
+	// If non-nil, this function overrides the depth on the Y axis (normally
+	// derived from LayerOffset, TileOffset and DepthOrder) of each tile, for
+	// games with unusual ordering needs like isometric towers or bridges. It
+	// is given the draw order index of the layer (see Layer.Index), the
+	// coordinates and global ID (without flip flags) of the tile. For tile
+	// objects the index is that of their object group and the coordinates are
+	// the object's position in pixels, with the group's effective offset
+	// applied.
+	Depth func(layerIndex, x, y int, gid uint32) float64
+
+	// The number of tiles duplicated across the left and right (WrapX) and
+	// top and bottom (WrapY) edges of tile layers, for wrap-around worlds.
+	// The tiles within that many tiles of each edge are repeated outside of
+	// the opposite edge, such that the world renders seamlessly as long as the
+	// camera is moved back by the size of the map (see Map.WrapPixel) whenever
+	// it crosses an edge. Object groups and image layers are not duplicated,
+	// and infinite maps do not wrap.
+	WrapX, WrapY int
+
+	// If true, generated geometry is placed in Tiled's own pixel space: the
+	// top-left corner of the map is at the origin and +Z points down the map
+	// (I.e. the Z coordinate of a vertex is it's pixel Y coordinate). By
+	// default the bottom of the map is at Z=0 and +Z points up the map.
+	TopLeftOrigin bool
+
+	// If non-empty, LoadFile caches the meshes it builds in this directory,
+	// keyed by a hash of the contents of the map, it's external tilesets and
+	// images, and of this configuration. Later loads of the same content reuse
+	// the cached meshes instead of building them again. Caching is not used
+	// when Flipbooks, TileObjects, ChunkSize, RasterizeText, Depth or
+	// BakeLayers are set, and failures to write the cache are ignored.
+	MeshCache string
+
+	// If non-nil, Load stores information about each object that it creates
+	// in this map (for instance it's name and the properties of the layer it
+	// was created from), which is useful for scene debuggers and profilers.
+	//
+	// A gfx.Object has no name or properties of it's own to set, so they are
+	// kept here, keyed by object, instead of being attached to the objects.
+	// The map is owned by the caller, and entries accumulate across loads
+	// until the caller removes them.
+	Info map[*gfx.Object]*ObjectInfo
+
+	// If non-nil, Load and LoadFile add statistics about the cost of loading
+	// to these metrics.
+	//
+	// The metrics are accumulated here rather than returned by Load and
+	// LoadFile, which keeps their signatures unchanged for existing clients
+	// and lets one Metrics sum the cost of several maps (e.g. a whole level
+	// set in CI). Clients wanting the cost of a single load should give a new
+	// Metrics for each.
+	Metrics *Metrics
+
+	// If non-empty, tiles whose tile definition has a property with this name
+	// and a "#rrggbb" color value are tinted by that color, using vertex
+	// colors. Other tiles are left untinted.
+	ColorProperty string
+
+	// If non-nil, the colors of all objects are multiplied by this lightmap
+	// image (see BakeLightmap), which is stretched to cover the entire map.
+	Lightmap *image.RGBA
+
+	// If non-nil, the pixels of tileset (and image layer) images are palette
+	// indices rather than colors: the red channel of each pixel selects one
+	// of the 256 colors of this palette texture (see NewPaletteTexture), whose
+	// alpha is multiplied by that of the pixel. Tileset images are thus
+	// typically grayscale, and the palette can be swapped at runtime using
+	// SetPalette. Rasterized text is drawn through the palette as well.
+	Palette *gfx.Texture
+
+	// If non-nil, Load adds the objects of layers with scrolling textures to
+	// it, see Scrollers for more information.
+	Scrollers *Scrollers
+
+	// If non-nil, Load adds the objects of all layers to it (enabling vertex
+	// colors and alpha blending for them) such that their opacity can be
+	// faded at runtime, see Faders for more information.
+	Faders *Faders
+
+	// If non-nil, the objects of all layers are color graded by shaders whose
+	// uniforms it controls, such that the saturation, brightness and tint of
+	// layers can be changed at runtime, see Graders for more information.
+	Graders *Graders
+
+	// If non-nil, animated tiles are drawn from strip textures baked with all
+	// of their frames, and are animated by it, see Flipbooks for more
+	// information. Each animated tile of a layer is drawn by an object of it's
+	// own, named like "tilesetImage#tileID".
+	Flipbooks *Flipbooks
+
+	// If non-nil, tile layers are loaded as one small object per tile (which
+	// share a single texture per tileset image), stored in this map instead
+	// of being merged into the per-layer objects returned by Load. This allows
+	// for showing, hiding or recoloring individual tiles (e.g. for fog of war)
+	// without rebuilding large meshes. Tile objects are released with
+	// ReleaseTileObjects.
+	TileObjects map[TileKey]*gfx.Object
+
+	// If non-zero, the objects of tile layers are split into chunks of this
+	// many by this many cells, named like "tilesetImage:x,y" after the
+	// position of the chunk (in chunks, such that the chunk 0,0 holds the
+	// cell 0,0), rather than holding all of the tiles of a layer. RebuildDirty
+	// then only rebuilds the chunks holding changed cells, and renderers may
+	// cull chunks (see NewChunks).
+	//
+	// The tiles of each chunk are offset in depth (see TileOffset) from the
+	// depth of the layer on their own, so tiles overlapping the edge of a
+	// chunk may draw in an undefined order relative to the tiles of the
+	// neighbouring chunk, as with DepthOrder. Chunked layers are not cached
+	// (see MeshCache).
+	ChunkSize int
+
+	// If non-nil, text objects are drawn as textured cards covering the
+	// object, whose texture is the image returned by this function for it
+	// (or nothing, if it returns nil). The function typically draws the text
+	// with a font face of the application's choosing (for instance with the
+	// golang.org/x/image/font package) honoring the text's alignment, color
+	// and wrapping. Each text object is drawn by an object of it's own, named
+	// like "text#index" where index is the index of the object in it's group.
+	RasterizeText func(o *Object, t *Text) *image.RGBA
+
+	// If non-empty, layers (and image layers, and object groups) that have a
+	// custom property with this name and a non-empty value are returned
+	// grouped together under that value instead of under their own names,
+	// with their objects named like "layerName/tilesetImage". For instance a
+	// "rendergroup" property with values like "below-player" and
+	// "above-player" allows retrieving exactly those two sets of objects.
+	// Group names should not be the names of ungrouped layers.
+	GroupProperty string
+
+	// If non-empty, only the layers (and image layers, and object groups)
+	// with these names, or within group layers with these names, are loaded.
+	// Layers with names in ExcludeLayers, or within group layers with names
+	// in it, are never loaded. Both are evaluated before any of the tiles of
+	// a layer are visited, so excluding large layers saves all of the work of
+	// loading them.
+	IncludeLayers, ExcludeLayers []string
+
+	// The names of static tile layers which are baked into a single large
+	// texture each (see BakeLayer) rendered by a single card, rather than
+	// rendered by a card per tile. This trades texture memory for near-zero
+	// per-frame geometry, which suits static backgrounds on low-end hardware.
+	//
+	// Layers larger than BakeMaxSize pixels (4096 if zero) along either axis
+	// are split into several textures of at most that size, as GPUs limit the
+	// size of textures. The objects of baked layers are named like
+	// "baked:x,y" after the position of their texture within the layer, and
+	// are not rebuilt by RebuildDirty nor cached (see MeshCache).
+	BakeLayers  []string
+	BakeMaxSize int
+
+	// If non-nil, a map of tileset (and image layer) image filenames and the
+	// materials to use for the objects textured by them, instead of the
+	// textures and shaders that Load would otherwise create. The images must
+	// still be given to Load, as their size determines texture coordinates.
+	Materials map[string]*Material
+
+	// If non-nil, textures of tileset (and image layer) images are shared
+	// through this cache, see TextureCache for more information.
+	Textures *TextureCache
+
+	// If non-nil, the filtering of the textures of tileset (and image layer)
+	// images, see TextureFiltering. The default is DefaultFiltering.
+	// Textures shared through a texture cache keep the filtering of the
+	// configuration that created them.
+	Filtering *TextureFiltering
+
+	// How the sRGB encoded colors of tileset (and image layer) images are
+	// converted to linear colors, for linear-space rendering pipelines, see
+	// Linearize. By default they are not converted.
+	Linearize Linearize
+
+	// The number of goroutines with which LoadFile decodes tileset (and image
+	// layer) images and converts them to RGBA, which spreads the cost of
+	// loading large tileset sheets across processors. If zero or one, images
+	// are decoded one after another on the calling goroutine.
+	ImageWorkers int
 }
 
-// Load loads the given tmx map, m, and returns a slice of *gfx.Object with the
-// proper meshes and textures attached to them.
+// Material overrides the texture and/or shader of the objects that Load
+// creates for an image, see Config.Materials.
 //
-// If the configuration, c, is non-nil then it is used in place of the default
-// configuration.
+// This allows for using already uploaded textures, or special shaders (for
+// instance palette-swapping ones), instead of fresh ones each load. The
+// texture is shared as-is, except that Load sets it's wrap mode to repeat for
+// scrolling and repeating layers.
+type Material struct {
+	// If non-nil, the texture to use in place of a new one for the image.
+	Texture *gfx.Texture
+
+	// If non-nil, the shader to use in place of the package's own.
+	Shader *gfx.Shader
+}
+
+// TileKey identifies a single tile of a tile layer, see Config.TileObjects.
+type TileKey struct {
+	// The name of the tile layer.
+	Layer string
+
+	// The tile coordinates within the layer.
+	Coord Coord
+}
+
+// Metrics represents statistics about the cost of loading maps, which can be
+// used to track regressions in map cost or to profile loading hitches.
 //
-// The tsImages map should be a map of tileset image filenames and their
-// associated loaded RGBA images. Tiles who reference tilesets who are not
-// found in the map will be omited (not rendered) in the returned objects.
-func Load(m *Map, c *Config, tsImages map[string]*image.RGBA) (layers map[string]map[string]*gfx.Object) {
-	if c == nil {
-		c = &Config{
-			LayerOffset: 0.001,
-			TileOffset:  0.000001,
+// Values are always added to, such that a single Metrics can accumulate the
+// statistics of several loads.
+type Metrics struct {
+	// The number of tile cards emitted into meshes.
+	TilesEmitted int
+
+	// The number of tiles skipped because no image was given for their
+	// tileset, or because their global tile ID is invalid.
+	TilesSkipped int
+
+	// The number of vertices emitted into meshes.
+	Vertices int
+
+	// The number of textures created.
+	Textures int
+
+	// The number of bytes of binary tile data decoded (after decompression)
+	// when parsing maps. Only recorded by LoadFile.
+	BytesDecompressed int64
+
+	// The time spent in each stage of loading. Only the Mesh stage is recorded
+	// by Load, LoadFile records all of them.
+	Parse, Tilesets, Images, Mesh time.Duration
+}
+
+// DefaultConfig returns a new copy of the default configuration used by Load
+// when none is specified. Clients wishing to change some configuration values
+// should start from it.
+func DefaultConfig() *Config {
+	return &Config{
+		LayerOffset: 0.001,
+		TileOffset:  0.000001,
+	}
+}
+
+// ObjectInfo describes where an object created by Load came from.
+type ObjectInfo struct {
+	// The name of the object, like "tmx:layerName/tilesetImage".
+	Name string
+
+	// The name of the layer (or image layer, or object group) that the object
+	// was created from.
+	Layer string
+
+	// The name of the image file whose texture is attached to the object.
+	Image string
+
+	// The properties of the layer that the object was created from.
+	Properties map[string]string
+}
+
+// String returns the name of the object.
+func (i *ObjectInfo) String() string {
+	return i.Name
+}
+
+// loader holds the state used while loading a single map.
+type loader struct {
+	m        *Map
+	c        *Config
+	tsImages map[string]*image.RGBA
+
+	// A map of layer names to a map of image names and objects each
+	// containing one texture and mesh.
+	layers map[string]map[string]*gfx.Object
+
+	// The lightmap texture shared by all objects, if any.
+	lightmap *gfx.Texture
+
+	// The effective opacity of each translucent layer by index (as layers in
+	// different groups may share a name), and of the meshes of the objects
+	// created for those layers.
+	opacity map[int]float64
+	alpha   map[*gfx.Mesh]float32
+
+	// Tables of the rectangles of each tile of a tileset within it's image,
+	// see tileRect.
+	rects map[rectKey][]image.Rectangle
+
+	// The functions registering objects like the given layer objects with the
+	// configured effects, for layer objects that are dropped in favor of tile
+	// objects (see tileObject).
+	register map[*gfx.Object]func(*gfx.Object)
+
+	// The positions on the Y axis, in layers, of the layers by index (see
+	// layerDepth).
+	depths map[int]float64
+}
+
+// rectKey identifies a table of tile rectangles for a tileset whose image has
+// the given size.
+type rectKey struct {
+	ts   *Tileset
+	w, h int
+}
+
+// tileRect returns the rectangle of the tile with the given gid within the
+// image (with the given bounds) of the tileset, like Map.TilesetRect does, but
+// using a table of rectangles built on first use instead of computing it for
+// every tile.
+func (ld *loader) tileRect(ts *Tileset, bounds image.Rectangle, gid uint32) image.Rectangle {
+	key := rectKey{ts, bounds.Dx(), bounds.Dy()}
+	table, ok := ld.rects[key]
+	if !ok {
+		t := *ts
+		t.Image = &Image{Width: key.w, Height: key.h}
+		columns, rows := t.Grid()
+		table = make([]image.Rectangle, columns*rows)
+		for id := range table {
+			table[id] = ld.m.TilesetRect(ts, key.w, key.h, true, ts.Firstgid+uint32(id))
+		}
+		if ld.rects == nil {
+			ld.rects = make(map[rectKey][]image.Rectangle)
+		}
+		ld.rects[key] = table
+	}
+	if id := int(gid&^flipFlags) - int(ts.Firstgid); id >= 0 && id < len(table) {
+		return table[id]
+	}
+	return ld.m.TilesetRect(ts, key.w, key.h, true, gid)
+}
+
+// newLoader returns a new loader for the given map, configuration and images.
+func newLoader(m *Map, c *Config, tsImages map[string]*image.RGBA) *loader {
+	ld := &loader{
+		m:        m,
+		c:        c,
+		tsImages: tsImages,
+		layers:   make(map[string]map[string]*gfx.Object, len(m.Layers)+len(m.ImageLayers)),
+		opacity:  make(map[int]float64),
+		alpha:    make(map[*gfx.Mesh]float32),
+		register: make(map[*gfx.Object]func(*gfx.Object)),
+	}
+
+	// Layers are rendered with their opacity composited through the group
+	// layers they are within, like Tiled does. All layers may be faded when
+	// there are faders.
+	translucent := func(index int, opacity float64) {
+		if opacity < 1 || c.Faders != nil {
+			ld.opacity[index] = opacity
+		}
+	}
+	for _, l := range m.Layers {
+		translucent(l.Index, opacityProperty(l.Properties, l.EffectiveOpacity()))
+	}
+	for _, l := range m.ImageLayers {
+		translucent(l.Index, opacityProperty(l.Properties, l.EffectiveOpacity()))
+	}
+	for _, g := range m.ObjectGroups {
+		translucent(g.Index, opacityProperty(g.Properties, g.EffectiveOpacity()))
+	}
+	ld.depths = layerDepths(m)
+	return ld
+}
+
+// layerDepths returns the positions on the Y axis, in layers, of the layers of
+// the map by index. Tile layers keep their position in m.Layers (as they were
+// placed before the other kinds of layers were rendered), the other kinds of
+// layers are spread evenly between the tile layers they are drawn between.
+func layerDepths(m *Map) map[int]float64 {
+	type entry struct {
+		index int
+		tile  bool
+	}
+	var entries []entry
+	for _, l := range m.Layers {
+		entries = append(entries, entry{l.Index, true})
+	}
+	for _, l := range m.ImageLayers {
+		entries = append(entries, entry{l.Index, false})
+	}
+	for _, g := range m.ObjectGroups {
+		entries = append(entries, entry{g.Index, false})
+	}
+	for _, g := range m.Groups {
+		entries = append(entries, entry{g.Index, false})
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].index < entries[j].index
+	})
+
+	depths := make(map[int]float64, len(entries))
+	var gap []int
+	below := -1.0
+	spread := func(above float64) {
+		for k, index := range gap {
+			depths[index] = below + (above-below)*float64(k+1)/float64(len(gap)+1)
+		}
+		gap = gap[:0]
+	}
+	tiles := 0
+	for _, e := range entries {
+		if !e.tile {
+			gap = append(gap, e.index)
+			continue
+		}
+		spread(float64(tiles))
+		if _, ok := depths[e.index]; !ok {
+			depths[e.index] = float64(tiles)
+		}
+		below = float64(tiles)
+		tiles++
+	}
+	spread(below + 1)
+	return depths
+}
+
+// jobs returns the small units of work which, when run in order, load the
+// entire map.
+func (ld *loader) jobs() []func() {
+	var jobs []func()
+	for _, layer := range ld.m.Layers {
+		layer := layer
+		switch {
+		case !ld.rendered(layer.Name, layer.Parent, layer.Properties):
+		case ld.baked(layer.Name):
+			jobs = append(jobs, func() {
+				ld.bakedLayer(layer)
+			})
+		default:
+			jobs = append(jobs, ld.tileLayer(layer, ld.layerChunks(layer, image.Rectangle{}))...)
+		}
+	}
+	for _, layer := range ld.m.ImageLayers {
+		layer := layer
+		if !ld.rendered(layer.Name, layer.Parent, layer.Properties) {
+			continue
+		}
+		jobs = append(jobs, func() {
+			ld.imageLayer(layer)
+		})
+	}
+	for _, group := range ld.m.ObjectGroups {
+		group := group
+		if !ld.rendered(group.Name, group.Parent, group.Properties) {
+			continue
+		}
+		jobs = append(jobs, func() {
+			ld.objectGroup(group)
+		})
+	}
+	return jobs
+}
+
+// object returns the textured object for the given image name in the given
+// map of objects, creating it if needed. The layer index, name and properties
+// are used to describe newly created objects.
+func (ld *loader) object(objects map[string]*gfx.Object, index int, layer string, props map[string]string, name string, rgba *image.RGBA) *gfx.Object {
+	obj, ok := objects[name]
+	if ok {
+		return obj
+	}
+
+	// Create texture, unless the configuration gives us one or it is shared
+	// through the texture cache (only textures of the given images are, and
+	// not e.g. baked ones). Scrolled textures change their wrap mode, so they
+	// are never shared.
+	mat := ld.c.Materials[name]
+	var scrolled bool
+	if ld.c.Scrollers != nil {
+		x, y := scrollSpeed(props)
+		scrolled = x != 0 || y != 0
+	}
+	var t *gfx.Texture
+	created := true
+	switch {
+	case mat != nil && mat.Texture != nil:
+		t, created = mat.Texture, false
+	case ld.c.Textures != nil && ld.tsImages[name] == rgba && !scrolled:
+		t, created = ld.c.Textures.texture(name, rgba)
+	default:
+		t = newTexture(rgba)
+	}
+	if created {
+		ld.filtering().apply(t)
+		if ld.c.Linearize == LinearizeImages {
+			t.Source = LinearImage(rgba)
+		}
+		if ld.c.Metrics != nil {
+			ld.c.Metrics.Textures++
+		}
+	}
+
+	// And the object.
+	blend := blendModeProperty(props)
+	obj = gfx.NewObject()
+	obj.Shader = ld.shader(index, layer, props)
+	if mat != nil && mat.Shader != nil {
+		obj.Shader = mat.Shader
+	}
+	obj.Meshes = []*gfx.Mesh{gfx.NewMesh()}
+	obj.Textures = []*gfx.Texture{t}
+	if scrolled && created && !rgba.Bounds().Empty() {
+		t.WrapU = gfx.Repeat
+		t.WrapV = gfx.Repeat
+	}
+	if ld.c.Lightmap != nil {
+		if ld.lightmap == nil {
+			ld.lightmap = gfx.NewTexture()
+			ld.lightmap.Source = ld.c.Lightmap
+			ld.lightmap.Bounds = ld.c.Lightmap.Bounds()
+			ld.lightmap.WrapU = gfx.Clamp
+			ld.lightmap.WrapV = gfx.Clamp
+			ld.lightmap.MinFilter = gfx.Linear
+			ld.lightmap.MagFilter = gfx.Linear
+		}
+		obj.Textures = append(obj.Textures, ld.lightmap)
+	}
+	if ld.c.Palette != nil {
+		obj.Textures = append(obj.Textures, ld.c.Palette)
+	}
+
+	// Disable face culling because of the flipped cards.
+	obj.State = gfx.NewState()
+	obj.State.FaceCulling = gfx.NoFaceCulling
+	obj.State.AlphaMode = gfx.AlphaToCoverage
+	if opacity, ok := ld.opacity[index]; ok {
+		obj.State.AlphaMode = gfx.AlphaBlend
+		ld.alpha[obj.Meshes[0]] = float32(opacity)
+	}
+	if ld.c.DepthOrder {
+		obj.State.DepthCmp = gfx.LessOrEqual
+	}
+	blend.apply(obj.State)
+	objects[name] = obj
+
+	// Register the object with the configured effects. The objects of tile
+	// layers are dropped when their tiles are placed into objects of their
+	// own, in which case those are registered instead.
+	register := func(obj *gfx.Object) {
+		if ld.c.Graders != nil && (mat == nil || mat.Shader == nil) {
+			ld.c.Graders.add(layer, props, obj)
+		}
+		if ld.c.Scrollers != nil {
+			ld.c.Scrollers.add(obj, props, rgba.Bounds())
+		}
+		if ld.c.Faders != nil {
+			ld.c.Faders.add(layer, obj)
+		}
+	}
+	if ld.dropped(index) {
+		ld.register[obj] = register
+	} else {
+		register(obj)
+	}
+
+	if ld.c.Info != nil {
+		ld.c.Info[obj] = &ObjectInfo{
+			Name:       fmt.Sprintf("tmx:%s/%s", layer, name),
+			Layer:      layer,
+			Image:      name,
+			Properties: props,
+		}
+	}
+	return obj
+}
+
+// dropped tells if the objects created for the layer with the given index are
+// dropped, because it is a tile layer whose tiles are placed into objects of
+// their own (see Config.TileObjects).
+func (ld *loader) dropped(index int) bool {
+	if ld.c.TileObjects == nil {
+		return false
+	}
+	for _, l := range ld.m.Layers {
+		if l.Index == index {
+			return true
+		}
+	}
+	return false
+}
+
+// tileObject returns a new object for the single tile at the given coordinates
+// of the named layer, which shares the shader, state and textures of the
+// given layer object, and stores it in the configuration's tile objects. It is
+// registered with the configured effects in place of the layer object.
+//
+// Each tile object holds a reference to a texture shared through the texture
+// cache, such that it stays alive until all of them are released (see
+// ReleaseTileObjects).
+func (ld *loader) tileObject(layerObj *gfx.Object, layer string, c Coord) *gfx.Object {
+	state := *layerObj.State
+	obj := gfx.NewObject()
+	obj.Shader = layerObj.Shader
+	obj.State = &state
+	obj.Meshes = []*gfx.Mesh{gfx.NewMesh()}
+	obj.Textures = append([]*gfx.Texture(nil), layerObj.Textures...)
+	if ld.c.Textures != nil {
+		ld.c.Textures.retain(obj.Textures[0])
+	}
+	if alpha, ok := ld.alpha[layerObj.Meshes[0]]; ok {
+		ld.alpha[obj.Meshes[0]] = alpha
+	}
+	ld.register[layerObj](obj)
+	if ld.c.Info != nil {
+		info := *ld.c.Info[layerObj]
+		info.Name = fmt.Sprintf("%s@%d,%d", info.Name, c.X, c.Y)
+		ld.c.Info[obj] = &info
+	}
+	ld.c.TileObjects[TileKey{layer, c}] = obj
+	return obj
+}
+
+// shader returns the shader used by objects created by the loader for the
+// named layer with the given index, whose properties are given, with the given
+// extra features.
+func (ld *loader) shader(index int, layer string, props map[string]string, extra ...string) *gfx.Shader {
+	features := extra
+	if _, translucent := ld.opacity[index]; translucent || len(ld.c.ColorProperty) > 0 {
+		features = append(features, featureVertexColor)
+	}
+	if ld.c.Lightmap != nil {
+		features = append(features, featureLightmap)
+	}
+	if ld.c.Palette != nil {
+		features = append(features, featurePalette)
+	}
+	if blendModeProperty(props) != BlendNormal {
+		features = append(features, featurePremultiply)
+	}
+	features = append(features, ld.filtering().features(ld.c.Palette != nil)...)
+	if ld.c.Linearize == LinearizeShader {
+		features = append(features, featureSRGB)
+	}
+	if ld.c.Graders != nil {
+		features = append(features, featureGrading)
+		return ld.c.Graders.shader(layer, props, shaderVariant(features...))
+	}
+	return shaderVariant(features...)
+}
+
+// cardAdded appends the per-vertex data required by the enabled shader
+// features for the vertices that were appended to the mesh after it had the
+// given number of vertices (and were moved into their final position), for the
+// tile with the given gid (or zero for none), and then moves those vertices to
+// the configured origin (see origin).
+func (ld *loader) cardAdded(mesh *gfx.Mesh, start int, gid uint32) {
+	if _, translucent := ld.alpha[mesh]; translucent || len(ld.c.ColorProperty) > 0 {
+		ld.appendColors(mesh, start, gid)
+	}
+	if ld.c.Lightmap != nil {
+		ld.appendLightmapCoords(mesh, start)
+	}
+	ld.origin(mesh, start)
+}
+
+// origin moves the vertices of the mesh, starting at the given one, from the
+// default axis mapping into the top-left origin one if the configuration asks
+// for it.
+func (ld *loader) origin(mesh *gfx.Mesh, start int) {
+	if !ld.c.TopLeftOrigin {
+		return
+	}
+	h := float32(ld.m.Height * ld.m.TileHeight)
+	for i := start; i < len(mesh.Vertices); i++ {
+		mesh.Vertices[i].Z = h - mesh.Vertices[i].Z
+	}
+}
+
+// appendLightmapCoords appends lightmap texture coordinates, which map the
+// lightmap onto the entire map, to the given vertices of the mesh.
+func (ld *loader) appendLightmapCoords(mesh *gfx.Mesh, start int) {
+	for len(mesh.TexCoords) < 2 {
+		mesh.TexCoords = append(mesh.TexCoords, gfx.TexCoordSet{})
+	}
+	w := float32(ld.m.Width * ld.m.TileWidth)
+	h := float32(ld.m.Height * ld.m.TileHeight)
+	for _, v := range mesh.Vertices[start:] {
+		mesh.TexCoords[1].Slice = append(mesh.TexCoords[1].Slice, gfx.TexCoord{v.X / w, (h - v.Z) / h})
+	}
+}
+
+// appendColors appends the vertex color for the tile with the given gid (or
+// white, for a zero gid) to the given vertices of the mesh, with the alpha of
+// the mesh's layer applied.
+func (ld *loader) appendColors(mesh *gfx.Mesh, start int, gid uint32) {
+	c := gfx.Color{1, 1, 1, 1}
+	if ts, id, _ := ld.m.DecomposeGID(gid); ts != nil && len(ld.c.ColorProperty) > 0 {
+		if t := ts.Tiles[int(id)]; t != nil {
+			if v, ok := t.Properties[ld.c.ColorProperty]; ok {
+				rgba := hexToRGBA(v)
+				c = gfx.Color{
+					float32(rgba.R) / 255,
+					float32(rgba.G) / 255,
+					float32(rgba.B) / 255,
+					float32(rgba.A) / 255,
+				}
+			}
+		}
+	}
+	if alpha, ok := ld.alpha[mesh]; ok {
+		c.A *= alpha
+	}
+	for i := start; i < len(mesh.Vertices); i++ {
+		mesh.Colors = append(mesh.Colors, c)
+	}
+}
+
+// flipMatrix returns the transformation matrix which applies the given flips
+// to a card centered at the origin.
+func flipMatrix(flips Flip) lmath.Mat4 {
+	flip := lmath.Mat4Identity
+	diagFlipped := flips.Diagonal()
+	horizFlipped := flips.Horizontal()
+	vertFlipped := flips.Vertical()
+	if diagFlipped {
+		if horizFlipped && vertFlipped {
+			flip = cw90.Mul(flip)
+			flip = horizFlip.Mul(flip)
+		} else if horizFlipped {
+			flip = cw90.Mul(flip)
+		} else if vertFlipped {
+			flip = cwn90.Mul(flip)
+		} else {
+			flip = horizFlip.Mul(flip)
+			flip = cw90.Mul(flip)
+		}
+	} else {
+		if horizFlipped {
+			flip = horizFlip.Mul(flip)
+		}
+		if vertFlipped {
+			flip = vertFlip.Mul(flip)
+		}
+	}
+	return flip
+}
+
+// depthProperty returns the value of the conventional "z" (or "draworder")
+// custom property in the given properties map, or zero if there is none.
+func depthProperty(props map[string]string) float64 {
+	for _, name := range []string{"z", "draworder"} {
+		if v, ok := props[name]; ok {
+			z, err := strconv.ParseFloat(v, 64)
+			if err == nil {
+				return z
+			}
+		}
+	}
+	return 0
+}
+
+// layerDepth returns the depth on the Y axis of the layer with the given draw
+// order index and properties (see layerDepths). Layers with the conventional
+// "above-entities" custom property are moved in front of all of the layers of
+// the map.
+func (ld *loader) layerDepth(index int, props map[string]string) float64 {
+	depth, ok := ld.depths[index]
+	if !ok {
+		depth = float64(index)
+	}
+	if props["above-entities"] == "true" {
+		m := ld.m
+		depth += float64(len(m.Layers) + len(m.ObjectGroups) + len(m.ImageLayers) + len(m.Groups))
+	}
+	return -(depth + depthProperty(props)) * ld.c.LayerOffset
+}
+
+// rendered tells if the layer with the given name, parent group layer and
+// properties is rendered, I.e. it is included by the configuration (see
+// Config.IncludeLayers) and does not carry the conventional "norender" or
+// "collision-only" custom properties.
+func (ld *loader) rendered(name string, parent *GroupLayer, props map[string]string) bool {
+	if props["norender"] == "true" || props["collision-only"] == "true" {
+		return false
+	}
+	has := func(names []string, name string) bool {
+		for _, n := range names {
+			if n == name {
+				return true
+			}
+		}
+		return false
+	}
+	included := len(ld.c.IncludeLayers) == 0 || has(ld.c.IncludeLayers, name)
+	if has(ld.c.ExcludeLayers, name) {
+		return false
+	}
+	for g := parent; g != nil; g = g.Parent {
+		if has(ld.c.ExcludeLayers, g.Name) {
+			return false
+		}
+		included = included || has(ld.c.IncludeLayers, g.Name)
+	}
+	return included
+}
+
+// opacityProperty returns the value of the conventional "opacity-override"
+// custom property in the given properties map, or the given opacity if there
+// is none.
+func opacityProperty(props map[string]string, opacity float64) float64 {
+	if v, ok := props["opacity-override"]; ok {
+		if o, err := strconv.ParseFloat(v, 64); err == nil {
+			return math.Max(0, math.Min(o, 1))
+		}
+	}
+	return opacity
+}
+
+// tileOffset returns the depth offset between the individual tiles of a layer.
+func (ld *loader) tileOffset() float64 {
+	if ld.c.DepthOrder {
+		return 0
+	}
+	return ld.c.TileOffset
+}
+
+// fitTile returns the size of the card for a tile of the given tileset, when
+// it is rendered into an area of the given size, according to the tileset's
+// fill mode.
+func fitTile(ts *Tileset, width, height float64) (w, h float64) {
+	if ts.FillMode != FillModePreserveAspectFit || ts.Width == 0 || ts.Height == 0 {
+		return width, height
+	}
+	scale := math.Min(width/float64(ts.Width), height/float64(ts.Height))
+	return float64(ts.Width) * scale, float64(ts.Height) * scale
+}
+
+// tileCard appends a card of the given size (in pixels) to the object's mesh
+// for the tile with the given gid, whose image is the rectangle r of the
+// object's texture with the given bounds, and then transforms it by the given
+// matrix.
+//
+// Before transformation the card is centered at the origin, with the tile's
+// flips applied.
+func (ld *loader) tileCard(obj *gfx.Object, r, tex image.Rectangle, gid uint32, width, height float32, trans lmath.Mat4) {
+	_, _, flips := ld.m.DecomposeGID(gid)
+	cardStart := appendQuad(obj.Meshes[0], r, tex, flips, width, height, trans)
+	cardEnd := len(obj.Meshes[0].Vertices)
+	ld.cardAdded(obj.Meshes[0], cardStart, gid)
+	if ld.c.Metrics != nil {
+		ld.c.Metrics.TilesEmitted++
+		ld.c.Metrics.Vertices += cardEnd - cardStart
+	}
+}
+
+// tilesetObject returns the textured object for tiles of the given tileset,
+// whose image has the given name, in the given map of objects for a layer (see
+// object). Animated tiles get an object of their own if the configuration has
+// flipbooks. The rectangle of the tile with the given gid within the object's
+// texture, and the bounds of that texture, are returned as well.
+func (ld *loader) tilesetObject(objects map[string]*gfx.Object, index int, layer string, props map[string]string, ts *Tileset, name string, rgba *image.RGBA, gid uint32) (obj *gfx.Object, r, tex image.Rectangle) {
+	if ld.c.Flipbooks != nil {
+		if book := ld.c.Flipbooks.book(ld.m, ts, name, rgba, gid); book != nil {
+			_, exists := objects[book.name]
+			obj = ld.object(objects, index, layer, props, book.name, book.strip)
+			if !exists {
+				obj.Shader = book.shader(ld.shader(index, layer, props, featureFlipbook))
+			}
+			return obj, image.Rect(0, 0, ts.Width, ts.Height), book.strip.Bounds()
 		}
 	}
+	obj = ld.object(objects, index, layer, props, name, rgba)
+	r = ld.tileRect(ts, rgba.Bounds(), gid)
+	return obj, r, rgba.Bounds()
+}
+
+// skipped records that a tile was skipped in the metrics (if any).
+func (ld *loader) skipped() {
+	if ld.c.Metrics != nil {
+		ld.c.Metrics.TilesSkipped++
+	}
+}
+
+// tileCenter returns the center (with a zero depth) of the area that the tile
+// at the given coordinates of the tile layer is rendered into, and the size of
+// the tile's card within that area.
+func (ld *loader) tileCenter(layer *Layer, ts *Tileset, x, y int) (center lmath.Vec3, width, height float64) {
+	m := ld.m
+	cellWidth, cellHeight := float64(ts.Width), float64(ts.Height)
+	if ts.RenderSize == TileRenderSizeGrid {
+		cellWidth, cellHeight = float64(m.TileWidth), float64(m.TileHeight)
+	}
+	width, height = fitTile(ts, cellWidth, cellHeight)
+	ox, oy := layer.EffectiveOffset()
+	center = lmath.Vec3{
+		float64(x*m.TileWidth) + ox + cellWidth/2.0,
+		0,
+		float64((m.Height-y)*m.TileHeight) - oy - cellHeight/2.0,
+	}
+	return
+}
 
-	// A map of layer names to a slice of objects each containing one texture
-	// and mesh.
-	layers = make(map[string]map[string]*gfx.Object, len(m.Layers))
-	var layerOffset float64
+// layerChunks returns the rectangles of cells of the given tile layer which
+// are loaded into objects of their own (see Config.ChunkSize), in the order
+// that they are loaded. Without chunks the entire layer is a single chunk.
+//
+// If dirty is not empty, only the chunks holding cells within it (or copies
+// of them, see Config.WrapX) are returned.
+func (ld *loader) layerChunks(layer *Layer, dirty image.Rectangle) []image.Rectangle {
+	m := ld.m
 
-	for _, layer := range m.Layers {
-		// A slice of objects which contain a single texture and mesh.
-		texObjects := make(map[string]*gfx.Object)
+	// Infinite maps are loaded within the bounds of their tiles, and do not
+	// wrap.
+	bounds := layer.Bounds(m)
+	wrapX, wrapY := ld.c.WrapX, ld.c.WrapY
+	if m.Infinite {
+		wrapX, wrapY = 0, 0
+	}
+	cells := image.Rect(bounds.Min.X-wrapX, bounds.Min.Y-wrapY, bounds.Max.X+wrapX, bounds.Max.Y+wrapY)
+	size := ld.c.ChunkSize
+	if size <= 0 {
+		return []image.Rectangle{cells}
+	}
+
+	// Copies of the dirty cells across the edges of the map are dirty too.
+	copies := []image.Rectangle{dirty}
+	if wrapX > 0 {
+		copies = append(copies, dirty.Add(image.Pt(-m.Width, 0)), dirty.Add(image.Pt(m.Width, 0)))
+	}
+	if wrapY > 0 {
+		for _, r := range copies {
+			copies = append(copies, r.Add(image.Pt(0, -m.Height)), r.Add(image.Pt(0, m.Height)))
+		}
+	}
+	var chunks []image.Rectangle
+	for cx := floorDiv(cells.Min.X, size); cx*size < cells.Max.X; cx++ {
+		for cy := floorDiv(cells.Min.Y, size); cy*size < cells.Max.Y; cy++ {
+			r := image.Rect(cx*size, cy*size, cx*size+size, cy*size+size).Intersect(cells)
+			if dirty.Empty() {
+				chunks = append(chunks, r)
+				continue
+			}
+			for _, d := range copies {
+				if r.Overlaps(d) {
+					chunks = append(chunks, r)
+					break
+				}
+			}
+		}
+	}
+	return chunks
+}
+
+// cellChunk returns the position (in chunks) of the chunk holding the given
+// cell, see Config.ChunkSize. Without chunks it is always zero.
+func (ld *loader) cellChunk(c Coord) Coord {
+	if ld.c.ChunkSize <= 0 {
+		return Coord{}
+	}
+	return Coord{floorDiv(c.X, ld.c.ChunkSize), floorDiv(c.Y, ld.c.ChunkSize)}
+}
+
+// chunkName returns the name of the object of the chunk at the given position
+// with the given name (e.g. that of a tileset image), see Config.ChunkSize.
+func (ld *loader) chunkName(name string, chunk Coord) string {
+	if ld.c.ChunkSize <= 0 {
+		return name
+	}
+	return fmt.Sprintf("%s:%d,%d", name, chunk.X, chunk.Y)
+}
+
+// objectChunk returns the name (e.g. that of a tileset image) and the position
+// of the chunk of the tile layer object with the given name, see chunkName.
+func (ld *loader) objectChunk(name string) (base string, chunk Coord) {
+	if ld.c.ChunkSize <= 0 {
+		return name, Coord{}
+	}
+	i := strings.LastIndex(name, ":")
+	if i < 0 {
+		return name, Coord{}
+	}
+	fmt.Sscanf(name[i+1:], "%d,%d", &chunk.X, &chunk.Y)
+	return name[:i], chunk
+}
+
+// tileLayer returns the jobs (see jobs) which load the given chunks (see
+// layerChunks) of the given tile layer, one per column of tiles of each chunk.
+func (ld *loader) tileLayer(layer *Layer, chunks []image.Rectangle) []func() {
+	m := ld.m
+	depth := ld.layerDepth(layer.Index, layer.Properties)
+
+	// A map of objects which contain a single texture and mesh, those of the
+	// layer that is being rebuilt are reused (see RebuildDirty).
+	texObjects := ld.layers[layer.Name]
+	if texObjects == nil {
+		texObjects = make(map[string]*gfx.Object)
+	}
+
+	var jobs []func()
+	chunkObjects := make(map[Coord]map[string]*gfx.Object, len(chunks))
+	for _, r := range chunks {
+		// The existing objects of the chunk are emptied, and reused.
+		chunk := ld.cellChunk(Coord{r.Min.X, r.Min.Y})
+		objects := make(map[string]*gfx.Object)
+		for name, obj := range texObjects {
+			if base, c := ld.objectChunk(name); c == chunk {
+				mesh := obj.Meshes[0]
+				mesh.Vertices = nil
+				mesh.Colors = nil
+				mesh.TexCoords = nil
+				if opacity, ok := ld.opacity[layer.Index]; ok {
+					ld.alpha[mesh] = float32(opacity)
+				}
+				objects[base] = obj
+			}
+		}
 		var tileOffset float64
 
-		for x := 0; x < m.Width; x++ {
-			for y := 0; y < m.Height; y++ {
-				gid, hasTile := layer.Tiles[Coord{x, y}]
-				if !hasTile {
+		column := func(x int, cells image.Rectangle) {
+			for y := cells.Min.Y; y < cells.Max.Y; y++ {
+				// Tiles outside of the map are duplicated from the opposite
+				// edge.
+				c := Coord{x, y}
+				if !m.Infinite {
+					c = m.Wrap(c)
+				}
+				gid := layer.Tile(c)
+				if gid == 0 {
 					continue
 				}
 
-				tileset := m.FindTileset(gid)
+				tileset, _, _ := m.DecomposeGID(gid)
+				if tileset == nil {
+					ld.skipped()
+					continue
+				}
 
 				// Load the tileset texture if needed
 				tsImage := filepath.Base(tileset.Image.Source)
-				rgba, haveTilesetImage := tsImages[tsImage]
+				rgba, haveTilesetImage := ld.tsImages[tsImage]
 				if !haveTilesetImage {
 					// We weren't given a RGBA image for the tileset, so we
 					// will just omit this tile.
+					ld.skipped()
 					continue
 				}
 
 				// Create a textured mesh object, if needed.
-				obj, ok := texObjects[tsImage]
-				if !ok {
-					// Create texture.
-					t := gfx.NewTexture()
-					t.Source = rgba
-					t.Bounds = rgba.Bounds()
-					t.WrapU = gfx.Clamp
-					t.WrapV = gfx.Clamp
-					t.MinFilter = gfx.LinearMipmapLinear
-					t.MagFilter = gfx.Linear
-
-					// And the object.
-					obj = gfx.NewObject()
-					obj.Shader = Shader
-					obj.Meshes = []*gfx.Mesh{gfx.NewMesh()}
-					obj.Textures = []*gfx.Texture{t}
-
-					// Disable face culling because of the flipped cards.
-					obj.State = gfx.NewState()
-					obj.State.FaceCulling = gfx.NoFaceCulling
-					obj.State.AlphaMode = gfx.AlphaToCoverage
-					texObjects[tsImage] = obj
-				}
-				r := m.TilesetRect(tileset, rgba.Bounds().Dx(), rgba.Bounds().Dy(), true, gid)
-
-				halfWidth := float32(tileset.Width) / 2.0
-				halfHeight := float32(tileset.Height) / 2.0
-				cardStart := len(obj.Meshes[0].Vertices)
-				appendCard(
-					obj.Meshes[0],
-					-halfWidth,
-					halfWidth,
-					-halfHeight,
-					halfHeight,
-					0, r, rgba.Bounds(),
-				)
-				cardEnd := len(obj.Meshes[0].Vertices)
-
-				// apply necessary flips
-				flip := lmath.Mat4Identity
-				diagFlipped := (gid & FLIPPED_DIAGONALLY_FLAG) > 0
-				horizFlipped := (gid & FLIPPED_HORIZONTALLY_FLAG) > 0
-				vertFlipped := (gid & FLIPPED_VERTICALLY_FLAG) > 0
-				if diagFlipped {
-					if horizFlipped && vertFlipped {
-						flip = cw90.Mul(flip)
-						flip = horizFlip.Mul(flip)
-					} else if horizFlipped {
-						flip = cw90.Mul(flip)
-					} else if vertFlipped {
-						flip = cwn90.Mul(flip)
-					} else {
-						flip = horizFlip.Mul(flip)
-						flip = cw90.Mul(flip)
-					}
-				} else {
-					if horizFlipped {
-						flip = horizFlip.Mul(flip)
-					}
-					if vertFlipped {
-						flip = vertFlip.Mul(flip)
-					}
+				obj, r, tex := ld.tilesetObject(objects, layer.Index, layer.Name, layer.Properties, tileset, tsImage, rgba, gid)
+				if ld.c.TileObjects != nil {
+					obj = ld.tileObject(obj, layer.Name, Coord{x, y})
 				}
 
-				// Move the card,
-				move := lmath.Mat4FromTranslation(lmath.Vec3{
-					float64(x*m.TileWidth) + float64(halfWidth),
-					layerOffset + tileOffset,
-					float64((m.Height-y)*m.TileHeight) - float64(halfHeight),
-				})
-				tileOffset -= c.TileOffset
-
-				trans := flip.Mul(move)
-
-				// Apply transformation.
-				verts := obj.Meshes[0].Vertices
-				for i, v := range verts[cardStart:cardEnd] {
-					vt := v.Vec3().TransformMat4(trans)
-					verts[cardStart+i] = gfx.Vec3{float32(vt.X), float32(vt.Y), float32(vt.Z)}
+				// Move the card to the center of the area it's rendered into.
+				center, width, height := ld.tileCenter(layer, tileset, x, y)
+				center.Y = depth + tileOffset
+				if ld.c.Depth != nil {
+					center.Y = ld.c.Depth(layer.Index, x, y, gid&^flipFlags)
 				}
+				move := lmath.Mat4FromTranslation(center)
+				tileOffset -= ld.tileOffset()
+
+				ld.tileCard(obj, r, tex, gid, float32(width), float32(height), move)
+			}
+		}
+		for x := r.Min.X; x < r.Max.X; x++ {
+			x, r := x, r
+			jobs = append(jobs, func() {
+				column(x, r)
+			})
+		}
+		chunkObjects[chunk] = objects
+	}
+
+	// Add the objects to the map of layers, unless the tiles were placed into
+	// objects of their own. In that case the layer objects are dropped, along
+	// with their references to cached textures.
+	return append(jobs, func() {
+		for chunk, objects := range chunkObjects {
+			for name, obj := range objects {
+				texObjects[ld.chunkName(name, chunk)] = obj
+			}
+		}
+		if ld.c.TileObjects == nil {
+			ld.layers[layer.Name] = texObjects
+			return
+		}
+		for _, obj := range texObjects {
+			if ld.c.Textures != nil {
+				ld.c.Textures.release(obj.Textures[0])
+			}
+			if ld.c.Info != nil {
+				delete(ld.c.Info, obj)
 			}
+			delete(ld.register, obj)
+		}
+	})
+}
+
+// objectGroup loads the tile objects (I.e. those with a non-zero Gid) of the
+// given object group, as well as it's text objects if the configuration has a
+// text rasterizer.
+func (ld *loader) objectGroup(group *ObjectGroup) {
+	m := ld.m
+	texObjects := make(map[string]*gfx.Object)
+	var tileOffset float64
+	ox, oy := group.EffectiveOffset()
+
+	for i, o := range group.Objects {
+		if t, ok := o.Value.(*Text); ok && ld.c.RasterizeText != nil {
+			depth := ld.layerDepth(group.Index, group.Properties) + tileOffset
+			depth -= depthProperty(o.Properties) * ld.c.LayerOffset
+			ld.textObject(texObjects, group, o, t, fmt.Sprintf("text#%d", i), depth)
+			tileOffset -= ld.tileOffset()
+			continue
+		}
+		if o.Gid == 0 {
+			continue
+		}
+		tileset, _, _ := m.DecomposeGID(o.Gid)
+		if tileset == nil {
+			ld.skipped()
+			continue
+		}
+
+		// Load the tileset texture if needed
+		tsImage := filepath.Base(tileset.Image.Source)
+		rgba, haveTilesetImage := ld.tsImages[tsImage]
+		if !haveTilesetImage {
+			ld.skipped()
+			continue
+		}
+		obj, r, tex := ld.tilesetObject(texObjects, group.Index, group.Name, group.Properties, tileset, tsImage, rgba, o.Gid)
+
+		// Tile objects default to the size of the tiles in their tileset.
+		width, height := float64(o.Width), float64(o.Height)
+		if width == 0 || height == 0 {
+			width, height = float64(tileset.Width), float64(tileset.Height)
 		}
 
-		// Add the slice to the map of layers.
-		layers[layer.Name] = texObjects
+		// Tile objects are aligned to the bottom-left, and rotate about that
+		// point.
+		depth := ld.layerDepth(group.Index, group.Properties)
+		depth -= depthProperty(o.Properties) * ld.c.LayerOffset
+		depth += tileOffset
+		if ld.c.Depth != nil {
+			depth = ld.c.Depth(group.Index, pixelFloor(o.X, ox), pixelFloor(o.Y, oy), o.Gid)
+		}
+		center := lmath.Mat4FromTranslation(lmath.Vec3{width / 2.0, 0, height / 2.0})
+		rotate := lmath.Mat4FromAxisAngle(
+			lmath.Vec3{0, 1, 0},
+			lmath.Radians(o.Rotation),
+			lmath.CoordSysZUpRight,
+		)
+		move := lmath.Mat4FromTranslation(lmath.Vec3{
+			float64(o.X) + ox,
+			depth,
+			float64(m.Height*m.TileHeight-o.Y) - oy,
+		})
+		tileOffset -= ld.tileOffset()
 
-		// Decrease layer offset
-		layerOffset -= c.LayerOffset
+		cardWidth, cardHeight := fitTile(tileset, width, height)
+		ld.tileCard(obj, r, tex, o.flaggedGid(), float32(cardWidth), float32(cardHeight), center.Mul(rotate).Mul(move))
 	}
+	// This replaces the objects of any tile or image layer of the same name,
+	// see Load.
+	if len(texObjects) > 0 {
+		ld.layers[group.Name] = texObjects
+	}
+}
 
-	/*
-			m := new(gfx.Mesh)
-			o := &gfx.Object{
-				State: gfx.DefaultState,
-				Meshes: []*gfx.Mesh{m},
-				Textures: texturesSlice,
-			}
+// textObject loads the given text object of the group as a card, textured by
+// the configuration's text rasterizer, at the given depth.
+func (ld *loader) textObject(objects map[string]*gfx.Object, group *ObjectGroup, o *Object, t *Text, name string, depth float64) {
+	if o.Width == 0 || o.Height == 0 {
+		return
+	}
+	rgba := ld.c.RasterizeText(o, t)
+	if rgba == nil || rgba.Bounds().Empty() {
+		return
+	}
+	obj := ld.object(objects, group.Index, group.Name, group.Properties, name, rgba)
+
+	// Text objects are aligned to the top-left, and rotate about that point.
+	width, height := float64(o.Width), float64(o.Height)
+	ox, oy := group.EffectiveOffset()
+	center := lmath.Mat4FromTranslation(lmath.Vec3{width / 2.0, 0, -height / 2.0})
+	rotate := lmath.Mat4FromAxisAngle(
+		lmath.Vec3{0, 1, 0},
+		lmath.Radians(o.Rotation),
+		lmath.CoordSysZUpRight,
+	)
+	move := lmath.Mat4FromTranslation(lmath.Vec3{
+		float64(o.X) + ox,
+		depth,
+		float64(ld.m.Height*ld.m.TileHeight-o.Y) - oy,
+	})
+	ld.tileCard(obj, rgba.Bounds(), rgba.Bounds(), 0, float32(width), float32(height), center.Mul(rotate).Mul(move))
+}
+
+// imageLayer loads the given image layer.
+func (ld *loader) imageLayer(layer *ImageLayer) {
+	m := ld.m
+	depth := ld.layerDepth(layer.Index, layer.Properties)
+	name := filepath.Base(layer.Image.Source)
+	rgba, ok := ld.tsImages[name]
+	if !ok {
+		// We weren't given a RGBA image for the image layer, so we will just
+		// omit it.
+		return
+	}
+
+	texObjects := make(map[string]*gfx.Object, 1)
+	obj := ld.object(texObjects, layer.Index, layer.Name, layer.Properties, name, rgba)
+
+	if ld.c.Metrics != nil {
+		ld.c.Metrics.Vertices += 6
+	}
+	// Repeating images are drawn as a quad covering the map along the
+	// repeating axis, whose texture coordinates repeat the image.
+	b := rgba.Bounds()
+	rect := image.Rect(0, 0, b.Dx(), b.Dy())
+	ox, oy := layer.EffectiveOffset()
+	repeat := func(offset float64, size, mapSize int) (min, max int) {
+		min = int(math.Floor(-offset / float64(size)))
+		max = int(math.Ceil((float64(mapSize) - offset) / float64(size)))
+		if max <= min {
+			max = min + 1
+		}
+		return min * size, max * size
+	}
+	if layer.RepeatX && b.Dx() > 0 {
+		rect.Min.X, rect.Max.X = repeat(ox, b.Dx(), m.Width*m.TileWidth)
+		obj.Textures[0].WrapU = gfx.Repeat
+	}
+	if layer.RepeatY && b.Dy() > 0 {
+		rect.Min.Y, rect.Max.Y = repeat(oy, b.Dy(), m.Height*m.TileHeight)
+		obj.Textures[0].WrapV = gfx.Repeat
+	}
+	left := float32(ox + float64(rect.Min.X))
+	top := float32(float64(m.Height*m.TileHeight-rect.Min.Y) - oy)
+	appendCard(
+		obj.Meshes[0],
+		left,
+		left+float32(rect.Dx()),
+		top-float32(rect.Dy()),
+		top,
+		float32(depth), rect, b,
+	)
+	ld.cardAdded(obj.Meshes[0], 0, 0)
+	ld.layers[layer.Name] = texObjects
+}
+
+// Load loads the given tmx map, m, and returns a slice of *gfx.Object with the
+// proper meshes and textures attached to them.
+//
+// If the configuration, c, is non-nil then it is used in place of the default
+// configuration.
+//
+// The tsImages map should be a map of tileset (and image layer) image
+// filenames and their associated loaded RGBA images. Tiles who reference
+// tilesets who are not found in the map will be omited (not rendered) in the
+// returned objects, as will image layers whose image is not found.
+//
+// The returned map is keyed by layer name, and then by the name of the image
+// whose texture is attached to each object. Tile layers are loaded first, then
+// image layers and then object groups, and a layer of any kind replaces the
+// entry of a layer of the same name loaded before it, so layers whose objects
+// are needed should have unique names.
+//
+// Tile layers are placed on the Y axis in the order of m.Layers, each
+// c.LayerOffset apart. Object groups, image layers and group layers are placed
+// in their draw order (see Layer.Index) too, spread evenly between the tile
+// layers they are drawn between, such that the tile layers stay where they
+// were before the other kinds of layers were rendered. Tile objects (those
+// with a non-zero Gid) are rendered as part of their object group's layer.
+//
+// Layers, image layers, object groups and objects may carry a conventional
+// "z" (or "draworder") custom property whose numeric value offsets their
+// depth by that many layers, where a positive value draws them above
+// (I.e. closer to the camera than) what their draw order would otherwise
+// dictate. Layers may also carry a "blendmode" custom property, see BlendMode.
+//
+// Designers may control rendering further with these conventional custom
+// properties of layers, image layers and object groups:
+//  norender         - If "true", the layer is not loaded at all.
+//  collision-only   - If "true", the layer only holds collision data (see
+//                     Map.Outlines), and is not loaded either.
+//  above-entities   - If "true", the layer is drawn in front of all other
+//                     layers of the map (as if it's draw order were offset by
+//                     the number of layers), and thus in front of entities
+//                     placed at the depth of any other layer.
+//  opacity-override - A number between 0 and 1 which replaces the effective
+//                     opacity of the layer.
+//
+// The offset and opacity of each layer are composited through the group layers
+// it is within (see Layer.EffectiveOffset and Layer.EffectiveOpacity), and
+// translucent layers are alpha blended. Invisible layers are loaded all the
+// same, clients may hide their objects using Layer.EffectiveVisible.
+func Load(m *Map, c *Config, tsImages map[string]*image.RGBA) (layers map[string]map[string]*gfx.Object) {
+	if c == nil {
+		c = DefaultConfig()
+	}
+	return groupLayers(m, c, load(m, c, tsImages))
+}
+
+// load is like Load, except the configuration must be non-nil and the layers
+// are not grouped.
+func load(m *Map, c *Config, tsImages map[string]*image.RGBA) map[string]map[string]*gfx.Object {
+	if c.Metrics != nil {
+		start := time.Now()
+		defer func() {
+			c.Metrics.Mesh += time.Since(start)
+		}()
+	}
+
+	ld := newLoader(m, c, tsImages)
+	for _, job := range ld.jobs() {
+		job()
+	}
+	return ld.layers
+}
+
+// layerProperties returns a map of the names of all of the layers, image
+// layers and object groups of the map and their properties.
+func (m *Map) layerProperties() map[string]map[string]string {
+	props := make(map[string]map[string]string, len(m.Layers)+len(m.ImageLayers)+len(m.ObjectGroups))
+	for _, l := range m.Layers {
+		props[l.Name] = l.Properties
+	}
+	for _, l := range m.ImageLayers {
+		props[l.Name] = l.Properties
+	}
+	for _, g := range m.ObjectGroups {
+		props[g.Name] = g.Properties
+	}
+	return props
+}
+
+// groupLayers groups the loaded layers by the values of the configuration's
+// group property (see Config.GroupProperty), returning them unmodified if
+// there is none.
+func groupLayers(m *Map, c *Config, layers map[string]map[string]*gfx.Object) map[string]map[string]*gfx.Object {
+	if len(c.GroupProperty) == 0 {
+		return layers
+	}
+	props := m.layerProperties()
+	grouped := make(map[string]map[string]*gfx.Object, len(layers))
+	for layer, objects := range layers {
+		group := props[layer][c.GroupProperty]
+		if len(group) == 0 {
+			grouped[layer] = objects
+			continue
+		}
+		g, ok := grouped[group]
+		if !ok {
+			g = make(map[string]*gfx.Object)
+			grouped[group] = g
+		}
+		for name, obj := range objects {
+			g[layer+"/"+name] = obj
+		}
+	}
+	return grouped
+}
 
-		for _, layer := range m.Layers {
-			// Collect all the little tile meshes
-			for tex, tsImageNode := range texturedNodes {
-				_, collected := geom.Collect(tsImageNode)
-				collected.SetParent(baseNode)
-				tsImageNode.Detatch()
-				//collected := tsImageNode
-
-				// Add the tileset texture to the collected set of tile meshes
-				tex.SetWrapModeU(texture.Clamp)
-				tex.SetWrapModeV(texture.Clamp)
-				texture.Set(collected, texture.DefaultLayer, tex)
-
-				// Decrease Y by layer offset
-				x, _, z := collected.Pos()
-				collected.SetPos(x, float64(layerOffset), z)
+// ReplaceImage replaces the image of the texture attached to the given object,
+// which must be one returned by Load, with the given RGBA image. The texture
+// is marked as not loaded such that the new image is uploaded the next time
+// the object is drawn, the object's meshes are left untouched. Objects
+// without textures are left untouched as well.
+//
+// This is useful for driving dynamic image layers (e.g. a day/night sky, or
+// video frames) at runtime without rebuilding the entire map.
+func ReplaceImage(obj *gfx.Object, rgba *image.RGBA) {
+	obj.RLock()
+	if len(obj.Textures) == 0 {
+		obj.RUnlock()
+		return
+	}
+	t := obj.Textures[0]
+	obj.RUnlock()
+	replaceTexture(t, rgba)
+}
+
+// replaceTexture replaces the image of the given texture, marking it as not
+// loaded, see ReplaceImage.
+func replaceTexture(t *gfx.Texture, rgba *image.RGBA) {
+	t.Lock()
+	if t.NativeTexture != nil {
+		t.NativeTexture.Destroy()
+		t.NativeTexture = nil
+	}
+	t.Source = rgba
+	t.Bounds = rgba.Bounds()
+	t.Loaded = false
+	t.Unlock()
+}
+
+// ReplaceTextures replaces the image of the textures of the given objects (as
+// returned by Load or LoadFile, grouped or not) which were created for the
+// image with the given name (like the keys of the tsImages map given to Load),
+// for instance to animate a tileset image or cycle it's palette at runtime.
+// Each texture is replaced once, even if it is shared by several objects (see
+// TextureCache), and is marked as not loaded such that the new image is
+// uploaded the next time it is drawn. Meshes are left untouched.
+//
+// The image must have the same size as the one it replaces, as the texture
+// coordinates of the meshes depend on it, otherwise an error is returned and
+// no texture is replaced. If the map was loaded with LinearizeImages, the image
+// should be linearized as well (see LinearImage). It returns the number of
+// textures that were replaced.
+func ReplaceTextures(layers map[string]map[string]*gfx.Object, name string, rgba *image.RGBA) (int, error) {
+	var textures []*gfx.Texture
+	seen := make(map[*gfx.Texture]bool)
+	for _, objects := range layers {
+		for key, obj := range objects {
+			if key != name && !strings.HasSuffix(key, "/"+name) {
+				continue
+			}
+			obj.RLock()
+			var t *gfx.Texture
+			if len(obj.Textures) > 0 {
+				t = obj.Textures[0]
+			}
+			obj.RUnlock()
+			if t == nil || seen[t] {
+				continue
 			}
+			seen[t] = true
+			t.RLock()
+			bounds := t.Bounds
+			t.RUnlock()
+			if bounds.Size() != rgba.Bounds().Size() {
+				return 0, fmt.Errorf("ReplaceTextures(): image %q is %v, want %v", name, rgba.Bounds().Size(), bounds.Size())
+			}
+			textures = append(textures, t)
+		}
+	}
+	for _, t := range textures {
+		replaceTexture(t, rgba)
+	}
+	return len(textures), nil
+}
 
+// Pick returns the tile coordinates of the map cell, and the topmost visible
+// object (if any), under the given window point.
+//
+// The camera, cam, must be the one rendering the objects returned by Load for
+// the map m with the given configuration, c (if nil, DefaultConfig is used),
+// and bounds are the bounds of the window or canvas the point is relative to.
+//
+// Load lays the cells of maps of every orientation out orthogonally, and so
+// does Pick, such that the returned cell is the one drawn under the point.
+// Maps whose cells are positioned according to their orientation instead
+// should use PickCell. Layer offsets are not applied to the returned cell, but
+// they are when searching for objects. If the point is not above the map, ok
+// is false.
+func Pick(m *Map, c *Config, cam *gfx.Camera, bounds image.Rectangle, p image.Point) (tile Coord, obj *Object, ok bool) {
+	return pick(m, c, cam, bounds, p)
+}
+
+// unprojector unprojects window points into rays in world space, like
+// *gfx.Camera does.
+type unprojector interface {
+	Unproject(p lmath.Vec2) (near, far lmath.Vec3, ok bool)
+}
+
+// pick implements Pick for any camera.
+func pick(m *Map, c *Config, cam unprojector, bounds image.Rectangle, p image.Point) (tile Coord, obj *Object, ok bool) {
+	if c == nil {
+		c = DefaultConfig()
+	}
+	if m.TileWidth == 0 || m.TileHeight == 0 {
+		return
+	}
+	ray, ok := pickRay(m, c, cam, bounds, p)
+	if !ok {
+		return tile, nil, false
+	}
+	at := func(depth float64) image.Point {
+		p := ray(depth)
+		return image.Pt(int(math.Floor(p.X)), int(math.Floor(p.Y)))
+	}
+
+	// Search objects from the topmost object group downward.
+	ld := &loader{m: m, c: c}
+	order := drawOrder(m)
+	for i := len(order) - 1; i >= 0 && obj == nil; i-- {
+		g := order[i].group
+		if g == nil || !g.EffectiveVisible() {
+			continue
 		}
-	*/
-	return layers
+		pt := at(ld.layerDepth(g.Index, g.Properties))
+		for j := len(g.Objects) - 1; j >= 0; j-- {
+			if o := g.Objects[j]; o.Visible && pt.In(g.Bounds(o)) {
+				obj = o
+				break
+			}
+		}
+	}
+
+	pt := at(0)
+	tile = Coord{
+		int(math.Floor(float64(pt.X) / float64(m.TileWidth))),
+		int(math.Floor(float64(pt.Y) / float64(m.TileHeight))),
+	}
+	ok = image.Pt(tile.X, tile.Y).In(m.Bounds())
+	return tile, obj, ok
+}
+
+// pickRay returns a function returning the map pixel at which the ray through
+// the given window point (see Pick) intersects the plane at the given depth,
+// undoing the axis mapping of Load. If there is no such ray, or it is parallel
+// to the map, ok is false.
+func pickRay(m *Map, c *Config, cam unprojector, bounds image.Rectangle, p image.Point) (at func(depth float64) Pixel, ok bool) {
+	if bounds.Empty() {
+		return nil, false
+	}
+
+	// Convert the window point (where +Y is down) into the -1 to +1 range.
+	near, far, ok := cam.Unproject(lmath.Vec2{
+		2*float64(p.X-bounds.Min.X)/float64(bounds.Dx()) - 1,
+		1 - 2*float64(p.Y-bounds.Min.Y)/float64(bounds.Dy()),
+	})
+	if !ok {
+		return nil, false
+	}
+	dir := far.Sub(near)
+	if dir.Y == 0 {
+		return nil, false
+	}
+	return func(depth float64) Pixel {
+		return WorldToPixel(m, c, near.Add(dir.MulScalar((depth-near.Y)/dir.Y)))
+	}, true
 }
 
 // LoadFile works just like Load except it loads all associated dependencies
@@ -324,19 +1853,66 @@ func Load(m *Map, c *Config, tsImages map[string]*image.RGBA) (layers map[string
 // Advanced clients who wish to have more control over file IO will use Load()
 // directly instead of using this function.
 func LoadFile(path string, c *Config) (*Map, map[string]map[string]*gfx.Object, error) {
-	f, err := os.Open(path)
+	m, tsImages, content, err := readMapFile(path, c)
 	if err != nil {
 		return nil, nil, err
 	}
+	if content == nil {
+		return m, Load(m, c, tsImages), nil
+	}
+
+	// The cache stores the layers before they are grouped, such that the
+	// group property does not affect the cache.
+	start := time.Now()
+	cache := c.meshCachePath(content)
+	if layers, ok := readMeshCache(cache, m, c, tsImages); ok {
+		if c.Metrics != nil {
+			c.Metrics.Mesh += time.Since(start)
+		}
+		return m, groupLayers(m, c, layers), nil
+	}
+	layers := load(m, c, tsImages)
+	writeMeshCache(cache, layers)
+	return m, groupLayers(m, c, layers), nil
+}
+
+// readMapFile reads and parses the map file at the given path, along with it's
+// external tilesets and images, for LoadFile. If the configuration uses the
+// mesh cache, the hash of all of the content is returned as well.
+func readMapFile(path string, c *Config) (m *Map, tsImages map[string]*image.RGBA, content hash.Hash, err error) {
+	// Measure the time spent in each stage, if desired.
+	var metrics *Metrics
+	if c != nil {
+		metrics = c.Metrics
+	}
+	stage := time.Now()
+	lap := func() time.Duration {
+		now := time.Now()
+		d := now.Sub(stage)
+		stage = now
+		return d
+	}
 
-	data, err := ioutil.ReadAll(f)
+	data, unmap, err := mapFile(path)
 	if err != nil {
-		return nil, nil, err
+		return nil, nil, nil, err
 	}
+	defer unmap()
 
-	m, err := Parse(data)
+	m, err = Parse(data)
 	if err != nil {
-		return nil, nil, err
+		return nil, nil, nil, err
+	}
+	if metrics != nil {
+		metrics.Parse += lap()
+		metrics.BytesDecompressed += m.decoded
+	}
+
+	// The hash of all of the content, if caching meshes.
+	if c.useMeshCache() {
+		content = sha256.New()
+		c.hashMesh(content)
+		content.Write(data)
 	}
 
 	relativeDir := filepath.Dir(path)
@@ -347,52 +1923,152 @@ func LoadFile(path string, c *Config) (*Map, map[string]map[string]*gfx.Object,
 			// Open tsx file
 			f, err := os.Open(filepath.Join(relativeDir, filepath.Base(ts.Source)))
 			if err != nil {
-				return nil, nil, err
+				return nil, nil, nil, err
 			}
 
 			// Read file data
 			data, err := ioutil.ReadAll(f)
 			if err != nil {
-				return nil, nil, err
+				return nil, nil, nil, err
+			}
+			if content != nil {
+				content.Write(data)
 			}
 
 			// Load the tileset
 			err = ts.Load(data)
 			if err != nil {
-				return nil, nil, err
+				return nil, nil, nil, err
 			}
 		}
 	}
 
-	// We must also load the images of the tileset
-	tsImages := make(map[string]*image.RGBA)
+	if metrics != nil {
+		metrics.Tilesets += lap()
+	}
+
+	// We must also load the images of the tilesets and image layers.
+	var sources []string
 	for _, ts := range m.Tilesets {
-		// Name of the tileset image file
-		tsImage := filepath.Base(ts.Image.Source)
+		sources = append(sources, ts.Image.Source)
+	}
+	for _, l := range m.ImageLayers {
+		if len(l.Image.Source) > 0 {
+			sources = append(sources, l.Image.Source)
+		}
+	}
+	var (
+		names []string
+		files = make(map[string][]byte)
+	)
+	for _, source := range sources {
+		// Name of the image file
+		tsImage := filepath.Base(source)
 
-		// Open tileset image
-		f, err := os.Open(filepath.Join(relativeDir, tsImage))
+		// Read tileset image
+		data, err := ioutil.ReadFile(filepath.Join(relativeDir, tsImage))
 		if err != nil {
-			return nil, nil, err
+			return nil, nil, nil, err
 		}
+		if content != nil {
+			fmt.Fprintf(content, "%s\n", tsImage)
+			content.Write(data)
+		}
+		if _, ok := files[tsImage]; !ok {
+			names = append(names, tsImage)
+		}
+		files[tsImage] = data
+	}
 
-		// Decode the image
-		src, _, err := image.Decode(f)
-		if err != nil {
-			return nil, nil, err
+	// Decode the images, in parallel if desired.
+	workers := 1
+	if c != nil && c.ImageWorkers > 1 {
+		workers = c.ImageWorkers
+	}
+	images := make([]*image.RGBA, len(names))
+	errs := make([]error, len(names))
+	decode := func(i int) {
+		images[i], errs[i] = decodeRGBA(files[names[i]])
+	}
+	if workers == 1 {
+		for i := range names {
+			decode(i)
+		}
+	} else {
+		next := make(chan int)
+		var wg sync.WaitGroup
+		for w := 0; w < workers && w < len(names); w++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for i := range next {
+					decode(i)
+				}
+			}()
+		}
+		for i := range names {
+			next <- i
 		}
+		close(next)
+		wg.Wait()
+	}
 
-		// If need be, convert to RGBA
-		rgba, ok := src.(*image.RGBA)
-		if !ok {
-			b := src.Bounds()
-			rgba = image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
-			draw.Draw(rgba, rgba.Bounds(), src, b.Min, draw.Src)
+	tsImages = make(map[string]*image.RGBA, len(names))
+	for i, name := range names {
+		if errs[i] != nil {
+			return nil, nil, nil, errs[i]
 		}
+		tsImages[name] = images[i]
+	}
 
-		// Put into the tileset images map
-		tsImages[tsImage] = rgba
+	// Fill in the image sizes which the TMX file did not specify, such that
+	// Tileset.RectForGID works.
+	for _, ts := range m.Tilesets {
+		rgba, ok := tsImages[filepath.Base(ts.Image.Source)]
+		if ok && ts.Image.Width == 0 && ts.Image.Height == 0 {
+			ts.Image.Width, ts.Image.Height = rgba.Bounds().Dx(), rgba.Bounds().Dy()
+		}
 	}
+	if metrics != nil {
+		metrics.Images += lap()
+	}
+
+	return m, tsImages, content, nil
+}
 
-	return m, Load(m, c, tsImages), nil
+// decodeRGBA decodes the given image file data, converting the image to RGBA
+// if need be. RGBA images are used as-is and NRGBA images are premultiplied in
+// place, such that neither is copied.
+func decodeRGBA(data []byte) (*image.RGBA, error) {
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	switch src := src.(type) {
+	case *image.RGBA:
+		return src, nil
+
+	case *image.NRGBA:
+		b := src.Bounds()
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			p := src.Pix[src.PixOffset(b.Min.X, y):]
+			for x := 0; x < 4*b.Dx(); x += 4 {
+				a := uint32(p[x+3])
+				if a == 255 {
+					continue
+				}
+
+				// This rounds exactly like draw.Draw does.
+				a *= 0x101
+				for i := 0; i < 3; i++ {
+					p[x+i] = uint8(uint32(p[x+i]) * a / 0xff >> 8)
+				}
+			}
+		}
+		return &image.RGBA{Pix: src.Pix, Stride: src.Stride, Rect: src.Rect}, nil
+	}
+	b := src.Bounds()
+	rgba := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(rgba, rgba.Bounds(), src, b.Min, draw.Src)
+	return rgba, nil
 }
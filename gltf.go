@@ -0,0 +1,341 @@
+// Copyright 2014 The Azul3D Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tmx
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"image"
+	"image/png"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+
+	"azul3d.org/gfx.v2-unstable"
+)
+
+// glTF constants, see the glTF 2.0 specification.
+const (
+	gltfFloat        = 5126
+	gltfUnsignedInt  = 5125
+	gltfArrayBuffer  = 34962
+	gltfElementArray = 34963
+	gltfNearest      = 9728
+	gltfLinear       = 9729
+	gltfRepeat       = 10497
+	gltfClamp        = 33071
+	gltfMirror       = 33648
+)
+
+type gltfAsset struct {
+	Version   string `json:"version"`
+	Generator string `json:"generator"`
+}
+
+type gltfScene struct {
+	Nodes []int `json:"nodes"`
+}
+
+type gltfNode struct {
+	Name string `json:"name"`
+	Mesh *int   `json:"mesh,omitempty"`
+}
+
+type gltfPrimitive struct {
+	Attributes map[string]int `json:"attributes"`
+	Indices    *int           `json:"indices,omitempty"`
+	Material   int            `json:"material"`
+}
+
+type gltfMesh struct {
+	Name       string          `json:"name"`
+	Primitives []gltfPrimitive `json:"primitives"`
+}
+
+type gltfAccessor struct {
+	BufferView    int       `json:"bufferView"`
+	ComponentType int       `json:"componentType"`
+	Count         int       `json:"count"`
+	Type          string    `json:"type"`
+	Min           []float32 `json:"min,omitempty"`
+	Max           []float32 `json:"max,omitempty"`
+}
+
+type gltfBufferView struct {
+	Buffer     int `json:"buffer"`
+	ByteOffset int `json:"byteOffset"`
+	ByteLength int `json:"byteLength"`
+	Target     int `json:"target"`
+}
+
+type gltfBuffer struct {
+	ByteLength int    `json:"byteLength"`
+	URI        string `json:"uri"`
+}
+
+type gltfTextureInfo struct {
+	Index int `json:"index"`
+}
+
+type gltfPBR struct {
+	BaseColorTexture *gltfTextureInfo `json:"baseColorTexture,omitempty"`
+	MetallicFactor   float64          `json:"metallicFactor"`
+}
+
+type gltfMaterial struct {
+	PBR         gltfPBR                `json:"pbrMetallicRoughness"`
+	AlphaMode   string                 `json:"alphaMode"`
+	DoubleSided bool                   `json:"doubleSided"`
+	Extensions  map[string]interface{} `json:"extensions"`
+}
+
+type gltfTexture struct {
+	Sampler int `json:"sampler"`
+	Source  int `json:"source"`
+}
+
+type gltfImage struct {
+	URI string `json:"uri"`
+}
+
+type gltfSampler struct {
+	MagFilter int `json:"magFilter"`
+	MinFilter int `json:"minFilter"`
+	WrapS     int `json:"wrapS"`
+	WrapT     int `json:"wrapT"`
+}
+
+type gltfDocument struct {
+	Asset          gltfAsset        `json:"asset"`
+	ExtensionsUsed []string         `json:"extensionsUsed"`
+	Scene          int              `json:"scene"`
+	Scenes         []gltfScene      `json:"scenes"`
+	Nodes          []gltfNode       `json:"nodes"`
+	Meshes         []gltfMesh       `json:"meshes,omitempty"`
+	Accessors      []gltfAccessor   `json:"accessors,omitempty"`
+	BufferViews    []gltfBufferView `json:"bufferViews,omitempty"`
+	Buffers        []gltfBuffer     `json:"buffers,omitempty"`
+	Materials      []gltfMaterial   `json:"materials,omitempty"`
+	Textures       []gltfTexture    `json:"textures,omitempty"`
+	Images         []gltfImage      `json:"images,omitempty"`
+	Samplers       []gltfSampler    `json:"samplers,omitempty"`
+}
+
+// gltfWriter builds a glTF document, see WriteGLTF.
+type gltfWriter struct {
+	doc       gltfDocument
+	buf       bytes.Buffer
+	materials map[*gfx.Texture]int
+	images    map[image.Image]int
+	samplers  map[gltfSampler]int
+}
+
+// view appends the given data to the buffer, and returns the index of the
+// accessor of it.
+func (g *gltfWriter) view(data interface{}, target, componentType, count int, typ string) int {
+	offset := g.buf.Len()
+	binary.Write(&g.buf, binary.LittleEndian, data)
+	g.doc.BufferViews = append(g.doc.BufferViews, gltfBufferView{
+		ByteOffset: offset,
+		ByteLength: g.buf.Len() - offset,
+		Target:     target,
+	})
+	g.doc.Accessors = append(g.doc.Accessors, gltfAccessor{
+		BufferView:    len(g.doc.BufferViews) - 1,
+		ComponentType: componentType,
+		Count:         count,
+		Type:          typ,
+	})
+	return len(g.doc.Accessors) - 1
+}
+
+// gltfFilter returns the glTF filter for the given texture filter.
+func gltfFilter(f gfx.TexFilter) int {
+	if f == gfx.Nearest || f == gfx.NearestMipmapNearest || f == gfx.NearestMipmapLinear {
+		return gltfNearest
+	}
+	return gltfLinear
+}
+
+// gltfWrap returns the glTF wrap mode for the given texture wrap mode.
+func gltfWrap(w gfx.TexWrap) int {
+	switch w {
+	case gfx.Repeat:
+		return gltfRepeat
+	case gfx.Mirror:
+		return gltfMirror
+	}
+	return gltfClamp
+}
+
+// material returns the index of the material for the given texture (or nil,
+// for untextured objects), encoding it's source image if needed. Textures
+// sharing a source image share the encoded image.
+func (g *gltfWriter) material(t *gfx.Texture) (int, error) {
+	if i, ok := g.materials[t]; ok {
+		return i, nil
+	}
+	m := gltfMaterial{
+		AlphaMode: "BLEND",
+		// Flipped tiles are mirrored cards, so their winding is reversed.
+		DoubleSided: true,
+		Extensions:  map[string]interface{}{"KHR_materials_unlit": struct{}{}},
+	}
+	if t != nil && t.Source != nil {
+		img, ok := g.images[t.Source]
+		if !ok {
+			data := new(bytes.Buffer)
+			if err := png.Encode(data, t.Source); err != nil {
+				return 0, err
+			}
+			img = len(g.doc.Images)
+			g.images[t.Source] = img
+			g.doc.Images = append(g.doc.Images, gltfImage{
+				URI: "data:image/png;base64," + base64.StdEncoding.EncodeToString(data.Bytes()),
+			})
+		}
+		s := gltfSampler{
+			MagFilter: gltfFilter(t.MagFilter),
+			MinFilter: gltfFilter(t.MinFilter),
+			WrapS:     gltfWrap(t.WrapU),
+			WrapT:     gltfWrap(t.WrapV),
+		}
+		si, ok := g.samplers[s]
+		if !ok {
+			si = len(g.doc.Samplers)
+			g.samplers[s] = si
+			g.doc.Samplers = append(g.doc.Samplers, s)
+		}
+		g.doc.Textures = append(g.doc.Textures, gltfTexture{Sampler: si, Source: img})
+		m.PBR.BaseColorTexture = &gltfTextureInfo{Index: len(g.doc.Textures) - 1}
+	}
+	g.doc.Materials = append(g.doc.Materials, m)
+	g.materials[t] = len(g.doc.Materials) - 1
+	return len(g.doc.Materials) - 1, nil
+}
+
+// primitive appends the primitive of the given mesh, textured by t (which may
+// be nil), to the glTF mesh gm.
+func (g *gltfWriter) primitive(gm *gltfMesh, mesh *gfx.Mesh, t *gfx.Texture) error {
+	mesh.RLock()
+	defer mesh.RUnlock()
+	if len(mesh.Vertices) == 0 {
+		return nil
+	}
+	mat, err := g.material(t)
+	if err != nil {
+		return err
+	}
+	p := gltfPrimitive{Attributes: make(map[string]int), Material: mat}
+
+	// Azul3D is Z-up with +Y into the screen, glTF is Y-up with -Z into the
+	// screen.
+	positions := make([][3]float32, len(mesh.Vertices))
+	min := []float32{math.MaxFloat32, math.MaxFloat32, math.MaxFloat32}
+	max := []float32{-math.MaxFloat32, -math.MaxFloat32, -math.MaxFloat32}
+	for i, v := range mesh.Vertices {
+		positions[i] = [3]float32{v.X, v.Z, -v.Y}
+		for k, c := range positions[i] {
+			min[k] = float32(math.Min(float64(min[k]), float64(c)))
+			max[k] = float32(math.Max(float64(max[k]), float64(c)))
+		}
+	}
+	p.Attributes["POSITION"] = g.view(positions, gltfArrayBuffer, gltfFloat, len(positions), "VEC3")
+	g.doc.Accessors[p.Attributes["POSITION"]].Min = min
+	g.doc.Accessors[p.Attributes["POSITION"]].Max = max
+
+	if len(mesh.Colors) == len(mesh.Vertices) {
+		p.Attributes["COLOR_0"] = g.view(mesh.Colors, gltfArrayBuffer, gltfFloat, len(mesh.Colors), "VEC4")
+	}
+	for i, set := range mesh.TexCoords {
+		if len(set.Slice) == len(mesh.Vertices) {
+			p.Attributes["TEXCOORD_"+strconv.Itoa(i)] = g.view(set.Slice, gltfArrayBuffer, gltfFloat, len(set.Slice), "VEC2")
+		}
+	}
+	if len(mesh.Indices) > 0 {
+		i := g.view(mesh.Indices, gltfElementArray, gltfUnsignedInt, len(mesh.Indices), "SCALAR")
+		p.Indices = &i
+	}
+	gm.Primitives = append(gm.Primitives, p)
+	return nil
+}
+
+// WriteGLTF writes the given layers, as returned by Load, as a glTF 2.0 scene
+// to w, such that maps can be inspected in standard 3D viewers or imported into
+// other tools. The buffer and the (PNG encoded) texture images are embedded in
+// the file.
+//
+// Each layer is a single node of the scene, named after it, whose mesh holds a
+// primitive for each of the layer's objects. The vertices are exported just as
+// Load placed them (object transforms are ignored), converted to the Y-up
+// coordinate system of glTF. Materials are unlit and alpha blended.
+//
+// Only the data that is still held in memory is exported (I.e. not that of
+// meshes which were loaded by the renderer and then freed).
+func WriteGLTF(w io.Writer, layers map[string]map[string]*gfx.Object) error {
+	g := &gltfWriter{
+		doc: gltfDocument{
+			Asset:          gltfAsset{Version: "2.0", Generator: "azul3d.org/tmx.v1"},
+			ExtensionsUsed: []string{"KHR_materials_unlit"},
+			Scenes:         []gltfScene{{Nodes: []int{}}},
+		},
+		materials: make(map[*gfx.Texture]int),
+		images:    make(map[image.Image]int),
+		samplers:  make(map[gltfSampler]int),
+	}
+
+	// Sort things, such that the output is deterministic.
+	var names []string
+	for name := range layers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		var keys []string
+		for key := range layers[name] {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		gm := gltfMesh{Name: name}
+		for _, key := range keys {
+			obj := layers[name][key]
+			obj.RLock()
+			var t *gfx.Texture
+			if len(obj.Textures) > 0 {
+				t = obj.Textures[0]
+			}
+			for _, mesh := range obj.Meshes {
+				if err := g.primitive(&gm, mesh, t); err != nil {
+					obj.RUnlock()
+					return err
+				}
+			}
+			obj.RUnlock()
+		}
+
+		node := gltfNode{Name: name}
+		if len(gm.Primitives) > 0 {
+			i := len(g.doc.Meshes)
+			node.Mesh = &i
+			g.doc.Meshes = append(g.doc.Meshes, gm)
+		}
+		g.doc.Scenes[0].Nodes = append(g.doc.Scenes[0].Nodes, len(g.doc.Nodes))
+		g.doc.Nodes = append(g.doc.Nodes, node)
+	}
+
+	if g.buf.Len() > 0 {
+		g.doc.Buffers = []gltfBuffer{{
+			ByteLength: g.buf.Len(),
+			URI:        "data:application/octet-stream;base64," + base64.StdEncoding.EncodeToString(g.buf.Bytes()),
+		}}
+	}
+	e := json.NewEncoder(w)
+	e.SetIndent("", " ")
+	return e.Encode(g.doc)
+}
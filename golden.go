@@ -0,0 +1,262 @@
+// Copyright 2014 The Azul3D Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tmx
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"math"
+	"os"
+	"sort"
+
+	"azul3d.org/gfx.v2-unstable"
+	"azul3d.org/lmath.v1"
+)
+
+// RasterizeLayers renders the given objects (as returned by Load or LoadFile
+// for the map m with the configuration c, or nil for the default one) in
+// software, viewed from above such that each pixel of the returned image is a
+// pixel of the map (see WorldToPixel). The image covers all of the objects'
+// triangles, whether indexed (see DedupCards) or consecutive.
+//
+// Triangles are drawn from the farthest to the nearest depth, sampling their
+// texture at the nearest texel, multiplying it by their vertex colors and
+// blending it over the image. Shaders are not run, so effects like palettes
+// and color grading are not rendered.
+//
+// Along with CompareImages and CheckGolden this makes for regression tests of
+// the tessellation of maps, catching orientation, offset and flip mistakes.
+func RasterizeLayers(m *Map, c *Config, layers map[string]map[string]*gfx.Object) *image.RGBA {
+	type vertex struct {
+		p    Pixel
+		uv   gfx.TexCoord
+		col  gfx.Color
+		tex  image.Image
+		dpth float32
+	}
+	var tris [][3]vertex
+	for _, objects := range layers {
+		for _, obj := range objects {
+			obj.RLock()
+			if len(obj.Meshes) == 0 || len(obj.Textures) == 0 {
+				obj.RUnlock()
+				continue
+			}
+			mesh, t := obj.Meshes[0], obj.Textures[0]
+			obj.RUnlock()
+
+			t.RLock()
+			tex := t.Source
+			t.RUnlock()
+			mesh.RLock()
+
+			// Triangles are indexed (see DedupCards) or just consecutive.
+			indices := mesh.Indices
+			if len(indices) == 0 {
+				indices = make([]uint32, len(mesh.Vertices)/3*3)
+				for i := range indices {
+					indices[i] = uint32(i)
+				}
+			}
+		triangles:
+			for i := 0; i+2 < len(indices); i += 3 {
+				var tri [3]vertex
+				for j := range tri {
+					k := int(indices[i+j])
+					if k >= len(mesh.Vertices) {
+						continue triangles
+					}
+					v := mesh.Vertices[k]
+					tri[j] = vertex{
+						p:    WorldToPixel(m, c, lmath.Vec3{float64(v.X), float64(v.Y), float64(v.Z)}),
+						col:  gfx.Color{R: 1, G: 1, B: 1, A: 1},
+						tex:  tex,
+						dpth: v.Y,
+					}
+					if len(mesh.TexCoords) > 0 && k < len(mesh.TexCoords[0].Slice) {
+						tri[j].uv = mesh.TexCoords[0].Slice[k]
+					}
+					if k < len(mesh.Colors) {
+						tri[j].col = mesh.Colors[k]
+					}
+				}
+				tris = append(tris, tri)
+			}
+			mesh.RUnlock()
+		}
+	}
+
+	// Load places nearer layers (and tiles) at lower depths. Triangles at
+	// the same depth are sorted by position, such that the output does not
+	// depend on the order of the maps of objects.
+	depth := func(t [3]vertex) float32 {
+		return t[0].dpth + t[1].dpth + t[2].dpth
+	}
+	sort.SliceStable(tris, func(i, j int) bool {
+		a, b := tris[i], tris[j]
+		if da, db := depth(a), depth(b); da != db {
+			return da > db
+		}
+		if a[0].p.Y != b[0].p.Y {
+			return a[0].p.Y < b[0].p.Y
+		}
+		return a[0].p.X < b[0].p.X
+	})
+
+	minX, minY := math.Inf(1), math.Inf(1)
+	maxX, maxY := math.Inf(-1), math.Inf(-1)
+	for _, t := range tris {
+		for _, v := range t {
+			minX, minY = math.Min(minX, v.p.X), math.Min(minY, v.p.Y)
+			maxX, maxY = math.Max(maxX, v.p.X), math.Max(maxY, v.p.Y)
+		}
+	}
+	var bounds image.Rectangle
+	if len(tris) > 0 {
+		bounds = image.Rect(int(math.Floor(minX)), int(math.Floor(minY)), int(math.Ceil(maxX)), int(math.Ceil(maxY)))
+	}
+	out := image.NewRGBA(bounds)
+
+	for _, t := range tris {
+		va, vb, vc := t[0], t[1], t[2]
+		edge := func(p, q Pixel, x, y float64) float64 {
+			return (q.X-p.X)*(y-p.Y) - (q.Y-p.Y)*(x-p.X)
+		}
+		area := edge(va.p, vb.p, vc.p.X, vc.p.Y)
+		if area == 0 {
+			continue
+		}
+		if area < 0 {
+			vb, vc = vc, vb
+			area = -area
+		}
+
+		// Pixels on edges shared by two triangles are only filled by one of
+		// them (the top-left fill rule), such that translucent cards are not
+		// blended twice along their diagonal.
+		topLeft := func(p, q Pixel) bool {
+			return (p.Y == q.Y && q.X < p.X) || q.Y < p.Y
+		}
+		inside := func(w float64, p, q Pixel) bool {
+			return w > 0 || (w == 0 && topLeft(p, q))
+		}
+
+		minX := int(math.Floor(math.Min(va.p.X, math.Min(vb.p.X, vc.p.X))))
+		minY := int(math.Floor(math.Min(va.p.Y, math.Min(vb.p.Y, vc.p.Y))))
+		maxX := int(math.Ceil(math.Max(va.p.X, math.Max(vb.p.X, vc.p.X))))
+		maxY := int(math.Ceil(math.Max(va.p.Y, math.Max(vb.p.Y, vc.p.Y))))
+		for y := minY; y < maxY; y++ {
+			for x := minX; x < maxX; x++ {
+				px, py := float64(x)+0.5, float64(y)+0.5
+				wa := edge(vb.p, vc.p, px, py)
+				wb := edge(vc.p, va.p, px, py)
+				wc := edge(va.p, vb.p, px, py)
+				if !inside(wa, vb.p, vc.p) || !inside(wb, vc.p, va.p) || !inside(wc, va.p, vb.p) {
+					continue
+				}
+				wa, wb, wc = wa/area, wb/area, wc/area
+				lerp := func(fa, fb, fc float32) float64 {
+					return wa*float64(fa) + wb*float64(fb) + wc*float64(fc)
+				}
+				u := lerp(va.uv.U, vb.uv.U, vc.uv.U)
+				v := lerp(va.uv.V, vb.uv.V, vc.uv.V)
+				tb := va.tex.Bounds()
+				tx := tb.Min.X + clamp(int(math.Floor(u*float64(tb.Dx()))), 0, tb.Dx()-1)
+				ty := tb.Min.Y + clamp(int(math.Floor(v*float64(tb.Dy()))), 0, tb.Dy()-1)
+				tr, tg, tbl, ta := va.tex.At(tx, ty).RGBA()
+
+				// The texels are premultiplied by alpha, as is the output.
+				cr := lerp(va.col.R, vb.col.R, vc.col.R)
+				cg := lerp(va.col.G, vb.col.G, vc.col.G)
+				cb := lerp(va.col.B, vb.col.B, vc.col.B)
+				ca := lerp(va.col.A, vb.col.A, vc.col.A)
+				sr := float64(tr) / 0xffff * cr * ca
+				sg := float64(tg) / 0xffff * cg * ca
+				sb := float64(tbl) / 0xffff * cb * ca
+				sa := float64(ta) / 0xffff * ca
+
+				dst := out.RGBAAt(x, y)
+				over := func(s float64, d uint8) uint8 {
+					return uint8(math.Min(255, (s+float64(d)/255*(1-sa))*255+0.5))
+				}
+				out.SetRGBA(x, y, color.RGBA{
+					over(sr, dst.R),
+					over(sg, dst.G),
+					over(sb, dst.B),
+					over(sa, dst.A),
+				})
+			}
+		}
+	}
+	return out
+}
+
+// CompareImages returns the number of pixels of the two images (which are
+// aligned by the top-left corner of their bounds) which differ by more than
+// the given tolerance in any of their 8-bit color components. Pixels which
+// only one of the images has, if they differ in size, count as different.
+func CompareImages(got, want image.Image, tolerance uint8) int {
+	gb, wb := got.Bounds(), want.Bounds()
+	w, h := gb.Dx(), gb.Dy()
+	if wb.Dx() > w {
+		w = wb.Dx()
+	}
+	if wb.Dy() > h {
+		h = wb.Dy()
+	}
+	diff := 0
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			if x >= gb.Dx() || y >= gb.Dy() || x >= wb.Dx() || y >= wb.Dy() {
+				diff++
+				continue
+			}
+			g := color.RGBAModel.Convert(got.At(gb.Min.X+x, gb.Min.Y+y)).(color.RGBA)
+			e := color.RGBAModel.Convert(want.At(wb.Min.X+x, wb.Min.Y+y)).(color.RGBA)
+			for i, gc := range [4]uint8{g.R, g.G, g.B, g.A} {
+				ec := [4]uint8{e.R, e.G, e.B, e.A}[i]
+				if gc > ec && gc-ec > tolerance || ec > gc && ec-gc > tolerance {
+					diff++
+					break
+				}
+			}
+		}
+	}
+	return diff
+}
+
+// CheckGolden compares the given image against the golden PNG image at the
+// given path (see CompareImages), returning an error describing the
+// difference if any pixel differs by more than the given tolerance. If update
+// is true, the golden image is written instead (for instance when a test is
+// run with an update flag, after verifying the change is intended).
+func CheckGolden(path string, got image.Image, tolerance uint8, update bool) error {
+	if update {
+		f, err := os.Create(path)
+		if err != nil {
+			return err
+		}
+		err = png.Encode(f, got)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		return err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	want, err := png.Decode(f)
+	if err != nil {
+		return err
+	}
+	if n := CompareImages(got, want, tolerance); n > 0 {
+		return fmt.Errorf("%s: %d pixels differ (image is %v, golden image is %v)", path, n, got.Bounds().Size(), want.Bounds().Size())
+	}
+	return nil
+}
@@ -0,0 +1,156 @@
+// Copyright 2014 The Azul3D Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tmx
+
+import (
+	"strconv"
+
+	"azul3d.org/gfx.v2-unstable"
+)
+
+// Grade is the simple color grading of a layer, see Graders.
+type Grade struct {
+	// The saturation of colors, where zero is grayscale and one leaves colors
+	// unchanged.
+	Saturation float64
+
+	// The factor that colors are multiplied by, one leaves them unchanged.
+	Brightness float64
+
+	// The color added to colors after the above are applied, for instance a
+	// dark blue at night. It's alpha is ignored.
+	Tint gfx.Color
+}
+
+// NoGrade is the grade which leaves colors unchanged.
+var NoGrade = Grade{Saturation: 1, Brightness: 1}
+
+// gradeProperties returns the grade described by the conventional
+// "saturation", "brightness" and "tint" (a "#rrggbb" color) custom properties
+// in the given properties map, which default to those of NoGrade.
+func gradeProperties(props map[string]string) Grade {
+	g := NoGrade
+	if v, err := strconv.ParseFloat(props["saturation"], 64); err == nil {
+		g.Saturation = v
+	}
+	if v, err := strconv.ParseFloat(props["brightness"], 64); err == nil {
+		g.Brightness = v
+	}
+	if v, ok := props["tint"]; ok {
+		if c, err := ParseColor(v); err == nil {
+			g.Tint = gfx.Color{float32(c.R) / 255, float32(c.G) / 255, float32(c.B) / 255, 1}
+		}
+	}
+	return g
+}
+
+// Graders controls the color grading of the objects created by Load for each
+// layer, as shader uniforms, such that the saturation, brightness and tint of
+// layers can be changed at runtime, for instance for day and night cycles,
+// without a post-processing stack.
+//
+// The grade of each layer is initially that described by it's conventional
+// "saturation", "brightness" and "tint" (a "#rrggbb" color) custom
+// properties, unless Set was called for the layer before it was loaded.
+// Objects using the shaders of the configuration's materials are not graded.
+//
+// A Graders is not safe for use by multiple goroutines at once.
+type Graders struct {
+	layers map[string]*grader
+}
+
+// grader is the grading of a single layer.
+type grader struct {
+	grade Grade
+
+	// The graded variants of the shared shaders, and the objects using them.
+	shaders map[*gfx.Shader]*gfx.Shader
+	objects []*gfx.Object
+}
+
+// layer returns the grader of the named layer, whose properties are given,
+// creating it if needed.
+func (g *Graders) layer(layer string, props map[string]string) *grader {
+	if g.layers == nil {
+		g.layers = make(map[string]*grader)
+	}
+	gr, ok := g.layers[layer]
+	if !ok {
+		gr = &grader{
+			grade:   gradeProperties(props),
+			shaders: make(map[*gfx.Shader]*gfx.Shader),
+		}
+		g.layers[layer] = gr
+	}
+	return gr
+}
+
+// shader returns the graded copy of the given shader variant for the named
+// layer, whose properties are given.
+func (g *Graders) shader(layer string, props map[string]string, variant *gfx.Shader) *gfx.Shader {
+	gr := g.layer(layer, props)
+	if s, ok := gr.shaders[variant]; ok {
+		return s
+	}
+	s := &gfx.Shader{
+		Name:   variant.Name + "/" + layer,
+		GLSL:   variant.GLSL,
+		Inputs: make(map[string]interface{}),
+	}
+	gr.grade.inputs(s.Inputs)
+	gr.shaders[variant] = s
+	return s
+}
+
+// add adds the given object, created for the named layer.
+func (g *Graders) add(layer string, props map[string]string, obj *gfx.Object) {
+	gr := g.layer(layer, props)
+	gr.objects = append(gr.objects, obj)
+}
+
+// inputs stores the grade as the shader inputs of the given map.
+func (gd Grade) inputs(inputs map[string]interface{}) {
+	inputs["Saturation"] = float32(gd.Saturation)
+	inputs["Brightness"] = float32(gd.Brightness)
+	inputs["Tint"] = gd.Tint
+}
+
+// Set sets the grade of the named layer, updating the shaders of it's objects
+// if it is loaded already.
+func (g *Graders) Set(layer string, grade Grade) {
+	gr := g.layer(layer, nil)
+	gr.grade = grade
+
+	// The objects may use copies of the graded shaders (e.g. those of
+	// flipbooks), so update the shaders of the objects themselves.
+	done := make(map[*gfx.Shader]bool)
+	for _, s := range gr.shaders {
+		done[s] = true
+		s.Lock()
+		grade.inputs(s.Inputs)
+		s.Unlock()
+	}
+	for _, obj := range gr.objects {
+		obj.RLock()
+		s := obj.Shader
+		obj.RUnlock()
+		if s == nil || done[s] {
+			continue
+		}
+		done[s] = true
+		s.Lock()
+		grade.inputs(s.Inputs)
+		s.Unlock()
+	}
+}
+
+// Grade returns the current grade of the named layer, or NoGrade if it is not
+// known.
+func (g *Graders) Grade(layer string) Grade {
+	if gr, ok := g.layers[layer]; ok {
+		return gr.grade
+	}
+	return NoGrade
+}
@@ -0,0 +1,196 @@
+// Copyright 2014 Lightpoke. All rights reserved.
+// This source code is subject to the terms and
+// conditions defined in the "License.txt" file.
+
+package tmx
+
+import (
+	"fmt"
+	"math"
+)
+
+type xmlGroup struct {
+	Name       string        `xml:"name,attr"`
+	Class      string        `xml:"class,attr"`
+	Type       string        `xml:"type,attr"`
+	Opacity    *float64      `xml:"opacity,attr"`
+	Visible    *int          `xml:"visible,attr"`
+	OffsetX    float64       `xml:"offsetx,attr"`
+	OffsetY    float64       `xml:"offsety,attr"`
+	ParallaxX  *float64      `xml:"parallaxx,attr"`
+	ParallaxY  *float64      `xml:"parallaxy,attr"`
+	Properties xmlProperties `xml:"properties"`
+	Layers     []xmlMapLayer `xml:",any"`
+}
+
+func (x xmlGroup) toGroupLayer() *GroupLayer {
+	return &GroupLayer{
+		Name:       x.Name,
+		Class:      classAttr(x.Class, x.Type),
+		Opacity:    floatAttr(x.Opacity, 1),
+		Visible:    visibleAttr(x.Visible),
+		OffsetX:    x.OffsetX,
+		OffsetY:    x.OffsetY,
+		ParallaxX:  floatAttr(x.ParallaxX, 1),
+		ParallaxY:  floatAttr(x.ParallaxY, 1),
+		Properties: x.Properties.toMap(),
+	}
+}
+
+// GroupLayer represents a group layer, which groups other layers (including
+// other group layers) together.
+//
+// The opacity, visibility, offset and parallax factors of a group apply to
+// all of the layers within it, see for instance Layer.EffectiveOpacity.
+type GroupLayer struct {
+	// The name of the group layer.
+	Name string
+
+	// The class of the group layer (Tiled 1.9 and later).
+	Class string
+
+	// The draw-order index of this group layer, see Layer.Index for more
+	// information. The layers of a group always come after the group itself.
+	Index int
+
+	// Value between 0 and 1 representing the opacity of the group layer.
+	Opacity float64
+
+	// Boolean value representing whether or not the group layer is visible.
+	Visible bool
+
+	// The horizontal and vertical offset of the group layer in pixels, where
+	// +Y is down.
+	OffsetX, OffsetY float64
+
+	// The horizontal and vertical parallax scrolling factors of the group
+	// layer, see Layer.ParallaxX for more information.
+	ParallaxX, ParallaxY float64
+
+	// Map of properties for this group layer.
+	Properties map[string]string
+
+	// The group layer that this group layer is within, or nil if it is at the
+	// top level of the map.
+	Parent *GroupLayer
+}
+
+// String returns a string representation of this group layer.
+func (g *GroupLayer) String() string {
+	return fmt.Sprintf("GroupLayer(Name=%q, Opacity=%1.f, Visible=%v)", g.Name, g.Opacity, g.Visible)
+}
+
+// EffectiveOpacity returns the opacity of this group layer multiplied by the
+// opacity of all of it's ancestors. It returns one for a nil group.
+func (g *GroupLayer) EffectiveOpacity() float64 {
+	if g == nil {
+		return 1
+	}
+	return g.Opacity * g.Parent.EffectiveOpacity()
+}
+
+// EffectiveVisible tells if this group layer and all of it's ancestors are
+// visible. It returns true for a nil group.
+func (g *GroupLayer) EffectiveVisible() bool {
+	if g == nil {
+		return true
+	}
+	return g.Visible && g.Parent.EffectiveVisible()
+}
+
+// EffectiveOffset returns the offset of this group layer plus the offset of
+// all of it's ancestors, in pixels. It returns zero for a nil group.
+func (g *GroupLayer) EffectiveOffset() (x, y float64) {
+	if g == nil {
+		return 0, 0
+	}
+	x, y = g.Parent.EffectiveOffset()
+	return x + g.OffsetX, y + g.OffsetY
+}
+
+// EffectiveParallax returns the parallax scrolling factors of this group
+// layer multiplied by those of all of it's ancestors. It returns one for a nil
+// group.
+func (g *GroupLayer) EffectiveParallax() (x, y float64) {
+	if g == nil {
+		return 1, 1
+	}
+	x, y = g.Parent.EffectiveParallax()
+	return x * g.ParallaxX, y * g.ParallaxY
+}
+
+// EffectiveOpacity returns the opacity of this layer multiplied by the opacity
+// of all of the group layers it is within, as Tiled displays it.
+func (l *Layer) EffectiveOpacity() float64 {
+	return l.Opacity * l.Parent.EffectiveOpacity()
+}
+
+// EffectiveVisible tells if this layer and all of the group layers it is
+// within are visible.
+func (l *Layer) EffectiveVisible() bool {
+	return l.Visible && l.Parent.EffectiveVisible()
+}
+
+// EffectiveOffset returns the offset of this layer plus the offset of all of
+// the group layers it is within, in pixels.
+func (l *Layer) EffectiveOffset() (x, y float64) {
+	x, y = l.Parent.EffectiveOffset()
+	return x + l.OffsetX, y + l.OffsetY
+}
+
+// EffectiveParallax returns the parallax scrolling factors of this layer
+// multiplied by those of all of the group layers it is within.
+func (l *Layer) EffectiveParallax() (x, y float64) {
+	x, y = l.Parent.EffectiveParallax()
+	return x * l.ParallaxX, y * l.ParallaxY
+}
+
+// EffectiveOpacity is like Layer.EffectiveOpacity, but for object groups.
+func (o *ObjectGroup) EffectiveOpacity() float64 {
+	return o.Opacity * o.Parent.EffectiveOpacity()
+}
+
+// EffectiveVisible is like Layer.EffectiveVisible, but for object groups.
+func (o *ObjectGroup) EffectiveVisible() bool {
+	return o.Visible && o.Parent.EffectiveVisible()
+}
+
+// EffectiveOffset is like Layer.EffectiveOffset, but for object groups.
+func (o *ObjectGroup) EffectiveOffset() (x, y float64) {
+	x, y = o.Parent.EffectiveOffset()
+	return x + o.OffsetX, y + o.OffsetY
+}
+
+// EffectiveParallax is like Layer.EffectiveParallax, but for object groups.
+func (o *ObjectGroup) EffectiveParallax() (x, y float64) {
+	x, y = o.Parent.EffectiveParallax()
+	return x * o.ParallaxX, y * o.ParallaxY
+}
+
+// EffectiveOpacity is like Layer.EffectiveOpacity, but for image layers.
+func (l *ImageLayer) EffectiveOpacity() float64 {
+	return l.Opacity * l.Parent.EffectiveOpacity()
+}
+
+// EffectiveVisible is like Layer.EffectiveVisible, but for image layers.
+func (l *ImageLayer) EffectiveVisible() bool {
+	return l.Visible && l.Parent.EffectiveVisible()
+}
+
+// EffectiveOffset is like Layer.EffectiveOffset, but for image layers.
+func (l *ImageLayer) EffectiveOffset() (x, y float64) {
+	x, y = l.Parent.EffectiveOffset()
+	return x + l.OffsetX, y + l.OffsetY
+}
+
+// EffectiveParallax is like Layer.EffectiveParallax, but for image layers.
+func (l *ImageLayer) EffectiveParallax() (x, y float64) {
+	x, y = l.Parent.EffectiveParallax()
+	return x * l.ParallaxX, y * l.ParallaxY
+}
+
+// pixelFloor returns the whole pixel containing the integer position p moved
+// by the (possibly fractional) offset.
+func pixelFloor(p int, offset float64) int {
+	return int(math.Floor(float64(p) + offset))
+}
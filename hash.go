@@ -0,0 +1,26 @@
+// Copyright 2014 Lightpoke. All rights reserved.
+// This source code is subject to the terms and
+// conditions defined in the "License.txt" file.
+
+package tmx
+
+import "crypto/sha256"
+
+// Hash returns a SHA-256 digest of the logical content of this map, which is
+// useful as a cache key, or for detecting clients and servers whose versions
+// of a map differ.
+//
+// The digest is that of the map written as a TMX map file with CSV encoded
+// tile data (see Write), so it does not depend on the attribute order,
+// whitespace or tile data encoding of the file that the map was parsed from.
+// External tilesets are included by their source only.
+func (m *Map) Hash() [sha256.Size]byte {
+	h := sha256.New()
+
+	// Writing CSV tile data to a hash cannot fail.
+	Write(h, m, &WriteConfig{Encoding: CSVEncoding})
+
+	var sum [sha256.Size]byte
+	copy(sum[:], h.Sum(nil))
+	return sum
+}
@@ -0,0 +1,115 @@
+// Copyright 2014 Lightpoke. All rights reserved.
+// This source code is subject to the terms and
+// conditions defined in the "License.txt" file.
+
+package tmx
+
+// hexParams are the derived pixel metrics of a staggered or hexagonal map, as
+// used by Tiled's hexagonal renderer (staggered maps are hexagonal maps whose
+// side length is zero).
+type hexParams struct {
+	sideLengthX, sideLengthY int
+	columnWidth, rowHeight   int
+}
+
+// hex returns the hexagonal pixel metrics of the map.
+func (m *Map) hex() hexParams {
+	var p hexParams
+	tw, th := m.TileWidth&^1, m.TileHeight&^1
+	if m.Orientation == Hexagonal {
+		if m.StaggerAxis == StaggerAxisX {
+			p.sideLengthX = m.HexSideLength
+		} else {
+			p.sideLengthY = m.HexSideLength
+		}
+	}
+	p.columnWidth = (tw-p.sideLengthX)/2 + p.sideLengthX
+	p.rowHeight = (th-p.sideLengthY)/2 + p.sideLengthY
+	return p
+}
+
+// staggered tells if the row (or column, depending on the stagger axis) with
+// the given index is shifted.
+func (m *Map) staggered(i int) bool {
+	odd := i&1 == 1
+	return odd != (m.StaggerIndex == StaggerIndexEven)
+}
+
+// Axial represents axial hexagon coordinates, see Cube.
+type Axial struct {
+	Q, R int
+}
+
+// Cube returns the cube coordinates for these axial coordinates.
+func (a Axial) Cube() Cube {
+	return Cube{a.Q, a.R, -a.Q - a.R}
+}
+
+// Cube represents cube hexagon coordinates, where Q+R+S is always zero. They
+// make hexagon math like distances and rotations simple, unlike the offset
+// coordinates (I.e. Coord) used by staggered and hexagonal maps.
+type Cube struct {
+	Q, R, S int
+}
+
+// Axial returns the axial coordinates for these cube coordinates.
+func (c Cube) Axial() Axial {
+	return Axial{c.Q, c.R}
+}
+
+// hexDirections are the axial offsets to the six neighbours of a hexagon.
+var hexDirections = [6]Axial{
+	{1, 0}, {1, -1}, {0, -1}, {-1, 0}, {-1, 1}, {0, 1},
+}
+
+// ToAxial converts the tile coordinates of a staggered or hexagonal map into
+// axial hexagon coordinates, honoring the map's stagger axis and index.
+func (m *Map) ToAxial(c Coord) Axial {
+	if m.StaggerAxis == StaggerAxisX {
+		shift := c.X & 1
+		if m.StaggerIndex == StaggerIndexOdd {
+			shift = -shift
+		}
+		return Axial{c.X, c.Y - (c.X+shift)/2}
+	}
+	shift := c.Y & 1
+	if m.StaggerIndex == StaggerIndexOdd {
+		shift = -shift
+	}
+	return Axial{c.X - (c.Y+shift)/2, c.Y}
+}
+
+// FromAxial converts axial hexagon coordinates into the tile coordinates of a
+// staggered or hexagonal map, it is the inverse of ToAxial.
+func (m *Map) FromAxial(a Axial) Coord {
+	if m.StaggerAxis == StaggerAxisX {
+		shift := a.Q & 1
+		if m.StaggerIndex == StaggerIndexOdd {
+			shift = -shift
+		}
+		return Coord{a.Q, a.R + (a.Q+shift)/2}
+	}
+	shift := a.R & 1
+	if m.StaggerIndex == StaggerIndexOdd {
+		shift = -shift
+	}
+	return Coord{a.Q + (a.R+shift)/2, a.R}
+}
+
+// HexNeighbors returns the tile coordinates of the six neighbours of the given
+// tile of a staggered or hexagonal map. Neighbours may lie outside of the map.
+func (m *Map) HexNeighbors(c Coord) [6]Coord {
+	var n [6]Coord
+	a := m.ToAxial(c)
+	for i, d := range hexDirections {
+		n[i] = m.FromAxial(Axial{a.Q + d.Q, a.R + d.R})
+	}
+	return n
+}
+
+// HexDistance returns the distance, in steps between neighbouring tiles,
+// between two tiles of a staggered or hexagonal map.
+func (m *Map) HexDistance(a, b Coord) int {
+	ca, cb := m.ToAxial(a).Cube(), m.ToAxial(b).Cube()
+	return (abs(ca.Q-cb.Q) + abs(ca.R-cb.R) + abs(ca.S-cb.S)) / 2
+}
@@ -0,0 +1,100 @@
+// Copyright 2014 Lightpoke. All rights reserved.
+// This source code is subject to the terms and
+// conditions defined in the "License.txt" file.
+
+package tmx
+
+import (
+	"fmt"
+)
+
+type xmlImagelayer struct {
+	Name       string        `xml:"name,attr"`
+	Class      string        `xml:"class,attr"`
+	Type       string        `xml:"type,attr"`
+	Opacity    *float64      `xml:"opacity,attr"`
+	Visible    *int          `xml:"visible,attr"`
+	OffsetX    float64       `xml:"offsetx,attr"`
+	OffsetY    float64       `xml:"offsety,attr"`
+	ParallaxX  *float64      `xml:"parallaxx,attr"`
+	ParallaxY  *float64      `xml:"parallaxy,attr"`
+	RepeatX    int           `xml:"repeatx,attr"`
+	RepeatY    int           `xml:"repeaty,attr"`
+	Properties xmlProperties `xml:"properties"`
+	Image      xmlImage      `xml:"image"`
+}
+
+func (x xmlImagelayer) toImageLayer() *ImageLayer {
+	return &ImageLayer{
+		Name:       x.Name,
+		Class:      classAttr(x.Class, x.Type),
+		Opacity:    floatAttr(x.Opacity, 1),
+		Visible:    visibleAttr(x.Visible),
+		OffsetX:    x.OffsetX,
+		OffsetY:    x.OffsetY,
+		ParallaxX:  floatAttr(x.ParallaxX, 1),
+		ParallaxY:  floatAttr(x.ParallaxY, 1),
+		RepeatX:    x.RepeatX == 1,
+		RepeatY:    x.RepeatY == 1,
+		Properties: x.Properties.toMap(),
+		Image:      x.Image.toImage(),
+	}
+}
+
+// ImageLayer represents a layer consisting of a single image.
+type ImageLayer struct {
+	// The name of the image layer.
+	Name string
+
+	// The class of the image layer (Tiled 1.9 and later).
+	Class string
+
+	// The draw-order index of this image layer, see Layer.Index for more
+	// information.
+	Index int
+
+	// Value between 0 and 1 representing the opacity of the image layer.
+	Opacity float64
+
+	// Boolean value representing whether or not the image layer is visible.
+	Visible bool
+
+	// The horizontal and vertical offset of the image layer in pixels, where
+	// +Y is down.
+	OffsetX, OffsetY float64
+
+	// The horizontal and vertical parallax scrolling factors of the image
+	// layer, see Layer.ParallaxX for more information.
+	ParallaxX, ParallaxY float64
+
+	// Whether the image repeats infinitely along the X and Y axis (Tiled 1.8
+	// and later).
+	RepeatX, RepeatY bool
+
+	// Map of properties for this image layer.
+	Properties map[string]string
+
+	// The group layer that this image layer is within, or nil if it is at the top
+	// level of the map.
+	Parent *GroupLayer
+
+	// The image of this image layer.
+	Image *Image
+}
+
+// ParallaxOffset returns the additional offset, in pixels, that should be
+// applied to this image layer for the given camera position (in pixels) in
+// order to achieve it's parallax scrolling effect, taking the parallax factors
+// of any group layers it is within into account.
+//
+// The map, m, must be the map that this image layer belongs to, as it
+// specifies the origin for parallax scrolling.
+func (l *ImageLayer) ParallaxOffset(m *Map, camX, camY float64) (x, y float64) {
+	fx, fy := l.EffectiveParallax()
+	return parallaxOffset(m, fx, fy, camX, camY)
+}
+
+// String returns a string representation of this image layer.
+func (l *ImageLayer) String() string {
+	return fmt.Sprintf("ImageLayer(Name=%q, Opacity=%1.f, Visible=%v, Image=%v)", l.Name, l.Opacity, l.Visible, l.Image)
+}
@@ -0,0 +1,60 @@
+// Copyright 2014 The Azul3D Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tmx
+
+import (
+	"image"
+	"time"
+
+	"azul3d.org/gfx.v2-unstable"
+)
+
+// IncrementalLoader loads a map exactly like Load does, but across several
+// calls to Step, such that maps can be loaded during gameplay (e.g. when
+// crossing into a neighbouring map) without causing a hitch.
+//
+// The objects being loaded must not be drawn until loading is done.
+type IncrementalLoader struct {
+	ld   *loader
+	jobs []func()
+}
+
+// NewIncrementalLoader returns a new incremental loader for the given map, the
+// arguments are the same as for Load.
+func NewIncrementalLoader(m *Map, c *Config, tsImages map[string]*image.RGBA) *IncrementalLoader {
+	if c == nil {
+		c = DefaultConfig()
+	}
+	ld := newLoader(m, c, tsImages)
+	return &IncrementalLoader{
+		ld:   ld,
+		jobs: ld.jobs(),
+	}
+}
+
+// Step continues loading the map for roughly the given amount of time (at
+// least one column of tiles, or one image layer or object group, is always
+// loaded), and returns whether or not loading is done.
+func (l *IncrementalLoader) Step(budget time.Duration) (done bool) {
+	start := time.Now()
+	for len(l.jobs) > 0 {
+		l.jobs[0]()
+		l.jobs[0] = nil
+		l.jobs = l.jobs[1:]
+		if time.Since(start) >= budget {
+			break
+		}
+	}
+	if m := l.ld.c.Metrics; m != nil {
+		m.Mesh += time.Since(start)
+	}
+	return len(l.jobs) == 0
+}
+
+// Layers returns the loaded objects, in the same form as Load returns them.
+// The result is complete only once Step has returned true.
+func (l *IncrementalLoader) Layers() map[string]map[string]*gfx.Object {
+	return groupLayers(l.ld.m, l.ld.c, l.ld.layers)
+}
@@ -0,0 +1,169 @@
+// Copyright 2014 The Azul3D Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tmx
+
+import (
+	"image"
+	"path/filepath"
+
+	"azul3d.org/gfx.v2-unstable"
+)
+
+// Instance describes a single tile of a tile layer, such that renderers which
+// support instancing can draw an entire layer as many instances of a single
+// unit quad (see UnitQuad) in one draw call.
+type Instance struct {
+	// The center of the tile's card in world space (including the depth of the
+	// tile on the Y axis), exactly as it would be placed by Load.
+	Pos gfx.Vec3
+
+	// The size of the tile's card.
+	Width, Height float32
+
+	// The texture coordinates of the top-left and bottom-right corners of the
+	// tile within the tileset's texture.
+	U0, V0, U1, V1 float32
+
+	// The tile's flipping flags, which are the three most significant bits of
+	// it's global tile ID: 4 when flipped horizontally, 2 when flipped
+	// vertically, and 1 when flipped diagonally.
+	Flip uint8
+}
+
+// UnitQuad returns a new mesh of a single one-unit card centered at the origin
+// (facing the -Y axis, like the cards of Load) whose texture coordinates span
+// the range [0, 1]. Instances are drawn by scaling it by the size of the
+// instance, mapping it's texture coordinates to the instance's, applying the
+// instance's flips and moving it to the instance's position.
+func UnitQuad() *gfx.Mesh {
+	m := gfx.NewMesh()
+	m.Vertices = []gfx.Vec3{
+		{-0.5, 0, 0.5}, {-0.5, 0, -0.5}, {0.5, 0, -0.5},
+		{-0.5, 0, 0.5}, {0.5, 0, -0.5}, {0.5, 0, 0.5},
+	}
+	m.TexCoords = []gfx.TexCoordSet{{Slice: []gfx.TexCoord{
+		{0, 0}, {0, 1}, {1, 1},
+		{0, 0}, {1, 1}, {1, 0},
+	}}}
+	return m
+}
+
+// LoadInstances is like Load, except it returns the tiles of each tile layer as
+// instances instead of objects. The returned map is of layer names and a map
+// of tileset image filenames and the instances of tiles using that image.
+//
+// Only tile layers are returned, image layers and object groups should still be
+// loaded using Load. The Config.LayerOffset, TileOffset, DepthOrder,
+// TopLeftOrigin and Metrics (tiles emitted and skipped) options are honored.
+// With TopLeftOrigin the unit quad must also be mirrored along the Z axis,
+// such that the top of each card is placed towards -Z.
+func LoadInstances(m *Map, c *Config, tsImages map[string]*image.RGBA) map[string]map[string][]Instance {
+	if c == nil {
+		c = DefaultConfig()
+	}
+	ld := newLoader(m, c, tsImages)
+	layers := make(map[string]map[string][]Instance, len(m.Layers))
+	for _, layer := range m.Layers {
+		if !ld.rendered(layer.Name, layer.Parent, layer.Properties) {
+			continue
+		}
+		images := make(map[string][]Instance)
+		depth := ld.layerDepth(layer.Index, layer.Properties)
+		var tileOffset float64
+		bounds := layer.Bounds(m)
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+				gid := layer.Tile(Coord{x, y})
+				if gid == 0 {
+					continue
+				}
+				ts := m.FindTileset(gid)
+				if ts == nil || !ts.HasGID(gid) {
+					ld.skipped()
+					continue
+				}
+				name := filepath.Base(ts.Image.Source)
+				rgba, ok := tsImages[name]
+				if !ok {
+					ld.skipped()
+					continue
+				}
+
+				center, width, height := ld.tileCenter(layer, ts, x, y)
+				if c.TopLeftOrigin {
+					center.Z = float64(m.Height*m.TileHeight) - center.Z
+				}
+				r := ld.tileRect(ts, rgba.Bounds(), gid)
+				u0, v0, u1, v1 := texRect(r, rgba.Bounds())
+				images[name] = append(images[name], Instance{
+					Pos:    gfx.Vec3{float32(center.X), float32(depth + tileOffset), float32(center.Z)},
+					Width:  float32(width),
+					Height: float32(height),
+					U0:     u0,
+					V0:     v0,
+					U1:     u1,
+					V1:     v1,
+					Flip:   uint8(gid >> 29),
+				})
+				tileOffset -= ld.tileOffset()
+				if c.Metrics != nil {
+					c.Metrics.TilesEmitted++
+				}
+			}
+		}
+		layers[layer.Name] = images
+	}
+	return layers
+}
+
+// cardKey identifies the geometry of a card, regardless of it's position.
+type cardKey struct {
+	width, height  float32
+	u0, v0, u1, v1 float32
+	flip           uint8
+}
+
+// DedupCards returns an indexed mesh holding a single card (four vertices and
+// six indices) for each distinct combination of size, texture coordinates and
+// flips among the given instances, and for each instance the index of it's
+// card within the mesh.
+//
+// Cards are centered at the origin with the instance's flips already applied,
+// card i uses the vertices [4*i, 4*i+4) and the indices [6*i, 6*i+6). Since
+// most maps repeat the same few tiles thousands of times, drawing each instance
+// as it's card moved to the instance's position (e.g. by instancing with the
+// positions as per-instance data) needs only a tiny mesh.
+func DedupCards(instances []Instance) (mesh *gfx.Mesh, cards []int) {
+	mesh = gfx.NewMesh()
+	mesh.TexCoords = []gfx.TexCoordSet{{}}
+	cards = make([]int, len(instances))
+	unique := make(map[cardKey]int)
+	for i, inst := range instances {
+		key := cardKey{inst.Width, inst.Height, inst.U0, inst.V0, inst.U1, inst.V1, inst.Flip}
+		card, ok := unique[key]
+		if !ok {
+			card = len(unique)
+			unique[key] = card
+
+			// The corners of the card, in the same order as appendCard.
+			l, r := -inst.Width/2, inst.Width/2
+			b, t := -inst.Height/2, inst.Height/2
+			flip := flipMatrix(Flip(uint32(inst.Flip) << 29))
+			for _, v := range [4]gfx.Vec3{{l, 0, t}, {l, 0, b}, {r, 0, b}, {r, 0, t}} {
+				vt := v.Vec3().TransformMat4(flip)
+				mesh.Vertices = append(mesh.Vertices, gfx.Vec3{float32(vt.X), float32(vt.Y), float32(vt.Z)})
+			}
+			set := &mesh.TexCoords[0]
+			set.Slice = append(set.Slice,
+				gfx.TexCoord{inst.U0, inst.V0}, gfx.TexCoord{inst.U0, inst.V1},
+				gfx.TexCoord{inst.U1, inst.V1}, gfx.TexCoord{inst.U1, inst.V0},
+			)
+			base := uint32(4 * card)
+			mesh.Indices = append(mesh.Indices, base, base+1, base+2, base, base+2, base+3)
+		}
+		cards[i] = card
+	}
+	return mesh, cards
+}
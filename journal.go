@@ -0,0 +1,201 @@
+// Copyright 2014 Lightpoke. All rights reserved.
+// This source code is subject to the terms and
+// conditions defined in the "License.txt" file.
+
+package tmx
+
+// journalEdit is a single reversible edit of a map.
+type journalEdit struct {
+	redo, undo func()
+}
+
+// Journal records edits of maps as reversible operations, such that they can
+// be undone and redone (for instance by in-game editors) without taking
+// snapshots of entire maps.
+//
+// Edits are made through the journal's methods, and take effect immediately.
+// Each edit is a step of it's own, unless it is made between calls to Begin
+// and End, in which case all those edits are undone and redone as a single
+// step. Making an edit discards the steps which could be redone.
+//
+// The zero value is an empty journal ready for use. A Journal is not safe for
+// use by multiple goroutines at once.
+type Journal struct {
+	// If non-nil, the changes of tiles made through the journal (including
+	// by undoing and redoing steps) are recorded here, for instance to send
+	// them to other players.
+	Changes *TileChanges
+
+	undo, redo [][]journalEdit
+	open       []journalEdit
+	depth      int
+}
+
+// Begin begins a step consisting of all the edits made until the matching
+// call to End. Calls may be nested, only the outermost ones delimit a step.
+func (j *Journal) Begin() {
+	j.depth++
+}
+
+// End ends a step begun by Begin.
+func (j *Journal) End() {
+	if j.depth == 0 {
+		return
+	}
+	j.depth--
+	if j.depth == 0 && len(j.open) > 0 {
+		j.undo = append(j.undo, j.open)
+		j.open = nil
+	}
+}
+
+// record applies the given edit and records it.
+func (j *Journal) record(e journalEdit) {
+	e.redo()
+	j.redo = nil
+	if j.depth > 0 {
+		j.open = append(j.open, e)
+		return
+	}
+	j.undo = append(j.undo, []journalEdit{e})
+}
+
+// CanUndo tells if there is a step which can be undone.
+func (j *Journal) CanUndo() bool {
+	return len(j.undo) > 0
+}
+
+// CanRedo tells if there is a step which can be redone.
+func (j *Journal) CanRedo() bool {
+	return len(j.redo) > 0
+}
+
+// Undo undoes the last step, it returns false if there was none.
+func (j *Journal) Undo() bool {
+	if len(j.undo) == 0 {
+		return false
+	}
+	step := j.undo[len(j.undo)-1]
+	j.undo = j.undo[:len(j.undo)-1]
+	for i := len(step) - 1; i >= 0; i-- {
+		step[i].undo()
+	}
+	j.redo = append(j.redo, step)
+	return true
+}
+
+// Redo redoes the last undone step, it returns false if there was none.
+func (j *Journal) Redo() bool {
+	if len(j.redo) == 0 {
+		return false
+	}
+	step := j.redo[len(j.redo)-1]
+	j.redo = j.redo[:len(j.redo)-1]
+	for _, e := range step {
+		e.redo()
+	}
+	j.undo = append(j.undo, step)
+	return true
+}
+
+// SetTile sets the gid at the given coordinates of the layer, see
+// Layer.SetTile.
+func (j *Journal) SetTile(l *Layer, c Coord, gid uint32) {
+	old := l.Tile(c)
+	if old == gid {
+		return
+	}
+	j.record(journalEdit{
+		redo: func() { j.setTile(l, c, old, gid) },
+		undo: func() { j.setTile(l, c, gid, old) },
+	})
+}
+
+// setTile sets the tile at the given coordinates of the layer from the old to
+// the given gid, recording the change if needed.
+func (j *Journal) setTile(l *Layer, c Coord, old, gid uint32) {
+	l.SetTile(c, gid)
+	if j.Changes != nil {
+		j.Changes.Record(l.Name, c, old, gid)
+	}
+}
+
+// ApplyStamp applies the stamp to the layer as a single step, see
+// Layer.ApplyStamp.
+func (j *Journal) ApplyStamp(l *Layer, x, y int, s *Stamp) {
+	j.Begin()
+	for sy := 0; sy < s.Height; sy++ {
+		for sx := 0; sx < s.Width; sx++ {
+			if gid := s.At(sx, sy); gid != 0 {
+				j.SetTile(l, Coord{x + sx, y + sy}, gid)
+			}
+		}
+	}
+	j.End()
+}
+
+// AddObject appends the object to the object group.
+func (j *Journal) AddObject(g *ObjectGroup, o *Object) {
+	j.record(journalEdit{
+		redo: func() { g.Objects = append(g.Objects, o) },
+		undo: func() { g.Objects = removeObject(g.Objects, o) },
+	})
+}
+
+// RemoveObject removes the object from the object group, if it is in it.
+func (j *Journal) RemoveObject(g *ObjectGroup, o *Object) {
+	index := -1
+	for i, v := range g.Objects {
+		if v == o {
+			index = i
+			break
+		}
+	}
+	if index == -1 {
+		return
+	}
+	j.record(journalEdit{
+		redo: func() { g.Objects = removeObject(g.Objects, o) },
+		undo: func() {
+			g.Objects = append(g.Objects, nil)
+			copy(g.Objects[index+1:], g.Objects[index:])
+			g.Objects[index] = o
+		},
+	})
+}
+
+// EditObject edits the object using the given function, which may change any
+// of the object's fields. The object's properties are restored by undoing,
+// but it's value (E.g. the points of a polygon) must be replaced rather than
+// modified for it to be.
+func (j *Journal) EditObject(o *Object, edit func(o *Object)) {
+	// Copies are restored such that later edits of the properties do not
+	// modify the snapshots.
+	copyObject := func(dst, src *Object) {
+		*dst = *src
+		if src.Properties != nil {
+			dst.Properties = make(map[string]string, len(src.Properties))
+			for k, v := range src.Properties {
+				dst.Properties[k] = v
+			}
+		}
+	}
+	var before, after Object
+	copyObject(&before, o)
+	edit(o)
+	copyObject(&after, o)
+	j.record(journalEdit{
+		redo: func() { copyObject(o, &after) },
+		undo: func() { copyObject(o, &before) },
+	})
+}
+
+// removeObject returns the given objects without the object o.
+func removeObject(objects []*Object, o *Object) []*Object {
+	for i, v := range objects {
+		if v == o {
+			return append(objects[:i], objects[i+1:]...)
+		}
+	}
+	return objects
+}
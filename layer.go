@@ -6,25 +6,54 @@ package tmx
 
 import (
 	"fmt"
+	"image"
 )
 
 type xmlLayer struct {
-	Name    string  `xml:"name,attr"`
-	Opacity float64 `xml:"opacity,attr"`
-	Visible int     `xml:"visible,attr"`
-	Data    xmlData `xml:"data"`
+	Name       string        `xml:"name,attr"`
+	Class      string        `xml:"class,attr"`
+	Type       string        `xml:"type,attr"`
+	Opacity    *float64      `xml:"opacity,attr"`
+	Visible    *int          `xml:"visible,attr"`
+	OffsetX    float64       `xml:"offsetx,attr"`
+	OffsetY    float64       `xml:"offsety,attr"`
+	ParallaxX  *float64      `xml:"parallaxx,attr"`
+	ParallaxY  *float64      `xml:"parallaxy,attr"`
+	Properties xmlProperties `xml:"properties"`
+	Data       xmlData       `xml:"data"`
 }
 
-func (x xmlLayer) toLayer(width, height int) (*Layer, error) {
-	tiles, err := x.Data.tiles(width, height)
-	if err != nil {
-		return nil, err
+// toLayer converts the layer, if reuse is non-nil the tiles are decoded into it
+// instead of a new map. Recoverable problems with the tile data are passed to
+// the given function, see xmlData.tiles.
+func (x xmlLayer) toLayer(width, height int, skipTiles bool, reuse map[Coord]uint32, problem func(err error) error) (*Layer, error) {
+	var (
+		tiles   map[Coord]uint32
+		decoded int64
+	)
+	if !skipTiles {
+		var err error
+		tiles, decoded, err = x.Data.tiles(width, height, reuse, func(err error) error {
+			return problem(fmt.Errorf("layer %q: %w", x.Name, err))
+		})
+		if err != nil {
+			return nil, err
+		}
 	}
 	return &Layer{
-		Name:    x.Name,
-		Opacity: x.Opacity,
-		Visible: x.Visible != 0,
-		Tiles:   tiles,
+		Name:        x.Name,
+		Class:       classAttr(x.Class, x.Type),
+		Opacity:     floatAttr(x.Opacity, 1),
+		Visible:     visibleAttr(x.Visible),
+		OffsetX:     x.OffsetX,
+		OffsetY:     x.OffsetY,
+		ParallaxX:   floatAttr(x.ParallaxX, 1),
+		ParallaxY:   floatAttr(x.ParallaxY, 1),
+		Properties:  x.Properties.toMap(),
+		Encoding:    x.Data.Encoding,
+		Compression: x.Data.Compression,
+		Tiles:       tiles,
+		decoded:     decoded,
 	}, nil
 }
 
@@ -33,17 +62,51 @@ type Coord struct {
 	X, Y int
 }
 
+// Pixel represents a single 2D position (x, y) in pixels, where +Y is down.
+type Pixel struct {
+	X, Y float64
+}
+
 // Layer represents a single map layer and all of it's tiles
 type Layer struct {
 	// The name of the layer.
 	Name string
 
+	// The class of the layer (Tiled 1.9 and later).
+	Class string
+
+	// The index of the layer amongst all of the map's layers, object groups,
+	// image layers and group layers, in the order they are drawn (I.e. zero is
+	// the bottom-most layer).
+	Index int
+
 	// Value between 0 and 1 representing the opacity of the layer.
 	Opacity float64
 
 	// Boolean value representing whether or not the layer is visible.
 	Visible bool
 
+	// The horizontal and vertical offset of the layer in pixels, where +Y is
+	// down.
+	OffsetX, OffsetY float64
+
+	// The horizontal and vertical parallax scrolling factors of the layer. A
+	// factor of 1 means the layer scrolls with the camera as normal, while a
+	// factor of 0 means the layer does not move at all.
+	ParallaxX, ParallaxY float64
+
+	// Map of properties for this layer.
+	Properties map[string]string
+
+	// The group layer that this layer is within, or nil if it is at the top
+	// level of the map.
+	Parent *GroupLayer
+
+	// The encoding and compression of the layer's tile data, as it was
+	// parsed. They are used when writing the layer.
+	Encoding    Encoding
+	Compression Compression
+
 	// A map of 2D coordinates in this layer to so called "global tile IDs"
 	// (gids).
 	//
@@ -55,10 +118,280 @@ type Layer struct {
 	// tile is you need to find the tileset with the highest Firstgid that is
 	// still lower or equal than the gid. The tilesets are always stored with
 	// increasing firstgids.
+	//
+	// If the map was parsed with ParseConfig.SkipTiles set, or the tiles are
+	// stored in RLE instead, this map is nil.
 	Tiles map[Coord]uint32
+
+	// If non-nil, the layer's tiles are stored run-length encoded here instead
+	// of in Tiles (see ParseConfig.RLETiles). The Tile, SetTile and EachTile
+	// methods, and the functions which edit layers in bulk (like Map.Resize),
+	// work with either storage. Clients using Tiles directly must Expand the
+	// layer first.
+	RLE *RLETiles
+
+	// The number of bytes of binary tile data decoded when parsing.
+	decoded int64
+
+	// The bounding rectangle of the cells changed by SetTile since the layer
+	// was last rebuilt, see Dirty.
+	dirty image.Rectangle
+
+	// The bounds of the layer's tiles on infinite maps, if they are known,
+	// see Bounds.
+	bounds      image.Rectangle
+	boundsKnown bool
+
+	// Whether the tile storage is shared with a snapshot of the map, and must
+	// be copied before it is changed, see Map.Snapshot.
+	shared bool
 }
 
 // String returns a string representation of this layer.
 func (l *Layer) String() string {
 	return fmt.Sprintf("Layer(Name=%q, Opacity=%1.f, Visible=%v)", l.Name, l.Opacity, l.Visible)
 }
+
+// Tile returns the gid at the given coordinates of the layer, or zero if there
+// is no tile, regardless of how the tiles are stored.
+func (l *Layer) Tile(c Coord) uint32 {
+	if l.RLE != nil {
+		return l.RLE.Get(c)
+	}
+	return l.Tiles[c]
+}
+
+// SetTile sets the gid at the given coordinates of the layer, where zero
+// removes the tile, regardless of how the tiles are stored. The cell is marked
+// as dirty, see Dirty.
+func (l *Layer) SetTile(c Coord, gid uint32) {
+	l.unshare()
+	l.markDirty(c)
+	l.setBounds(c, gid)
+	switch {
+	case l.RLE != nil:
+		l.RLE.Set(c, gid)
+	case gid == 0:
+		delete(l.Tiles, c)
+	default:
+		if l.Tiles == nil {
+			l.Tiles = make(map[Coord]uint32)
+		}
+		l.Tiles[c] = gid
+	}
+}
+
+// unshare copies the tile storage of the layer if it is shared with a snapshot
+// of the map, such that it may be changed without changing the snapshot.
+func (l *Layer) unshare() {
+	if !l.shared {
+		return
+	}
+	l.shared = false
+	if l.RLE != nil {
+		l.RLE = &RLETiles{
+			width:  l.RLE.width,
+			height: l.RLE.height,
+			runs:   append([]tileRun(nil), l.RLE.runs...),
+		}
+	}
+	if l.Tiles != nil {
+		tiles := make(map[Coord]uint32, len(l.Tiles))
+		for c, gid := range l.Tiles {
+			tiles[c] = gid
+		}
+		l.Tiles = tiles
+	}
+}
+
+// MarkDirty marks the cell at the given coordinates as changed, such that
+// RebuildDirty rebuilds the layer (and Bounds finds the bounds of it's tiles
+// again). SetTile does this automatically, clients editing the Tiles map
+// directly must do it themselves.
+func (l *Layer) MarkDirty(c Coord) {
+	l.boundsKnown = false
+	l.markDirty(c)
+}
+
+// markDirty marks the cell at the given coordinates as changed, see MarkDirty.
+func (l *Layer) markDirty(c Coord) {
+	cell := image.Rect(c.X, c.Y, c.X+1, c.Y+1)
+	if l.dirty.Empty() {
+		l.dirty = cell
+		return
+	}
+	l.dirty = l.dirty.Union(cell)
+}
+
+// setBounds updates the known bounds of the layer's tiles (see Bounds) for the
+// tile at the given coordinates being set to the given gid.
+func (l *Layer) setBounds(c Coord, gid uint32) {
+	if !l.boundsKnown {
+		return
+	}
+	b := l.bounds
+	cell := image.Rect(c.X, c.Y, c.X+1, c.Y+1)
+	switch {
+	case gid != 0 && b.Empty():
+		l.bounds = cell
+	case gid != 0:
+		l.bounds = b.Union(cell)
+	case c.X == b.Min.X || c.Y == b.Min.Y || c.X == b.Max.X-1 || c.Y == b.Max.Y-1:
+		// Removing a tile at the edge may shrink the bounds.
+		l.boundsKnown = false
+	}
+}
+
+// Dirty returns the bounding rectangle of the cells that changed since the
+// layer was parsed or last rebuilt by RebuildDirty, or an empty rectangle if
+// none did.
+func (l *Layer) Dirty() image.Rectangle {
+	return l.dirty
+}
+
+// EachTile calls f for each tile of the layer, regardless of how the tiles are
+// stored. The order is row-major for run-length encoded layers and undefined
+// otherwise.
+func (l *Layer) EachTile(f func(c Coord, gid uint32)) {
+	if l.RLE != nil {
+		l.RLE.Range(f)
+		return
+	}
+	for c, gid := range l.Tiles {
+		f(c, gid)
+	}
+}
+
+// Compact moves the tiles of the layer into run-length encoded storage. The
+// map, m, must be the map that this layer belongs to, as it dictates the size
+// of the layer. Layers of infinite maps are left as-is.
+func (l *Layer) Compact(m *Map) {
+	if l.RLE == nil && !m.Infinite {
+		l.RLE = NewRLETiles(m.Width, m.Height, l.Tiles)
+		l.Tiles = nil
+	}
+}
+
+// Expand moves the tiles of a run-length encoded layer back into the Tiles map.
+func (l *Layer) Expand() {
+	if l.RLE != nil {
+		l.Tiles = l.RLE.Map()
+		l.RLE = nil
+	}
+}
+
+// ParallaxOffset returns the additional offset, in pixels, that should be
+// applied to this layer for the given camera position (in pixels) in order to
+// achieve it's parallax scrolling effect, taking the parallax factors of any
+// group layers it is within into account.
+//
+// The map, m, must be the map that this layer belongs to, as it specifies the
+// origin for parallax scrolling.
+func (l *Layer) ParallaxOffset(m *Map, camX, camY float64) (x, y float64) {
+	fx, fy := l.EffectiveParallax()
+	return parallaxOffset(m, fx, fy, camX, camY)
+}
+
+// Bounds returns the rectangle of tile coordinates covered by this layer. For
+// finite maps that is the size of the map, while for infinite maps it is the
+// bounding rectangle of the layer's tiles, which may have negative
+// coordinates. The latter is found once and then kept up to date by SetTile
+// and MarkDirty.
+//
+// The map, m, must be the map that this layer belongs to.
+func (l *Layer) Bounds(m *Map) image.Rectangle {
+	if !m.Infinite {
+		return image.Rect(0, 0, m.Width, m.Height)
+	}
+	if l.boundsKnown {
+		return l.bounds
+	}
+	var (
+		b     image.Rectangle
+		first = true
+	)
+	l.EachTile(func(c Coord, gid uint32) {
+		if gid == 0 {
+			return
+		}
+		r := image.Rect(c.X, c.Y, c.X+1, c.Y+1)
+		if first {
+			b, first = r, false
+			return
+		}
+		b = b.Union(r)
+	})
+	l.bounds, l.boundsKnown = b, true
+	return b
+}
+
+// GIDs returns a dense slice of the global tile IDs in this layer, in
+// row-major order (I.e. the gid at 2D coordinate (x, y) is stored at index
+// y*m.Width + x).
+//
+// Coordinates which have no tile have a gid of zero. The map, m, must be the
+// map that this layer belongs to, as it dictates the size of the slice. Tiles
+// of infinite maps outside of the map's width and height are not included,
+// see Bounds.
+//
+// The slice is built on each call, so clients needing it often should hold
+// onto it.
+func (l *Layer) GIDs(m *Map) []uint32 {
+	return l.GIDsIn(image.Rect(0, 0, m.Width, m.Height))
+}
+
+// GIDsIn is like GIDs, except the slice covers the given rectangle of tile
+// coordinates (which may have negative coordinates, such as the bounds of
+// layers of infinite maps, see Bounds) instead of the map. The gid at 2D
+// coordinate (x, y) is stored at index (y-r.Min.Y)*r.Dx() + (x-r.Min.X).
+func (l *Layer) GIDsIn(r image.Rectangle) []uint32 {
+	r = r.Canon()
+	gids := make([]uint32, r.Dx()*r.Dy())
+	l.EachTile(func(c Coord, gid uint32) {
+		if !image.Pt(c.X, c.Y).In(r) {
+			return
+		}
+		gids[(c.Y-r.Min.Y)*r.Dx()+(c.X-r.Min.X)] = gid
+	})
+	return gids
+}
+
+// FloodRegion returns the connected region of cells, starting at the given
+// cell, whose global tile ID matches that of the starting cell. Cells are
+// connected to their four direct neighbours.
+//
+// The same function decides whether the gid of a cell matches the gid of the
+// starting cell (which is given first), if nil then only equal gids match.
+// Coordinates without a tile have a gid of zero, so empty regions can be
+// found as well.
+//
+// The map, m, must be the map that this layer belongs to, as it bounds the
+// region (to the layer's Bounds for infinite maps). If the starting cell is
+// outside of those bounds, nil is returned.
+func (l *Layer) FloodRegion(m *Map, x, y int, same func(start, gid uint32) bool) []Coord {
+	bounds := l.Bounds(m)
+	if !image.Pt(x, y).In(bounds) {
+		return nil
+	}
+	if same == nil {
+		same = func(start, gid uint32) bool {
+			return start == gid
+		}
+	}
+	start := l.Tile(Coord{x, y})
+	seen := map[Coord]bool{Coord{x, y}: true}
+	region := []Coord{{x, y}}
+	for i := 0; i < len(region); i++ {
+		c := region[i]
+		for _, n := range [4]Coord{{c.X - 1, c.Y}, {c.X + 1, c.Y}, {c.X, c.Y - 1}, {c.X, c.Y + 1}} {
+			if !image.Pt(n.X, n.Y).In(bounds) || seen[n] {
+				continue
+			}
+			seen[n] = true
+			if same(start, l.Tile(n)) {
+				region = append(region, n)
+			}
+		}
+	}
+	return region
+}
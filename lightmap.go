@@ -0,0 +1,122 @@
+// Copyright 2014 Lightpoke. All rights reserved.
+// This source code is subject to the terms and
+// conditions defined in the "License.txt" file.
+
+package tmx
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"strconv"
+)
+
+// LightmapConfig represents the configuration used to bake lightmaps.
+type LightmapConfig struct {
+	// The color of areas that no light reaches.
+	Ambient color.RGBA
+
+	// The number of map pixels covered by each pixel of the lightmap along
+	// each axis, if zero then four is used.
+	Scale int
+}
+
+// light is a single light found in an object group.
+type light struct {
+	x, y, radius, intensity float64
+	color                   color.RGBA
+}
+
+// lights returns all of the lights (I.e. visible objects with a radius) of
+// the object group.
+func (o *ObjectGroup) lights() []light {
+	var lights []light
+	ox, oy := o.EffectiveOffset()
+	for _, obj := range o.Objects {
+		if !obj.Visible {
+			continue
+		}
+		num := func(name string, def float64) float64 {
+			if v, err := strconv.ParseFloat(obj.Properties[name], 64); err == nil {
+				return v
+			}
+			return def
+		}
+		l := light{
+			x:         float64(obj.X) + ox + float64(obj.Width)/2,
+			y:         float64(obj.Y) + oy + float64(obj.Height)/2,
+			radius:    num("radius", math.Max(float64(obj.Width), float64(obj.Height))/2),
+			intensity: num("intensity", 1),
+			color:     color.RGBA{255, 255, 255, 255},
+		}
+		if v, ok := obj.Properties["color"]; ok {
+			l.color = hexToRGBA(v)
+		}
+		if l.radius > 0 {
+			lights = append(lights, l)
+		}
+	}
+	return lights
+}
+
+// BakeLightmap bakes the lights found in the given object group into a
+// lightmap image covering the entire map, m, which can be used with
+// Config.Lightmap.
+//
+// Each visible object of the group with a size, or a "radius" property (in
+// pixels), is a light centered on the object. Lights are white unless they
+// have a "color" property like "#rrggbb", and may have an "intensity"
+// property (one by default). The light of each falls off smoothly to zero at
+// it's radius, and lights are added together on top of the ambient color.
+//
+// If the configuration, c, is nil then a black ambient color and the default
+// scale are used.
+func BakeLightmap(m *Map, g *ObjectGroup, c *LightmapConfig) *image.RGBA {
+	var cfg LightmapConfig
+	if c != nil {
+		cfg = *c
+	}
+	if cfg.Scale <= 0 {
+		cfg.Scale = 4
+	}
+	scale := float64(cfg.Scale)
+	w := (m.Width*m.TileWidth + cfg.Scale - 1) / cfg.Scale
+	h := (m.Height*m.TileHeight + cfg.Scale - 1) / cfg.Scale
+
+	// Accumulate the light of each texel, starting with the ambient color.
+	acc := make([][3]float64, w*h)
+	for i := range acc {
+		acc[i] = [3]float64{float64(cfg.Ambient.R), float64(cfg.Ambient.G), float64(cfg.Ambient.B)}
+	}
+	for _, l := range g.lights() {
+		x0 := clamp(int((l.x-l.radius)/scale), 0, w)
+		y0 := clamp(int((l.y-l.radius)/scale), 0, h)
+		x1 := clamp(int(math.Ceil((l.x+l.radius)/scale)), 0, w)
+		y1 := clamp(int(math.Ceil((l.y+l.radius)/scale)), 0, h)
+		for y := y0; y < y1; y++ {
+			for x := x0; x < x1; x++ {
+				// Sample at the center of the texel.
+				dx := (float64(x)+0.5)*scale - l.x
+				dy := (float64(y)+0.5)*scale - l.y
+				d := math.Sqrt(dx*dx+dy*dy) / l.radius
+				if d >= 1 {
+					continue
+				}
+				f := (1 - d) * (1 - d) * l.intensity
+				a := &acc[y*w+x]
+				a[0] += float64(l.color.R) * f
+				a[1] += float64(l.color.G) * f
+				a[2] += float64(l.color.B) * f
+			}
+		}
+	}
+
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for i, a := range acc {
+		img.Pix[i*4+0] = uint8(math.Min(a[0], 255))
+		img.Pix[i*4+1] = uint8(math.Min(a[1], 255))
+		img.Pix[i*4+2] = uint8(math.Min(a[2], 255))
+		img.Pix[i*4+3] = 255
+	}
+	return img
+}
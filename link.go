@@ -0,0 +1,85 @@
+// Copyright 2014 Lightpoke. All rights reserved.
+// This source code is subject to the terms and
+// conditions defined in the "License.txt" file.
+
+package tmx
+
+import (
+	"fmt"
+	"path"
+	"sort"
+)
+
+// Link represents a link (like a portal, or a door) from an object of one map
+// to an object of another (or the same) map, see ResolveLinks.
+type Link struct {
+	// The file name, object group and object that the link starts at.
+	Map    string
+	Group  *ObjectGroup
+	Object *Object
+
+	// The file name, map, object group and object that the link leads to.
+	TargetMap    string
+	Target       *Map
+	TargetGroup  *ObjectGroup
+	TargetObject *Object
+}
+
+// findObject returns the first object of the given map with the given name.
+func findObject(m *Map, name string) (*ObjectGroup, *Object) {
+	for _, g := range m.ObjectGroups {
+		for _, o := range g.Objects {
+			if o.Name == name {
+				return g, o
+			}
+		}
+	}
+	return nil, nil
+}
+
+// ResolveLinks resolves the links of the given maps, keyed by their file
+// names (for instance those of a World), such that level transitions can be
+// data-driven.
+//
+// Links are the objects with a "target_object" custom property, which names
+// the object the link leads to. If the object also has a "target_map"
+// property, the target object is within that map (relative to the directory
+// of the link's map, as Tiled stores file names), otherwise it is within the
+// link's own map.
+//
+// Links are returned in order of the file names of their maps, and then in the
+// order of their objects. An error is returned for the first link whose map
+// or object does not exist, which makes this suitable for validating maps.
+func ResolveLinks(maps map[string]*Map) ([]Link, error) {
+	names := make([]string, 0, len(maps))
+	for name := range maps {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var links []Link
+	for _, name := range names {
+		for _, g := range maps[name].ObjectGroups {
+			for _, o := range g.Objects {
+				targetObject, ok := o.Properties["target_object"]
+				if !ok {
+					continue
+				}
+				l := Link{Map: name, Group: g, Object: o, TargetMap: name}
+				if target := o.Properties["target_map"]; len(target) > 0 {
+					l.TargetMap = path.Join(path.Dir(name), target)
+				}
+				l.Target = maps[l.TargetMap]
+				if l.Target == nil {
+					return nil, fmt.Errorf("%s: object %q links to unknown map %q", name, o.Name, l.TargetMap)
+				}
+				l.TargetGroup, l.TargetObject = findObject(l.Target, targetObject)
+				if l.TargetObject == nil {
+					return nil, fmt.Errorf("%s: object %q links to unknown object %q of map %q", name, o.Name, targetObject, l.TargetMap)
+				}
+				links = append(links, l)
+			}
+		}
+	}
+	return links, nil
+}
@@ -0,0 +1,164 @@
+// Copyright 2014 The Azul3D Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tmx
+
+import (
+	"fmt"
+	"image"
+	"path/filepath"
+
+	"azul3d.org/gfx.v2-unstable"
+)
+
+// LoadLOD is like Load, except it returns a low-detail representation of the
+// tile layers of the map for use at far zoom levels (like world maps), where
+// drawing every tile would be wasteful.
+//
+// Each tile layer is split into chunks of n by n tiles, whose tiles are
+// composited into an image which is downscaled by the given scale factor. Each
+// chunk is drawn by a single card textured with that image. The returned map
+// is of layer names and a map of chunk names (like "lod:x,y") and objects.
+//
+// The LOD is a good replacement for the objects returned by Load whenever a
+// single pixel on screen covers about scale (or more) pixels of the map. Only
+// the tiles themselves are composited, per-tile shader features (like
+// Config.ColorProperty tints) are not applied.
+func LoadLOD(m *Map, c *Config, tsImages map[string]*image.RGBA, n, scale int) map[string]map[string]*gfx.Object {
+	if c == nil {
+		c = DefaultConfig()
+	}
+	if n < 1 {
+		n = 1
+	}
+	if scale < 1 {
+		scale = 1
+	}
+	ld := newLoader(m, c, tsImages)
+	chunkW, chunkH := n*m.TileWidth, n*m.TileHeight
+	for _, layer := range m.Layers {
+		if !ld.rendered(layer.Name, layer.Parent, layer.Properties) {
+			continue
+		}
+
+		// Composite the tiles into the downscaled chunk images they overlap.
+		chunks := make(map[image.Point]*image.RGBA)
+		bounds := layer.Bounds(m)
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+				gid := layer.Tile(Coord{x, y})
+				if gid == 0 {
+					continue
+				}
+				ts := m.FindTileset(gid)
+				if ts == nil || !ts.HasGID(gid) {
+					ld.skipped()
+					continue
+				}
+				rgba, ok := tsImages[filepath.Base(ts.Image.Source)]
+				if !ok {
+					ld.skipped()
+					continue
+				}
+				center, width, height := ld.tileCenter(layer, ts, x, y)
+				left := int(center.X - width/2)
+				top := m.Height*m.TileHeight - int(center.Z+height/2)
+				card := image.Rect(left, top, left+int(width), top+int(height))
+				src := ld.tileRect(ts, rgba.Bounds(), gid)
+
+				c0 := image.Pt(floorDiv(card.Min.X, chunkW), floorDiv(card.Min.Y, chunkH))
+				c1 := image.Pt(floorDiv(card.Max.X-1, chunkW), floorDiv(card.Max.Y-1, chunkH))
+				for cy := c0.Y; cy <= c1.Y; cy++ {
+					for cx := c0.X; cx <= c1.X; cx++ {
+						p := image.Pt(cx, cy)
+						img, ok := chunks[p]
+						if !ok {
+							img = image.NewRGBA(image.Rect(0, 0, (chunkW+scale-1)/scale, (chunkH+scale-1)/scale))
+							chunks[p] = img
+						}
+						origin := image.Pt(cx*chunkW, cy*chunkH)
+						blitTile(img, card.Sub(origin), scale, rgba, src, gid)
+					}
+				}
+			}
+		}
+
+		// Create a card for each chunk.
+		objects := make(map[string]*gfx.Object, len(chunks))
+		depth := float32(ld.layerDepth(layer.Index, layer.Properties))
+		for p, img := range chunks {
+			name := fmt.Sprintf("lod:%d,%d", p.X, p.Y)
+			obj := ld.object(objects, layer.Index, layer.Name, layer.Properties, name, img)
+			left := float32(p.X * chunkW)
+			top := float32(m.Height*m.TileHeight - p.Y*chunkH)
+			appendCard(obj.Meshes[0], left, left+float32(chunkW), top-float32(chunkH), top, depth, img.Bounds(), img.Bounds())
+			ld.cardAdded(obj.Meshes[0], 0, 0)
+		}
+		ld.layers[layer.Name] = objects
+	}
+	return groupLayers(m, c, ld.layers)
+}
+
+// blitTile composites the rectangle src of the tileset image, flipped
+// according to the flags of the given gid and stretched to fill the card
+// rectangle (in full resolution pixels), over the image dst that is
+// downscaled by the given scale factor using box filtering.
+func blitTile(dst *image.RGBA, card image.Rectangle, scale int, tileset *image.RGBA, src image.Rectangle, gid uint32) {
+	diag := gid&FLIPPED_DIAGONALLY_FLAG != 0
+	horiz := gid&FLIPPED_HORIZONTALLY_FLAG != 0
+	vert := gid&FLIPPED_VERTICALLY_FLAG != 0
+
+	// The size of the tile after the diagonal flip.
+	tw, th := src.Dx(), src.Dy()
+	if diag {
+		tw, th = th, tw
+	}
+
+	// The area of the downscaled image covered by the card.
+	area := image.Rect(
+		floorDiv(card.Min.X, scale), floorDiv(card.Min.Y, scale),
+		floorDiv(card.Max.X-1, scale)+1, floorDiv(card.Max.Y-1, scale)+1,
+	).Intersect(dst.Bounds())
+	samples := scale * scale
+	for py := area.Min.Y; py < area.Max.Y; py++ {
+		for px := area.Min.X; px < area.Max.X; px++ {
+			// Average the full resolution pixels within the card.
+			var r, g, b, a int
+			for fy := py * scale; fy < (py+1)*scale; fy++ {
+				for fx := px * scale; fx < (px+1)*scale; fx++ {
+					if !image.Pt(fx, fy).In(card) {
+						continue
+					}
+					x := (fx - card.Min.X) * tw / card.Dx()
+					y := (fy - card.Min.Y) * th / card.Dy()
+					if horiz {
+						x = tw - 1 - x
+					}
+					if vert {
+						y = th - 1 - y
+					}
+					if diag {
+						x, y = y, x
+					}
+					i := tileset.PixOffset(src.Min.X+x, src.Min.Y+y)
+					r += int(tileset.Pix[i+0])
+					g += int(tileset.Pix[i+1])
+					b += int(tileset.Pix[i+2])
+					a += int(tileset.Pix[i+3])
+				}
+			}
+			if a == 0 {
+				continue
+			}
+
+			// Composite the (premultiplied) average over the destination.
+			i := dst.PixOffset(px, py)
+			inv := 255*samples - a
+			dst.Pix[i+0] = uint8((r*255 + int(dst.Pix[i+0])*inv) / (255 * samples))
+			dst.Pix[i+1] = uint8((g*255 + int(dst.Pix[i+1])*inv) / (255 * samples))
+			dst.Pix[i+2] = uint8((b*255 + int(dst.Pix[i+2])*inv) / (255 * samples))
+			dst.Pix[i+3] = uint8((a*255 + int(dst.Pix[i+3])*inv) / (255 * samples))
+		}
+	}
+}
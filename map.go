@@ -8,6 +8,7 @@ import (
 	"fmt"
 	"image"
 	"image/color"
+	"math"
 )
 
 // Map represents a single TMX map file.
@@ -21,14 +22,35 @@ type Map struct {
 	// E.g. VersionMajor=1, VersionMinor=0 for "1.0"
 	VersionMajor, VersionMinor int
 
+	// The class of the map (Tiled 1.9 and later).
+	Class string
+
 	// Orientation of the map.
 	//
-	// Like "orthogonal", "isometric" or "staggered".
+	// Like "orthogonal", "isometric", "staggered" or "hexagonal".
 	Orientation Orientation
 
+	// The order in which the tiles of orthogonal maps are rendered by Tiled.
+	// It is kept when writing the map, Load does not depend on it.
+	RenderOrder RenderOrder
+
+	// For staggered and hexagonal maps, which axis is staggered and whether
+	// the odd or even rows (or columns) are shifted.
+	StaggerAxis  StaggerAxis
+	StaggerIndex StaggerIndex
+
+	// For hexagonal maps, the length in pixels of the side of a tile along the
+	// staggered axis.
+	HexSideLength int
+
 	// Width and height of the map in tiles.
 	Width, Height int
 
+	// Whether or not the map is infinite, in which case the tiles of it's
+	// layers may lie anywhere (including at negative coordinates) rather than
+	// within Width and Height, see Layer.Bounds.
+	Infinite bool
+
 	// Width and height of a tile in pixels.
 	TileWidth, TileHeight int
 
@@ -37,6 +59,14 @@ type Map struct {
 	// Like "#FF0000".
 	BackgroundColor color.RGBA
 
+	// The compression level used for compressed tile layer data when writing
+	// the map, if nil (or -1) then the default level of the compression
+	// method is used.
+	CompressionLevel *int
+
+	// The origin, in pixels, used for parallax scrolling of layers.
+	ParallaxOriginX, ParallaxOriginY float64
+
 	// Map of property names and values for all properties set on the map.
 	Properties map[string]string
 
@@ -48,6 +78,15 @@ type Map struct {
 
 	// A list of all the object groups in this map.
 	ObjectGroups []*ObjectGroup
+
+	// A list of all the image layers in this map.
+	ImageLayers []*ImageLayer
+
+	// A list of all the group layers in this map, including nested ones.
+	Groups []*GroupLayer
+
+	// The number of bytes of binary tile data decoded when parsing the map.
+	decoded int64
 }
 
 // String returns a string representation of this map.
@@ -55,6 +94,14 @@ func (m *Map) String() string {
 	return fmt.Sprintf("Map(Version=%d.%d, Size=%dx%d, TileSize=%dx%dpx)", m.VersionMajor, m.VersionMinor, m.Width, m.Height, m.TileWidth, m.TileHeight)
 }
 
+// parallaxOffset returns the parallax scrolling offset for the given factors
+// and camera position.
+func parallaxOffset(m *Map, fx, fy, camX, camY float64) (x, y float64) {
+	x = (camX - m.ParallaxOriginX) * (1 - fx)
+	y = (camY - m.ParallaxOriginY) * (1 - fy)
+	return
+}
+
 // FindTileset returns the proper tileset for the given global tile id.
 //
 // If the global tile id is invalid this function will return nil.
@@ -70,6 +117,21 @@ func (m *Map) FindTileset(gid uint32) *Tileset {
 	return nil
 }
 
+// DecomposeGID splits the given global tile id into the tileset holding the
+// tile, the local ID of the tile within that tileset, and the flipping flags of
+// the gid.
+//
+// If the gid is zero (I.e. no tile) or no tileset holds the tile (see
+// Tileset.HasGID) then the returned tileset is nil and the local ID is zero.
+func (m *Map) DecomposeGID(gid uint32) (ts *Tileset, localID uint32, flips Flip) {
+	flips = Flip(gid & flipFlags)
+	ts = m.FindTileset(gid)
+	if gid&^flipFlags == 0 || ts == nil || !ts.HasGID(gid) {
+		return nil, 0, flips
+	}
+	return ts, gid&^flipFlags - ts.Firstgid, flips
+}
+
 // TilesetTile returns the proper tile definition for the given global tile id.
 //
 // If there is no tile definition for the given gid (can be common), or if the
@@ -84,23 +146,377 @@ func (m *Map) TilesetTile(ts *Tileset, gid uint32) *Tile {
 // image represents the tile for the given gid.
 //
 // The image width and height must be passed as parameters because
-// ts.Image.Width and ts.Image.Height are not always available.
+// ts.Image.Width and ts.Image.Height are not always available. If the tileset
+// specifies it's number of columns then that is used instead of the image
+// width, which need not be an exact multiple of the tile width.
 //
 // If spacingAndMargins is true, then spacing and margins are applied to the
 // rectangle.
+//
+// Deprecated: use Tileset.RectForGID instead, this is a wrapper around it.
 func (m *Map) TilesetRect(ts *Tileset, width, height int, spacingAndMargins bool, gid uint32) image.Rectangle {
-	gid &^= (FLIPPED_HORIZONTALLY_FLAG | FLIPPED_VERTICALLY_FLAG | FLIPPED_DIAGONALLY_FLAG)
-	id := int(gid - ts.Firstgid)
-	coord := toCoord(id, width/ts.Width, height/ts.Height)
-	var cx, cy int
-	if spacingAndMargins {
-		cx = coord.X * (ts.Width + ts.Spacing)
-		cx += ts.Spacing
-		cy = coord.Y * (ts.Height + ts.Spacing)
-		cy += ts.Margin
-	} else {
-		cx = coord.X * ts.Width
-		cy = coord.Y * ts.Height
-	}
-	return image.Rect(cx, cy, cx+ts.Width, cy+ts.Height)
+	t := *ts
+	t.Image = &Image{Width: width, Height: height}
+	if !spacingAndMargins {
+		t.Spacing, t.Margin = 0, 0
+	}
+	return t.RectForGID(gid)
+}
+
+// ReplaceTileset replaces the old tileset of this map with the given one,
+// which must have the same tile size. The new tileset takes over the global
+// tile IDs of the old one.
+//
+// If mapping is not nil, it maps local tile IDs of the old tileset to local
+// tile IDs of the new one, and all tiles and tile objects using the old
+// tileset are re-pointed accordingly. Tiles whose ID is not in the mapping
+// keep their ID.
+//
+// An error is returned if the old tileset is not part of this map, if the
+// tile sizes differ, if the mapping maps from IDs outside of the range of
+// global tile IDs used by the old tileset, or if it maps to IDs which are not
+// tiles of the new tileset (when it's number of tiles is known, see
+// TileInfos) or which would run into the global tile IDs of the next tileset.
+func (m *Map) ReplaceTileset(old, ts *Tileset, mapping map[int]int) error {
+	index := -1
+	for i, t := range m.Tilesets {
+		if t == old {
+			index = i
+			break
+		}
+	}
+	if index == -1 {
+		return fmt.Errorf("ReplaceTileset(): tileset %q is not in the map", old.Name)
+	}
+	if ts.Width != old.Width || ts.Height != old.Height {
+		return fmt.Errorf("ReplaceTileset(): tile size %dx%d does not match %dx%d", ts.Width, ts.Height, old.Width, old.Height)
+	}
+	span := tilesetSpan(m, index)
+	count := tileCount(ts)
+	last := index == len(m.Tilesets)-1
+	for from, to := range mapping {
+		if from < 0 || uint32(from) >= span {
+			return fmt.Errorf("ReplaceTileset(): mapping from %d is out of range of the old tileset", from)
+		}
+		if to < 0 || (count > 0 && to >= count) || (!last && uint32(to) >= span) {
+			return fmt.Errorf("ReplaceTileset(): mapping to %d is out of range of the new tileset", to)
+		}
+	}
+
+	// Re-point the global tile IDs.
+	if len(mapping) > 0 {
+		remap := func(gid uint32) uint32 {
+			id := gid &^ flipFlags
+			if id < old.Firstgid || id-old.Firstgid >= span {
+				return gid
+			}
+			to, ok := mapping[int(id-old.Firstgid)]
+			if !ok {
+				return gid
+			}
+			return (old.Firstgid + uint32(to)) | (gid & flipFlags)
+		}
+		for _, l := range m.Layers {
+			changed := make(map[Coord]uint32)
+			l.EachTile(func(c Coord, gid uint32) {
+				if to := remap(gid); to != gid {
+					changed[c] = to
+				}
+			})
+			for c, gid := range changed {
+				l.SetTile(c, gid)
+			}
+		}
+		for _, g := range m.ObjectGroups {
+			for _, o := range g.Objects {
+				o.Gid = remap(o.Gid)
+			}
+		}
+	}
+	ts.Firstgid = old.Firstgid
+	m.Tilesets[index] = ts
+	return nil
+}
+
+// validGID tells if the given non-zero global tile ID refers to a tile of one of
+// the map's tilesets, see CheckGIDs.
+func (m *Map) validGID(gid uint32) bool {
+	ts := m.FindTileset(gid)
+	return ts != nil && ts.HasGID(gid)
+}
+
+// CheckGIDs returns an error describing a tile of a tile layer, or a tile
+// object, whose global tile ID does not refer to a tile of any tileset (for
+// instance one past the end of it's tileset, see Tileset.HasGID), or nil if
+// all of them are valid. External tilesets must be loaded first.
+//
+// Load skips such tiles, but they usually indicate a tileset that was
+// shortened after the map was last saved.
+func (m *Map) CheckGIDs() error {
+	for _, l := range m.Layers {
+		var err error
+		l.EachTile(func(c Coord, gid uint32) {
+			if err == nil && gid != 0 && !m.validGID(gid) {
+				err = fmt.Errorf("layer %q: tile %v has invalid gid %d", l.Name, c, gid&^flipFlags)
+			}
+		})
+		if err != nil {
+			return err
+		}
+	}
+	for _, g := range m.ObjectGroups {
+		for _, o := range g.Objects {
+			if o.Gid != 0 && !m.validGID(o.Gid) {
+				return fmt.Errorf("object group %q: object %q has invalid gid %d", g.Name, o.Name, o.Gid)
+			}
+		}
+	}
+	return nil
+}
+
+// Bounds returns the rectangle of tile coordinates covered by the map. For
+// finite maps that is the size of the map, while for infinite maps it is the
+// union of the bounds of all of the tile layers (see Layer.Bounds), which may
+// have negative coordinates.
+func (m *Map) Bounds() image.Rectangle {
+	if !m.Infinite {
+		return image.Rect(0, 0, m.Width, m.Height)
+	}
+	var b image.Rectangle
+	for _, l := range m.Layers {
+		b = b.Union(l.Bounds(m))
+	}
+	return b
+}
+
+// FindLayer returns the tile layer with the given name, or nil if there is no
+// such layer in this map.
+func (m *Map) FindLayer(name string) *Layer {
+	for _, l := range m.Layers {
+		if l.Name == name {
+			return l
+		}
+	}
+	return nil
+}
+
+// TileBounds returns the bounding rectangle, in pixels, of the map cell at
+// the given tile coordinates, honoring the map's orientation. For isometric
+// maps the rectangle bounds the diamond shape of the cell.
+func (m *Map) TileBounds(x, y int) image.Rectangle {
+	tw, th := m.TileWidth, m.TileHeight
+	switch m.Orientation {
+	case Isometric:
+		// The top corner of the diamond shaped cell.
+		originX := m.Height * tw / 2
+		topX := (x-y)*tw/2 + originX
+		topY := (x + y) * th / 2
+		return image.Rect(topX-tw/2, topY, topX+tw/2, topY+th)
+
+	case Staggered, Hexagonal:
+		p := m.hex()
+		var px, py int
+		if m.StaggerAxis == StaggerAxisX {
+			px = x * p.columnWidth
+			py = y * (th + p.sideLengthY)
+			if m.staggered(x) {
+				py += p.rowHeight
+			}
+		} else {
+			px = x * (tw + p.sideLengthX)
+			py = y * p.rowHeight
+			if m.staggered(y) {
+				px += p.columnWidth
+			}
+		}
+		return image.Rect(px, py, px+tw, py+th)
+
+	default:
+		return image.Rect(x*tw, y*th, x*tw+tw, y*th+th)
+	}
+}
+
+// pixelToTile returns the (fractional) tile coordinates of the given pixel,
+// honoring the map's orientation. For staggered and hexagonal maps it is only
+// an approximation, useful for finding candidate tiles.
+func (m *Map) pixelToTile(px, py float64) (x, y float64) {
+	tw, th := float64(m.TileWidth), float64(m.TileHeight)
+	switch m.Orientation {
+	case Isometric:
+		rx := (px - float64(m.Height)*tw/2) / (tw / 2)
+		ry := py / (th / 2)
+		return (ry + rx) / 2, (ry - rx) / 2
+	case Staggered, Hexagonal:
+		p := m.hex()
+		if m.StaggerAxis == StaggerAxisX {
+			return px / float64(p.columnWidth), py / float64(m.TileHeight+p.sideLengthY)
+		}
+		return px / float64(m.TileWidth+p.sideLengthX), py / float64(p.rowHeight)
+	default:
+		return px / tw, py / th
+	}
+}
+
+// TilesInRect invokes fn for every tile in the named layer whose drawn
+// rectangle intersects the given rectangle, r, in Tiled's pixel space (like
+// TileBounds, unlike the orthogonal layout of Load). As Tiled draws them, the
+// rectangle of a tile is that of it's cell (see TileBounds) extended from it's
+// bottom-left corner to the size of the tile's tileset (unless it renders at
+// the grid size), moved by the tileset's tile offset and the effective offset
+// of the layer. If fn returns false iteration stops.
+//
+// It is the shared primitive for things like culling, minimaps and loading
+// map chunks on demand, and only considers tiles near the rectangle rather
+// than every tile in the layer.
+//
+// If there is no layer with the given name, fn is never invoked.
+func (m *Map) TilesInRect(layer string, r image.Rectangle, fn func(c Coord, gid uint32) bool) {
+	l := m.FindLayer(layer)
+	if l == nil || m.TileWidth == 0 || m.TileHeight == 0 {
+		return
+	}
+	ox, oy := l.EffectiveOffset()
+	offset := image.Pt(pixelFloor(0, ox), pixelFloor(0, oy))
+
+	// Tiles larger than the map's tiles extend past their cell, so we must
+	// consider some extra cells around the rectangle.
+	var extraW, extraH int
+	for _, ts := range m.Tilesets {
+		w := ts.Width - m.TileWidth + abs(ts.OffsetX)
+		h := ts.Height - m.TileHeight + abs(ts.OffsetY)
+		if w > extraW {
+			extraW = w
+		}
+		if h > extraH {
+			extraH = h
+		}
+	}
+	margin := 1 + extraW/m.TileWidth + extraH/m.TileHeight
+
+	// Find the range of candidate cells from the corners of the rectangle.
+	minX, minY := math.Inf(1), math.Inf(1)
+	maxX, maxY := math.Inf(-1), math.Inf(-1)
+	for _, p := range []image.Point{r.Min, r.Max, {r.Min.X, r.Max.Y}, {r.Max.X, r.Min.Y}} {
+		p = p.Sub(offset)
+		x, y := m.pixelToTile(float64(p.X), float64(p.Y))
+		minX, minY = math.Min(minX, x), math.Min(minY, y)
+		maxX, maxY = math.Max(maxX, x), math.Max(maxY, y)
+	}
+	bounds := l.Bounds(m)
+	x0 := clamp(int(math.Floor(minX))-margin, bounds.Min.X, bounds.Max.X)
+	y0 := clamp(int(math.Floor(minY))-margin, bounds.Min.Y, bounds.Max.Y)
+	x1 := clamp(int(math.Ceil(maxX))+margin, bounds.Min.X, bounds.Max.X)
+	y1 := clamp(int(math.Ceil(maxY))+margin, bounds.Min.Y, bounds.Max.Y)
+
+	for y := y0; y < y1; y++ {
+		for x := x0; x < x1; x++ {
+			c := Coord{x, y}
+			gid := l.Tile(c)
+			if gid == 0 {
+				continue
+			}
+			b := m.TileBounds(x, y).Add(offset)
+			if ts := m.FindTileset(gid); ts != nil {
+				// Tiles are anchored to the bottom-left of their cell.
+				if ts.RenderSize != TileRenderSizeGrid {
+					b.Min.Y = b.Max.Y - ts.Height
+					b.Max.X = b.Min.X + ts.Width
+				}
+				b = b.Add(image.Pt(ts.OffsetX, ts.OffsetY))
+			}
+			if !b.Overlaps(r) {
+				continue
+			}
+			if !fn(c, gid) {
+				return
+			}
+		}
+	}
+}
+
+// Raycast steps through the cells of the named layer along the line from one
+// pixel position to another, and returns the first cell (closest to from)
+// whose tile is solid as decided by the given function. The point at which
+// the line enters that cell (or from, if it starts inside of a solid cell) is
+// returned as well.
+//
+// Only orthogonal and isometric maps are supported. If there is no layer with
+// the given name or no solid tile is hit, ok is false.
+func (m *Map) Raycast(layer string, from, to Pixel, solid func(gid uint32) bool) (c Coord, hit Pixel, ok bool) {
+	l := m.FindLayer(layer)
+	if l == nil || m.TileWidth == 0 || m.TileHeight == 0 || m.Orientation == Staggered || m.Orientation == Hexagonal {
+		return
+	}
+
+	// Walk the line in tile space, where each cell is a unit square (this is
+	// an affine transformation of pixel space for both orientations).
+	fx, fy := m.pixelToTile(from.X, from.Y)
+	tx, ty := m.pixelToTile(to.X, to.Y)
+	dx, dy := tx-fx, ty-fy
+	x, y := int(math.Floor(fx)), int(math.Floor(fy))
+
+	// The step direction, the line parameter at which the next cell boundary
+	// is crossed, and the line parameter spanned by one cell, on each axis.
+	step := func(d, f float64, cell int) (s int, next, delta float64) {
+		switch {
+		case d > 0:
+			return 1, (float64(cell+1) - f) / d, 1 / d
+		case d < 0:
+			return -1, (float64(cell) - f) / d, -1 / d
+		}
+		return 0, math.Inf(1), math.Inf(1)
+	}
+	stepX, nextX, deltaX := step(dx, fx, x)
+	stepY, nextY, deltaY := step(dy, fy, y)
+
+	bounds := l.Bounds(m)
+	var t float64
+	for t <= 1 {
+		if image.Pt(x, y).In(bounds) {
+			if gid := l.Tile(Coord{x, y}); gid != 0 && solid(gid) {
+				hit = Pixel{from.X + (to.X-from.X)*t, from.Y + (to.Y-from.Y)*t}
+				return Coord{x, y}, hit, true
+			}
+		}
+		if nextX < nextY {
+			t = nextX
+			x += stepX
+			nextX += deltaX
+		} else {
+			t = nextY
+			y += stepY
+			nextY += deltaY
+		}
+	}
+	return
+}
+
+func abs(v int) int {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
+
+func clamp(v, min, max int) int {
+	if v < min {
+		return min
+	}
+	if v > max {
+		return max
+	}
+	return v
+}
+
+// floorDiv returns a divided by b, rounded towards negative infinity.
+func floorDiv(a, b int) int {
+	if a < 0 {
+		return -((-a + b - 1) / b)
+	}
+	return a / b
+}
+
+// wrap returns v wrapped into the range [0, n).
+func wrap(v, n int) int {
+	return v - floorDiv(v, n)*n
 }
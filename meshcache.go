@@ -0,0 +1,159 @@
+// Copyright 2014 The Azul3D Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tmx
+
+import (
+	"encoding/gob"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"image"
+	"os"
+	"path/filepath"
+
+	"azul3d.org/gfx.v2-unstable"
+)
+
+// meshCacheVersion must be changed whenever the meshes emitted by Load or the
+// format of the cache files change, such that old caches are not used.
+const meshCacheVersion = 3
+
+// cachedObject is a single object stored in a mesh cache file.
+type cachedObject struct {
+	Layer, Image     string
+	RepeatU, RepeatV bool
+	Vertices         []gfx.Vec3
+	Colors           []gfx.Color
+	TexCoords        [][]gfx.TexCoord
+}
+
+// useMeshCache tells if the configuration allows for caching meshes, objects
+// stored in Config.Flipbooks or Config.TileObjects, chunks, rasterized text and
+// baked layers, cannot be restored.
+func (c *Config) useMeshCache() bool {
+	return c != nil && len(c.MeshCache) > 0 && c.Flipbooks == nil && c.TileObjects == nil && c.ChunkSize <= 0 && c.RasterizeText == nil && c.Depth == nil && len(c.BakeLayers) == 0
+}
+
+// hashMesh writes the configuration values which affect the emitted meshes to
+// the given hash.
+func (c *Config) hashMesh(h hash.Hash) {
+	fmt.Fprintf(h, "tmx mesh cache %d\n", meshCacheVersion)
+	fmt.Fprintf(h, "%v %v %v %q %v %v %v %v %v %q %q\n",
+		c.LayerOffset, c.TileOffset, c.DepthOrder, c.ColorProperty,
+		c.Lightmap != nil, c.WrapX, c.WrapY, c.TopLeftOrigin, c.Faders != nil,
+		c.IncludeLayers, c.ExcludeLayers,
+	)
+}
+
+// meshCachePath returns the path of the mesh cache file for the given content
+// hash.
+func (c *Config) meshCachePath(h hash.Hash) string {
+	return filepath.Join(c.MeshCache, hex.EncodeToString(h.Sum(nil))+".tmxmesh")
+}
+
+// readMeshCache restores the objects stored in the mesh cache file at the
+// given path, ok is false if the file does not exist or cannot be used.
+func readMeshCache(path string, m *Map, c *Config, tsImages map[string]*image.RGBA) (layers map[string]map[string]*gfx.Object, ok bool) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, false
+	}
+	defer f.Close()
+	var cached []cachedObject
+	if err := gob.NewDecoder(f).Decode(&cached); err != nil {
+		return nil, false
+	}
+
+	props := m.layerProperties()
+	ld := newLoader(m, c, tsImages)
+
+	// Objects are cached by layer name, the first layer of each name decides
+	// whether it's objects are translucent.
+	indices := make(map[string]int, len(props))
+	index := func(name string, i int) {
+		if _, ok := indices[name]; !ok {
+			indices[name] = i
+		}
+	}
+	for _, l := range m.Layers {
+		index(l.Name, l.Index)
+	}
+	for _, l := range m.ImageLayers {
+		index(l.Name, l.Index)
+	}
+	for _, g := range m.ObjectGroups {
+		index(g.Name, g.Index)
+	}
+	for _, co := range cached {
+		rgba, ok := tsImages[co.Image]
+		if !ok {
+			return nil, false
+		}
+		objects := ld.layers[co.Layer]
+		if objects == nil {
+			objects = make(map[string]*gfx.Object)
+			ld.layers[co.Layer] = objects
+		}
+		obj := ld.object(objects, indices[co.Layer], co.Layer, props[co.Layer], co.Image, rgba)
+		if co.RepeatU {
+			obj.Textures[0].WrapU = gfx.Repeat
+		}
+		if co.RepeatV {
+			obj.Textures[0].WrapV = gfx.Repeat
+		}
+		mesh := obj.Meshes[0]
+		mesh.Vertices = co.Vertices
+		mesh.Colors = co.Colors
+		for _, set := range co.TexCoords {
+			mesh.TexCoords = append(mesh.TexCoords, gfx.TexCoordSet{Slice: set})
+		}
+		if c.Metrics != nil {
+			c.Metrics.Vertices += len(mesh.Vertices)
+		}
+	}
+	return ld.layers, true
+}
+
+// writeMeshCache writes the given objects, as returned by Load, to a mesh cache
+// file at the given path.
+func writeMeshCache(path string, layers map[string]map[string]*gfx.Object) error {
+	var cached []cachedObject
+	for layer, objects := range layers {
+		for name, obj := range objects {
+			co := cachedObject{
+				Layer:    layer,
+				Image:    name,
+				RepeatU:  obj.Textures[0].WrapU == gfx.Repeat,
+				RepeatV:  obj.Textures[0].WrapV == gfx.Repeat,
+				Vertices: obj.Meshes[0].Vertices,
+				Colors:   obj.Meshes[0].Colors,
+			}
+			for _, set := range obj.Meshes[0].TexCoords {
+				co.TexCoords = append(co.TexCoords, set.Slice)
+			}
+			cached = append(cached, co)
+		}
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	// Write to a temporary file first, such that a partially written cache is
+	// never used.
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	err = gob.NewEncoder(f).Encode(cached)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,112 @@
+// Copyright 2014 Lightpoke. All rights reserved.
+// This source code is subject to the terms and
+// conditions defined in the "License.txt" file.
+
+package tmx
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// errMigrateToXML is returned when migrating maps to the legacy plain XML
+// encoding itself.
+var errMigrateToXML = errors.New("cannot migrate to the plain XML encoding")
+
+// MigrateEncoding changes the encoding and compression of the map's tile
+// layers which use the legacy plain XML encoding (I.e. one <tile gid="...">
+// element per cell, which Tiled deprecated) to the given ones, such that they
+// are written that way. Other layers are not changed. It returns the number of
+// layers that were changed.
+//
+// Parsing the plain XML encoding remains supported, this only helps upgrading
+// old content.
+func (m *Map) MigrateEncoding(encoding Encoding, compression Compression) int {
+	if encoding == XMLEncoding {
+		return 0
+	}
+	if encoding != Base64Encoding {
+		compression = NoCompression
+	}
+	n := 0
+	for _, l := range m.Layers {
+		if l.Encoding == XMLEncoding {
+			l.Encoding, l.Compression = encoding, compression
+			n++
+		}
+	}
+	return n
+}
+
+// MigrateFile rewrites the TMX map file at the given path in place if any of
+// it's tile layers use the legacy plain XML encoding, such that they use the
+// given encoding and compression instead (see Map.MigrateEncoding). It tells
+// whether the file was rewritten.
+//
+// The file is written to a temporary file first, which then replaces it, such
+// that a failure never leaves a partially written map behind. External
+// tilesets are not changed.
+func MigrateFile(path string, encoding Encoding, compression Compression) (bool, error) {
+	if encoding == XMLEncoding {
+		return false, errMigrateToXML
+	}
+	m, err := ParseFile(path, nil)
+	if err != nil {
+		return false, err
+	}
+	if m.MigrateEncoding(encoding, compression) == 0 {
+		return false, nil
+	}
+	data, err := Marshal(m, nil)
+	if err != nil {
+		return false, err
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return false, err
+	}
+	tmp := path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, info.Mode().Perm())
+	if err != nil {
+		return false, err
+	}
+	_, err = f.Write(data)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return false, err
+	}
+	return true, os.Rename(tmp, path)
+}
+
+// MigrateDir migrates all of the TMX map files (those with the .tmx extension)
+// within the given directory and it's subdirectories, see MigrateFile. It
+// returns the paths of the files that were rewritten, along with the first
+// error encountered, if any (files after it are not migrated).
+func MigrateDir(dir string, encoding Encoding, compression Compression) ([]string, error) {
+	if encoding == XMLEncoding {
+		return nil, errMigrateToXML
+	}
+	var migrated []string
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() || !strings.EqualFold(filepath.Ext(path), ".tmx") {
+			return nil
+		}
+		ok, err := MigrateFile(path, encoding, compression)
+		if err != nil {
+			return err
+		}
+		if ok {
+			migrated = append(migrated, path)
+		}
+		return nil
+	})
+	return migrated, err
+}
@@ -0,0 +1,115 @@
+// Copyright 2014 The Azul3D Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tmx
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+
+	"azul3d.org/gfx.v2-unstable"
+)
+
+// WriteOBJ writes the vertices and texture coordinates of the given layers, as
+// returned by Load, to w in the Wavefront OBJ format, which is useful for
+// quickly diffing the meshes that Load emits across versions without opening
+// a window.
+//
+// Each object is written as an OBJ object named "layer/image", in sorted
+// order. Coordinates are converted to the Y-up convention of OBJ just like
+// WriteGLTF does. If mtl is non-nil a material library is written to it, with
+// a material for each image referencing it (for instance the tileset image
+// "tilesheet.png"), and w refers to it by the given mtlLib file name.
+//
+// Only the data that is still held in memory is written (I.e. not that of
+// meshes which were loaded by the renderer and then freed).
+func WriteOBJ(w, mtl io.Writer, mtlLib string, layers map[string]map[string]*gfx.Object) error {
+	bw := bufio.NewWriter(w)
+	if mtl != nil {
+		fmt.Fprintf(bw, "mtllib %s\n", mtlLib)
+	}
+
+	var names []string
+	for name := range layers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	// OBJ indices are one-based, and count across all of the objects.
+	vertices, texCoords := 1, 1
+	materials := make(map[string]bool)
+	for _, name := range names {
+		var keys []string
+		for key := range layers[name] {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			obj := layers[name][key]
+			obj.RLock()
+			for _, mesh := range obj.Meshes {
+				mesh.RLock()
+				fmt.Fprintf(bw, "o %s/%s\n", name, key)
+				if mtl != nil {
+					fmt.Fprintf(bw, "usemtl %s\n", key)
+					materials[key] = true
+				}
+				for _, v := range mesh.Vertices {
+					fmt.Fprintf(bw, "v %g %g %g\n", v.X, v.Z, -v.Y)
+				}
+				var uv []gfx.TexCoord
+				if len(mesh.TexCoords) > 0 && len(mesh.TexCoords[0].Slice) == len(mesh.Vertices) {
+					uv = mesh.TexCoords[0].Slice
+				}
+				for _, t := range uv {
+					// OBJ texture coordinates start at the bottom-left.
+					fmt.Fprintf(bw, "vt %g %g\n", t.U, 1-t.V)
+				}
+
+				// Faces, indexed or just consecutive triangles.
+				indices := mesh.Indices
+				if len(indices) == 0 {
+					indices = make([]uint32, len(mesh.Vertices)/3*3)
+					for i := range indices {
+						indices[i] = uint32(i)
+					}
+				}
+				for i := 0; i+2 < len(indices); i += 3 {
+					bw.WriteString("f")
+					for _, idx := range indices[i : i+3] {
+						if uv != nil {
+							fmt.Fprintf(bw, " %d/%d", vertices+int(idx), texCoords+int(idx))
+						} else {
+							fmt.Fprintf(bw, " %d", vertices+int(idx))
+						}
+					}
+					bw.WriteString("\n")
+				}
+				vertices += len(mesh.Vertices)
+				texCoords += len(uv)
+				mesh.RUnlock()
+			}
+			obj.RUnlock()
+		}
+	}
+	if err := bw.Flush(); err != nil {
+		return err
+	}
+	if mtl == nil {
+		return nil
+	}
+
+	var keys []string
+	for key := range materials {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	bw = bufio.NewWriter(mtl)
+	for _, key := range keys {
+		fmt.Fprintf(bw, "newmtl %s\nKd 1 1 1\nd 1\nillum 0\nmap_Kd %s\n\n", key, key)
+	}
+	return bw.Flush()
+}
@@ -6,6 +6,8 @@ package tmx
 
 import (
 	"fmt"
+	"image"
+	"image/color"
 	"strconv"
 	"strings"
 )
@@ -24,6 +26,29 @@ type Point struct {
 	X, Y int
 }
 
+// pointsBounds returns the bounding rectangle of the given points.
+func pointsBounds(points []Point) image.Rectangle {
+	if len(points) == 0 {
+		return image.Rectangle{}
+	}
+	r := image.Rect(points[0].X, points[0].Y, points[0].X, points[0].Y)
+	for _, p := range points[1:] {
+		if p.X < r.Min.X {
+			r.Min.X = p.X
+		}
+		if p.Y < r.Min.Y {
+			r.Min.Y = p.Y
+		}
+		if p.X > r.Max.X {
+			r.Max.X = p.X
+		}
+		if p.Y > r.Max.Y {
+			r.Max.Y = p.Y
+		}
+	}
+	return r
+}
+
 // Polygon represents a polygon object, found in the Object.Value field.
 type Polygon struct {
 	// The position/origin of the polygon.
@@ -48,6 +73,78 @@ type Polyline struct {
 	Points []Point
 }
 
+// Text represents a text object, found in the Object.Value field.
+type Text struct {
+	// The text itself.
+	Text string
+
+	// The font family, and the size of the font in pixels.
+	FontFamily string
+	PixelSize  int
+
+	// Whether or not the text is word wrapped to the width of the object.
+	Wrap bool
+
+	// The color of the text.
+	Color color.RGBA
+
+	// Styles of the text.
+	Bold, Italic, Underline, Strikeout bool
+
+	// Whether or not kerning is used when placing the glyphs.
+	Kerning bool
+
+	// The horizontal alignment of the text within the object, one of "left",
+	// "center", "right" or "justify".
+	HAlign string
+
+	// The vertical alignment of the text within the object, one of "top",
+	// "center" or "bottom".
+	VAlign string
+}
+
+type xmlText struct {
+	FontFamily string `xml:"fontfamily,attr"`
+	PixelSize  *int   `xml:"pixelsize,attr"`
+	Wrap       int    `xml:"wrap,attr"`
+	Color      string `xml:"color,attr"`
+	Bold       int    `xml:"bold,attr"`
+	Italic     int    `xml:"italic,attr"`
+	Underline  int    `xml:"underline,attr"`
+	Strikeout  int    `xml:"strikeout,attr"`
+	Kerning    *int   `xml:"kerning,attr"`
+	HAlign     string `xml:"halign,attr"`
+	VAlign     string `xml:"valign,attr"`
+	Data       string `xml:",chardata"`
+}
+
+func (x xmlText) toText() *Text {
+	t := &Text{
+		Text:       x.Data,
+		FontFamily: x.FontFamily,
+		PixelSize:  16,
+		Wrap:       x.Wrap == 1,
+		Color:      hexToRGBA(x.Color),
+		Bold:       x.Bold == 1,
+		Italic:     x.Italic == 1,
+		Underline:  x.Underline == 1,
+		Strikeout:  x.Strikeout == 1,
+		Kerning:    x.Kerning == nil || *x.Kerning == 1,
+		HAlign:     x.HAlign,
+		VAlign:     x.VAlign,
+	}
+	if x.PixelSize != nil {
+		t.PixelSize = *x.PixelSize
+	}
+	if len(t.HAlign) == 0 {
+		t.HAlign = "left"
+	}
+	if len(t.VAlign) == 0 {
+		t.VAlign = "top"
+	}
+	return t
+}
+
 // Polygon and Polyset are identical, we share definitions here.
 type xmlPolyset struct {
 	Data string `xml:"points,attr"`
@@ -73,17 +170,19 @@ func (x xmlPolyset) toPoints() (points []Point) {
 type xmlObject struct {
 	Name       string        `xml:"name,attr"`
 	Type       string        `xml:"type,attr"`
+	Class      string        `xml:"class,attr"`
 	X          int           `xml:"x,attr"`
 	Y          int           `xml:"y,attr"`
 	Width      int           `xml:"width,attr"`
 	Height     int           `xml:"height,attr"`
 	Rotation   float64       `xml:"rotation,attr"`
-	Gid        int           `xml:"gid,attr"`
-	Visible    int           `xml:"visible,attr"`
+	Gid        uint32        `xml:"gid,attr"`
+	Visible    *int          `xml:"visible,attr"`
 	Properties xmlProperties `xml:"properties"`
 	Ellipse    *string       `xml:"ellipse"`
 	Polygon    xmlPolyset    `xml:"polygon"`
 	Polyline   xmlPolyset    `xml:"polyline"`
+	Text       *xmlText      `xml:"text"`
 
 	// FIXME: alledgedly, object tags can have images under them, but it's not
 	// clear what that would mean. There also is no way to create one in
@@ -114,22 +213,32 @@ func (x xmlObject) toValue() interface{} {
 			Points: x.Polyline.toPoints(),
 		}
 	}
+	if x.Text != nil {
+		return x.Text.toText()
+	}
 	return nil
 }
 
 func (x xmlObject) toObject() *Object {
+	const flags = FLIPPED_HORIZONTALLY_FLAG | FLIPPED_VERTICALLY_FLAG | FLIPPED_DIAGONALLY_FLAG
+	class := classAttr(x.Class, x.Type)
 	return &Object{
 		Name:       x.Name,
-		Type:       x.Type,
+		Type:       class,
+		Class:      class,
 		X:          x.X,
 		Y:          x.Y,
 		Width:      x.Width,
 		Height:     x.Height,
 		Rotation:   x.Rotation,
-		Gid:        uint32(x.Gid),
-		Visible:    x.Visible != 0,
+		Gid:        x.Gid &^ flags,
+		Visible:    visibleAttr(x.Visible),
 		Properties: x.Properties.toMap(),
 		Value:      x.toValue(),
+
+		FlippedHorizontally: x.Gid&FLIPPED_HORIZONTALLY_FLAG > 0,
+		FlippedVertically:   x.Gid&FLIPPED_VERTICALLY_FLAG > 0,
+		FlippedDiagonally:   x.Gid&FLIPPED_DIAGONALLY_FLAG > 0,
 	}
 }
 
@@ -140,8 +249,15 @@ type Object struct {
 	Name string
 
 	// The type of this object, which is an arbitrary string.
+	//
+	// Deprecated: Tiled 1.9 renamed type to class, use Class instead. When
+	// parsed this is always equal to Class.
 	Type string
 
+	// The class of this object, which is an arbitrary string. It is read from
+	// either the class attribute or the type attribute used before Tiled 1.9.
+	Class string
+
 	// The X and Y coordinates, as well as the width and height of this object
 	// in pixels.
 	X, Y, Width, Height int
@@ -155,8 +271,14 @@ type Object struct {
 	// currently depends on the map orientation:
 	//  Orthogonal - Aligned to the bottom-left
 	//  Isometric - Aligned to the bottom-center
+	//
+	// The flip flags of the gid are cleared, see the Flipped fields below.
 	Gid uint32
 
+	// Whether or not the tile of this object (see Gid) is flipped
+	// horizontally, vertically or diagonally.
+	FlippedHorizontally, FlippedVertically, FlippedDiagonally bool
+
 	// Boolean value representing whether or not the object group is visible.
 	Visible bool
 
@@ -169,11 +291,27 @@ type Object struct {
 	//  case *tmx.Ellipse: handleEllipse(obj, v)
 	//  case *tmx.Polygon: handlePolygon(obj, v)
 	//  case *tmx.Polyline: handlePolyline(obj, v)
+	//  case *tmx.Text: handleText(obj, v)
 	//  case *tmx.Image: handleImage(obj, v)
 	//  }
 	Value interface{}
 }
 
+// flaggedGid returns the gid of this object with it's flip flags set.
+func (o *Object) flaggedGid() uint32 {
+	gid := o.Gid
+	if o.FlippedHorizontally {
+		gid |= FLIPPED_HORIZONTALLY_FLAG
+	}
+	if o.FlippedVertically {
+		gid |= FLIPPED_VERTICALLY_FLAG
+	}
+	if o.FlippedDiagonally {
+		gid |= FLIPPED_DIAGONALLY_FLAG
+	}
+	return gid
+}
+
 // String returns a string representation of this object, like:
 //  Object(Name="the name", X=%d, Y=%d, Width=%d, Height=%d)
 func (o *Object) String() string {
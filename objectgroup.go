@@ -6,16 +6,28 @@ package tmx
 
 import (
 	"fmt"
+	"image"
 	"image/color"
+	"math"
 )
 
+// The number of line segments used to approximate ellipses.
+const ellipseSegments = 16
+
 // NOTE: x, y, width and height attributes are apparently meaningless:
-//  https://github.com/bjorn/tiled/wiki/TMX-Map-Format#objectgroup
+//
+//	https://github.com/bjorn/tiled/wiki/TMX-Map-Format#objectgroup
 type xmlObjectgroup struct {
 	Name       string        `xml:"name,attr"`
+	Class      string        `xml:"class,attr"`
+	Type       string        `xml:"type,attr"`
 	Color      string        `xml:"color,attr"`
-	Opacity    float64       `xml:"opacity,attr"`
-	Visible    int           `xml:"visible,attr"`
+	Opacity    *float64      `xml:"opacity,attr"`
+	Visible    *int          `xml:"visible,attr"`
+	OffsetX    float64       `xml:"offsetx,attr"`
+	OffsetY    float64       `xml:"offsety,attr"`
+	ParallaxX  *float64      `xml:"parallaxx,attr"`
+	ParallaxY  *float64      `xml:"parallaxy,attr"`
 	Properties xmlProperties `xml:"properties"`
 	Object     []xmlObject   `xml:"object"`
 }
@@ -27,9 +39,14 @@ func (x xmlObjectgroup) toObjectGroup() *ObjectGroup {
 	}
 	return &ObjectGroup{
 		Name:       x.Name,
+		Class:      classAttr(x.Class, x.Type),
 		Color:      hexToRGBA(x.Color),
-		Opacity:    x.Opacity,
-		Visible:    x.Visible != 0,
+		Opacity:    floatAttr(x.Opacity, 1),
+		Visible:    visibleAttr(x.Visible),
+		OffsetX:    x.OffsetX,
+		OffsetY:    x.OffsetY,
+		ParallaxX:  floatAttr(x.ParallaxX, 1),
+		ParallaxY:  floatAttr(x.ParallaxY, 1),
 		Properties: x.Properties.toMap(),
 		Objects:    objects,
 	}
@@ -40,6 +57,13 @@ type ObjectGroup struct {
 	// The name of this object group.
 	Name string
 
+	// The class of this object group (Tiled 1.9 and later).
+	Class string
+
+	// The draw-order index of this object group, see Layer.Index for more
+	// information.
+	Index int
+
 	// Color of this object group.
 	Color color.RGBA
 
@@ -49,15 +73,147 @@ type ObjectGroup struct {
 	// Boolean value representing whether or not the object group is visible.
 	Visible bool
 
+	// The horizontal and vertical offset of the object group in pixels, where
+	// +Y is down.
+	OffsetX, OffsetY float64
+
+	// The horizontal and vertical parallax scrolling factors of the object
+	// group, see Layer.ParallaxX for more information.
+	ParallaxX, ParallaxY float64
+
 	// Map of properties for this object group.
 	Properties map[string]string
 
+	// The group layer that this object group is within, or nil if it is at the top
+	// level of the map.
+	Parent *GroupLayer
+
 	// List of objects in this object group.
 	Objects []*Object
 }
 
 // String returns a string representation of this object group, like:
-//  ObjectGroup(Name="the name", 500 objects)
+//
+//	ObjectGroup(Name="the name", 500 objects)
 func (o *ObjectGroup) String() string {
 	return fmt.Sprintf("ObjectGroup(Name=%q, %d objects)", o.Name, len(o.Objects))
 }
+
+// ParallaxOffset returns the additional offset, in pixels, that should be
+// applied to this object group for the given camera position (in pixels) in
+// order to achieve it's parallax scrolling effect, taking the parallax factors
+// of any group layers it is within into account.
+//
+// The map, m, must be the map that this object group belongs to, as it
+// specifies the origin for parallax scrolling.
+func (o *ObjectGroup) ParallaxOffset(m *Map, camX, camY float64) (x, y float64) {
+	fx, fy := o.EffectiveParallax()
+	return parallaxOffset(m, fx, fy, camX, camY)
+}
+
+// Bounds returns the bounding rectangle, in pixels, of the given object in
+// the world space of the map (I.e. with the effective offset of this object
+// group applied).
+//
+// Tile objects (those with a non-zero Gid) are aligned to the bottom-left, as
+// they are in orthogonal maps. Polygons and polylines are bounded by their
+// points.
+func (o *ObjectGroup) Bounds(obj *Object) image.Rectangle {
+	var r image.Rectangle
+	switch v := obj.Value.(type) {
+	case *Polygon:
+		r = pointsBounds(v.Points)
+	case *Polyline:
+		r = pointsBounds(v.Points)
+	default:
+		if obj.Gid != 0 {
+			r = image.Rect(0, -obj.Height, obj.Width, 0)
+		} else {
+			r = image.Rect(0, 0, obj.Width, obj.Height)
+		}
+	}
+	ox, oy := o.EffectiveOffset()
+	return r.Add(image.Pt(pixelFloor(obj.X, ox), pixelFloor(obj.Y, oy)))
+}
+
+// Outline returns the outline of the given object as a list of points in the
+// world space of the map (I.e. with the effective offset of this object group
+// and the object's rotation applied), and whether the outline is closed.
+//
+// Rectangles (and tile objects, aligned like Bounds) are outlined by their
+// corners and ellipses are approximated by polygons. Polylines are the only
+// open outlines. Point objects (those with no size) have no outline.
+func (o *ObjectGroup) Outline(obj *Object) (points []Pixel, closed bool) {
+	var local []Pixel
+	closed = true
+	switch v := obj.Value.(type) {
+	case *Polygon:
+		for _, p := range v.Points {
+			local = append(local, Pixel{float64(p.X), float64(p.Y)})
+		}
+	case *Polyline:
+		for _, p := range v.Points {
+			local = append(local, Pixel{float64(p.X), float64(p.Y)})
+		}
+		closed = false
+	case *Ellipse:
+		rx, ry := float64(obj.Width)/2, float64(obj.Height)/2
+		for i := 0; i < ellipseSegments; i++ {
+			a := 2 * math.Pi * float64(i) / ellipseSegments
+			local = append(local, Pixel{rx + rx*math.Cos(a), ry + ry*math.Sin(a)})
+		}
+	default:
+		if obj.Width == 0 && obj.Height == 0 {
+			return nil, false
+		}
+		w, h := float64(obj.Width), float64(obj.Height)
+		top := 0.0
+		if obj.Gid != 0 {
+			top = -h
+		}
+		local = []Pixel{{0, top}, {w, top}, {w, top + h}, {0, top + h}}
+	}
+
+	// Apply the rotation (clockwise, about the object's position) and move
+	// into world space.
+	sin, cos := math.Sincos(obj.Rotation * math.Pi / 180)
+	ox, oy := o.EffectiveOffset()
+	x, y := float64(obj.X)+ox, float64(obj.Y)+oy
+	points = make([]Pixel, len(local))
+	for i, p := range local {
+		points[i] = Pixel{
+			x + p.X*cos - p.Y*sin,
+			y + p.X*sin + p.Y*cos,
+		}
+	}
+	return points, closed
+}
+
+// Contains tells if the given point, in the world space of the map (see
+// Outline), is within the closed outline of the given object. Polylines and
+// point objects contain no points.
+func (o *ObjectGroup) Contains(obj *Object, p Pixel) bool {
+	points, closed := o.Outline(obj)
+	if !closed {
+		return false
+	}
+	return polygonContains(points, p)
+}
+
+// polygonContains tells if the given point is within the closed polygon with
+// the given points.
+func polygonContains(points []Pixel, p Pixel) bool {
+	if len(points) < 3 {
+		return false
+	}
+
+	// Even-odd rule: count the edges crossed by a ray towards +X.
+	inside := false
+	for i, a := range points {
+		b := points[(i+1)%len(points)]
+		if (a.Y > p.Y) != (b.Y > p.Y) && p.X < a.X+(p.Y-a.Y)*(b.X-a.X)/(b.Y-a.Y) {
+			inside = !inside
+		}
+	}
+	return inside
+}
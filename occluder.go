@@ -0,0 +1,129 @@
+// Copyright 2014 Lightpoke. All rights reserved.
+// This source code is subject to the terms and
+// conditions defined in the "License.txt" file.
+
+package tmx
+
+import "image"
+
+// Edge represents a line segment from A to B, in pixels.
+type Edge struct {
+	A, B Pixel
+}
+
+// solidCells returns a function telling if the cell of the layer l at the
+// given coordinates is solid as decided by the given function. Cells outside of
+// the given bounds are not solid.
+func solidCells(l *Layer, bounds image.Rectangle, solid func(gid uint32) bool) func(x, y int) bool {
+	return func(x, y int) bool {
+		if !image.Pt(x, y).In(bounds) {
+			return false
+		}
+		gid := l.Tile(Coord{x, y})
+		return gid != 0 && solid(gid)
+	}
+}
+
+// Occluders returns the shadow casting edges of the named tile layer, which
+// are the boundaries between solid and non-solid cells as decided by the
+// given function. Cells outside of the map are not solid. Edges along the
+// same row or column are merged into the longest possible segments.
+//
+// Edges wind clockwise (as seen on screen, where +Y is down) around solid
+// regions, so the solid side of each edge is to it's right. Cells are laid out
+// orthogonally. Layers of infinite maps are considered within their bounds
+// (see Layer.Bounds), which may have negative coordinates.
+//
+// If there is no layer with the given name, nil is returned.
+func (m *Map) Occluders(layer string, solid func(gid uint32) bool) []Edge {
+	l := m.FindLayer(layer)
+	if l == nil {
+		return nil
+	}
+	bounds := l.Bounds(m)
+	isSolid := solidCells(l, bounds, solid)
+	tw, th := float64(m.TileWidth), float64(m.TileHeight)
+	var edges []Edge
+
+	// runs finds the runs of cells along a row or column (from min to max) for
+	// which the given function is true, and emits an edge for each one.
+	runs := func(min, max int, boundary func(i int) bool, emit func(start, end int)) {
+		start, inRun := 0, false
+		for i := min; i <= max; i++ {
+			if i < max && boundary(i) {
+				if !inRun {
+					start, inRun = i, true
+				}
+				continue
+			}
+			if inRun {
+				emit(start, i)
+				inRun = false
+			}
+		}
+	}
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		top, bottom := float64(y)*th, float64(y+1)*th
+		runs(bounds.Min.X, bounds.Max.X, func(x int) bool {
+			return isSolid(x, y) && !isSolid(x, y-1)
+		}, func(start, end int) {
+			edges = append(edges, Edge{Pixel{float64(start) * tw, top}, Pixel{float64(end) * tw, top}})
+		})
+		runs(bounds.Min.X, bounds.Max.X, func(x int) bool {
+			return isSolid(x, y) && !isSolid(x, y+1)
+		}, func(start, end int) {
+			edges = append(edges, Edge{Pixel{float64(end) * tw, bottom}, Pixel{float64(start) * tw, bottom}})
+		})
+	}
+	for x := bounds.Min.X; x < bounds.Max.X; x++ {
+		left, right := float64(x)*tw, float64(x+1)*tw
+		runs(bounds.Min.Y, bounds.Max.Y, func(y int) bool {
+			return isSolid(x, y) && !isSolid(x-1, y)
+		}, func(start, end int) {
+			edges = append(edges, Edge{Pixel{left, float64(end) * th}, Pixel{left, float64(start) * th}})
+		})
+		runs(bounds.Min.Y, bounds.Max.Y, func(y int) bool {
+			return isSolid(x, y) && !isSolid(x+1, y)
+		}, func(start, end int) {
+			edges = append(edges, Edge{Pixel{right, float64(start) * th}, Pixel{right, float64(end) * th}})
+		})
+	}
+	return edges
+}
+
+// Occluders returns the shadow casting edges of the visible objects in this
+// object group, see Outline. Closed outlines wind clockwise (as seen on
+// screen) like the edges returned by Map.Occluders.
+func (o *ObjectGroup) Occluders() []Edge {
+	var edges []Edge
+	for _, obj := range o.Objects {
+		if !obj.Visible {
+			continue
+		}
+		points, closed := o.Outline(obj)
+		if len(points) < 2 {
+			continue
+		}
+
+		// Reverse counter-clockwise outlines, using the sign of their area.
+		if closed {
+			var area float64
+			for i, a := range points {
+				b := points[(i+1)%len(points)]
+				area += a.X*b.Y - b.X*a.Y
+			}
+			if area < 0 {
+				for i, j := 0, len(points)-1; i < j; i, j = i+1, j-1 {
+					points[i], points[j] = points[j], points[i]
+				}
+			}
+		}
+		for i := 0; i+1 < len(points); i++ {
+			edges = append(edges, Edge{points[i], points[i+1]})
+		}
+		if closed {
+			edges = append(edges, Edge{points[len(points)-1], points[0]})
+		}
+	}
+	return edges
+}
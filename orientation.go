@@ -4,6 +4,8 @@
 
 package tmx
 
+import "fmt"
+
 // Orientation represents the map's orientation. It will always be one of the
 // predefined Orientation constants and will never be Invalid.
 type Orientation int
@@ -20,4 +22,105 @@ const (
 
 	// Staggered map orientation
 	Staggered
+
+	// Hexagonal map orientation
+	Hexagonal
+)
+
+// StaggerAxis represents which axis of a staggered or hexagonal map is
+// staggered.
+type StaggerAxis int
+
+const (
+	// Every other row is shifted (the default).
+	StaggerAxisY StaggerAxis = iota
+
+	// Every other column is shifted.
+	StaggerAxisX
 )
+
+// StaggerIndex represents whether the odd or even rows (or columns) of a
+// staggered or hexagonal map are shifted.
+type StaggerIndex int
+
+const (
+	// Odd rows or columns are shifted (the default).
+	StaggerIndexOdd StaggerIndex = iota
+
+	// Even rows or columns are shifted.
+	StaggerIndexEven
+)
+
+// RenderOrder represents the order in which the tiles of an orthogonal map's
+// layers are rendered, which decides how overlapping tiles are drawn.
+type RenderOrder int
+
+const (
+	// Rows are rendered from top to bottom, and each row from left to right
+	// (the default).
+	RightDown RenderOrder = iota
+
+	// Rows are rendered from bottom to top, and each row from left to right.
+	RightUp
+
+	// Rows are rendered from top to bottom, and each row from right to left.
+	LeftDown
+
+	// Rows are rendered from bottom to top, and each row from right to left.
+	LeftUp
+)
+
+// The names of the enums as they appear in TMX files, indexed by value.
+var (
+	orientationNames  = [...]string{"", "orthogonal", "isometric", "staggered", "hexagonal"}
+	staggerAxisNames  = [...]string{"y", "x"}
+	staggerIndexNames = [...]string{"odd", "even"}
+	renderOrderNames  = [...]string{"right-down", "right-up", "left-down", "left-up"}
+)
+
+// enumName returns the name of the given enum value, or a Go-syntax
+// representation of it if it has none.
+func enumName(names []string, typ string, v int) string {
+	if v < 0 || v >= len(names) || len(names[v]) == 0 {
+		return fmt.Sprintf("%s(%d)", typ, v)
+	}
+	return names[v]
+}
+
+// parseEnum returns the value of the enum with the given name, or an error if
+// there is none. The empty name is the default value def.
+func parseEnum(names []string, attr, name string, def int) (int, error) {
+	if len(name) == 0 {
+		return def, nil
+	}
+	for v, n := range names {
+		if n == name && len(n) > 0 {
+			return v, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown map %s %q.", attr, name)
+}
+
+// String returns the name of the orientation as it appears in TMX files, like
+// "orthogonal".
+func (o Orientation) String() string {
+	return enumName(orientationNames[:], "Orientation", int(o))
+}
+
+// String returns the name of the stagger axis as it appears in TMX files, "x"
+// or "y".
+func (s StaggerAxis) String() string {
+	return enumName(staggerAxisNames[:], "StaggerAxis", int(s))
+}
+
+// String returns the name of the stagger index as it appears in TMX files,
+// "odd" or "even".
+func (s StaggerIndex) String() string {
+	return enumName(staggerIndexNames[:], "StaggerIndex", int(s))
+}
+
+// String returns the name of the render order as it appears in TMX files, like
+// "right-down".
+func (r RenderOrder) String() string {
+	return enumName(renderOrderNames[:], "RenderOrder", int(r))
+}
@@ -0,0 +1,108 @@
+// Copyright 2014 Lightpoke. All rights reserved.
+// This source code is subject to the terms and
+// conditions defined in the "License.txt" file.
+
+package tmx
+
+import "image"
+
+// Outlines returns the outlines of the solid regions of the named tile layer,
+// as decided by the given function, as closed polygons in pixels. Cells are
+// laid out orthogonally, and cells outside of the layer's bounds (see
+// Layer.Bounds) are not solid.
+//
+// Each polygon follows the boundary between solid and non-solid cells (like
+// marching squares does), with collinear edges merged such that only the
+// corners of each region remain. This produces far fewer (and larger) shapes
+// than one per solid cell, which is what physics engines want.
+//
+// Just like the edges returned by Occluders, the outer boundary of each region
+// winds clockwise (as seen on screen, where +Y is down) and the boundaries of
+// any holes within it wind counter-clockwise, such that the solid side is
+// always to the right. Regions touching only at a corner are separate.
+//
+// If there is no layer with the given name, nil is returned.
+func (m *Map) Outlines(layer string, solid func(gid uint32) bool) [][]Pixel {
+	l := m.FindLayer(layer)
+	if l == nil {
+		return nil
+	}
+	bounds := l.Bounds(m)
+	isSolid := solidCells(l, bounds, solid)
+
+	// Find the unit edges (in cells) of the boundary, in a deterministic
+	// order, and the edges leaving each corner.
+	type edge struct {
+		a, b image.Point
+	}
+	var edges []edge
+	out := make(map[image.Point][]image.Point)
+	add := func(a, b image.Point) {
+		edges = append(edges, edge{a, b})
+		out[a] = append(out[a], b)
+	}
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			if !isSolid(x, y) {
+				continue
+			}
+			if !isSolid(x, y-1) {
+				add(image.Pt(x, y), image.Pt(x+1, y))
+			}
+			if !isSolid(x+1, y) {
+				add(image.Pt(x+1, y), image.Pt(x+1, y+1))
+			}
+			if !isSolid(x, y+1) {
+				add(image.Pt(x+1, y+1), image.Pt(x, y+1))
+			}
+			if !isSolid(x-1, y) {
+				add(image.Pt(x, y+1), image.Pt(x, y))
+			}
+		}
+	}
+
+	// next returns the edge following e along it's boundary. Two edges leave
+	// the corner where diagonal cells touch, turning right (towards the solid
+	// side) keeps the regions separate.
+	next := func(e edge) edge {
+		d := e.b.Sub(e.a)
+		for _, want := range []image.Point{{-d.Y, d.X}, d, {d.Y, -d.X}} {
+			for _, b := range out[e.b] {
+				if b.Sub(e.b) == want {
+					return edge{e.b, b}
+				}
+			}
+		}
+		panic("tmx: open outline")
+	}
+
+	tw, th := float64(m.TileWidth), float64(m.TileHeight)
+	var polygons [][]Pixel
+	used := make(map[edge]bool, len(edges))
+	for _, start := range edges {
+		if used[start] {
+			continue
+		}
+		var corners []image.Point
+		for e := start; ; {
+			used[e] = true
+			corners = append(corners, e.a)
+			if e = next(e); e == start {
+				break
+			}
+		}
+
+		// Merge collinear edges by dropping the corners that do not turn.
+		var polygon []Pixel
+		for i, c := range corners {
+			prev := corners[(i+len(corners)-1)%len(corners)]
+			after := corners[(i+1)%len(corners)]
+			if c.Sub(prev) == after.Sub(c) {
+				continue
+			}
+			polygon = append(polygon, Pixel{float64(c.X) * tw, float64(c.Y) * th})
+		}
+		polygons = append(polygons, polygon)
+	}
+	return polygons
+}
@@ -0,0 +1,165 @@
+// Copyright 2014 Lightpoke. All rights reserved.
+// This source code is subject to the terms and
+// conditions defined in the "License.txt" file.
+
+package tmx
+
+import (
+	"fmt"
+	"image"
+)
+
+// Precedence decides whether a base map or an overlay wins where both have a
+// tile or object, see Map.ApplyOverlay.
+type Precedence int
+
+const (
+	// The tiles and objects of the overlay replace those of the base map.
+	OverlayWins Precedence = iota
+
+	// The tiles and objects of the overlay only fill in where the base map
+	// has none.
+	BaseWins
+)
+
+// precedenceProperty returns the precedence described by the conventional
+// "overlay-precedence" custom property ("overlay" or "base") in the given
+// properties map, or def if it has none.
+func precedenceProperty(props map[string]string, def Precedence) Precedence {
+	switch props["overlay-precedence"] {
+	case "overlay":
+		return OverlayWins
+	case "base":
+		return BaseWins
+	}
+	return def
+}
+
+// ApplyOverlay composites the given overlay (or "patch") map, for instance
+// with seasonal decorations or event content, over this map at runtime, such
+// that such content can ship as small TMX files of their own.
+//
+// The non-empty tiles of each tile layer of the overlay are set on the base
+// map's tile layer of the same name (using SetTile, so they are marked as
+// dirty). The objects of each object group of the overlay are added to the
+// base map's object group of the same name, objects with the same non-empty
+// name as one of the base group replace it. Layers and object groups that the
+// base map lacks are added on top of it's layers, image layers are ignored.
+//
+// Where both maps have a tile or a named object, the given precedence decides
+// which one is kept, overlay layers and groups may override it through the
+// conventional "overlay-precedence" custom property ("overlay" or "base").
+//
+// Tilesets of the overlay that the base map has as well (see StitchMaps) are
+// shared, others are added to the base map, and the global tile IDs of the
+// overlay are remapped accordingly. Both maps must have the same orientation
+// and tile size, otherwise an error is returned and the map is not changed.
+func (m *Map) ApplyOverlay(overlay *Map, precedence Precedence) error {
+	if overlay.Orientation != m.Orientation {
+		return fmt.Errorf("ApplyOverlay(): overlay orientation differs from the map's")
+	}
+	if overlay.TileWidth != m.TileWidth || overlay.TileHeight != m.TileHeight {
+		return fmt.Errorf("ApplyOverlay(): overlay tile size %dx%d, want %dx%d", overlay.TileWidth, overlay.TileHeight, m.TileWidth, m.TileHeight)
+	}
+
+	// Share or add the tilesets of the overlay.
+	tilesets := make(map[*Tileset]*Tileset, len(overlay.Tilesets))
+	next := uint32(1)
+	if n := len(m.Tilesets); n > 0 {
+		next = m.Tilesets[n-1].Firstgid + tilesetSpan(m, n-1)
+	}
+	for i, ts := range overlay.Tilesets {
+		for _, base := range m.Tilesets {
+			if sameTileset(ts, base) {
+				tilesets[ts] = base
+				break
+			}
+		}
+		if _, ok := tilesets[ts]; ok {
+			continue
+		}
+		cpy := *ts
+		cpy.Firstgid = next
+		next += tilesetSpan(overlay, i)
+		m.Tilesets = append(m.Tilesets, &cpy)
+		tilesets[ts] = &cpy
+	}
+	remap := func(gid uint32) uint32 {
+		ts := overlay.FindTileset(gid)
+		if ts == nil || gid&^flipFlags == 0 {
+			return gid
+		}
+		return (tilesets[ts].Firstgid + gid&^flipFlags - ts.Firstgid) | gid&flipFlags
+	}
+
+	index := 0
+	for _, it := range drawOrder(m) {
+		if it.index >= index {
+			index = it.index + 1
+		}
+	}
+	for _, it := range drawOrder(overlay) {
+		switch {
+		case it.layer != nil:
+			l := m.FindLayer(it.layer.Name)
+			if l == nil {
+				cpy := *it.layer
+				cpy.Index = index
+				cpy.Tiles = make(map[Coord]uint32)
+				cpy.RLE = nil
+				cpy.Parent = nil
+				cpy.decoded = 0
+				cpy.dirty = image.Rectangle{}
+				cpy.boundsKnown = false
+				cpy.shared = false
+				l = &cpy
+				m.Layers = append(m.Layers, l)
+				index++
+			}
+			p := precedenceProperty(it.layer.Properties, precedence)
+			it.layer.EachTile(func(c Coord, gid uint32) {
+				if gid == 0 || (p == BaseWins && l.Tile(c) != 0) {
+					return
+				}
+				l.SetTile(c, remap(gid))
+			})
+
+		case it.group != nil:
+			var g *ObjectGroup
+			for _, bg := range m.ObjectGroups {
+				if bg.Name == it.group.Name {
+					g = bg
+					break
+				}
+			}
+			if g == nil {
+				cpy := *it.group
+				cpy.Index = index
+				cpy.Objects = nil
+				cpy.Parent = nil
+				g = &cpy
+				m.ObjectGroups = append(m.ObjectGroups, g)
+				index++
+			}
+			p := precedenceProperty(it.group.Properties, precedence)
+		objects:
+			for _, o := range it.group.Objects {
+				cpy := *o
+				cpy.Gid = remap(o.Gid)
+				if len(o.Name) > 0 {
+					for i, bo := range g.Objects {
+						if bo.Name != o.Name {
+							continue
+						}
+						if p == OverlayWins {
+							g.Objects[i] = &cpy
+						}
+						continue objects
+					}
+				}
+				g.Objects = append(g.Objects, &cpy)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,71 @@
+// Copyright 2014 The Azul3D Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tmx
+
+import (
+	"image"
+	"image/color"
+
+	"azul3d.org/gfx.v2-unstable"
+)
+
+// The number of colors of a palette, see Config.Palette.
+const paletteSize = 256
+
+// PaletteImage returns the palette image (a single row of 256 colors) holding
+// the colors of the pixels of the given image in row-major order, for instance
+// a strip of color swatches. Only the first 256 pixels are used, remaining
+// colors of the palette are transparent.
+func PaletteImage(img image.Image) *image.RGBA {
+	p := image.NewRGBA(image.Rect(0, 0, paletteSize, 1))
+	b := img.Bounds()
+	i := 0
+	for y := b.Min.Y; y < b.Max.Y && i < paletteSize; y++ {
+		for x := b.Min.X; x < b.Max.X && i < paletteSize; x++ {
+			p.Set(i, 0, color.RGBAModel.Convert(img.At(x, y)))
+			i++
+		}
+	}
+	return p
+}
+
+// NewPaletteTexture returns a new palette texture for Config.Palette, holding
+// the colors of the given image (see PaletteImage).
+func NewPaletteTexture(img image.Image) *gfx.Texture {
+	t := gfx.NewTexture()
+	t.Source = PaletteImage(img)
+	t.Bounds = t.Source.Bounds()
+	t.WrapU = gfx.Clamp
+	t.WrapV = gfx.Clamp
+	t.MinFilter = gfx.Nearest
+	t.MagFilter = gfx.Nearest
+	return t
+}
+
+// SetPalette swaps the palette texture used by the objects of the given
+// layers, as returned by Load with the configuration c, for the given one (see
+// Config.Palette), and stores it in c such that objects loaded with c later use
+// it as well. This swaps the colors of all of the tiles at once, for instance
+// for palette swap effects of retro games.
+//
+// The objects must have been loaded with a palette, and must not be drawn
+// while the palette is being swapped.
+func SetPalette(c *Config, palette *gfx.Texture, layers ...map[string]map[string]*gfx.Object) {
+	old := c.Palette
+	c.Palette = palette
+	for _, l := range layers {
+		for _, objects := range l {
+			for _, obj := range objects {
+				obj.Lock()
+				for i, t := range obj.Textures {
+					if i > 0 && t == old {
+						obj.Textures[i] = palette
+					}
+				}
+				obj.Unlock()
+			}
+		}
+	}
+}
@@ -0,0 +1,61 @@
+// Copyright 2014 Lightpoke. All rights reserved.
+// This source code is subject to the terms and
+// conditions defined in the "License.txt" file.
+
+package tmx
+
+// Parser parses TMX map files repeatedly while reusing memory from previous
+// parses, which reduces garbage collector pressure for e.g. game servers that
+// parse the same few maps for each match.
+//
+// A Parser is not safe for use by multiple goroutines at once.
+type Parser struct {
+	// The configuration used when parsing, if nil then the default
+	// configuration is used.
+	Config *ParseConfig
+
+	// The intermediate structure that map files are decoded into, whose slices
+	// are reused between parses.
+	x xmlMap
+}
+
+// reset resets the intermediate structure of the parser for a new parse, while
+// keeping the memory of it's slices.
+func (p *Parser) reset() *xmlMap {
+	// The decoder does not clear the elements it appends within the capacity
+	// of a slice, so they are cleared here.
+	tilesets, layers := p.x.Tileset[:cap(p.x.Tileset)], p.x.Layers[:cap(p.x.Layers)]
+	for i := range tilesets {
+		tilesets[i] = xmlTileset{}
+	}
+	for i := range layers {
+		layers[i] = xmlMapLayer{}
+	}
+	p.x = xmlMap{
+		Tileset: tilesets[:0],
+		Layers:  layers[:0],
+	}
+	return &p.x
+}
+
+// Parse parses the TMX map file data, just like ParseWithConfig does.
+func (p *Parser) Parse(data []byte) (*Map, error) {
+	return parse(data, p.Config, p.reset(), nil)
+}
+
+// ParseInto is like Parse, except it decodes the map file data into the
+// existing map, m, replacing all of it's contents. The tile maps of the
+// existing layers are cleared and reused for the new layers (in order), such
+// that repeatedly parsing maps of similar size allocates very little.
+//
+// Nothing else may hold onto the existing map's layers or their tile maps, as
+// they are overwritten. If an error is returned the map is left unchanged
+// (except for possibly the tile maps of it's layers).
+func (p *Parser) ParseInto(data []byte, m *Map) error {
+	parsed, err := parse(data, p.Config, p.reset(), m)
+	if err != nil {
+		return err
+	}
+	*m = *parsed
+	return nil
+}
@@ -0,0 +1,143 @@
+// Copyright 2014 The Azul3D Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tmx
+
+import (
+	"image"
+
+	"azul3d.org/gfx.v2-unstable"
+)
+
+// CollisionShape is a single collision shape of a collision layer, see
+// Map.CollisionShapes.
+type CollisionShape struct {
+	// The name of the layer (or object group) that the shape came from.
+	Layer string
+
+	// The object that the shape came from, or nil for shapes of tile layers.
+	Object *Object
+
+	// The points of the shape, in pixels. Closed shapes are convex polygons
+	// which wind clockwise (as seen on screen, where +Y is down), open ones
+	// are chains of line segments (I.e. polylines).
+	Points []Pixel
+	Closed bool
+}
+
+// collisionLayer tells if a layer with the given properties is tagged as a
+// collision layer, using the conventional "collision-only" custom property.
+func collisionLayer(props map[string]string) bool {
+	return props["collision-only"] == "true"
+}
+
+// CollisionShapes returns the collision shapes of the tile layers and object
+// groups of the map which carry the conventional "collision-only" custom
+// property (which Load does not render), in draw order.
+//
+// All of the tiles of collision tile layers are solid, their cells are merged
+// into as few rectangles as possible greedily (first along rows, then rows of
+// the same extent). The visible objects of collision object groups are split
+// into convex parts (see ObjectGroup.Outline and ConvexParts), polylines are
+// open shapes.
+func (m *Map) CollisionShapes() []CollisionShape {
+	var shapes []CollisionShape
+	for _, it := range drawOrder(m) {
+		switch {
+		case it.layer != nil && collisionLayer(it.layer.Properties):
+			shapes = append(shapes, m.tileCollisionShapes(it.layer)...)
+
+		case it.group != nil && collisionLayer(it.group.Properties):
+			for _, obj := range it.group.Objects {
+				if !obj.Visible {
+					continue
+				}
+				points, closed := it.group.Outline(obj)
+				if !closed {
+					if len(points) >= 2 {
+						shapes = append(shapes, CollisionShape{it.group.Name, obj, points, false})
+					}
+					continue
+				}
+
+				// Make outlines clockwise (see ObjectGroup.Occluders), such
+				// that their parts are as well.
+				var area float64
+				for i, a := range points {
+					b := points[(i+1)%len(points)]
+					area += a.X*b.Y - b.X*a.Y
+				}
+				if area < 0 {
+					for i, j := 0, len(points)-1; i < j; i, j = i+1, j-1 {
+						points[i], points[j] = points[j], points[i]
+					}
+				}
+				for _, part := range ConvexParts(points) {
+					shapes = append(shapes, CollisionShape{it.group.Name, obj, part, true})
+				}
+			}
+		}
+	}
+	return shapes
+}
+
+// tileCollisionShapes returns the rectangles covering the cells of the given
+// collision tile layer, see CollisionShapes.
+func (m *Map) tileCollisionShapes(l *Layer) []CollisionShape {
+	bounds := l.Bounds(m)
+	isSolid := solidCells(l, bounds, func(gid uint32) bool { return true })
+
+	// Runs of solid cells of the previous rows, by their extent, which are
+	// grown downwards for as long as the next row has the same run.
+	type run struct{ min, max int }
+	var rects []image.Rectangle
+	open := make(map[run]int)
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		next := make(map[run]int)
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			if !isSolid(x, y) {
+				continue
+			}
+			start := x
+			for x < bounds.Max.X && isSolid(x, y) {
+				x++
+			}
+			r := run{start, x}
+			if i, ok := open[r]; ok {
+				rects[i].Max.Y++
+				next[r] = i
+				continue
+			}
+			next[r] = len(rects)
+			rects = append(rects, image.Rect(start, y, x, y+1))
+		}
+		open = next
+	}
+
+	tw, th := float64(m.TileWidth), float64(m.TileHeight)
+	shapes := make([]CollisionShape, 0, len(rects))
+	for _, r := range rects {
+		x0, y0 := float64(r.Min.X)*tw, float64(r.Min.Y)*th
+		x1, y1 := float64(r.Max.X)*tw, float64(r.Max.Y)*th
+		shapes = append(shapes, CollisionShape{
+			Layer:  l.Name,
+			Points: []Pixel{{x0, y0}, {x1, y0}, {x1, y1}, {x0, y1}},
+			Closed: true,
+		})
+	}
+	return shapes
+}
+
+// LoadWithPhysics loads the map file at the given path like LoadFile does, and
+// returns the collision shapes of it's collision layers (see
+// Map.CollisionShapes) as well, which wires together the common case of
+// rendering the visible layers of a map and colliding with the designated
+// collision layers.
+func LoadWithPhysics(path string, c *Config) (*Map, map[string]map[string]*gfx.Object, []CollisionShape, error) {
+	m, layers, err := LoadFile(path, c)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return m, layers, m.CollisionShapes(), nil
+}
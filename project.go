@@ -0,0 +1,172 @@
+// Copyright 2014 Lightpoke. All rights reserved.
+// This source code is subject to the terms and
+// conditions defined in the "License.txt" file.
+
+package tmx
+
+import "encoding/json"
+
+// PropertyMember represents a member of a custom class, see PropertyType.
+type PropertyMember struct {
+	// The name of the member.
+	Name string `json:"name"`
+
+	// The type of the member ("string", "int", "float", "bool", "color",
+	// "file", "object" or "class"), and the name of it's custom property type
+	// if any.
+	Type         string `json:"type"`
+	PropertyType string `json:"propertyType"`
+
+	// The default value of the member, formatted as it is in TMX map files
+	// (E.g. "true" for booleans). It is empty for members whose type is a
+	// custom class, as their values are not flattened.
+	Value string `json:"-"`
+}
+
+// UnmarshalJSON implements the json.Unmarshaler interface.
+func (m *PropertyMember) UnmarshalJSON(data []byte) error {
+	type member PropertyMember
+	var x struct {
+		member
+		Value json.RawMessage `json:"value"`
+	}
+	if err := json.Unmarshal(data, &x); err != nil {
+		return err
+	}
+	*m = PropertyMember(x.member)
+	m.Value = jsonPropertyValue(x.Value)
+	return nil
+}
+
+// PropertyType represents a custom property type of a Tiled project.
+type PropertyType struct {
+	// The unique ID and name of the property type.
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+
+	// The kind of property type, either "class" or "enum".
+	Type string `json:"type"`
+
+	// What a class may be used as (E.g. "object", "layer" or "map"), or nil if
+	// not restricted.
+	UseAs []string `json:"useAs"`
+
+	// The members of a class, with their default values.
+	Members []PropertyMember `json:"members"`
+
+	// How the values of an enum are stored in properties, either "string"
+	// (by their name) or "int" (by their index in Values).
+	StorageType string `json:"storageType"`
+
+	// The values of an enum.
+	Values []string `json:"values"`
+
+	// Whether or not a property of an enum may hold several of it's values as
+	// flags, see Project.DecodeEnum.
+	ValuesAsFlags bool `json:"valuesAsFlags"`
+}
+
+// Defaults returns the default property values of the members of a class, or
+// nil if the property type is not a class.
+func (t *PropertyType) Defaults() map[string]string {
+	if t.Type != "class" {
+		return nil
+	}
+	defaults := make(map[string]string, len(t.Members))
+	for _, m := range t.Members {
+		if m.Type != "class" {
+			defaults[m.Name] = m.Value
+		}
+	}
+	return defaults
+}
+
+// usableAs tells if the property type is a class usable as the given kind of
+// class.
+func (t *PropertyType) usableAs(kind string) bool {
+	if t.Type != "class" {
+		return false
+	}
+	if len(t.UseAs) == 0 {
+		return true
+	}
+	for _, u := range t.UseAs {
+		if u == kind {
+			return true
+		}
+	}
+	return false
+}
+
+// Project represents a Tiled project file (.tiled-project), of which only the
+// custom property types are parsed.
+type Project struct {
+	// The custom property types of the project.
+	PropertyTypes []*PropertyType `json:"propertyTypes"`
+}
+
+// ParseProject parses the given Tiled project file data.
+func ParseProject(data []byte) (*Project, error) {
+	p := new(Project)
+	if err := json.Unmarshal(data, p); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// jsonPropertyValue returns the given JSON value formatted like a property
+// value of a TMX map file. Objects (the values of nested classes) and null
+// values are formatted as an empty string.
+func jsonPropertyValue(raw json.RawMessage) string {
+	var v interface{}
+	if err := json.Unmarshal(raw, &v); err != nil {
+		return ""
+	}
+	switch v := v.(type) {
+	case string:
+		return v
+	case bool, float64:
+		// Numbers keep their original formatting (E.g. integers have no
+		// decimal point), as do booleans.
+		return string(raw)
+	}
+	return ""
+}
+
+// FindPropertyType returns the custom property type with the given name, or
+// nil if there is none.
+func (p *Project) FindPropertyType(name string) *PropertyType {
+	for _, t := range p.PropertyTypes {
+		if t.Name == name {
+			return t
+		}
+	}
+	return nil
+}
+
+// Properties returns the effective properties of something (E.g. an object)
+// of the given class with the given raw properties: the default values of the
+// members of the class, overridden by the raw properties. If the class is not
+// known the raw properties are returned as-is. The raw properties are never
+// modified.
+func (p *Project) Properties(class string, raw map[string]string) map[string]string {
+	t := p.FindPropertyType(class)
+	if t == nil || t.Type != "class" {
+		return raw
+	}
+	effective := t.Defaults()
+	for k, v := range raw {
+		effective[k] = v
+	}
+	return effective
+}
+
+// ObjectProperties returns the effective properties of the given object (see
+// Properties), using the defaults of it's class if that class may be used for
+// objects. Object.Properties holds the raw properties, as stored in the map.
+func (p *Project) ObjectProperties(o *Object) map[string]string {
+	if t := p.FindPropertyType(o.Class); t == nil || !t.usableAs("object") {
+		return o.Properties
+	}
+	return p.Properties(o.Class, o.Properties)
+}
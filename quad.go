@@ -0,0 +1,135 @@
+// Copyright 2014 The Azul3D Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tmx
+
+import (
+	"image"
+
+	"azul3d.org/gfx.v2-unstable"
+	"azul3d.org/lmath.v1"
+)
+
+// TilePlacement describes where AppendTileQuad places the card of a tile.
+//
+// World space is that of Load: one unit is one pixel of the map, +X points to
+// the right of the map and the Y axis is depth, where cards with a lower Y
+// are nearer to the camera (which looks down the +Y axis) and thus draw on top.
+// By default +Z points up the map and the bottom of the map is at Z=0, see
+// QuadOptions.TopLeftOrigin for Tiled's own pixel space.
+type TilePlacement struct {
+	// The center of the card, in world space.
+	Center lmath.Vec3
+
+	// The size of the card, in pixels.
+	Width, Height float64
+
+	// The flips of the tile, which are applied to the card about it's center
+	// (diagonal flips rotate it, like Load does).
+	Flips Flip
+}
+
+// QuadOptions are the options of AppendTileQuad.
+type QuadOptions struct {
+	// The bounds of the texture which the source rectangle lies within, from
+	// which texture coordinates are computed. If empty, the source rectangle
+	// is the entire texture.
+	Texture image.Rectangle
+
+	// If non-nil, this color is appended to the mesh's colors for each of the
+	// card's vertices, for shaders using vertex colors.
+	Color *gfx.Color
+
+	// If true, the center is in Tiled's own pixel space where +Z points down
+	// the map (see Config.TopLeftOrigin), and the card is mirrored along the
+	// Z axis accordingly.
+	TopLeftOrigin bool
+}
+
+// appendQuad appends a card of the given size (in pixels) for the rectangle r
+// of a texture with the given bounds to the mesh. The card is centered at the
+// origin with the given flips applied, and is then transformed by the given
+// matrix. It returns the index of the first vertex of the card.
+func appendQuad(mesh *gfx.Mesh, r, tex image.Rectangle, flips Flip, width, height float32, trans lmath.Mat4) int {
+	halfWidth := width / 2.0
+	halfHeight := height / 2.0
+	start := len(mesh.Vertices)
+	appendCard(mesh, -halfWidth, halfWidth, -halfHeight, halfHeight, 0, r, tex)
+
+	// Apply transformation.
+	trans = flipMatrix(flips).Mul(trans)
+	verts := mesh.Vertices
+	for i, v := range verts[start:] {
+		vt := v.Vec3().TransformMat4(trans)
+		verts[start+i] = gfx.Vec3{float32(vt.X), float32(vt.Y), float32(vt.Z)}
+	}
+	return start
+}
+
+// AppendTileQuad appends the two triangles (six vertices, and their texture
+// coordinates) of the card of a tile to the mesh, using the same geometry that
+// Load uses for tiles. The card shows the source rectangle (in pixels) of a
+// texture, and is placed according to p (see TileQuad).
+//
+// This allows custom renderers, for instance ones rendering a changing subset
+// of the tiles of a map, to build meshes which match those of Load exactly.
+// If opts is nil then the zero value options are used.
+func AppendTileQuad(mesh *gfx.Mesh, p TilePlacement, srcRect image.Rectangle, opts *QuadOptions) {
+	if opts == nil {
+		opts = new(QuadOptions)
+	}
+	tex := opts.Texture
+	if tex.Empty() {
+		tex = srcRect
+	}
+	start := appendQuad(mesh, srcRect, tex, p.Flips, float32(p.Width), float32(p.Height), lmath.Mat4FromTranslation(p.Center))
+	if opts.TopLeftOrigin {
+		z := float32(p.Center.Z)
+		for i := start; i < len(mesh.Vertices); i++ {
+			mesh.Vertices[i].Z = 2*z - mesh.Vertices[i].Z
+		}
+	}
+	if opts.Color != nil {
+		for i := start; i < len(mesh.Vertices); i++ {
+			mesh.Colors = append(mesh.Colors, *opts.Color)
+		}
+	}
+}
+
+// TileQuad returns the placement, and the rectangle of it's tileset's image,
+// of the card that Load creates for the tile at the given coordinates of the
+// tile layer, for use with AppendTileQuad. The layer's offsets, tileset render
+// sizes and fill modes, and the Depth and TopLeftOrigin options of the
+// configuration are honored. Per-tile depth offsets (see Config.TileOffset)
+// depend on the order in which tiles are appended, so they are not applied.
+//
+// The size of the tileset's image must be known (see Tileset.Image). If there
+// is no tile at the coordinates, or it's tileset is unknown, ok is false. If c
+// is nil then the default configuration is used.
+func TileQuad(m *Map, c *Config, l *Layer, tile Coord) (p TilePlacement, srcRect image.Rectangle, ok bool) {
+	if c == nil {
+		c = DefaultConfig()
+	}
+	gid := l.Tile(tile)
+	ts, _, flips := m.DecomposeGID(gid)
+	if gid == 0 || ts == nil || ts.Image == nil {
+		return p, srcRect, false
+	}
+	ld := newLoader(m, c, nil)
+	center, width, height := ld.tileCenter(l, ts, tile.X, tile.Y)
+	center.Y = ld.layerDepth(l.Index, l.Properties)
+	if c.Depth != nil {
+		center.Y = c.Depth(l.Index, tile.X, tile.Y, gid&^flipFlags)
+	}
+	if c.TopLeftOrigin {
+		center.Z = float64(m.Height*m.TileHeight) - center.Z
+	}
+	p = TilePlacement{
+		Center: center,
+		Width:  width,
+		Height: height,
+		Flips:  flips,
+	}
+	return p, ts.RectForGID(gid), true
+}
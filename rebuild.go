@@ -0,0 +1,103 @@
+// Copyright 2014 The Azul3D Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tmx
+
+import (
+	"image"
+	"strings"
+
+	"azul3d.org/gfx.v2-unstable"
+)
+
+// RebuildDirty rebuilds the objects of the tile layers of the map m whose
+// cells changed since they were loaded or last rebuilt (see Layer.Dirty), and
+// marks them as clean. This pairs editing layers (e.g. with SetTile, or a
+// Journal) with rendering them, without loading the entire map again.
+//
+// The layers must be those returned by Load (or LoadFile) for the map, with
+// the same configuration c (or nil for the default one) and images. The
+// meshes of the existing objects of a dirty layer are rebuilt in place (and
+// marked as changed), objects for tileset images that the layer did not use
+// before are added to the layers. With Config.TileObjects, the tile objects of
+// dirty layers are replaced instead, and the old ones are released (see
+// ReleaseTileObjects).
+//
+// With Config.ChunkSize only the chunks holding changed cells are rebuilt,
+// otherwise all of the objects of a dirty layer are.
+//
+// The objects must not be drawn while they are being rebuilt.
+func RebuildDirty(layers map[string]map[string]*gfx.Object, m *Map, c *Config, tsImages map[string]*image.RGBA) {
+	if c == nil {
+		c = DefaultConfig()
+	}
+	ld := newLoader(m, c, tsImages)
+	for _, l := range m.Layers {
+		if l.dirty.Empty() || !ld.rendered(l.Name, l.Parent, l.Properties) || ld.baked(l.Name) {
+			continue
+		}
+		chunks := ld.layerChunks(l, l.dirty)
+		l.dirty = image.Rectangle{}
+		dirty := make(map[Coord]bool, len(chunks))
+		for _, r := range chunks {
+			dirty[ld.cellChunk(Coord{r.Min.X, r.Min.Y})] = true
+		}
+
+		// Find the existing objects of the layer, which are named differently
+		// when it is grouped (see Config.GroupProperty).
+		key, prefix := l.Name, ""
+		if g := l.Properties[c.GroupProperty]; len(c.GroupProperty) > 0 && len(g) > 0 {
+			key, prefix = g, l.Name+"/"
+		}
+		// The layer objects are dropped when there are tile objects, so there
+		// are none to reuse then.
+		objects := make(map[string]*gfx.Object)
+		for name, obj := range layers[key] {
+			if strings.HasPrefix(name, prefix) && c.TileObjects == nil {
+				objects[name[len(prefix):]] = obj
+			}
+		}
+		if c.TileObjects != nil {
+			old := make(map[TileKey]*gfx.Object)
+			for k, obj := range c.TileObjects {
+				if k.Layer == l.Name && dirty[ld.cellChunk(k.Coord)] {
+					old[k] = obj
+					delete(c.TileObjects, k)
+				}
+			}
+			ReleaseTileObjects(c, old)
+		}
+
+		ld.layers[l.Name] = objects
+		for _, job := range ld.tileLayer(l, chunks) {
+			job()
+		}
+		if c.TileObjects != nil {
+			continue
+		}
+		if layers[key] == nil {
+			layers[key] = make(map[string]*gfx.Object)
+		}
+		var rebuilt []*gfx.Object
+		for name, obj := range objects {
+			if _, chunk := ld.objectChunk(name); !dirty[chunk] {
+				continue
+			}
+			mesh := obj.Meshes[0]
+			mesh.VerticesChanged = true
+			mesh.ColorsChanged = true
+			for i := range mesh.TexCoords {
+				mesh.TexCoords[i].Changed = true
+			}
+			layers[key][prefix+name] = obj
+			if c.Scrollers != nil {
+				c.Scrollers.rebuilt(obj)
+			}
+			rebuilt = append(rebuilt, obj)
+		}
+		if c.Faders != nil {
+			c.Faders.rebuilt(l.Name, rebuilt)
+		}
+	}
+}
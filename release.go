@@ -0,0 +1,87 @@
+// Copyright 2014 The Azul3D Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tmx
+
+import (
+	"azul3d.org/gfx.v2-unstable"
+)
+
+// ReleaseLayers releases the objects of the given layers, as returned by Load
+// with the configuration c (or nil for the default configuration), such that
+// long-running programs can unload maps without leaking their large vertex
+// slices and GPU resources.
+//
+// The meshes of each object are destroyed and it's textures are detached.
+// Textures shared through the configuration's texture cache are released,
+// and only destroyed once no other objects use them. Textures given by the
+// configuration's materials, and the shared lightmap and palette textures, are
+// left alone.
+// Other textures are destroyed.
+//
+// The objects must not be used afterwards, including by Scrollers and
+// Flipbooks. Tile objects (see Config.TileObjects) are released with
+// ReleaseTileObjects instead.
+func ReleaseLayers(c *Config, layers ...map[string]map[string]*gfx.Object) {
+	var objects []*gfx.Object
+	for _, l := range layers {
+		for _, objs := range l {
+			for _, obj := range objs {
+				objects = append(objects, obj)
+			}
+		}
+	}
+	releaseObjects(c, objects)
+}
+
+// ReleaseTileObjects is like ReleaseLayers, except it releases the given tile
+// objects, as stored in Config.TileObjects by Load with the configuration c
+// (or nil for the default configuration).
+//
+// Each tile object holds a reference to it's texture in the configuration's
+// texture cache, and textures which are not cached are shared by the tile
+// objects of a layer and destroyed once.
+func ReleaseTileObjects(c *Config, objects map[TileKey]*gfx.Object) {
+	objs := make([]*gfx.Object, 0, len(objects))
+	for _, obj := range objects {
+		objs = append(objs, obj)
+	}
+	releaseObjects(c, objs)
+}
+
+// releaseObjects releases the given objects, see ReleaseLayers.
+func releaseObjects(c *Config, objects []*gfx.Object) {
+	if c == nil {
+		c = DefaultConfig()
+	}
+	userTextures := make(map[*gfx.Texture]bool, len(c.Materials))
+	for _, mat := range c.Materials {
+		if mat != nil && mat.Texture != nil {
+			userTextures[mat.Texture] = true
+		}
+	}
+	destroy := make(map[*gfx.Texture]bool)
+	for _, obj := range objects {
+		obj.Lock()
+		for _, mesh := range obj.Meshes {
+			mesh.Destroy()
+		}
+
+		// The first texture is the object's own, any others (I.e. the
+		// lightmap and palette) are shared by all of the objects of a map.
+		if len(obj.Textures) > 0 {
+			t := obj.Textures[0]
+			cached := c.Textures != nil && c.Textures.release(t)
+			if !cached && !userTextures[t] {
+				destroy[t] = true
+			}
+		}
+		obj.Meshes = nil
+		obj.Textures = nil
+		obj.Unlock()
+	}
+	for t := range destroy {
+		t.Destroy()
+	}
+}
@@ -0,0 +1,129 @@
+// Copyright 2014 Lightpoke. All rights reserved.
+// This source code is subject to the terms and
+// conditions defined in the "License.txt" file.
+
+package tmx
+
+import "image"
+
+// Anchor describes which part of a map stays in place when it is resized.
+type Anchor int
+
+// The anchors for each edge and corner of a map, as well as it's center.
+const (
+	TopLeft Anchor = iota
+	Top
+	TopRight
+	Left
+	Center
+	Right
+	BottomLeft
+	Bottom
+	BottomRight
+)
+
+// offset returns the offset, in tiles, at which the old content of a map
+// with the given size is placed when it is resized to the new size.
+func (a Anchor) offset(oldWidth, oldHeight, width, height int) (x, y int) {
+	switch a % 3 {
+	case 1:
+		x = (width - oldWidth) / 2
+	case 2:
+		x = width - oldWidth
+	}
+	switch a / 3 {
+	case 1:
+		y = (height - oldHeight) / 2
+	case 2:
+		y = height - oldHeight
+	}
+	return
+}
+
+// Resize resizes the map to the given width and height in tiles, the
+// existing contents of the map are placed according to the anchor.
+//
+// Tiles which fall outside of the new map size are removed, except on infinite
+// maps whose tiles are only moved. Objects and image layers are moved along
+// with the tiles, but are never removed.
+//
+// If the width or height is negative, a panic will occur.
+func (m *Map) Resize(width, height int, anchor Anchor) {
+	if width < 0 || height < 0 {
+		panic("Resize(): negative map size")
+	}
+	x, y := anchor.offset(m.Width, m.Height, width, height)
+	m.reframe(x, y, width, height)
+}
+
+// Crop crops the map to the given rectangle, in tiles, such that the tile at
+// r.Min becomes the top-left tile of the map.
+//
+// The rectangle is clipped to the bounds of the map first, except on infinite
+// maps whose tiles (like those of Resize) are only moved. Like Resize,
+// objects and image layers are moved but never removed.
+func (m *Map) Crop(r image.Rectangle) {
+	if !m.Infinite {
+		r = r.Intersect(image.Rect(0, 0, m.Width, m.Height))
+	}
+	m.reframe(-r.Min.X, -r.Min.Y, r.Dx(), r.Dy())
+}
+
+// reframe moves all of the contents of the map by the given number of tiles
+// and changes the size of the map, removing any tiles outside of it unless
+// the map is infinite. Layers stored run-length encoded stay that way.
+func (m *Map) reframe(dx, dy, width, height int) {
+	for _, l := range m.Layers {
+		tiles := make(map[Coord]uint32, len(l.Tiles))
+		l.EachTile(func(c Coord, gid uint32) {
+			c = Coord{c.X + dx, c.Y + dy}
+			if !m.Infinite && (c.X < 0 || c.Y < 0 || c.X >= width || c.Y >= height) {
+				return
+			}
+			tiles[c] = gid
+		})
+		l.shared = false
+		l.boundsKnown = false
+		if l.RLE != nil {
+			l.RLE = NewRLETiles(width, height, tiles)
+			continue
+		}
+		l.Tiles = tiles
+	}
+
+	// Object positions on isometric maps are measured in units of the tile
+	// height along both axes.
+	px, py := dx*m.TileWidth, dy*m.TileHeight
+	if m.Orientation == Isometric {
+		px = dx * m.TileHeight
+	}
+	for _, g := range m.ObjectGroups {
+		for _, o := range g.Objects {
+			o.X += px
+			o.Y += py
+		}
+	}
+
+	// Image layers are positioned in pixels regardless of orientation.
+	for _, l := range m.ImageLayers {
+		l.OffsetX += float64(dx * m.TileWidth)
+		l.OffsetY += float64(dy * m.TileHeight)
+	}
+
+	// Moving a staggered map by an odd number of rows (or columns) along it's
+	// stagger axis swaps which of them are shifted.
+	if m.Orientation == Staggered || m.Orientation == Hexagonal {
+		shift := dy
+		if m.StaggerAxis == StaggerAxisX {
+			shift = dx
+		}
+		if shift%2 != 0 {
+			if m.StaggerIndex == StaggerIndexOdd {
+				m.StaggerIndex = StaggerIndexEven
+			} else {
+				m.StaggerIndex = StaggerIndexOdd
+			}
+		}
+	}
+	m.Width, m.Height = width, height
+}
@@ -0,0 +1,90 @@
+// Copyright 2014 The Azul3D Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tmx
+
+import (
+	"sort"
+	"time"
+)
+
+// Reveals hides layers (typically the roofs of buildings, or other overlays)
+// while a point (typically the player's position) is within the regions that
+// reveal what is beneath them.
+//
+// Regions are the visible objects whose class is "reveal", with a "layer"
+// custom property naming the layer that they hide. Several regions may hide
+// the same layer.
+//
+// A Reveals is not safe for use by multiple goroutines at once.
+type Reveals struct {
+	// If non-nil, hidden layers are faded out (and shown layers faded back
+	// in) using these faders, over the given duration.
+	Faders   *Faders
+	Duration time.Duration
+
+	regions []revealRegion
+	hidden  map[string]bool
+}
+
+// revealRegion is a single region of a Reveals.
+type revealRegion struct {
+	group *ObjectGroup
+	obj   *Object
+	layer string
+}
+
+// NewReveals returns a new set of reveals for the regions found in the object
+// groups of the given map. No layers are hidden until Update is called.
+func NewReveals(m *Map) *Reveals {
+	r := &Reveals{
+		hidden: make(map[string]bool),
+	}
+	for _, g := range m.ObjectGroups {
+		for _, o := range g.Objects {
+			layer := o.Properties["layer"]
+			if o.Class != "reveal" || len(layer) == 0 || !o.Visible {
+				continue
+			}
+			r.regions = append(r.regions, revealRegion{g, o, layer})
+		}
+	}
+	return r
+}
+
+// Update hides the layers whose regions contain the given point (in pixels,
+// where +Y is down) and shows all others. The names of the layers that were
+// hidden or shown by this call are returned in sorted order.
+func (r *Reveals) Update(p Pixel) (changed []string) {
+	hide := make(map[string]bool)
+	for _, rg := range r.regions {
+		if _, ok := hide[rg.layer]; !ok {
+			hide[rg.layer] = false
+		}
+		if rg.group.Contains(rg.obj, p) {
+			hide[rg.layer] = true
+		}
+	}
+	for layer, hidden := range hide {
+		if r.hidden[layer] == hidden {
+			continue
+		}
+		r.hidden[layer] = hidden
+		changed = append(changed, layer)
+		if r.Faders != nil {
+			opacity := 1.0
+			if hidden {
+				opacity = 0
+			}
+			r.Faders.Fade(layer, opacity, r.Duration)
+		}
+	}
+	sort.Strings(changed)
+	return changed
+}
+
+// Hidden tells if the named layer is currently hidden.
+func (r *Reveals) Hidden(layer string) bool {
+	return r.hidden[layer]
+}
@@ -0,0 +1,162 @@
+// Copyright 2014 Lightpoke. All rights reserved.
+// This source code is subject to the terms and
+// conditions defined in the "License.txt" file.
+
+package tmx
+
+import "sort"
+
+// tileRun is a run of consecutive tiles (in row-major order) with the same
+// gid, ending just before the tile with the given index.
+type tileRun struct {
+	end int
+	gid uint32
+}
+
+// RLETiles stores the tiles of a layer run-length encoded in row-major order,
+// which uses far less memory than a map for large and mostly uniform layers,
+// at the cost of logarithmic time lookups. See ParseConfig.RLETiles.
+//
+// Coordinates outside of the layer always have a gid of zero.
+type RLETiles struct {
+	width, height int
+	runs          []tileRun
+}
+
+// NewRLETiles returns run-length encoded storage for a layer of the given size
+// holding the given tiles (which may be nil).
+func NewRLETiles(width, height int, tiles map[Coord]uint32) *RLETiles {
+	r := &RLETiles{width: width, height: height}
+	for i := 0; i < width*height; i++ {
+		r.append(tiles[Coord{i % width, i / width}])
+	}
+	return r
+}
+
+// append appends a single tile to the end of the runs.
+func (r *RLETiles) append(gid uint32) {
+	if n := len(r.runs); n > 0 && r.runs[n-1].gid == gid {
+		r.runs[n-1].end++
+		return
+	}
+	end := 1
+	if n := len(r.runs); n > 0 {
+		end = r.runs[n-1].end + 1
+	}
+	r.runs = append(r.runs, tileRun{end, gid})
+}
+
+// index returns the row-major index of the given coordinates, or -1 if they
+// are outside of the layer.
+func (r *RLETiles) index(c Coord) int {
+	if c.X < 0 || c.Y < 0 || c.X >= r.width || c.Y >= r.height {
+		return -1
+	}
+	return c.Y*r.width + c.X
+}
+
+// run returns the index of the run containing the tile with the given index.
+func (r *RLETiles) run(i int) int {
+	return sort.Search(len(r.runs), func(n int) bool {
+		return r.runs[n].end > i
+	})
+}
+
+// Get returns the gid at the given coordinates, or zero if there is no tile.
+func (r *RLETiles) Get(c Coord) uint32 {
+	i := r.index(c)
+	if i < 0 {
+		return 0
+	}
+	return r.runs[r.run(i)].gid
+}
+
+// Set sets the gid at the given coordinates, zero removes the tile. Setting
+// coordinates outside of the layer has no effect.
+func (r *RLETiles) Set(c Coord, gid uint32) {
+	i := r.index(c)
+	if i < 0 {
+		return
+	}
+	n := r.run(i)
+	old := r.runs[n]
+	if old.gid == gid {
+		return
+	}
+	start := 0
+	if n > 0 {
+		start = r.runs[n-1].end
+	}
+
+	// The runs are edited in place, and only merged with their direct
+	// neighbours, such that bulk edits (see Layer.SetTile) do not copy all of
+	// the runs for each tile.
+	switch {
+	case start == i && old.end == i+1:
+		// The tile is a run of it's own.
+		r.runs[n].gid = gid
+		if n+1 < len(r.runs) && r.runs[n+1].gid == gid {
+			r.runs[n].end = r.runs[n+1].end
+			r.remove(n + 1)
+		}
+		if n > 0 && r.runs[n-1].gid == gid {
+			r.runs[n-1].end = r.runs[n].end
+			r.remove(n)
+		}
+
+	case start == i:
+		// The tile starts a longer run.
+		if n > 0 && r.runs[n-1].gid == gid {
+			r.runs[n-1].end++
+			return
+		}
+		r.insert(n, tileRun{i + 1, gid})
+
+	case old.end == i+1:
+		// The tile ends a longer run.
+		r.runs[n].end = i
+		if n+1 < len(r.runs) && r.runs[n+1].gid == gid {
+			return
+		}
+		r.insert(n+1, tileRun{i + 1, gid})
+
+	default:
+		// The tile splits a run in two.
+		r.runs[n].end = i
+		r.insert(n+1, tileRun{i + 1, gid}, old)
+	}
+}
+
+// insert inserts the given runs before the run at index n.
+func (r *RLETiles) insert(n int, runs ...tileRun) {
+	r.runs = append(r.runs, runs...)
+	copy(r.runs[n+len(runs):], r.runs[n:])
+	copy(r.runs[n:], runs)
+}
+
+// remove removes the run at index n.
+func (r *RLETiles) remove(n int) {
+	r.runs = append(r.runs[:n], r.runs[n+1:]...)
+}
+
+// Range calls f for each tile (I.e. with a non-zero gid), in row-major order.
+func (r *RLETiles) Range(f func(c Coord, gid uint32)) {
+	start := 0
+	for _, run := range r.runs {
+		if run.gid != 0 {
+			for i := start; i < run.end; i++ {
+				f(Coord{i % r.width, i / r.width}, run.gid)
+			}
+		}
+		start = run.end
+	}
+}
+
+// Map returns the tiles as a map, like Layer.Tiles.
+func (r *RLETiles) Map() map[Coord]uint32 {
+	tiles := make(map[Coord]uint32)
+	r.Range(func(c Coord, gid uint32) {
+		tiles[c] = gid
+	})
+	return tiles
+}
@@ -0,0 +1,227 @@
+// Copyright 2014 Lightpoke. All rights reserved.
+// This source code is subject to the terms and
+// conditions defined in the "License.txt" file.
+
+package tmx
+
+import (
+	"image"
+	"sort"
+)
+
+// Room is a single enclosed region of a map, see Map.Rooms.
+type Room struct {
+	// The index of the room in RoomGraph.Rooms.
+	ID int
+
+	// The region object (and it's object group) that the room came from, and
+	// it's name, or nil for rooms found from the wall tiles.
+	Group  *ObjectGroup
+	Object *Object
+	Name   string
+
+	// The cells of the room in row-major order, and their bounding rectangle
+	// in tile coordinates.
+	Cells  []Coord
+	Bounds image.Rectangle
+}
+
+// RoomDoor is a door object connecting rooms, see Map.Rooms.
+type RoomDoor struct {
+	Group  *ObjectGroup
+	Object *Object
+
+	// The IDs of the rooms next to the door, sorted. Usually there are two of
+	// them, a door leading into a wall connects fewer.
+	Rooms []int
+}
+
+// RoomGraph is the graph of the rooms of a map, connected by their doors, see
+// Map.Rooms.
+type RoomGraph struct {
+	Rooms []*Room
+	Doors []*RoomDoor
+
+	// The room (or -1) of each cell within the bounds of the layer.
+	bounds image.Rectangle
+	cells  []int
+}
+
+// Rooms finds the rooms of the named tile layer and builds the graph of their
+// adjacency through doors, for instance for revealing rooms on minimaps or AI
+// reasoning about regions.
+//
+// Walls are the cells of the layer that are solid, as decided by the given
+// function (if nil, all of the layer's tiles are walls). Door objects (visible
+// objects whose class is "door") separate rooms as well, and connect the rooms
+// next to the cells they overlap. Cells whose center lies within a region
+// object (a visible object whose class is "region") form a room of that
+// object, regardless of walls, while the remaining cells form a room for each
+// connected area (where cells are connected to their four direct neighbours).
+//
+// Cells are laid out orthogonally. Layers of infinite maps are considered
+// within their bounds (see Layer.Bounds). If there is no layer with the given
+// name, nil is returned.
+func (m *Map) Rooms(layer string, solid func(gid uint32) bool) *RoomGraph {
+	l := m.FindLayer(layer)
+	if l == nil {
+		return nil
+	}
+	if solid == nil {
+		solid = func(gid uint32) bool { return true }
+	}
+	bounds := l.Bounds(m)
+	isSolid := solidCells(l, bounds, solid)
+	g := &RoomGraph{
+		bounds: bounds,
+		cells:  make([]int, bounds.Dx()*bounds.Dy()),
+	}
+	for i := range g.cells {
+		g.cells[i] = -1
+	}
+	index := func(x, y int) int {
+		return (y-bounds.Min.Y)*bounds.Dx() + (x - bounds.Min.X)
+	}
+	tw, th := m.TileWidth, m.TileHeight
+
+	// Door cells are in no room.
+	const doorCell = -2
+	var doorCells [][]Coord
+	for _, og := range m.ObjectGroups {
+		for _, o := range og.Objects {
+			if !o.Visible || o.Class != "door" {
+				continue
+			}
+			// Point objects are within a single cell.
+			px := og.Bounds(o)
+			var r image.Rectangle
+			r.Min = image.Pt(floorDiv(px.Min.X, tw), floorDiv(px.Min.Y, th))
+			r.Max = r.Min.Add(image.Pt(1, 1))
+			if !px.Empty() {
+				r.Max = image.Pt(floorDiv(px.Max.X-1, tw)+1, floorDiv(px.Max.Y-1, th)+1)
+			}
+			var cells []Coord
+			r = r.Intersect(bounds)
+			for y := r.Min.Y; y < r.Max.Y; y++ {
+				for x := r.Min.X; x < r.Max.X; x++ {
+					g.cells[index(x, y)] = doorCell
+					cells = append(cells, Coord{x, y})
+				}
+			}
+			g.Doors = append(g.Doors, &RoomDoor{Group: og, Object: o})
+			doorCells = append(doorCells, cells)
+		}
+	}
+
+	add := func(room *Room, x, y int) {
+		g.cells[index(x, y)] = room.ID
+		room.Cells = append(room.Cells, Coord{x, y})
+		cell := image.Rect(x, y, x+1, y+1)
+		if room.Bounds.Empty() {
+			room.Bounds = cell
+		} else {
+			room.Bounds = room.Bounds.Union(cell)
+		}
+	}
+
+	// Rooms of region objects.
+	for _, og := range m.ObjectGroups {
+		for _, o := range og.Objects {
+			if !o.Visible || o.Class != "region" {
+				continue
+			}
+			room := &Room{ID: len(g.Rooms), Group: og, Object: o, Name: o.Name}
+			for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+				for x := bounds.Min.X; x < bounds.Max.X; x++ {
+					center := Pixel{(float64(x) + 0.5) * float64(tw), (float64(y) + 0.5) * float64(th)}
+					if g.cells[index(x, y)] == -1 && og.Contains(o, center) {
+						add(room, x, y)
+					}
+				}
+			}
+			g.Rooms = append(g.Rooms, room)
+		}
+	}
+
+	// Rooms enclosed by walls.
+	neighbors := [4]image.Point{{1, 0}, {-1, 0}, {0, 1}, {0, -1}}
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			if g.cells[index(x, y)] != -1 || isSolid(x, y) {
+				continue
+			}
+			room := &Room{ID: len(g.Rooms)}
+			add(room, x, y)
+			for i := 0; i < len(room.Cells); i++ {
+				c := room.Cells[i]
+				for _, d := range neighbors {
+					n := image.Pt(c.X+d.X, c.Y+d.Y)
+					if n.In(bounds) && g.cells[index(n.X, n.Y)] == -1 && !isSolid(n.X, n.Y) {
+						add(room, n.X, n.Y)
+					}
+				}
+			}
+			sort.Slice(room.Cells, func(i, j int) bool {
+				a, b := room.Cells[i], room.Cells[j]
+				return a.Y < b.Y || (a.Y == b.Y && a.X < b.X)
+			})
+			g.Rooms = append(g.Rooms, room)
+		}
+	}
+
+	// Connect the rooms next to each door.
+	for i, door := range g.Doors {
+		seen := make(map[int]bool)
+		for _, c := range doorCells[i] {
+			for _, d := range neighbors {
+				n := image.Pt(c.X+d.X, c.Y+d.Y)
+				if !n.In(bounds) {
+					continue
+				}
+				if id := g.cells[index(n.X, n.Y)]; id >= 0 && !seen[id] {
+					seen[id] = true
+					door.Rooms = append(door.Rooms, id)
+				}
+			}
+		}
+		sort.Ints(door.Rooms)
+	}
+	return g
+}
+
+// RoomAt returns the room of the given cell, or nil if the cell is a wall, a
+// door or outside of the layer.
+func (g *RoomGraph) RoomAt(c Coord) *Room {
+	if !image.Pt(c.X, c.Y).In(g.bounds) {
+		return nil
+	}
+	id := g.cells[(c.Y-g.bounds.Min.Y)*g.bounds.Dx()+(c.X-g.bounds.Min.X)]
+	if id < 0 {
+		return nil
+	}
+	return g.Rooms[id]
+}
+
+// Neighbors returns the IDs of the rooms connected to the given room by a
+// door, sorted.
+func (g *RoomGraph) Neighbors(id int) []int {
+	seen := make(map[int]bool)
+	var ids []int
+	for _, door := range g.Doors {
+		connected := false
+		for _, r := range door.Rooms {
+			connected = connected || r == id
+		}
+		if !connected {
+			continue
+		}
+		for _, r := range door.Rooms {
+			if r != id && !seen[r] {
+				seen[r] = true
+				ids = append(ids, r)
+			}
+		}
+	}
+	sort.Ints(ids)
+	return ids
+}
@@ -0,0 +1,206 @@
+// Copyright 2014 Lightpoke. All rights reserved.
+// This source code is subject to the terms and
+// conditions defined in the "License.txt" file.
+
+package tmx
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+)
+
+// ObjectRef identifies an object of an authored map, by the name of it's
+// object group and it's index within that group as authored.
+type ObjectRef struct {
+	Group string
+	Index int
+}
+
+// SavedState holds the runtime changes made to a map (as opposed to the map
+// itself), for instance for storing them in save games, see RuntimeState.
+type SavedState struct {
+	// The changed tiles, whose old gids are those of the authored map.
+	Tiles []TileChange `json:",omitempty"`
+
+	// The visibility of the layers, object groups, image layers and group
+	// layers whose visibility was toggled, by name.
+	Visibility map[string]bool `json:",omitempty"`
+
+	// The authored objects which were removed, sorted.
+	Removed []ObjectRef `json:",omitempty"`
+}
+
+// Encode encodes the state as JSON.
+func (s *SavedState) Encode() ([]byte, error) {
+	return json.Marshal(s)
+}
+
+// DecodeSavedState decodes a state encoded by SavedState.Encode.
+func DecodeSavedState(data []byte) (*SavedState, error) {
+	s := new(SavedState)
+	if err := json.Unmarshal(data, s); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// RuntimeState records the runtime changes made to a map, such as changed
+// tiles, toggled layers and removed objects, separately from the authored map
+// such that save games can persist changes to the world (see Save) without
+// storing entire maps. Changes are made through the state's methods, which
+// apply them immediately.
+//
+// Objects added at runtime are not recorded, as they are typically spawned by
+// games themselves.
+//
+// A RuntimeState is not safe for use by multiple goroutines at once.
+type RuntimeState struct {
+	m          *Map
+	tiles      TileChanges
+	visibility map[string]bool
+	removed    map[ObjectRef]bool
+	refs       map[*Object]ObjectRef
+}
+
+// NewRuntimeState returns a new state recording the changes made to the given
+// map, which must not have been changed since it was loaded: the order of it's
+// objects identifies them (see ObjectRef).
+func NewRuntimeState(m *Map) *RuntimeState {
+	s := &RuntimeState{
+		m:          m,
+		visibility: make(map[string]bool),
+		removed:    make(map[ObjectRef]bool),
+		refs:       make(map[*Object]ObjectRef),
+	}
+	seen := make(map[string]bool)
+	for _, g := range m.ObjectGroups {
+		// Only the first group of each name can be referred to.
+		if seen[g.Name] {
+			continue
+		}
+		seen[g.Name] = true
+		for i, o := range g.Objects {
+			s.refs[o] = ObjectRef{g.Name, i}
+		}
+	}
+	return s
+}
+
+// RestoreRuntimeState applies the given saved state to the map, which must not
+// have been changed since it was loaded (see NewRuntimeState), and returns the
+// state recording further changes of it (which include the restored ones).
+//
+// An error is returned, and the map is not changed, if the saved state does
+// not match the map (for instance because a layer does not exist, or because
+// the authored map was changed since the state was saved).
+func RestoreRuntimeState(m *Map, saved *SavedState) (*RuntimeState, error) {
+	s := NewRuntimeState(m)
+	var objects []*Object
+	for _, ref := range saved.Removed {
+		var obj *Object
+		for o, r := range s.refs {
+			if r == ref {
+				obj = o
+				break
+			}
+		}
+		if obj == nil {
+			return nil, fmt.Errorf("removed object %d of unknown object group %q", ref.Index, ref.Group)
+		}
+		objects = append(objects, obj)
+	}
+	for name := range saved.Visibility {
+		if !s.setVisible(name, true, false) {
+			return nil, fmt.Errorf("visibility of unknown layer %q", name)
+		}
+	}
+	if err := ApplyTileChanges(m, saved.Tiles); err != nil {
+		return nil, err
+	}
+
+	for _, c := range saved.Tiles {
+		s.tiles.Record(c.Layer, c.Coord, c.Old, c.New)
+	}
+	for name, visible := range saved.Visibility {
+		s.SetVisible(name, visible)
+	}
+	for _, o := range objects {
+		s.RemoveObject(o)
+	}
+	return s, nil
+}
+
+// SetTile sets the gid at the given coordinates of the layer (see
+// Layer.SetTile) and records the change.
+func (s *RuntimeState) SetTile(l *Layer, c Coord, gid uint32) {
+	s.tiles.SetTile(l, c, gid)
+}
+
+// SetVisible sets the visibility of the map's layers, object groups, image
+// layers and group layers with the given name, and records the change. It
+// returns false if there are none.
+func (s *RuntimeState) SetVisible(name string, visible bool) bool {
+	return s.setVisible(name, visible, true)
+}
+
+// setVisible implements SetVisible, only finding the layers with the given
+// name unless apply is true.
+func (s *RuntimeState) setVisible(name string, visible, apply bool) bool {
+	found := false
+	for _, it := range drawOrder(s.m) {
+		var v *bool
+		switch {
+		case it.layer != nil && it.layer.Name == name:
+			v = &it.layer.Visible
+		case it.group != nil && it.group.Name == name:
+			v = &it.group.Visible
+		case it.image != nil && it.image.Name == name:
+			v = &it.image.Visible
+		case it.groupLayer != nil && it.groupLayer.Name == name:
+			v = &it.groupLayer.Visible
+		default:
+			continue
+		}
+		found = true
+		if apply {
+			*v = visible
+		}
+	}
+	if found && apply {
+		s.visibility[name] = visible
+	}
+	return found
+}
+
+// RemoveObject removes the given object from it's object group, and records
+// the removal if it is an authored object.
+func (s *RuntimeState) RemoveObject(o *Object) {
+	for _, g := range s.m.ObjectGroups {
+		g.Objects = removeObject(g.Objects, o)
+	}
+	if ref, ok := s.refs[o]; ok {
+		s.removed[ref] = true
+	}
+}
+
+// Save returns the recorded changes.
+func (s *RuntimeState) Save() *SavedState {
+	saved := &SavedState{
+		Tiles: s.tiles.Changes(),
+	}
+	if len(s.visibility) > 0 {
+		saved.Visibility = make(map[string]bool, len(s.visibility))
+		for name, visible := range s.visibility {
+			saved.Visibility[name] = visible
+		}
+	}
+	for ref := range s.removed {
+		saved.Removed = append(saved.Removed, ref)
+	}
+	sort.Slice(saved.Removed, func(i, j int) bool {
+		a, b := saved.Removed[i], saved.Removed[j]
+		return a.Group < b.Group || (a.Group == b.Group && a.Index < b.Index)
+	})
+	return saved
+}
@@ -0,0 +1,106 @@
+// Copyright 2014 Lightpoke. All rights reserved.
+// This source code is subject to the terms and
+// conditions defined in the "License.txt" file.
+
+package tmx
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// PropertySchema describes a single custom property, see Schema.
+type PropertySchema struct {
+	// The name of the property.
+	Name string
+
+	// The type of the property's value: "string", "int", "float", "bool",
+	// "color" or "file". The "string" and "file" types, and an empty type,
+	// allow any value.
+	Type string
+
+	// Whether or not the property must be present.
+	Required bool
+}
+
+// check returns a description of the problem with the given properties, or
+// an empty string if they satisfy the schema.
+func (p PropertySchema) check(props map[string]string) string {
+	v, ok := props[p.Name]
+	if !ok {
+		if p.Required {
+			return fmt.Sprintf("missing property %q", p.Name)
+		}
+		return ""
+	}
+	var err error
+	switch p.Type {
+	case "int":
+		_, err = strconv.ParseInt(v, 10, 64)
+	case "float":
+		_, err = strconv.ParseFloat(v, 64)
+	case "bool":
+		if v != "true" && v != "false" {
+			err = fmt.Errorf("not a bool")
+		}
+	case "color":
+		_, err = ParseColor(v)
+	}
+	if err != nil {
+		return fmt.Sprintf("property %q is %q, not a %s", p.Name, v, p.Type)
+	}
+	return ""
+}
+
+// Schema describes the custom properties expected of the contents of maps,
+// such that content errors can be found (for instance as part of continuous
+// integration) rather than at runtime when a property is missing.
+type Schema struct {
+	// The properties of the map itself.
+	Map []PropertySchema
+
+	// The properties of layers (tile layers, object groups, image layers and
+	// group layers), by the name of the layer.
+	Layers map[string][]PropertySchema
+
+	// The properties of objects, by the class of the object.
+	Objects map[string][]PropertySchema
+}
+
+// Validate validates the properties of the given map against the schema, and
+// returns an error for each missing or mistyped property. Errors are ordered
+// like the map's contents.
+func (s *Schema) Validate(m *Map) []error {
+	var errs []error
+	check := func(where string, schema []PropertySchema, props map[string]string) {
+		for _, p := range schema {
+			if problem := p.check(props); len(problem) > 0 {
+				errs = append(errs, fmt.Errorf("%s: %s", where, problem))
+			}
+		}
+	}
+	check("map", s.Map, m.Properties)
+
+	// Layers of different kinds are checked in draw order.
+	for _, it := range drawOrder(m) {
+		switch {
+		case it.layer != nil:
+			check(fmt.Sprintf("layer %q", it.layer.Name), s.Layers[it.layer.Name], it.layer.Properties)
+		case it.group != nil:
+			check(fmt.Sprintf("object group %q", it.group.Name), s.Layers[it.group.Name], it.group.Properties)
+		case it.image != nil:
+			check(fmt.Sprintf("image layer %q", it.image.Name), s.Layers[it.image.Name], it.image.Properties)
+		case it.groupLayer != nil:
+			check(fmt.Sprintf("group layer %q", it.groupLayer.Name), s.Layers[it.groupLayer.Name], it.groupLayer.Properties)
+		}
+	}
+
+	for _, g := range m.ObjectGroups {
+		for _, o := range g.Objects {
+			if schema, ok := s.Objects[o.Class]; ok {
+				check(fmt.Sprintf("object group %q: object %q", g.Name, o.Name), schema, o.Properties)
+			}
+		}
+	}
+	return errs
+}
@@ -0,0 +1,112 @@
+// Copyright 2014 The Azul3D Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tmx
+
+import (
+	"image"
+	"math"
+	"strconv"
+	"time"
+
+	"azul3d.org/gfx.v2-unstable"
+)
+
+// Scrollers animates the texture coordinates of the objects created by Load
+// from layers that have the conventional "scrollx" and/or "scrolly" custom
+// properties, whose numeric values are the speed (in pixels per second) at
+// which the texture scrolls along that axis (where +Y is down).
+//
+// The scrolled textures use the repeat wrap mode and scroll as a whole, which
+// works well for image layers, and for tile layers using tilesets whose image
+// is a single tile (like a waterfall or conveyor texture). Scrolled objects
+// therefore get textures of their own, rather than ones shared through
+// Config.Textures, while textures given by Config.Materials are left as-is
+// and should use the repeat wrap mode themselves.
+//
+// A Scrollers is not safe for use by multiple goroutines at once.
+type Scrollers struct {
+	scrollers []*scroller
+}
+
+// scroller is a single scrolled object.
+type scroller struct {
+	obj *gfx.Object
+
+	// The speed in texture units per second, and the current offset.
+	speedU, speedV float64
+	u, v           float64
+
+	// The original texture coordinates of the object's mesh.
+	base []gfx.TexCoord
+}
+
+// scrollSpeed returns the scrolling speed, in pixels per second, given by the
+// conventional "scrollx" and "scrolly" custom properties of a layer.
+func scrollSpeed(props map[string]string) (x, y float64) {
+	speed := func(name string) float64 {
+		v, err := strconv.ParseFloat(props[name], 64)
+		if err != nil {
+			return 0
+		}
+		return v
+	}
+	return speed("scrollx"), speed("scrolly")
+}
+
+// add adds the given object, with a texture with the given bounds, if the
+// given layer properties specify it should scroll. It returns whether or not
+// the object was added.
+func (s *Scrollers) add(obj *gfx.Object, props map[string]string, tex image.Rectangle) bool {
+	x, y := scrollSpeed(props)
+	if (x == 0 && y == 0) || tex.Empty() {
+		return false
+	}
+
+	// The texture coordinates must stay around for us to modify them.
+	obj.Meshes[0].KeepDataOnLoad = true
+	s.scrollers = append(s.scrollers, &scroller{
+		obj:    obj,
+		speedU: -x / float64(tex.Dx()),
+		speedV: -y / float64(tex.Dy()),
+	})
+	return true
+}
+
+// rebuilt tells that the mesh of the given object was rebuilt, such that it's
+// original texture coordinates must be found again.
+func (s *Scrollers) rebuilt(obj *gfx.Object) {
+	for _, sc := range s.scrollers {
+		if sc.obj == obj {
+			sc.base = nil
+		}
+	}
+}
+
+// Animate advances the scrolling of all the objects by the given amount of
+// time, typically the time since the last frame.
+func (s *Scrollers) Animate(dt time.Duration) {
+	secs := dt.Seconds()
+	for _, sc := range s.scrollers {
+		sc.u = math.Mod(sc.u+sc.speedU*secs, 1)
+		sc.v = math.Mod(sc.v+sc.speedV*secs, 1)
+
+		sc.obj.RLock()
+		mesh := sc.obj.Meshes[0]
+		sc.obj.RUnlock()
+
+		mesh.Lock()
+		if len(mesh.TexCoords) > 0 {
+			set := &mesh.TexCoords[0]
+			if sc.base == nil {
+				sc.base = append([]gfx.TexCoord(nil), set.Slice...)
+			}
+			for i, tc := range sc.base {
+				set.Slice[i] = gfx.TexCoord{tc.U + float32(sc.u), tc.V + float32(sc.v)}
+			}
+			set.Changed = true
+		}
+		mesh.Unlock()
+	}
+}
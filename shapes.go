@@ -0,0 +1,205 @@
+// Copyright 2014 The Azul3D Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tmx
+
+import (
+	"fmt"
+	"image"
+	"math"
+
+	"azul3d.org/gfx.v2-unstable"
+)
+
+// LoadShapes loads filled meshes for the shape objects of the map's object
+// groups, which is useful for visualizing triggers and zones, or for map
+// editors. The returned map is of object group names and objects, one per
+// group that has any visible shapes.
+//
+// Shapes are rectangles (objects with a size but no tile or other value),
+// ellipses (approximated by the given number of segments, or 32 if it is less
+// than three) and polygons. They are filled with the color of their object
+// group, multiplied by the effective opacity of the group, and are rotated about their
+// origin just like in Tiled. Polylines, text and tile objects are ignored.
+//
+// If c is nil then the default configuration is used.
+func LoadShapes(m *Map, c *Config, segments int) map[string]*gfx.Object {
+	if c == nil {
+		c = DefaultConfig()
+	}
+	if segments < 3 {
+		segments = 32
+	}
+	ld := newLoader(m, c, nil)
+	objects := make(map[string]*gfx.Object)
+	for _, group := range m.ObjectGroups {
+		color := gfx.Color{
+			R: float32(group.Color.R) / 255,
+			G: float32(group.Color.G) / 255,
+			B: float32(group.Color.B) / 255,
+			A: float32(group.Color.A) / 255 * float32(group.EffectiveOpacity()),
+		}
+		ox, oy := group.EffectiveOffset()
+		depth := ld.layerDepth(group.Index, group.Properties)
+
+		mesh := gfx.NewMesh()
+		for _, o := range group.Objects {
+			if !o.Visible {
+				continue
+			}
+			outline := o.outline(segments)
+			if len(outline) < 3 {
+				continue
+			}
+			d := float32(depth - depthProperty(o.Properties)*ld.c.LayerOffset)
+			depth -= ld.tileOffset()
+			for _, p := range triangulate(outline) {
+				mesh.Vertices = append(mesh.Vertices, gfx.Vec3{
+					float32(p.X) + float32(ox),
+					d,
+					float32(m.Height*m.TileHeight) - (float32(p.Y) + float32(oy)),
+				})
+				mesh.Colors = append(mesh.Colors, color)
+			}
+		}
+		if len(mesh.Vertices) == 0 {
+			continue
+		}
+		ld.origin(mesh, 0)
+		mesh.TexCoords = []gfx.TexCoordSet{{
+			Slice: make([]gfx.TexCoord, len(mesh.Vertices)),
+		}}
+
+		// Shapes are untextured, so we use a single white texel.
+		white := image.NewRGBA(image.Rect(0, 0, 1, 1))
+		copy(white.Pix, []uint8{255, 255, 255, 255})
+		t := gfx.NewTexture()
+		t.Source = white
+		t.Bounds = white.Bounds()
+		t.WrapU = gfx.Clamp
+		t.WrapV = gfx.Clamp
+		t.MinFilter = gfx.Nearest
+		t.MagFilter = gfx.Nearest
+
+		obj := gfx.NewObject()
+		obj.Shader = shaderVariant(featureVertexColor)
+		obj.Meshes = []*gfx.Mesh{mesh}
+		obj.Textures = []*gfx.Texture{t}
+		obj.State = gfx.NewState()
+		obj.State.FaceCulling = gfx.NoFaceCulling
+		obj.State.AlphaMode = gfx.AlphaBlend
+		if ld.c.DepthOrder {
+			obj.State.DepthCmp = gfx.LessOrEqual
+		}
+		objects[group.Name] = obj
+
+		if ld.c.Info != nil {
+			ld.c.Info[obj] = &ObjectInfo{
+				Name:       fmt.Sprintf("tmx:%s/shapes", group.Name),
+				Layer:      group.Name,
+				Properties: group.Properties,
+			}
+		}
+	}
+	return objects
+}
+
+// outline returns the outline of this object's shape in pixels, rotated about
+// the object's origin, or nil if it is not a filled shape. Ellipses are
+// approximated with the given number of segments.
+func (o *Object) outline(segments int) []Pixel {
+	var points []Pixel
+	switch v := o.Value.(type) {
+	case nil:
+		if o.Gid != 0 || o.Width == 0 || o.Height == 0 {
+			return nil
+		}
+		w, h := float64(o.Width), float64(o.Height)
+		points = []Pixel{{0, 0}, {w, 0}, {w, h}, {0, h}}
+	case *Ellipse:
+		rx, ry := float64(o.Width)/2, float64(o.Height)/2
+		for i := 0; i < segments; i++ {
+			a := 2 * math.Pi * float64(i) / float64(segments)
+			points = append(points, Pixel{rx + rx*math.Cos(a), ry + ry*math.Sin(a)})
+		}
+	case *Polygon:
+		for _, p := range v.Points {
+			points = append(points, Pixel{float64(p.X), float64(p.Y)})
+		}
+	default:
+		return nil
+	}
+
+	// Rotate clockwise (as seen on screen, where +Y is down) about the origin
+	// and move into place.
+	sin, cos := math.Sincos(o.Rotation * math.Pi / 180)
+	for i, p := range points {
+		points[i] = Pixel{
+			float64(o.X) + p.X*cos - p.Y*sin,
+			float64(o.Y) + p.X*sin + p.Y*cos,
+		}
+	}
+	return points
+}
+
+// triangulate triangulates the given simple (but possibly concave) polygon by
+// ear clipping, returning the vertices of the triangles.
+func triangulate(poly []Pixel) []Pixel {
+	cross := func(a, b, c Pixel) float64 {
+		return (b.X-a.X)*(c.Y-a.Y) - (b.Y-a.Y)*(c.X-a.X)
+	}
+
+	// The sign of the polygon's area tells it's winding, ears turn the same
+	// way.
+	var area float64
+	for i, p := range poly {
+		q := poly[(i+1)%len(poly)]
+		area += p.X*q.Y - q.X*p.Y
+	}
+	sign := 1.0
+	if area < 0 {
+		sign = -1
+	}
+
+	idx := make([]int, len(poly))
+	for i := range idx {
+		idx[i] = i
+	}
+	inside := func(p, a, b, c Pixel) bool {
+		return cross(a, b, p)*sign > 0 && cross(b, c, p)*sign > 0 && cross(c, a, p)*sign > 0
+	}
+	tris := make([]Pixel, 0, 3*(len(poly)-2))
+	for len(idx) > 3 {
+		clipped := false
+		for i := range idx {
+			ia, ib, ic := idx[(i+len(idx)-1)%len(idx)], idx[i], idx[(i+1)%len(idx)]
+			a, b, c := poly[ia], poly[ib], poly[ic]
+			if cross(a, b, c)*sign <= 0 {
+				continue
+			}
+			ear := true
+			for _, j := range idx {
+				if j != ia && j != ib && j != ic && inside(poly[j], a, b, c) {
+					ear = false
+					break
+				}
+			}
+			if !ear {
+				continue
+			}
+			tris = append(tris, a, b, c)
+			idx = append(idx[:i], idx[i+1:]...)
+			clipped = true
+			break
+		}
+		if !clipped {
+			// Degenerate (E.g. self-intersecting) polygon, fan the rest.
+			for i := 1; i+1 < len(idx); i++ {
+				tris = append(tris, poly[idx[0]], poly[idx[i]], poly[idx[i+1]])
+			}
+			return tris
+		}
+	}
+	return append(tris, poly[idx[0]], poly[idx[1]], poly[idx[2]])
+}
@@ -0,0 +1,127 @@
+// Copyright 2014 Lightpoke. All rights reserved.
+// This source code is subject to the terms and
+// conditions defined in the "License.txt" file.
+
+package tmx
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"sort"
+)
+
+// ImageUsage describes the use of a single image file by a set of maps, see
+// AnalyzeSharing.
+type ImageUsage struct {
+	// The path of the image file, relative to the same directory as the paths
+	// of the maps.
+	Path string
+
+	// The size of the image in pixels, and of it's RGBA texture in bytes. The
+	// size is zero if none of the maps specify it (LoadFile fills in the size
+	// of tileset images).
+	Width, Height int
+	Bytes         int64
+
+	// The paths of the maps using the image, and the names of the tilesets
+	// (and image layers) referring to it, both sorted.
+	Maps, Users []string
+}
+
+// SharingReport describes which images a set of maps share, see
+// AnalyzeSharing.
+type SharingReport struct {
+	// The images used by the maps, those shared by the most maps first (and
+	// then by path).
+	Images []ImageUsage
+
+	// The total size of the textures of the unique images used by the maps,
+	// I.e. with the maps loaded at once and sharing textures (see
+	// TextureCache), and the total size if each map had textures of it's own.
+	UniqueBytes, TotalBytes int64
+}
+
+// AnalyzeSharing analyzes the images (of tilesets and image layers) used by
+// the given maps, keyed by their file paths, and reports which of them are
+// shared and the total unique texture footprint. This guides the planning of
+// atlases for games which keep several maps loaded at once.
+//
+// Images are identified by their file name within the directory of each map,
+// just like LoadFile finds them.
+func AnalyzeSharing(maps map[string]*Map) *SharingReport {
+	images := make(map[string]*ImageUsage)
+	add := func(mapPath, user string, img *Image) {
+		if img == nil || len(img.Source) == 0 {
+			return
+		}
+		path := filepath.Join(filepath.Dir(mapPath), filepath.Base(img.Source))
+		u, ok := images[path]
+		if !ok {
+			u = &ImageUsage{Path: path}
+			images[path] = u
+		}
+		if u.Width == 0 && u.Height == 0 {
+			u.Width, u.Height = img.Width, img.Height
+			u.Bytes = int64(img.Width*img.Height) * 4
+		}
+		u.Maps = appendUnique(u.Maps, mapPath)
+		u.Users = appendUnique(u.Users, user)
+	}
+	for path, m := range maps {
+		for _, ts := range m.Tilesets {
+			add(path, ts.Name, ts.Image)
+		}
+		for _, l := range m.ImageLayers {
+			add(path, l.Name, l.Image)
+		}
+	}
+
+	r := new(SharingReport)
+	for _, u := range images {
+		sort.Strings(u.Maps)
+		sort.Strings(u.Users)
+		r.Images = append(r.Images, *u)
+		r.UniqueBytes += u.Bytes
+		r.TotalBytes += u.Bytes * int64(len(u.Maps))
+	}
+	sort.Slice(r.Images, func(i, j int) bool {
+		a, b := r.Images[i], r.Images[j]
+		if len(a.Maps) != len(b.Maps) {
+			return len(a.Maps) > len(b.Maps)
+		}
+		return a.Path < b.Path
+	})
+	return r
+}
+
+// appendUnique appends s to the slice, unless it already holds it.
+func appendUnique(slice []string, s string) []string {
+	for _, v := range slice {
+		if v == s {
+			return slice
+		}
+	}
+	return append(slice, s)
+}
+
+// Shared returns the images which are used by more than one map.
+func (r *SharingReport) Shared() []ImageUsage {
+	var shared []ImageUsage
+	for _, u := range r.Images {
+		if len(u.Maps) > 1 {
+			shared = append(shared, u)
+		}
+	}
+	return shared
+}
+
+// String returns a human readable report, with a line per image.
+func (r *SharingReport) String() string {
+	var buf bytes.Buffer
+	for _, u := range r.Images {
+		fmt.Fprintf(&buf, "%s (%dx%dpx, %d bytes): %d maps %v, used by %v\n", u.Path, u.Width, u.Height, u.Bytes, len(u.Maps), u.Maps, u.Users)
+	}
+	fmt.Fprintf(&buf, "%d unique bytes, %d bytes without sharing\n", r.UniqueBytes, r.TotalBytes)
+	return buf.String()
+}
@@ -0,0 +1,74 @@
+// Copyright 2014 Lightpoke. All rights reserved.
+// This source code is subject to the terms and
+// conditions defined in the "License.txt" file.
+
+package tmx
+
+import "image"
+
+// Snapshot returns a copy of the map which is not affected by later changes
+// to the map, such that another goroutine (for instance one building meshes
+// from it, see RebuildDirty) can read it while the map continues to be edited.
+//
+// The tile data of the layers is copied lazily: it is shared by the map and
+// the snapshot until either one is changed by SetTile or the other editing
+// methods of this package (which copy it first), so taking a snapshot is
+// cheap. Clients editing the Tiles map of layers directly must not do so
+// while a snapshot is in use. Layers, group layers, image layers, object
+// groups and their objects are copied, while tilesets are shared and must not
+// be changed.
+//
+// The dirty rectangles of the layers (see Layer.Dirty) are moved into the
+// snapshot, such that rebuilding it rebuilds the cells changed since the
+// previous snapshot was taken.
+//
+// Snapshot must not be called while the map is being changed.
+func (m *Map) Snapshot() *Map {
+	s := *m
+
+	groups := make(map[*GroupLayer]*GroupLayer, len(m.Groups))
+	s.Groups = make([]*GroupLayer, len(m.Groups))
+	for i, g := range m.Groups {
+		cpy := *g
+		s.Groups[i] = &cpy
+		groups[g] = &cpy
+	}
+	parent := func(g *GroupLayer) *GroupLayer {
+		if g == nil {
+			return nil
+		}
+		return groups[g]
+	}
+	for _, g := range s.Groups {
+		g.Parent = parent(g.Parent)
+	}
+
+	s.Layers = make([]*Layer, len(m.Layers))
+	for i, l := range m.Layers {
+		l.shared = true
+		cpy := *l
+		cpy.Parent = parent(l.Parent)
+		l.dirty = image.Rectangle{}
+		s.Layers[i] = &cpy
+	}
+
+	s.ObjectGroups = make([]*ObjectGroup, len(m.ObjectGroups))
+	for i, g := range m.ObjectGroups {
+		cpy := *g
+		cpy.Parent = parent(g.Parent)
+		cpy.Objects = make([]*Object, len(g.Objects))
+		for j, o := range g.Objects {
+			obj := *o
+			cpy.Objects[j] = &obj
+		}
+		s.ObjectGroups[i] = &cpy
+	}
+
+	s.ImageLayers = make([]*ImageLayer, len(m.ImageLayers))
+	for i, l := range m.ImageLayers {
+		cpy := *l
+		cpy.Parent = parent(l.Parent)
+		s.ImageLayers[i] = &cpy
+	}
+	return &s
+}
@@ -0,0 +1,169 @@
+// Copyright 2014 The Azul3D Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tmx
+
+import (
+	"encoding/json"
+	"io"
+	"strconv"
+
+	"azul3d.org/lmath.v1"
+)
+
+// SpawnConfig configures which objects SpawnManifest includes, and how.
+type SpawnConfig struct {
+	// If non-empty, only objects of the object groups with these names, and
+	// of these types (see Spawn.Type), are included.
+	Groups, Types []string
+
+	// Whether invisible object groups and objects are included.
+	Invisible bool
+
+	// If non-nil, the properties of objects include the defaults of their
+	// class, and are decoded according to the types of the class members
+	// (see Project.ObjectProperties). Otherwise they are all strings.
+	Project *Project
+}
+
+// Spawn is a single entry of a spawn manifest, see SpawnManifest.
+type Spawn struct {
+	// The type of entity to spawn, which is the class of the object (or it's
+	// type, for maps made before Tiled 1.9).
+	Type string
+
+	// The name of the object, and of it's object group.
+	Name, Group string
+
+	// The position of the object's origin in world space, as Load places the
+	// layers of the map (with the same configuration), including the depth
+	// of it's object group.
+	Position lmath.Vec3
+
+	// The rotation of the object in degrees, clockwise as seen on screen.
+	Rotation float64
+
+	// The global tile ID of tile objects (including flip flags), or zero.
+	Gid uint32 `json:",omitempty"`
+
+	// The properties of the object, as strings or decoded values (int64,
+	// float64 and bool) depending on SpawnConfig.Project.
+	Properties map[string]interface{} `json:",omitempty"`
+}
+
+// SpawnManifest returns a flat manifest of the objects of the map's object
+// groups in draw order, ready to be fed to the loader of an entity component
+// system. Objects are laid out orthogonally.
+//
+// If c is nil then the default configuration is used, and if sc is nil all of
+// the visible objects are included.
+func SpawnManifest(m *Map, c *Config, sc *SpawnConfig) []Spawn {
+	if c == nil {
+		c = DefaultConfig()
+	}
+	if sc == nil {
+		sc = new(SpawnConfig)
+	}
+	has := func(names []string, name string) bool {
+		if len(names) == 0 {
+			return true
+		}
+		for _, n := range names {
+			if n == name {
+				return true
+			}
+		}
+		return false
+	}
+
+	ld := newLoader(m, c, nil)
+	var spawns []Spawn
+	for _, it := range drawOrder(m) {
+		g := it.group
+		if g == nil || !has(sc.Groups, g.Name) || (!sc.Invisible && !g.EffectiveVisible()) {
+			continue
+		}
+		ox, oy := g.EffectiveOffset()
+		depth := ld.layerDepth(g.Index, g.Properties)
+		for _, o := range g.Objects {
+			typ := o.Class
+			if len(typ) == 0 {
+				typ = o.Type
+			}
+			if !has(sc.Types, typ) || (!sc.Invisible && !o.Visible) {
+				continue
+			}
+			z := float64(o.Y) + oy
+			if !c.TopLeftOrigin {
+				z = float64(m.Height*m.TileHeight) - z
+			}
+			spawns = append(spawns, Spawn{
+				Type:  typ,
+				Name:  o.Name,
+				Group: g.Name,
+				Position: lmath.Vec3{
+					X: float64(o.X) + ox,
+					Y: depth - depthProperty(o.Properties)*c.LayerOffset,
+					Z: z,
+				},
+				Rotation:   o.Rotation,
+				Gid:        o.flaggedGid(),
+				Properties: sc.properties(typ, o),
+			})
+		}
+	}
+	return spawns
+}
+
+// properties returns the decoded properties of the given object of the given
+// type, see Spawn.Properties.
+func (sc *SpawnConfig) properties(typ string, o *Object) map[string]interface{} {
+	props := o.Properties
+	var members []PropertyMember
+	if sc.Project != nil {
+		if t := sc.Project.FindPropertyType(typ); t != nil && t.usableAs("object") {
+			props = sc.Project.Properties(typ, o.Properties)
+			members = t.Members
+		}
+	}
+	if len(props) == 0 {
+		return nil
+	}
+	decoded := make(map[string]interface{}, len(props))
+	for k, v := range props {
+		decoded[k] = v
+	}
+	for _, member := range members {
+		v, ok := props[member.Name]
+		if !ok {
+			continue
+		}
+		var err error
+		var value interface{}
+		switch member.Type {
+		case "int", "object":
+			value, err = strconv.ParseInt(v, 10, 64)
+		case "float":
+			value, err = strconv.ParseFloat(v, 64)
+		case "bool":
+			value, err = strconv.ParseBool(v)
+		default:
+			continue
+		}
+		if err == nil {
+			decoded[member.Name] = value
+		}
+	}
+	return decoded
+}
+
+// WriteSpawnManifest writes the given spawn manifest to w as a JSON array.
+func WriteSpawnManifest(w io.Writer, spawns []Spawn) error {
+	if spawns == nil {
+		spawns = []Spawn{}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "\t")
+	return enc.Encode(spawns)
+}
@@ -0,0 +1,74 @@
+// Copyright 2014 The Azul3D Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tmx
+
+import (
+	"image"
+	"math"
+)
+
+// Linearize describes how the sRGB encoded colors of images are converted to
+// linear colors, see Config.Linearize.
+type Linearize int
+
+const (
+	// The colors of images are used as-is, which suits pipelines that render
+	// in sRGB (gamma) space, as most 2D games do.
+	NoLinearize Linearize = iota
+
+	// The shaders decode the colors sampled from textures to linear colors,
+	// the equivalent of sRGB textures (which the gfx package does not expose)
+	// except that textures are filtered in sRGB space. This keeps the full
+	// precision of dark colors.
+	LinearizeShader
+
+	// The colors of images are converted to linear colors when textures are
+	// created for them (see LinearImage). This costs a copy of each image and
+	// loses precision in dark colors, but works with any shader.
+	LinearizeImages
+)
+
+// srgbToLinear maps 8-bit sRGB encoded color components to linear ones.
+var srgbToLinear = func() (t [256]uint8) {
+	for i := range t {
+		c := float64(i) / 255
+		if c <= 0.04045 {
+			c /= 12.92
+		} else {
+			c = math.Pow((c+0.055)/1.055, 2.4)
+		}
+		t[i] = uint8(c*255 + 0.5)
+	}
+	return
+}()
+
+// LinearImage returns a copy of the given image whose sRGB encoded colors are
+// converted to linear colors. The alpha of pixels is kept, it is not encoded.
+func LinearImage(src *image.RGBA) *image.RGBA {
+	dst := image.NewRGBA(src.Bounds())
+	b := src.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		s := src.Pix[src.PixOffset(b.Min.X, y):]
+		d := dst.Pix[dst.PixOffset(b.Min.X, y):]
+		for x := 0; x < 4*b.Dx(); x += 4 {
+			a := uint32(s[x+3])
+			d[x+3] = uint8(a)
+			if a == 0 {
+				continue
+			}
+
+			// The colors of image.RGBA are premultiplied by alpha, but the
+			// encoding applies to the colors themselves.
+			for i := 0; i < 3; i++ {
+				c := uint32(s[x+i]) * 255 / a
+				if c > 255 {
+					c = 255
+				}
+				d[x+i] = uint8(uint32(srgbToLinear[c]) * a / 255)
+			}
+		}
+	}
+	return dst
+}
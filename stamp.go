@@ -0,0 +1,55 @@
+// Copyright 2014 Lightpoke. All rights reserved.
+// This source code is subject to the terms and
+// conditions defined in the "License.txt" file.
+
+package tmx
+
+import (
+	"image"
+)
+
+// Stamp is a small rectangular patch of tiles, for instance a multi-tile
+// structure captured from a region of a map, which can be applied to layers
+// repeatedly like the stamp brush of Tiled.
+type Stamp struct {
+	// The size of the stamp in tiles.
+	Width, Height int
+
+	// The global tile IDs (including any flipping flags) of the stamp, the gid
+	// at (x, y) is stored at index y*Width + x. Zero means there is no tile.
+	GIDs []uint32
+}
+
+// At returns the gid at the given coordinates of the stamp, or zero if they
+// are outside of it.
+func (s *Stamp) At(x, y int) uint32 {
+	if x < 0 || y < 0 || x >= s.Width || y >= s.Height {
+		return 0
+	}
+	return s.GIDs[y*s.Width+x]
+}
+
+// CaptureStamp returns a new stamp holding the tiles of the layer within the
+// given rectangle of tile coordinates.
+func (l *Layer) CaptureStamp(r image.Rectangle) *Stamp {
+	r = r.Canon()
+	return &Stamp{
+		Width:  r.Dx(),
+		Height: r.Dy(),
+		GIDs:   l.GIDsIn(r),
+	}
+}
+
+// ApplyStamp applies the stamp to the layer, with the top-left tile of the
+// stamp at the given tile coordinates. Cells of the stamp without a tile leave
+// the layer's tiles as-is, such that irregularly shaped structures can be
+// stamped. Tiles are set just like SetTile does.
+func (l *Layer) ApplyStamp(x, y int, s *Stamp) {
+	for sy := 0; sy < s.Height; sy++ {
+		for sx := 0; sx < s.Width; sx++ {
+			if gid := s.At(sx, sy); gid != 0 {
+				l.SetTile(Coord{x + sx, y + sy}, gid)
+			}
+		}
+	}
+}
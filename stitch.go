@@ -0,0 +1,367 @@
+// Copyright 2014 Lightpoke. All rights reserved.
+// This source code is subject to the terms and
+// conditions defined in the "License.txt" file.
+
+package tmx
+
+import (
+	"fmt"
+	"image"
+	"sort"
+)
+
+// Placement describes where a map is placed when stitching maps together.
+type Placement struct {
+	// The map to place.
+	M *Map
+
+	// The offset of the map's top-left corner in pixels, like those found in
+	// Tiled .world files. The offset must be a multiple of the tile size.
+	OffsetX, OffsetY int
+}
+
+// StitchMaps merges several maps into a single larger map.
+//
+// Tilesets used by several of the maps (I.e. the same external tileset, or
+// embedded tilesets with the same name, image and tile size) are merged
+// into one, and the global tile IDs of all tiles and objects are remapped
+// accordingly.
+//
+// Tile layers and object groups with the same name are merged together, the
+// first map (in placement order) that has a layer decides it's attributes and
+// draw order. Image layers are never merged. The map attributes and
+// properties are taken from the first map, with properties missing there
+// being taken from the later maps.
+//
+// All of the maps must be orthogonal and have the same tile size, otherwise
+// an error is returned.
+func StitchMaps(placements []Placement) (*Map, error) {
+	if len(placements) == 0 {
+		return nil, fmt.Errorf("StitchMaps(): no maps to stitch")
+	}
+	first := placements[0].M
+	tw, th := first.TileWidth, first.TileHeight
+
+	// Validate the maps and find the bounds of the stitched map, in tiles.
+	var minX, minY, maxX, maxY int
+	for i, p := range placements {
+		m := p.M
+		if m.Orientation != Orthogonal {
+			return nil, fmt.Errorf("StitchMaps(): map %d is not orthogonal", i)
+		}
+		if m.TileWidth != tw || m.TileHeight != th {
+			return nil, fmt.Errorf("StitchMaps(): map %d has tile size %dx%d, want %dx%d", i, m.TileWidth, m.TileHeight, tw, th)
+		}
+		if p.OffsetX%tw != 0 || p.OffsetY%th != 0 {
+			return nil, fmt.Errorf("StitchMaps(): map %d offset is not a multiple of the tile size", i)
+		}
+		x, y := p.OffsetX/tw, p.OffsetY/th
+		if i == 0 || x < minX {
+			minX = x
+		}
+		if i == 0 || y < minY {
+			minY = y
+		}
+		if i == 0 || x+m.Width > maxX {
+			maxX = x + m.Width
+		}
+		if i == 0 || y+m.Height > maxY {
+			maxY = y + m.Height
+		}
+	}
+
+	out := &Map{
+		VersionMajor:    first.VersionMajor,
+		VersionMinor:    first.VersionMinor,
+		Class:           first.Class,
+		Orientation:     first.Orientation,
+		RenderOrder:     first.RenderOrder,
+		Width:           maxX - minX,
+		Height:          maxY - minY,
+		TileWidth:       tw,
+		TileHeight:      th,
+		BackgroundColor: first.BackgroundColor,
+		ParallaxOriginX: first.ParallaxOriginX,
+		ParallaxOriginY: first.ParallaxOriginY,
+		Properties:      make(map[string]string),
+	}
+	if first.CompressionLevel != nil {
+		level := *first.CompressionLevel
+		out.CompressionLevel = &level
+	}
+	for _, p := range placements {
+		for k, v := range p.M.Properties {
+			if _, ok := out.Properties[k]; !ok {
+				out.Properties[k] = v
+			}
+		}
+	}
+
+	// Find the unique tilesets and the number of global IDs each one needs.
+	var (
+		unique []*Tileset
+		spans  []uint32
+		which  = make(map[*Tileset]int)
+	)
+	for _, p := range placements {
+		for i, ts := range p.M.Tilesets {
+			n := -1
+			for j, u := range unique {
+				if sameTileset(ts, u) {
+					n = j
+					break
+				}
+			}
+			if n == -1 {
+				n = len(unique)
+				unique = append(unique, ts)
+				spans = append(spans, 0)
+			}
+			if span := tilesetSpan(p.M, i); span > spans[n] {
+				spans[n] = span
+			}
+			which[ts] = n
+		}
+	}
+	firstgid := uint32(1)
+	for i, ts := range unique {
+		cpy := *ts
+		cpy.Firstgid = firstgid
+		out.Tilesets = append(out.Tilesets, &cpy)
+		firstgid += spans[i]
+	}
+
+	// Merge all of the layers.
+	var (
+		layers      = make(map[string]*Layer)
+		groups      = make(map[string]*ObjectGroup)
+		groupLayers = make(map[string]*GroupLayer)
+		index       int
+	)
+	// parent returns the merged group layer for the given group layer of one
+	// of the maps.
+	parent := func(g *GroupLayer) *GroupLayer {
+		if g == nil {
+			return nil
+		}
+		return groupLayers[g.Name]
+	}
+	for _, p := range placements {
+		m := p.M
+		remap := func(gid uint32) uint32 {
+			ts := m.FindTileset(gid)
+			if ts == nil || gid&^flipFlags == 0 {
+				return gid
+			}
+			id := gid&^flipFlags - ts.Firstgid
+			return (out.Tilesets[which[ts]].Firstgid + id) | (gid & flipFlags)
+		}
+		dx, dy := p.OffsetX/tw-minX, p.OffsetY/th-minY
+		px, py := dx*tw, dy*th
+
+		for _, item := range drawOrder(m) {
+			switch {
+			case item.layer != nil:
+				l, ok := layers[item.layer.Name]
+				if !ok {
+					cpy := *item.layer
+					cpy.Index = index
+					cpy.Tiles = make(map[Coord]uint32, len(item.layer.Tiles))
+					cpy.RLE = nil
+					cpy.decoded = 0
+					cpy.dirty = image.Rectangle{}
+					cpy.boundsKnown = false
+					cpy.shared = false
+					cpy.Properties = copyProperties(cpy.Properties)
+					cpy.Parent = parent(cpy.Parent)
+					l = &cpy
+					layers[l.Name] = l
+					out.Layers = append(out.Layers, l)
+					index++
+				}
+				item.layer.EachTile(func(c Coord, gid uint32) {
+					l.Tiles[Coord{c.X + dx, c.Y + dy}] = remap(gid)
+				})
+
+			case item.group != nil:
+				g, ok := groups[item.group.Name]
+				if !ok {
+					cpy := *item.group
+					cpy.Index = index
+					cpy.Objects = nil
+					cpy.Properties = copyProperties(cpy.Properties)
+					cpy.Parent = parent(cpy.Parent)
+					g = &cpy
+					groups[g.Name] = g
+					out.ObjectGroups = append(out.ObjectGroups, g)
+					index++
+				}
+				for _, o := range item.group.Objects {
+					cpy := copyObject(o)
+					cpy.X += px
+					cpy.Y += py
+					switch v := cpy.Value.(type) {
+					case *Ellipse:
+						v.X, v.Y = cpy.X, cpy.Y
+					case *Polygon:
+						v.X, v.Y = cpy.X, cpy.Y
+					case *Polyline:
+						v.X, v.Y = cpy.X, cpy.Y
+					}
+					cpy.Gid = remap(o.Gid)
+					g.Objects = append(g.Objects, cpy)
+				}
+
+			case item.image != nil:
+				cpy := *item.image
+				cpy.Index = index
+				cpy.OffsetX += float64(px)
+				cpy.OffsetY += float64(py)
+				cpy.Properties = copyProperties(cpy.Properties)
+				if cpy.Image != nil {
+					img := *cpy.Image
+					cpy.Image = &img
+				}
+				cpy.Parent = parent(cpy.Parent)
+				out.ImageLayers = append(out.ImageLayers, &cpy)
+				index++
+
+			case item.groupLayer != nil:
+				if _, ok := groupLayers[item.groupLayer.Name]; !ok {
+					cpy := *item.groupLayer
+					cpy.Index = index
+					cpy.Properties = copyProperties(cpy.Properties)
+					cpy.Parent = parent(cpy.Parent)
+					groupLayers[cpy.Name] = &cpy
+					out.Groups = append(out.Groups, &cpy)
+					index++
+				}
+			}
+		}
+	}
+	return out, nil
+}
+
+// copyProperties returns a copy of the given properties, such that the
+// stitched map does not share them with the source maps.
+func copyProperties(props map[string]string) map[string]string {
+	if props == nil {
+		return nil
+	}
+	cpy := make(map[string]string, len(props))
+	for k, v := range props {
+		cpy[k] = v
+	}
+	return cpy
+}
+
+// copyObject returns a copy of the given object, including its properties and
+// value (e.g. the points of polygons), such that the stitched map does not
+// share them with the source maps.
+func copyObject(o *Object) *Object {
+	cpy := *o
+	cpy.Properties = copyProperties(o.Properties)
+	switch v := o.Value.(type) {
+	case *Ellipse:
+		e := *v
+		cpy.Value = &e
+	case *Polygon:
+		p := *v
+		p.Points = append([]Point(nil), v.Points...)
+		cpy.Value = &p
+	case *Polyline:
+		p := *v
+		p.Points = append([]Point(nil), v.Points...)
+		cpy.Value = &p
+	case *Text:
+		t := *v
+		cpy.Value = &t
+	case *Image:
+		i := *v
+		cpy.Value = &i
+	}
+	return &cpy
+}
+
+// sameTileset tells if the two tilesets are the same tileset, I.e. they are
+// the same external tileset, or embedded ones with the same name, image and
+// tile size.
+func sameTileset(a, b *Tileset) bool {
+	if len(a.Source) > 0 || len(b.Source) > 0 {
+		return a.Source == b.Source
+	}
+	if a.Name != b.Name || a.Width != b.Width || a.Height != b.Height {
+		return false
+	}
+	if a.Image == nil || b.Image == nil {
+		return a.Image == b.Image
+	}
+	return a.Image.Source == b.Image.Source
+}
+
+// tilesetSpan returns the number of global tile IDs used by the i'th tileset
+// of the map.
+func tilesetSpan(m *Map, i int) uint32 {
+	ts := m.Tilesets[i]
+	if i+1 < len(m.Tilesets) {
+		return m.Tilesets[i+1].Firstgid - ts.Firstgid
+	}
+
+	// The last tileset spans all of the tiles in it's image, and at least all
+	// of the tiles used by the map.
+	var span uint32
+	if ts.TileCount > 0 {
+		span = uint32(ts.TileCount)
+	} else if cols, rows := ts.Grid(); cols > 0 && rows > 0 {
+		span = uint32(cols * rows)
+	}
+	use := func(gid uint32) {
+		gid &^= flipFlags
+		if gid >= ts.Firstgid && gid-ts.Firstgid+1 > span {
+			span = gid - ts.Firstgid + 1
+		}
+	}
+	for _, l := range m.Layers {
+		l.EachTile(func(c Coord, gid uint32) {
+			use(gid)
+		})
+	}
+	for _, g := range m.ObjectGroups {
+		for _, o := range g.Objects {
+			use(o.Gid)
+		}
+	}
+	return span
+}
+
+// drawItem is a single tile layer, object group, image layer or group layer of
+// a map.
+type drawItem struct {
+	index      int
+	layer      *Layer
+	group      *ObjectGroup
+	image      *ImageLayer
+	groupLayer *GroupLayer
+}
+
+// drawOrder returns all of the tile layers, object groups, image layers and
+// group layers of the map sorted by their draw-order index.
+func drawOrder(m *Map) []drawItem {
+	var items []drawItem
+	for _, l := range m.Layers {
+		items = append(items, drawItem{index: l.Index, layer: l})
+	}
+	for _, g := range m.ObjectGroups {
+		items = append(items, drawItem{index: g.Index, group: g})
+	}
+	for _, l := range m.ImageLayers {
+		items = append(items, drawItem{index: l.Index, image: l})
+	}
+	for _, g := range m.Groups {
+		items = append(items, drawItem{index: g.Index, groupLayer: g})
+	}
+	sort.SliceStable(items, func(i, j int) bool {
+		return items[i].index < items[j].index
+	})
+	return items
+}
@@ -0,0 +1,109 @@
+// Copyright 2014 The Azul3D Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tmx
+
+import (
+	"image"
+	"sync"
+
+	"azul3d.org/gfx.v2-unstable"
+)
+
+// TextureCache shares textures between the objects of several loaded maps,
+// such that maps referencing the same tileset (or image layer) image use a
+// single texture and it is only uploaded to the GPU once, see
+// Config.Textures.
+//
+// Textures are identified by the filename of their image (just like the keys
+// of the tsImages map given to Load), so maps sharing a cache should not use
+// different images of the same name. Each texture is referenced by the objects
+// using it, once they are all released (see ReleaseLayers) the texture is
+// destroyed and removed from the cache.
+//
+// A TextureCache is safe for use by multiple goroutines at once.
+type TextureCache struct {
+	access    sync.Mutex
+	textures  map[string]*cachedTexture
+	byTexture map[*gfx.Texture]*cachedTexture
+}
+
+// cachedTexture is a single texture of a texture cache.
+type cachedTexture struct {
+	name string
+	tex  *gfx.Texture
+	refs int
+}
+
+// NewTextureCache returns a new, empty, texture cache.
+func NewTextureCache() *TextureCache {
+	return &TextureCache{
+		textures:  make(map[string]*cachedTexture),
+		byTexture: make(map[*gfx.Texture]*cachedTexture),
+	}
+}
+
+// Len returns the number of textures in the cache.
+func (c *TextureCache) Len() int {
+	c.access.Lock()
+	defer c.access.Unlock()
+	return len(c.textures)
+}
+
+// texture returns the cached texture for the named image, creating it from
+// the given image if needed, and whether or not it was created. The texture is
+// referenced once more.
+func (c *TextureCache) texture(name string, rgba *image.RGBA) (t *gfx.Texture, created bool) {
+	c.access.Lock()
+	defer c.access.Unlock()
+	if ct, ok := c.textures[name]; ok {
+		ct.refs++
+		return ct.tex, false
+	}
+	ct := &cachedTexture{name: name, tex: newTexture(rgba), refs: 1}
+	c.textures[name] = ct
+	c.byTexture[ct.tex] = ct
+	return ct.tex, true
+}
+
+// retain references the given texture once more, if it is in the cache.
+func (c *TextureCache) retain(t *gfx.Texture) {
+	c.access.Lock()
+	defer c.access.Unlock()
+	if ct, ok := c.byTexture[t]; ok {
+		ct.refs++
+	}
+}
+
+// release releases a reference to the given texture, destroying it and
+// removing it from the cache if it was the last one. It returns false if the
+// texture is not in the cache at all.
+func (c *TextureCache) release(t *gfx.Texture) bool {
+	c.access.Lock()
+	defer c.access.Unlock()
+	ct, ok := c.byTexture[t]
+	if !ok {
+		return false
+	}
+	ct.refs--
+	if ct.refs <= 0 {
+		delete(c.textures, ct.name)
+		delete(c.byTexture, t)
+		t.Destroy()
+	}
+	return true
+}
+
+// newTexture returns a new texture for the given image, as used by the objects
+// created by Load.
+func newTexture(rgba *image.RGBA) *gfx.Texture {
+	t := gfx.NewTexture()
+	t.Source = rgba
+	t.Bounds = rgba.Bounds()
+	t.WrapU = gfx.Clamp
+	t.WrapV = gfx.Clamp
+	t.MinFilter = gfx.LinearMipmapLinear
+	t.MagFilter = gfx.Linear
+	return t
+}
@@ -9,6 +9,7 @@ import (
 	"encoding/csv"
 	"fmt"
 	"strconv"
+	"time"
 )
 
 const (
@@ -23,12 +24,44 @@ const (
 	FLIPPED_DIAGONALLY_FLAG   uint32 = 0x20000000
 )
 
+// flipFlags is all of the tile flipping flags combined.
+const flipFlags = FLIPPED_HORIZONTALLY_FLAG | FLIPPED_VERTICALLY_FLAG | FLIPPED_DIAGONALLY_FLAG
+
+// Flip holds the flipping flags of a global tile id (see Map.DecomposeGID), as
+// the same bits as the FLIPPED_* flags.
+type Flip uint32
+
+// Horizontal tells if the tile is flipped horizontally.
+func (f Flip) Horizontal() bool {
+	return uint32(f)&FLIPPED_HORIZONTALLY_FLAG > 0
+}
+
+// Vertical tells if the tile is flipped vertically.
+func (f Flip) Vertical() bool {
+	return uint32(f)&FLIPPED_VERTICALLY_FLAG > 0
+}
+
+// Diagonal tells if the tile is flipped diagonally (I.e. it's X and Y axes
+// are swapped, which is applied before the horizontal and vertical flips).
+func (f Flip) Diagonal() bool {
+	return uint32(f)&FLIPPED_DIAGONALLY_FLAG > 0
+}
+
 type xmlTile struct {
-	ID          int           `xml:"id,attr"`
-	Terrain     []byte        `xml:"terrain,attr"`
-	Probability float64       `xml:"probability,attr"`
-	Properties  xmlProperties `xml:"properties"`
-	Image       xmlImage      `xml:"image"`
+	ID          int             `xml:"id,attr"`
+	Class       string          `xml:"class,attr"`
+	Type        string          `xml:"type,attr"`
+	Terrain     []byte          `xml:"terrain,attr"`
+	Probability *float64        `xml:"probability,attr"`
+	Properties  xmlProperties   `xml:"properties"`
+	Image       xmlImage        `xml:"image"`
+	Animation   []xmlFrame      `xml:"animation>frame"`
+	Objectgroup *xmlObjectgroup `xml:"objectgroup"`
+}
+
+type xmlFrame struct {
+	TileID   int `xml:"tileid,attr"`
+	Duration int `xml:"duration,attr"`
 }
 
 func (x xmlTile) terrainArray() (indices [4]int) {
@@ -55,13 +88,24 @@ func (x xmlTile) terrainArray() (indices [4]int) {
 }
 
 func (x xmlTile) toTile() *Tile {
-	return &Tile{
+	t := &Tile{
 		ID:          x.ID,
+		Class:       classAttr(x.Class, x.Type),
 		Terrain:     x.terrainArray(),
-		Probability: x.Probability,
+		Probability: floatAttr(x.Probability, 1),
 		Properties:  x.Properties.toMap(),
 		Image:       x.Image.toImage(),
 	}
+	if x.Objectgroup != nil {
+		t.Collision = x.Objectgroup.toObjectGroup()
+	}
+	for _, f := range x.Animation {
+		t.Animation = append(t.Animation, Frame{
+			TileID:   f.TileID,
+			Duration: time.Duration(f.Duration) * time.Millisecond,
+		})
+	}
+	return t
 }
 
 // Tile represents a single tile definition and it's properties
@@ -69,6 +113,9 @@ type Tile struct {
 	// The ID of the tile
 	ID int
 
+	// The class of the tile (called it's type before Tiled 1.9).
+	Class string
+
 	// An array defining the terrain type of each corner of the tile, as indices
 	// into the terrain types slice of the tileset this tile came from, in the
 	// order of: top left, top right, bottom left, bottom right.
@@ -76,8 +123,8 @@ type Tile struct {
 	// -1 values have a meaning of 'no terrain'.
 	Terrain [4]int
 
-	// Percentage chance indicating the probability that this tile is chosen
-	// when editing with the terrain tool.
+	// Relative probability that this tile is chosen when editing with the
+	// terrain tool, one by default.
 	Probability float64
 
 	// Map of properties for the tile
@@ -85,6 +132,23 @@ type Tile struct {
 
 	// Image for the tile
 	Image *Image
+
+	// The frames of the tile's animation, or nil if the tile is not animated.
+	Animation []Frame
+
+	// The collision shapes of the tile, relative to it's top-left corner, or
+	// nil if the tile has none.
+	Collision *ObjectGroup
+}
+
+// Frame represents a single frame of an animated tile.
+type Frame struct {
+	// The local ID of the tile shown during this frame, within the same
+	// tileset as the animated tile.
+	TileID int
+
+	// How long the frame is shown for, with millisecond precision.
+	Duration time.Duration
 }
 
 // String returns a string representation of this tileset.
@@ -0,0 +1,89 @@
+// Copyright 2014 Lightpoke. All rights reserved.
+// This source code is subject to the terms and
+// conditions defined in the "License.txt" file.
+
+package tmx
+
+// TileInfo is the metadata of a single global tile ID, see Map.TileInfos.
+type TileInfo struct {
+	// The tileset of the tile, and it's tile definition (or nil if the
+	// tileset has none for the tile).
+	Tileset *Tileset
+	Tile    *Tile
+
+	// The class and custom properties of the tile definition, if any.
+	Class      string
+	Properties map[string]string
+
+	// Whether the tile is animated, and whether it has collision shapes.
+	Animated, Collision bool
+}
+
+// TileInfos is a flat lookup table of tile metadata, indexed by global tile
+// ID (without flip flags), see Map.TileInfos.
+type TileInfos []TileInfo
+
+// TileInfos builds the lookup table of the metadata of all of the tiles of the
+// map's tilesets once, such that hot paths (for instance gameplay code
+// querying the tile under each entity each frame) can find it by global tile
+// ID without map lookups. The first entry (global tile ID zero, I.e. no tile)
+// is empty, as are all entries for IDs without a tileset.
+//
+// The number of tiles of each tileset is it's tile count, or if that is unknown
+// the number of tiles of it's grid (see Tileset.Grid), or else one past the
+// highest ID of it's tile definitions.
+//
+// The table is not updated when the map's tilesets change.
+func (m *Map) TileInfos() TileInfos {
+	var infos TileInfos
+	for _, ts := range m.Tilesets {
+		count := tileCount(ts)
+		if count == 0 {
+			continue
+		}
+		end := int(ts.Firstgid) + count
+		if end > len(infos) {
+			infos = append(infos, make(TileInfos, end-len(infos))...)
+		}
+		for id := 0; id < count; id++ {
+			info := TileInfo{Tileset: ts}
+			if t := ts.Tiles[id]; t != nil {
+				info.Tile = t
+				info.Class = t.Class
+				info.Properties = t.Properties
+				info.Animated = len(t.Animation) > 0
+				info.Collision = t.Collision != nil && len(t.Collision.Objects) > 0
+			}
+			infos[int(ts.Firstgid)+id] = info
+		}
+	}
+	return infos
+}
+
+// tileCount returns the number of tiles of the given tileset, see
+// Map.TileInfos.
+func tileCount(ts *Tileset) int {
+	if ts.TileCount > 0 {
+		return ts.TileCount
+	}
+	if columns, rows := ts.Grid(); columns > 0 && rows > 0 {
+		return columns * rows
+	}
+	count := 0
+	for id := range ts.Tiles {
+		if id >= count {
+			count = id + 1
+		}
+	}
+	return count
+}
+
+// Lookup returns the metadata of the given global tile ID (whose flip flags are
+// ignored), or nil if no tileset has a tile with the ID.
+func (t TileInfos) Lookup(gid uint32) *TileInfo {
+	gid &^= flipFlags
+	if gid == 0 || int(gid) >= len(t) || t[gid].Tileset == nil {
+		return nil
+	}
+	return &t[gid]
+}
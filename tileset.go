@@ -7,22 +7,31 @@ package tmx
 import (
 	"encoding/xml"
 	"fmt"
+	"image"
 )
 
 type xmlTileset struct {
-	Raw          []byte `xml:",innerxml"`
-	Firstgid     uint32 `xml:"firstgid,attr"`
-	Source       string `xml:"source,attr"`
-	Name         string `xml:"name,attr"`
-	TileWidth    int    `xml:"tilewidth,attr"`
-	TileHeight   int    `xml:"tileheight,attr"`
-	Spacing      int    `xml:"spacing,attr"`
-	Margin       int    `xml:"margin,attr"`
-	Tileoffset   xmlTileoffset
-	Properties   xmlProperties   `xml:"properties"`
-	Image        xmlImage        `xml:"image"`
-	Tile         []xmlTile       `xml:"tile"`
-	Terraintypes xmlTerraintypes `xml:"terraintypes"`
+	Raw             []byte             `xml:",innerxml"`
+	Firstgid        uint32             `xml:"firstgid,attr"`
+	Source          string             `xml:"source,attr"`
+	Name            string             `xml:"name,attr"`
+	Class           string             `xml:"class,attr"`
+	Type            string             `xml:"type,attr"`
+	TileWidth       int                `xml:"tilewidth,attr"`
+	TileHeight      int                `xml:"tileheight,attr"`
+	Spacing         int                `xml:"spacing,attr"`
+	Margin          int                `xml:"margin,attr"`
+	TileCount       int                `xml:"tilecount,attr"`
+	Columns         int                `xml:"columns,attr"`
+	RenderSize      string             `xml:"tilerendersize,attr"`
+	FillMode        string             `xml:"fillmode,attr"`
+	Tileoffset      xmlTileoffset      `xml:"tileoffset"`
+	Properties      xmlProperties      `xml:"properties"`
+	Image           xmlImage           `xml:"image"`
+	Tile            []xmlTile          `xml:"tile"`
+	Terraintypes    xmlTerraintypes    `xml:"terraintypes"`
+	Transformations xmlTransformations `xml:"transformations"`
+	WangSets        xmlWangSets        `xml:"wangsets"`
 }
 
 func (x *xmlTileset) tilesMap() map[int]*Tile {
@@ -44,6 +53,55 @@ func (x *xmlTileset) terrainTypes() []TerrainType {
 	return terrainTypes
 }
 
+// renderMode returns the tile render size and fill mode of the tileset, or an
+// error if either attribute is invalid.
+func (x *xmlTileset) renderMode() (TileRenderSize, FillMode, error) {
+	var size TileRenderSize
+	switch x.RenderSize {
+	case "", "tile":
+		size = TileRenderSizeTile
+	case "grid":
+		size = TileRenderSizeGrid
+	default:
+		return 0, 0, fmt.Errorf("unknown tileset tilerendersize %q.", x.RenderSize)
+	}
+	var fill FillMode
+	switch x.FillMode {
+	case "", "stretch":
+		fill = FillModeStretch
+	case "preserve-aspect-fit":
+		fill = FillModePreserveAspectFit
+	default:
+		return 0, 0, fmt.Errorf("unknown tileset fillmode %q.", x.FillMode)
+	}
+	return size, fill, nil
+}
+
+// TileRenderSize represents the size at which the tiles of a tileset are
+// rendered.
+type TileRenderSize int
+
+const (
+	// Tiles are rendered at their own size (the default).
+	TileRenderSizeTile TileRenderSize = iota
+
+	// Tiles are rendered at the map's grid size.
+	TileRenderSizeGrid
+)
+
+// FillMode represents how tiles are fit to their render size, when it
+// differs from the size of the tiles.
+type FillMode int
+
+const (
+	// Tiles are stretched to fill their render size (the default).
+	FillModeStretch FillMode = iota
+
+	// Tiles are scaled uniformly to fit within their render size, and are
+	// centered inside of it.
+	FillModePreserveAspectFit
+)
+
 // TerrainType defines a single terrain with a name and associated tile ID
 type TerrainType struct {
 	// Name of the terrain type
@@ -59,6 +117,9 @@ type Tileset struct {
 	// The name of this tileset.
 	Name string
 
+	// The class of this tileset (Tiled 1.9 and later).
+	Class string
+
 	// The first global tile ID of this tileset (this global ID maps to the
 	// first tile in this tileset).
 	Firstgid uint32
@@ -80,6 +141,16 @@ type Tileset struct {
 	// The margin in pixels around the tiles in this tileset.
 	Margin int
 
+	// The number of tiles in this tileset, and the number of columns of tiles
+	// in it's image. Either may be zero if the TMX file does not specify it
+	// (it is optional before Tiled 0.13), in which case it is unknown.
+	TileCount, Columns int
+
+	// The size at which tiles of this tileset are rendered, and how they are
+	// fit to that size.
+	RenderSize TileRenderSize
+	FillMode   FillMode
+
 	// Map of property names and values for all properties set on the map.
 	Properties map[string]string
 
@@ -91,6 +162,12 @@ type Tileset struct {
 
 	// The slice of terrain types
 	Terrain []TerrainType
+
+	// The transformations allowed for tiles of this tileset.
+	Transformations Transformations
+
+	// The wang sets (I.e. terrain sets) of this tileset.
+	WangSets []*WangSet
 }
 
 // String returns a string representation of this tileset.
@@ -98,6 +175,67 @@ func (t *Tileset) String() string {
 	return fmt.Sprintf("Tileset(Name=%q, Firstgid=%v, Source=%q, Size=%dx%dpx, Offset=%dx%dpx, Spacing=%dpx, Margin=%dpx)", t.Name, t.Firstgid, t.Source, t.Width, t.Height, t.OffsetX, t.OffsetY, t.Spacing, t.Margin)
 }
 
+// HasGID tells if the given global tile ID (whose flip flags are ignored)
+// refers to a tile of this tileset, according to it's first global ID and
+// tile count. If the tile count is unknown then all global IDs past the first
+// one are assumed to be valid.
+func (t *Tileset) HasGID(gid uint32) bool {
+	gid &^= flipFlags
+	if gid < t.Firstgid {
+		return false
+	}
+	return t.TileCount == 0 || gid-t.Firstgid < uint32(t.TileCount)
+}
+
+// Grid returns the number of columns and rows of tiles in the tileset's image.
+//
+// The number of columns is Columns, and the number of rows is the number of
+// rows needed to hold TileCount tiles. Only if those are unknown are they
+// computed from the size of the tileset's image (see Image.Width and
+// Image.Height, which LoadFile fills in if the TMX file does not specify
+// them), such that images with trailing padding or which were resized still
+// map global tile IDs to the correct tiles. Either is zero if it is unknown.
+func (t *Tileset) Grid() (columns, rows int) {
+	var width, height int
+	if t.Image != nil {
+		width, height = t.Image.Width, t.Image.Height
+	}
+	columns = t.Columns
+	if columns <= 0 && t.Width+t.Spacing > 0 {
+		columns = (width - 2*t.Margin + t.Spacing) / (t.Width + t.Spacing)
+	}
+	if columns <= 0 {
+		return 0, 0
+	}
+	if t.TileCount > 0 {
+		return columns, (t.TileCount + columns - 1) / columns
+	}
+	if t.Height+t.Spacing > 0 {
+		rows = (height - 2*t.Margin + t.Spacing) / (t.Height + t.Spacing)
+	}
+	if rows < 0 {
+		rows = 0
+	}
+	return columns, rows
+}
+
+// RectForGID returns the rectangle of the tileset's image which holds the tile
+// with the given global tile ID (whose flip flags are ignored), honoring the
+// margin and spacing of the tileset.
+//
+// The number of columns of tiles is that of Grid. If it is unknown an empty
+// rectangle is returned.
+func (t *Tileset) RectForGID(gid uint32) image.Rectangle {
+	columns, _ := t.Grid()
+	if columns <= 0 {
+		return image.Rectangle{}
+	}
+	c := toCoord(int(gid&^flipFlags-t.Firstgid), columns, 0)
+	x := t.Margin + c.X*(t.Width+t.Spacing)
+	y := t.Margin + c.Y*(t.Height+t.Spacing)
+	return image.Rect(x, y, x+t.Width, y+t.Height)
+}
+
 // Load loads the specified data as this tileset or returns a error if the data
 // is invalid.
 //
@@ -114,10 +252,17 @@ func (t *Tileset) Load(data []byte) error {
 		return err
 	}
 	t.Name = x.Name
+	t.Class = classAttr(x.Class, x.Type)
 	t.Width = x.TileWidth
 	t.Height = x.TileHeight
 	t.Spacing = x.Spacing
 	t.Margin = x.Margin
+	t.TileCount = x.TileCount
+	t.Columns = x.Columns
+	t.RenderSize, t.FillMode, err = x.renderMode()
+	if err != nil {
+		return err
+	}
 
 	// Find tileset offset
 	t.OffsetX, t.OffsetY = x.Tileoffset.X, x.Tileoffset.Y
@@ -138,5 +283,12 @@ func (t *Tileset) Load(data []byte) error {
 	// Find terrain definitions
 	t.Terrain = x.terrainTypes()
 
+	// Find wang sets
+	t.Transformations = x.Transformations.toTransformations()
+	t.WangSets, err = x.WangSets.toWangSets()
+	if err != nil {
+		return err
+	}
+
 	return nil
 }
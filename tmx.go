@@ -17,52 +17,74 @@ import (
 	"encoding/xml"
 	"fmt"
 	"image/color"
+	"sort"
 	"strconv"
 	"strings"
 )
 
-// hexColorToRGBA converts hex color strings to color.RGBA
+// ParseColor parses a hex color string as found in TMX files, which is one of
+// "#RRGGBB", "#AARRGGBB" (as Tiled writes for colors with transparency) or
+// the "#RGB" shorthand. The leading # is optional. Colors without an alpha
+// component are opaque.
 //
-// Alpha value in returned color will always be 255
-func hexToRGBA(c string) color.RGBA {
-	// There isin't really a color specification I can find on TMX file format,
-	// but Tiled exports #RRGGBB hex values, but this also supports #RGB ones
-	// just in case some abstract tool uses them by coincidence.
-
-	// Strip leading # if there is one
-	if len(c) > 0 && c[0] == '#' {
-		c = c[1:]
+// The returned color is not alpha-premultiplied, it's components are exactly
+// those found in the string.
+func ParseColor(s string) (color.RGBA, error) {
+	c := strings.TrimPrefix(strings.TrimSpace(s), "#")
+	v, err := strconv.ParseUint(c, 16, 32)
+	if err != nil {
+		return color.RGBA{}, fmt.Errorf("invalid color %q.", s)
 	}
+	switch len(c) {
+	case 3:
+		expand := func(n uint64) uint8 {
+			n &= 0xf
+			return uint8(n<<4 | n)
+		}
+		return color.RGBA{expand(v >> 8), expand(v >> 4), expand(v), 255}, nil
+	case 6:
+		return color.RGBA{uint8(v >> 16), uint8(v >> 8), uint8(v), 255}, nil
+	case 8:
+		return color.RGBA{uint8(v >> 16), uint8(v >> 8), uint8(v), uint8(v >> 24)}, nil
+	}
+	return color.RGBA{}, fmt.Errorf("invalid color %q.", s)
+}
 
-	// If an invalid length value then simply return
-	if len(c) != 6 && len(c) != 3 {
+// hexToRGBA converts hex color strings to color.RGBA, see ParseColor.
+//
+// Invalid (or empty) strings result in opaque black.
+func hexToRGBA(c string) color.RGBA {
+	rgba, err := ParseColor(c)
+	if err != nil {
 		return color.RGBA{0, 0, 0, 255}
 	}
+	return rgba
+}
 
-	var r, g, b uint8
-	if len(c) == 6 {
-		// Parse RRGGBB color
-		rgb, err := strconv.ParseUint(c, 16, 48)
-		if err != nil {
-			return color.RGBA{0, 0, 0, 255}
-		}
-		r = uint8(rgb >> 16)
-		g = uint8(rgb >> 8)
-		b = uint8(rgb)
-	} else {
-		// Parse #RGB values
-		rgb, err := strconv.ParseUint(c, 16, 24)
-		if err != nil {
-			return color.RGBA{0, 0, 0, 255}
-		}
-		r = uint8(rgb>>8) & 0xf
-		g = uint8(rgb>>4) & 0xf
-		b = uint8(rgb) & 0xf
-		r |= r << 4
-		g |= g << 4
-		b |= b << 4
+// visibleAttr returns the boolean value of an optional visible attribute. TMX
+// files omit the attribute when it is the default (1, visible).
+func visibleAttr(v *int) bool {
+	return v == nil || *v != 0
+}
+
+// floatAttr returns the value of an optional floating-point attribute. TMX
+// files omit attributes like opacity and parallax factors when they are the
+// default.
+func floatAttr(v *float64, def float64) float64 {
+	if v == nil {
+		return def
 	}
-	return color.RGBA{r, g, b, 255}
+	return *v
+}
+
+// classAttr returns the class of an element given it's class and type
+// attributes. Tiled 1.9 renamed the type attribute to class, so files may use
+// either.
+func classAttr(class, typ string) string {
+	if len(class) > 0 {
+		return class
+	}
+	return typ
 }
 
 type xmlProperty struct {
@@ -89,7 +111,7 @@ type xmlTileoffset struct {
 
 type xmlTerrain struct {
 	Name string `xml:"name,attr"`
-	Tile int    `xml:"id,attr"`
+	Tile int    `xml:"tile,attr"`
 }
 
 type xmlTerraintypes struct {
@@ -97,26 +119,164 @@ type xmlTerraintypes struct {
 }
 
 type xmlMap struct {
-	Version         string           `xml:"version,attr"`
-	Orientation     string           `xml:"orientation,attr"`
-	Width           int              `xml:"width,attr"`
-	Height          int              `xml:"height,attr"`
-	TileWidth       int              `xml:"tilewidth,attr"`
-	TileHeight      int              `xml:"tileheight,attr"`
-	BackgroundColor string           `xml:"backgroundcolor,attr"`
-	Properties      xmlProperties    `xml:"properties"`
-	Tileset         []xmlTileset     `xml:"tileset"`
-	Layer           []xmlLayer       `xml:"layer"`
-	Objectgroup     []xmlObjectgroup `xml:"objectgroup"`
+	Version          string        `xml:"version,attr"`
+	Class            string        `xml:"class,attr"`
+	Type             string        `xml:"type,attr"`
+	Orientation      string        `xml:"orientation,attr"`
+	RenderOrder      string        `xml:"renderorder,attr"`
+	Width            int           `xml:"width,attr"`
+	Height           int           `xml:"height,attr"`
+	Infinite         int           `xml:"infinite,attr"`
+	TileWidth        int           `xml:"tilewidth,attr"`
+	TileHeight       int           `xml:"tileheight,attr"`
+	HexSideLength    int           `xml:"hexsidelength,attr"`
+	StaggerAxis      string        `xml:"staggeraxis,attr"`
+	StaggerIndex     string        `xml:"staggerindex,attr"`
+	BackgroundColor  string        `xml:"backgroundcolor,attr"`
+	ParallaxOriginX  float64       `xml:"parallaxoriginx,attr"`
+	ParallaxOriginY  float64       `xml:"parallaxoriginy,attr"`
+	CompressionLevel *int          `xml:"compressionlevel,attr"`
+	Properties       xmlProperties `xml:"properties"`
+	Tileset          []xmlTileset  `xml:"tileset"`
+	Layers           []xmlMapLayer `xml:",any"`
+}
+
+// xmlMapLayer represents a single <layer>, <objectgroup>, <imagelayer> or
+// <group> element of a map. They are decoded together (I.e. in document order) because
+// their order dictates the order in which they are drawn.
+type xmlMapLayer struct {
+	Layer       *xmlLayer
+	Objectgroup *xmlObjectgroup
+	Imagelayer  *xmlImagelayer
+	Group       *xmlGroup
+}
+
+func (x *xmlMapLayer) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	switch start.Name.Local {
+	case "layer":
+		x.Layer = new(xmlLayer)
+		return d.DecodeElement(x.Layer, &start)
+	case "objectgroup":
+		x.Objectgroup = new(xmlObjectgroup)
+		return d.DecodeElement(x.Objectgroup, &start)
+	case "imagelayer":
+		x.Imagelayer = new(xmlImagelayer)
+		return d.DecodeElement(x.Imagelayer, &start)
+	case "group":
+		x.Group = new(xmlGroup)
+		return d.DecodeElement(x.Group, &start)
+	}
+	// Unknown element, e.g. <editorsettings>.
+	return d.Skip()
+}
+
+// ParseConfig represents a configuration used when parsing TMX map files.
+type ParseConfig struct {
+	// If true, tile layer data is not decoded at all. Layers are still
+	// returned with all of their other information (name, properties, etc)
+	// but their Tiles map will be nil.
+	//
+	// This is useful for e.g. authoritative game servers that only need the
+	// objects and properties of a map (spawn points, triggers, collision
+	// objects) and wish to use as little memory per map as possible.
+	SkipTiles bool
+
+	// If true, the tiles of each layer are stored run-length encoded (see
+	// Layer.RLE) instead of in the Layer.Tiles map, which uses far less memory
+	// for large and mostly uniform maps.
+	RLETiles bool
+
+	// How problems with the tile data of layers are handled, such as data
+	// holding more or fewer tiles than the layer has cells (see
+	// ErrBadTileCount), or global tile IDs (whose flip flags are ignored)
+	// beyond the ranges of the map's tilesets (see Map.CheckGIDs).
+	Problems ProblemPolicy
+
+	// If non-nil, problems are passed to this function when Problems is
+	// WarnProblems.
+	Warn func(err error)
+}
+
+// ProblemPolicy decides how problems with the tile data of maps are handled
+// when parsing, see ParseConfig.Problems.
+type ProblemPolicy int
+
+const (
+	// Problems are ignored, as they were before they were detected: tiles
+	// past the end of a layer are dropped, cells past the end of short tile
+	// data are left empty, and invalid global tile IDs are kept (and skipped
+	// by Load).
+	IgnoreProblems ProblemPolicy = iota
+
+	// Problems are passed to ParseConfig.Warn, and handled as they are by
+	// IgnoreProblems except that invalid global tile IDs are cleared.
+	WarnProblems
+
+	// Problems are returned as errors, and the map is not parsed.
+	RejectProblems
+)
+
+// problem handles the given problem with the tile data of a map according to
+// the configuration, returning the error to abort parsing with, if any.
+func (c *ParseConfig) problem(err error) error {
+	switch c.Problems {
+	case WarnProblems:
+		if c.Warn != nil {
+			c.Warn(err)
+		}
+	case RejectProblems:
+		return err
+	}
+	return nil
 }
 
 // Parse parses the TMX map file data and returns a *Map.
 //
 // nil and a error will be returned if there are any problems parsing the data.
 func Parse(data []byte) (*Map, error) {
+	return ParseWithConfig(data, nil)
+}
+
+// ParseWithConfig works just like Parse except it uses the given parsing
+// configuration, c.
+//
+// If the configuration, c, is nil then the default configuration is used (the
+// default configuration is simply the zero value of ParseConfig).
+func ParseWithConfig(data []byte, c *ParseConfig) (*Map, error) {
+	return parse(data, c, new(xmlMap), nil)
+}
+
+// ParseFile works just like ParseWithConfig except it parses the TMX map file
+// at the given path. Where supported, the file is memory-mapped read-only
+// rather than read into memory, such that parsing huge map files does not
+// first allocate (and grow) a buffer holding all of the file.
+//
+// External tilesets are not loaded, see LoadFile for that.
+func ParseFile(path string, c *ParseConfig) (*Map, error) {
+	data, unmap, err := mapFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	// The decoder copies everything that it keeps out of the data, so it may
+	// be unmapped once parsed.
+	m, err := ParseWithConfig(data, c)
+	if uerr := unmap(); err == nil && uerr != nil {
+		return nil, uerr
+	}
+	return m, err
+}
+
+// parse parses the TMX map file data using the given configuration, into the
+// given intermediate structure x. If old is non-nil, the tile maps of it's
+// layers are reused.
+func parse(data []byte, c *ParseConfig, x *xmlMap, old *Map) (*Map, error) {
+	if c == nil {
+		c = new(ParseConfig)
+	}
+
 	// Unmarshal map data
-	x := new(xmlMap)
-	err := xml.Unmarshal(data, &x)
+	err := xml.Unmarshal(data, x)
 	if err != nil {
 		return nil, err
 	}
@@ -137,17 +297,27 @@ func Parse(data []byte) (*Map, error) {
 	}
 
 	// Find map orientation
-	var orient Orientation
-	switch x.Orientation {
-	case "orthogonal":
-		orient = Orthogonal
-	case "isometric":
-		orient = Isometric
-	case "staggered":
-		orient = Staggered
-	default:
+	if len(x.Orientation) == 0 {
 		return nil, fmt.Errorf("unknown map orientation.")
 	}
+	orient, err := parseEnum(orientationNames[:], "orientation", x.Orientation, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	// Find map stagger axis and index, and render order
+	staggerAxis, err := parseEnum(staggerAxisNames[:], "staggeraxis", x.StaggerAxis, int(StaggerAxisY))
+	if err != nil {
+		return nil, err
+	}
+	staggerIndex, err := parseEnum(staggerIndexNames[:], "staggerindex", x.StaggerIndex, int(StaggerIndexOdd))
+	if err != nil {
+		return nil, err
+	}
+	renderOrder, err := parseEnum(renderOrderNames[:], "renderorder", x.RenderOrder, int(RightDown))
+	if err != nil {
+		return nil, err
+	}
 
 	// Find map properties
 	props := make(map[string]string, len(x.Properties.Property))
@@ -160,12 +330,22 @@ func Parse(data []byte) (*Map, error) {
 	for i, tsx := range x.Tileset {
 		ts := &Tileset{
 			Name:     tsx.Name,
+			Class:    classAttr(tsx.Class, tsx.Type),
 			Firstgid: tsx.Firstgid,
 			Source:   tsx.Source,
 			Width:    tsx.TileWidth,
 			Height:   tsx.TileHeight,
 			Spacing:  tsx.Spacing,
 			Margin:   tsx.Margin,
+
+			TileCount: tsx.TileCount,
+			Columns:   tsx.Columns,
+		}
+
+		// Find tileset render size and fill mode
+		ts.RenderSize, ts.FillMode, err = tsx.renderMode()
+		if err != nil {
+			return nil, err
 		}
 
 		// Find tileset offset
@@ -187,39 +367,143 @@ func Parse(data []byte) (*Map, error) {
 		// Find terrain definitions
 		ts.Terrain = tsx.terrainTypes()
 
-		tilesets[i] = ts
-	}
-
-	// Manage loading layers
-	layers := make([]*Layer, len(x.Layer))
-	for i, xl := range x.Layer {
-		var err error
-		layers[i], err = xl.toLayer(x.Width, x.Height)
+		// Find wang sets
+		ts.Transformations = tsx.Transformations.toTransformations()
+		ts.WangSets, err = tsx.WangSets.toWangSets()
 		if err != nil {
 			return nil, err
 		}
+
+		tilesets[i] = ts
 	}
 
-	// Manager loading object groups.
-	objectGroups := make([]*ObjectGroup, len(x.Objectgroup))
-	for i, group := range x.Objectgroup {
-		objectGroups[i] = group.toObjectGroup()
+	// Manage loading layers, object groups, image layers and (recursively)
+	// group layers, which all share the same draw-order index.
+	var (
+		layers       []*Layer
+		objectGroups []*ObjectGroup
+		imageLayers  []*ImageLayer
+		groups       []*GroupLayer
+		index        int
+		decoded      int64
+		parseLayers  func(xls []xmlMapLayer, parent *GroupLayer) error
+	)
+	parseLayers = func(xls []xmlMapLayer, parent *GroupLayer) error {
+		for _, xl := range xls {
+			switch {
+			case xl.Layer != nil:
+				var reuse map[Coord]uint32
+				if old != nil && len(layers) < len(old.Layers) {
+					reuse = old.Layers[len(layers)].Tiles
+				}
+				l, err := xl.Layer.toLayer(x.Width, x.Height, c.SkipTiles, reuse, c.problem)
+				if err != nil {
+					return err
+				}
+				if err := c.checkGIDs(tilesets, l); err != nil {
+					return err
+				}
+				l.Index = index
+				l.Parent = parent
+				if c.RLETiles && l.Tiles != nil && x.Infinite != 1 {
+					l.RLE = NewRLETiles(x.Width, x.Height, l.Tiles)
+					l.Tiles = nil
+				}
+				layers = append(layers, l)
+				decoded += l.decoded
+
+			case xl.Objectgroup != nil:
+				g := xl.Objectgroup.toObjectGroup()
+				g.Index = index
+				g.Parent = parent
+				objectGroups = append(objectGroups, g)
+
+			case xl.Imagelayer != nil:
+				l := xl.Imagelayer.toImageLayer()
+				l.Index = index
+				l.Parent = parent
+				imageLayers = append(imageLayers, l)
+
+			case xl.Group != nil:
+				g := xl.Group.toGroupLayer()
+				g.Index = index
+				g.Parent = parent
+				groups = append(groups, g)
+				index++
+				if err := parseLayers(xl.Group.Layers, g); err != nil {
+					return err
+				}
+				continue
+
+			default:
+				continue
+			}
+			index++
+		}
+		return nil
+	}
+	if err := parseLayers(x.Layers, nil); err != nil {
+		return nil, err
 	}
 
 	// Create actual map
 	m := &Map{
-		VersionMajor:    major,
-		VersionMinor:    minor,
-		Orientation:     orient,
-		Width:           x.Width,
-		Height:          x.Height,
-		TileWidth:       x.TileWidth,
-		TileHeight:      x.TileHeight,
-		BackgroundColor: hexToRGBA(x.BackgroundColor),
-		Properties:      props,
-		Tilesets:        tilesets,
-		Layers:          layers,
-		ObjectGroups:    objectGroups,
+		Class:            classAttr(x.Class, x.Type),
+		VersionMajor:     major,
+		VersionMinor:     minor,
+		Orientation:      Orientation(orient),
+		RenderOrder:      RenderOrder(renderOrder),
+		Width:            x.Width,
+		Height:           x.Height,
+		Infinite:         x.Infinite == 1,
+		TileWidth:        x.TileWidth,
+		TileHeight:       x.TileHeight,
+		HexSideLength:    x.HexSideLength,
+		StaggerAxis:      StaggerAxis(staggerAxis),
+		StaggerIndex:     StaggerIndex(staggerIndex),
+		BackgroundColor:  hexToRGBA(x.BackgroundColor),
+		ParallaxOriginX:  x.ParallaxOriginX,
+		ParallaxOriginY:  x.ParallaxOriginY,
+		CompressionLevel: x.CompressionLevel,
+		Properties:       props,
+		Tilesets:         tilesets,
+		Layers:           layers,
+		ObjectGroups:     objectGroups,
+		ImageLayers:      imageLayers,
+		Groups:           groups,
+		decoded:          decoded,
 	}
 	return m, nil
 }
+
+// checkGIDs handles the global tile IDs of the given freshly decoded layer
+// which are beyond the ranges of the given tilesets according to the
+// configuration, see ParseConfig.Problems. It returns the error to abort
+// parsing with, if any.
+func (c *ParseConfig) checkGIDs(tilesets []*Tileset, l *Layer) error {
+	if c.Problems == IgnoreProblems || l.Tiles == nil {
+		return nil
+	}
+	m := &Map{Tilesets: tilesets}
+
+	// Tiles are visited in row-major order, such that problems are reported
+	// (and parsing aborts) in the same order every time.
+	var invalid []Coord
+	for coord, gid := range l.Tiles {
+		if !m.validGID(gid) {
+			invalid = append(invalid, coord)
+		}
+	}
+	sort.Slice(invalid, func(i, j int) bool {
+		a, b := invalid[i], invalid[j]
+		return a.Y < b.Y || (a.Y == b.Y && a.X < b.X)
+	})
+	for _, coord := range invalid {
+		gid := l.Tiles[coord]
+		if err := c.problem(fmt.Errorf("layer %q: tile %v has invalid gid %d", l.Name, coord, gid&^flipFlags)); err != nil {
+			return err
+		}
+		delete(l.Tiles, coord)
+	}
+	return nil
+}
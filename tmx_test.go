@@ -5,10 +5,30 @@
 package tmx
 
 import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"flag"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"io"
 	"io/ioutil"
+	"math"
+	"math/rand"
 	"os"
 	"path/filepath"
+	"reflect"
+	"runtime"
+	"sort"
+	"strings"
 	"testing"
+	"time"
+
+	"azul3d.org/gfx.v2-unstable"
+	"azul3d.org/lmath.v1"
 )
 
 func verify(t *testing.T, name string) {
@@ -131,3 +151,4969 @@ func TestBase64ZLibMap(t *testing.T) {
 func TestObjects(t *testing.T) {
 	verify(t, "test_objects.tmx")
 }
+
+func TestTilesetOffset(t *testing.T) {
+	m, err := Parse([]byte(`<map version="1.0" orientation="orthogonal" width="1" height="1" tilewidth="16" tileheight="16">
+ <tileset firstgid="1" name="ts" tilewidth="16" tileheight="16">
+  <tileoffset x="4" y="-8"/>
+ </tileset>
+</map>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := m.Tilesets[0]
+	if ts.OffsetX != 4 || ts.OffsetY != -8 {
+		t.Fatal("incorrect tile offset", ts.OffsetX, ts.OffsetY)
+	}
+}
+
+func TestTerrainTile(t *testing.T) {
+	// The tile representing a terrain is given by it's "tile" attribute, and
+	// not by an "id" one (which Tiled does not write for terrains).
+	m, err := Parse([]byte(`<map version="1.0" orientation="orthogonal" width="1" height="1" tilewidth="16" tileheight="16">
+ <tileset firstgid="1" name="ts" tilewidth="16" tileheight="16">
+  <terraintypes>
+   <terrain name="grass" tile="3"/>
+   <terrain name="water" id="7" tile="-1"/>
+  </terraintypes>
+ </tileset>
+</map>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []TerrainType{{"grass", 3}, {"water", -1}}
+	if ts := m.Tilesets[0]; !reflect.DeepEqual(ts.Terrain, want) {
+		t.Fatal("incorrect terrain types", ts.Terrain)
+	}
+
+	// The writer uses the same attribute.
+	if ts := roundTrip(t, m, nil).Tilesets[0]; !reflect.DeepEqual(ts.Terrain, want) {
+		t.Fatal("incorrect written terrain types", ts.Terrain)
+	}
+}
+
+func TestSkipTiles(t *testing.T) {
+	data, err := ioutil.ReadFile(filepath.Join("testdata", "test_objects.tmx"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := ParseWithConfig(data, &ParseConfig{SkipTiles: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m.Layers) != 1 {
+		t.Fatal("expected one layer, got", len(m.Layers))
+	}
+	if m.Layers[0].Tiles != nil {
+		t.Fatal("expected nil tiles map")
+	}
+	if m.Layers[0].Name != "Tile Layer 1" {
+		t.Fatal("incorrect layer name", m.Layers[0].Name)
+	}
+	if len(m.ObjectGroups) != 2 || len(m.ObjectGroups[1].Objects) != 10 {
+		t.Fatal("incorrect objects")
+	}
+	if m.Properties["mymap_prop"] != "mymap_prop_value" {
+		t.Fatal("incorrect map property value")
+	}
+}
+
+func TestLayerGIDs(t *testing.T) {
+	data, err := ioutil.ReadFile(filepath.Join("testdata", "test_csv.tmx"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := Parse(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, layer := range m.Layers {
+		gids := layer.GIDs(m)
+		if len(gids) != m.Width*m.Height {
+			t.Fatal("incorrect slice length", len(gids))
+		}
+		for i, gid := range gids {
+			c := Coord{X: i % m.Width, Y: i / m.Width}
+			if gid != layer.Tiles[c] {
+				t.Fatalf("gid mismatch at %v: %d != %d", c, gid, layer.Tiles[c])
+			}
+		}
+	}
+}
+
+func TestVisibleOpacityDefaults(t *testing.T) {
+	data, err := ioutil.ReadFile(filepath.Join("testdata", "test_objects.tmx"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := Parse(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, l := range m.Layers {
+		if !l.Visible || l.Opacity != 1 {
+			t.Fatal("expected default visibility and opacity", l)
+		}
+	}
+	for _, g := range m.ObjectGroups {
+		if !g.Visible || g.Opacity != 1 {
+			t.Fatal("expected default visibility and opacity", g)
+		}
+		for _, o := range g.Objects {
+			if !o.Visible {
+				t.Fatal("expected default object visibility", o)
+			}
+		}
+	}
+}
+
+func TestVisibleOpacityExplicit(t *testing.T) {
+	m, err := Parse([]byte(`<map version="1.0" orientation="orthogonal" width="1" height="1" tilewidth="32" tileheight="32">
+ <layer name="a" width="1" height="1" opacity="0.5" visible="0">
+  <data encoding="csv">0</data>
+ </layer>
+ <objectgroup name="b" opacity="0.25" visible="0">
+  <object x="1" y="2" visible="0"/>
+ </objectgroup>
+</map>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := m.Layers[0]
+	if l.Visible || l.Opacity != 0.5 {
+		t.Fatal("incorrect layer visibility/opacity", l)
+	}
+	g := m.ObjectGroups[0]
+	if g.Visible || g.Opacity != 0.25 {
+		t.Fatal("incorrect object group visibility/opacity", g)
+	}
+	if g.Objects[0].Visible {
+		t.Fatal("incorrect object visibility")
+	}
+}
+
+func TestOffsetsAndParallax(t *testing.T) {
+	m, err := Parse([]byte(`<map version="1.0" orientation="orthogonal" width="1" height="1" tilewidth="32" tileheight="32" parallaxoriginx="10">
+ <layer name="a" width="1" height="1" offsetx="4" offsety="-8" parallaxx="0.5">
+  <data encoding="csv">0</data>
+ </layer>
+ <objectgroup name="b" offsetx="16" offsety="32" parallaxy="2">
+  <object x="1" y="2" width="3" height="4"/>
+ </objectgroup>
+</map>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := m.Layers[0]
+	if l.OffsetX != 4 || l.OffsetY != -8 || l.ParallaxX != 0.5 || l.ParallaxY != 1 {
+		t.Fatal("incorrect layer offset/parallax", l.OffsetX, l.OffsetY, l.ParallaxX, l.ParallaxY)
+	}
+	if x, y := l.ParallaxOffset(m, 110, 50); x != 50 || y != 0 {
+		t.Fatal("incorrect layer parallax offset", x, y)
+	}
+	g := m.ObjectGroups[0]
+	if g.OffsetX != 16 || g.OffsetY != 32 || g.ParallaxX != 1 || g.ParallaxY != 2 {
+		t.Fatal("incorrect object group offset/parallax", g.OffsetX, g.OffsetY, g.ParallaxX, g.ParallaxY)
+	}
+	if r := g.Bounds(g.Objects[0]); r != image.Rect(17, 34, 20, 38) {
+		t.Fatal("incorrect object bounds", r)
+	}
+}
+
+func TestFractionalOffsets(t *testing.T) {
+	m, err := Parse([]byte(`<map version="1.0" orientation="orthogonal" width="1" height="1" tilewidth="32" tileheight="32">
+ <group name="g" offsetx="0.25" offsety="-0.5">
+  <layer name="a" width="1" height="1" offsetx="12.5" offsety="1.75">
+   <data encoding="csv">0</data>
+  </layer>
+  <objectgroup name="b" offsetx="-2.5">
+   <object x="1" y="2" width="3" height="4"/>
+  </objectgroup>
+  <imagelayer name="c" offsety="0.125">
+   <image source="c.png"/>
+  </imagelayer>
+ </group>
+</map>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, g, il := m.Layers[0], m.ObjectGroups[0], m.ImageLayers[0]
+	if l.OffsetX != 12.5 || l.OffsetY != 1.75 || g.OffsetX != -2.5 || il.OffsetY != 0.125 {
+		t.Fatal("incorrect offsets", l.OffsetX, l.OffsetY, g.OffsetX, il.OffsetY)
+	}
+	if x, y := l.EffectiveOffset(); x != 12.75 || y != 1.25 {
+		t.Fatal("incorrect effective offset", x, y)
+	}
+	if r := g.Bounds(g.Objects[0]); r != image.Rect(-2, 1, 1, 5) {
+		t.Fatal("incorrect object bounds", r)
+	}
+
+	// Fractional offsets are written back as they were parsed.
+	m2 := roundTrip(t, m, nil)
+	l2, g2, il2 := m2.Layers[0], m2.ObjectGroups[0], m2.ImageLayers[0]
+	if l2.OffsetX != 12.5 || l2.OffsetY != 1.75 || g2.OffsetX != -2.5 || il2.OffsetY != 0.125 {
+		t.Fatal("incorrect written offsets", l2.OffsetX, l2.OffsetY, g2.OffsetX, il2.OffsetY)
+	}
+	if x, y := l2.Parent.OffsetX, l2.Parent.OffsetY; x != 0.25 || y != -0.5 {
+		t.Fatal("incorrect written group offset", x, y)
+	}
+}
+
+func TestImageLayers(t *testing.T) {
+	m, err := Parse([]byte(`<map version="1.0" orientation="orthogonal" width="1" height="1" tilewidth="32" tileheight="32">
+ <imagelayer name="sky" offsetx="5" opacity="0.5">
+  <image source="sky.png" width="64" height="32"/>
+ </imagelayer>
+ <layer name="a" width="1" height="1">
+  <data encoding="csv">0</data>
+ </layer>
+ <editorsettings/>
+ <objectgroup name="b"/>
+ <imagelayer name="fog" visible="0">
+  <image source="fog.png"/>
+ </imagelayer>
+</map>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m.ImageLayers) != 2 {
+		t.Fatal("expected two image layers, got", len(m.ImageLayers))
+	}
+	sky, fog := m.ImageLayers[0], m.ImageLayers[1]
+	if sky.Name != "sky" || sky.OffsetX != 5 || sky.Opacity != 0.5 || !sky.Visible {
+		t.Fatal("incorrect image layer", sky)
+	}
+	if sky.Image.Source != "sky.png" || sky.Image.Width != 64 || sky.Image.Height != 32 {
+		t.Fatal("incorrect image layer image", sky.Image)
+	}
+	if fog.Visible {
+		t.Fatal("incorrect image layer visibility")
+	}
+
+	// Verify draw order.
+	if sky.Index != 0 || m.Layers[0].Index != 1 || m.ObjectGroups[0].Index != 2 || fog.Index != 3 {
+		t.Fatal("incorrect draw order", sky.Index, m.Layers[0].Index, m.ObjectGroups[0].Index, fog.Index)
+	}
+}
+
+// destroyRecorder is a native texture which records whether it was destroyed.
+type destroyRecorder struct {
+	destroyed bool
+}
+
+func (d *destroyRecorder) Destroy() { d.destroyed = true }
+
+func TestReplaceImage(t *testing.T) {
+	m, err := Parse([]byte(`<map version="1.0" orientation="orthogonal" width="1" height="1" tilewidth="32" tileheight="32">
+ <imagelayer name="sky">
+  <image source="sky.png" width="64" height="32"/>
+ </imagelayer>
+</map>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sky := image.NewRGBA(image.Rect(0, 0, 64, 32))
+	obj := Load(m, nil, map[string]*image.RGBA{"sky.png": sky})["sky"]["sky.png"]
+	if obj == nil {
+		t.Fatal("image layer not loaded")
+	}
+	tex := obj.Textures[0]
+	native := new(destroyRecorder)
+	tex.NativeTexture = native
+	tex.Loaded = true
+	vertices := append([]gfx.Vec3(nil), obj.Meshes[0].Vertices...)
+
+	night := image.NewRGBA(image.Rect(0, 0, 128, 64))
+	ReplaceImage(obj, night)
+	if obj.Textures[0] != tex || tex.Source != night || tex.Bounds != night.Bounds() {
+		t.Fatal("texture image not replaced")
+	}
+	if tex.Loaded || tex.NativeTexture != nil || !native.destroyed {
+		t.Fatal("replaced texture not marked for upload")
+	}
+	if !reflect.DeepEqual(obj.Meshes[0].Vertices, vertices) {
+		t.Fatal("mesh changed by ReplaceImage")
+	}
+
+	// Objects without textures are left untouched.
+	empty := gfx.NewObject()
+	ReplaceImage(empty, night)
+	if len(empty.Textures) != 0 {
+		t.Fatal("texture added to an object without textures")
+	}
+}
+
+func TestLayerDepths(t *testing.T) {
+	m, err := Parse([]byte(`<map version="1.0" orientation="orthogonal" width="1" height="1" tilewidth="16" tileheight="16">
+ <imagelayer name="sky"/>
+ <layer name="a" width="1" height="1">
+  <data encoding="csv">0</data>
+ </layer>
+ <objectgroup name="things"/>
+ <imagelayer name="fog"/>
+ <layer name="b" width="1" height="1">
+  <data encoding="csv">0</data>
+ </layer>
+ <objectgroup name="top"/>
+</map>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Tile layers keep their position amongst the tile layers, the other
+	// layers are spread between them.
+	ld := newLoader(m, DefaultConfig(), nil)
+	want := map[string]float64{"sky": -0.5, "a": 0, "things": 1.0 / 3, "fog": 2.0 / 3, "b": 1, "top": 1.5}
+	got := map[string]float64{
+		"sky":    ld.depths[m.ImageLayers[0].Index],
+		"a":      ld.depths[m.Layers[0].Index],
+		"things": ld.depths[m.ObjectGroups[0].Index],
+		"fog":    ld.depths[m.ImageLayers[1].Index],
+		"b":      ld.depths[m.Layers[1].Index],
+		"top":    ld.depths[m.ObjectGroups[1].Index],
+	}
+	for name, depth := range want {
+		if math.Abs(got[name]-depth) > 1e-9 {
+			t.Fatal("incorrect depth of layer", name, got[name], "want", depth)
+		}
+	}
+	if d := ld.layerDepth(m.Layers[1].Index, nil); d != -ld.c.LayerOffset {
+		t.Fatal("incorrect tile layer depth", d)
+	}
+}
+
+func TestTilesInRect(t *testing.T) {
+	data, err := ioutil.ReadFile(filepath.Join("testdata", "test_csv.tmx"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := Parse(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := m.Layers[0]
+	r := image.Rect(100, 40, 700, 200)
+	found := make(map[Coord]uint32)
+	m.TilesInRect(l.Name, r, func(c Coord, gid uint32) bool {
+		found[c] = gid
+		return true
+	})
+	for c, gid := range l.Tiles {
+		want := m.TileBounds(c.X, c.Y).Overlaps(r)
+		if _, ok := found[c]; ok != want {
+			t.Fatalf("tile %v (gid %d): found=%v, want %v", c, gid, ok, want)
+		}
+	}
+	if len(found) == 0 {
+		t.Fatal("expected some tiles")
+	}
+
+	// Stopping iteration early.
+	n := 0
+	m.TilesInRect(l.Name, r, func(c Coord, gid uint32) bool {
+		n++
+		return false
+	})
+	if n != 1 {
+		t.Fatal("expected iteration to stop, got", n)
+	}
+
+	// Tall tiles extend up from the bottom-left of their cell, as Tiled draws
+	// them, unless they render at the grid size.
+	tall := &Map{
+		Width: 4, Height: 4, TileWidth: 16, TileHeight: 16,
+		Tilesets: []*Tileset{{Firstgid: 1, Width: 16, Height: 32}},
+		Layers:   []*Layer{{Name: "tall", Tiles: map[Coord]uint32{{1, 1}: 1}}},
+	}
+	ts, tl := tall.Tilesets[0], tall.Layers[0]
+	hits := func(r image.Rectangle) bool {
+		var hit bool
+		tall.TilesInRect("tall", r, func(c Coord, gid uint32) bool {
+			hit = true
+			return true
+		})
+		return hit
+	}
+	below, above := image.Rect(16, 36, 32, 40), image.Rect(16, 4, 32, 8)
+	if hits(below) || !hits(above) {
+		t.Fatal("incorrect tall tile anchoring", hits(below), hits(above))
+	}
+	ts.RenderSize = TileRenderSizeGrid
+	if hits(below) || hits(above) {
+		t.Fatal("incorrect grid sized tile bounds", hits(below), hits(above))
+	}
+	ts.RenderSize = TileRenderSizeTile
+
+	// The tile offset of the tileset moves the tiles.
+	ts.OffsetY = 16
+	if !hits(below) || hits(above) {
+		t.Fatal("tile offset not applied", hits(below), hits(above))
+	}
+	ts.OffsetY = 0
+
+	// As does the effective offset of the layer, including that of it's
+	// group.
+	tl.OffsetY, tl.Parent = 8.5, &GroupLayer{OffsetX: 16, OffsetY: 7.5}
+	if !hits(below.Add(image.Pt(16, 0))) || hits(above.Add(image.Pt(16, 0))) || hits(below) {
+		t.Fatal("layer offset not applied")
+	}
+}
+
+func TestTileBoundsIsometric(t *testing.T) {
+	m := &Map{Orientation: Isometric, Width: 4, Height: 4, TileWidth: 64, TileHeight: 32}
+	if r := m.TileBounds(0, 0); r != image.Rect(96, 0, 160, 32) {
+		t.Fatal("incorrect bounds", r)
+	}
+	if r := m.TileBounds(1, 0); r != image.Rect(128, 16, 192, 48) {
+		t.Fatal("incorrect bounds", r)
+	}
+	for y := 0; y < m.Height; y++ {
+		for x := 0; x < m.Width; x++ {
+			b := m.TileBounds(x, y)
+			c := b.Min.Add(b.Max).Div(2)
+			fx, fy := m.pixelToTile(float64(c.X), float64(c.Y))
+			if int(fx) != x || int(fy) != y {
+				t.Fatalf("pixelToTile(%v) = %v, %v; want %d, %d", c, fx, fy, x, y)
+			}
+		}
+	}
+}
+
+func TestObjectGidFlags(t *testing.T) {
+	m, err := Parse([]byte(`<map version="1.0" orientation="orthogonal" width="1" height="1" tilewidth="32" tileheight="32">
+ <objectgroup name="b">
+  <object gid="2147483653" x="1" y="2"/>
+  <object gid="1073741830" x="1" y="2"/>
+  <object gid="7" x="1" y="2"/>
+ </objectgroup>
+</map>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	objs := m.ObjectGroups[0].Objects
+	if o := objs[0]; o.Gid != 5 || !o.FlippedHorizontally || o.FlippedVertically || o.FlippedDiagonally {
+		t.Fatal("incorrect horizontally flipped object", o.Gid, o.FlippedHorizontally, o.FlippedVertically)
+	}
+	if o := objs[1]; o.Gid != 6 || o.FlippedHorizontally || !o.FlippedVertically || o.FlippedDiagonally {
+		t.Fatal("incorrect vertically flipped object", o.Gid, o.FlippedHorizontally, o.FlippedVertically)
+	}
+	if o := objs[2]; o.Gid != 7 || o.FlippedHorizontally || o.FlippedVertically || o.flaggedGid() != 7 {
+		t.Fatal("incorrect object", o.Gid)
+	}
+	if objs[0].flaggedGid() != 2147483653 {
+		t.Fatal("incorrect flagged gid", objs[0].flaggedGid())
+	}
+}
+
+func TestClass(t *testing.T) {
+	m, err := Parse([]byte(`<map version="1.9" class="world" orientation="orthogonal" width="1" height="1" tilewidth="32" tileheight="32">
+ <tileset firstgid="1" name="ts" class="tsclass" tilewidth="32" tileheight="32"/>
+ <layer name="a" class="ground" width="1" height="1">
+  <data encoding="csv">0</data>
+ </layer>
+ <objectgroup name="b" class="triggers">
+  <object x="1" y="2" type="old"/>
+  <object x="1" y="2" class="new"/>
+ </objectgroup>
+ <imagelayer name="c" class="sky"/>
+</map>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Class != "world" || m.Tilesets[0].Class != "tsclass" || m.Layers[0].Class != "ground" {
+		t.Fatal("incorrect class", m.Class, m.Tilesets[0].Class, m.Layers[0].Class)
+	}
+	if m.ObjectGroups[0].Class != "triggers" || m.ImageLayers[0].Class != "sky" {
+		t.Fatal("incorrect class", m.ObjectGroups[0].Class, m.ImageLayers[0].Class)
+	}
+	objs := m.ObjectGroups[0].Objects
+	if objs[0].Class != "old" || objs[0].Type != "old" || objs[1].Class != "new" || objs[1].Type != "new" {
+		t.Fatal("incorrect object class", objs[0].Class, objs[0].Type, objs[1].Class, objs[1].Type)
+	}
+}
+
+func TestTileRenderSize(t *testing.T) {
+	m, err := Parse([]byte(`<map version="1.9" orientation="orthogonal" width="1" height="1" tilewidth="32" tileheight="32">
+ <tileset firstgid="1" name="a" tilewidth="64" tileheight="32" tilerendersize="grid" fillmode="preserve-aspect-fit"/>
+ <tileset firstgid="2" name="b" tilewidth="32" tileheight="32"/>
+</map>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, b := m.Tilesets[0], m.Tilesets[1]
+	if a.RenderSize != TileRenderSizeGrid || a.FillMode != FillModePreserveAspectFit {
+		t.Fatal("incorrect render mode", a.RenderSize, a.FillMode)
+	}
+	if b.RenderSize != TileRenderSizeTile || b.FillMode != FillModeStretch {
+		t.Fatal("incorrect default render mode", b.RenderSize, b.FillMode)
+	}
+	if w, h := fitTile(a, 32, 32); w != 32 || h != 16 {
+		t.Fatal("incorrect fit size", w, h)
+	}
+	if w, h := fitTile(b, 16, 48); w != 16 || h != 48 {
+		t.Fatal("incorrect stretch size", w, h)
+	}
+
+	_, err = Parse([]byte(`<map version="1.9" orientation="orthogonal" width="1" height="1" tilewidth="32" tileheight="32">
+ <tileset firstgid="1" name="a" tilewidth="32" tileheight="32" fillmode="bogus"/>
+</map>`))
+	if err == nil {
+		t.Fatal("expected error for invalid fillmode")
+	}
+}
+
+func TestResizeAndCrop(t *testing.T) {
+	data := []byte(`<map version="1.0" orientation="orthogonal" width="2" height="2" tilewidth="16" tileheight="16">
+ <layer name="a" width="2" height="2">
+  <data encoding="csv">1,2,3,4</data>
+ </layer>
+ <objectgroup name="b">
+  <object x="8" y="8"/>
+ </objectgroup>
+</map>`)
+	m, err := Parse(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.Resize(4, 4, Center)
+	if m.Width != 4 || m.Height != 4 {
+		t.Fatal("incorrect size", m.Width, m.Height)
+	}
+	if g := m.Layers[0].Tiles[Coord{1, 1}]; g != 1 {
+		t.Fatal("incorrect tile after resize", g)
+	}
+	if o := m.ObjectGroups[0].Objects[0]; o.X != 24 || o.Y != 24 {
+		t.Fatal("incorrect object after resize", o.X, o.Y)
+	}
+
+	m.Crop(image.Rect(2, 1, 3, 3))
+	if m.Width != 1 || m.Height != 2 {
+		t.Fatal("incorrect size after crop", m.Width, m.Height)
+	}
+	tiles := m.Layers[0].Tiles
+	if len(tiles) != 2 || tiles[Coord{0, 0}] != 2 || tiles[Coord{0, 1}] != 4 {
+		t.Fatal("incorrect tiles after crop", tiles)
+	}
+	if o := m.ObjectGroups[0].Objects[0]; o.X != -8 || o.Y != 8 {
+		t.Fatal("incorrect object after crop", o.X, o.Y)
+	}
+
+	// Run-length encoded layers are moved the same way, and stay encoded.
+	rm, err := ParseWithConfig(data, &ParseConfig{RLETiles: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rm.Resize(4, 4, Center)
+	rm.Crop(image.Rect(2, 1, 3, 3))
+	rl := rm.Layers[0]
+	if rl.RLE == nil || !reflect.DeepEqual(rl.RLE.Map(), tiles) {
+		t.Fatal("incorrect run-length encoded tiles after crop", rl.Tiles, rl.RLE)
+	}
+	if !reflect.DeepEqual(rl.GIDs(rm), m.Layers[0].GIDs(m)) {
+		t.Fatal("run-length encoded layer differs after crop")
+	}
+
+	// Moving a staggered map by an odd number of rows keeps the tiles in
+	// place relative to each other.
+	for _, axis := range []StaggerAxis{StaggerAxisX, StaggerAxisY} {
+		sm := &Map{Orientation: Staggered, StaggerAxis: axis, Width: 4, Height: 4, TileWidth: 32, TileHeight: 16}
+		a, b := sm.TileBounds(1, 1), sm.TileBounds(2, 2)
+		sm.Resize(5, 5, BottomRight)
+		if a2, b2 := sm.TileBounds(2, 2), sm.TileBounds(3, 3); b2.Sub(a2.Min) != b.Sub(a.Min) {
+			t.Fatal("stagger changed by resize", axis, a, b, a2, b2)
+		}
+		sm.Crop(image.Rect(1, 1, 5, 5))
+		if a2, b2 := sm.TileBounds(1, 1), sm.TileBounds(2, 2); b2.Sub(a2.Min) != b.Sub(a.Min) {
+			t.Fatal("stagger changed by crop", axis, a, b, a2, b2)
+		}
+	}
+
+	// The tiles of infinite maps are moved, but never removed.
+	im := parseFile(t, "test_infinite.tmx")
+	want := im.Layers[0].Tile(Coord{-1, -1})
+	if want == 0 {
+		t.Fatal("expected a tile at -1,-1")
+	}
+	n := len(im.Layers[0].Tiles)
+	im.Crop(image.Rect(-1, -1, 2, 2))
+	if got := im.Layers[0].Tile(Coord{0, 0}); got != want || len(im.Layers[0].Tiles) != n {
+		t.Fatal("incorrect infinite tiles after crop", got, len(im.Layers[0].Tiles))
+	}
+	im.Resize(1, 1, TopLeft)
+	if got := im.Layers[0].Tile(Coord{0, 0}); got != want || len(im.Layers[0].Tiles) != n {
+		t.Fatal("incorrect infinite tiles after resize", got, len(im.Layers[0].Tiles))
+	}
+}
+
+func TestStitchMaps(t *testing.T) {
+	parse := func(data string) *Map {
+		m, err := Parse([]byte(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return m
+	}
+	a := parse(`<map version="1.0" orientation="orthogonal" width="1" height="1" tilewidth="16" tileheight="16">
+ <tileset firstgid="1" source="ground.tsx"/>
+ <layer name="ground" width="1" height="1">
+  <data encoding="csv">2</data>
+ </layer>
+</map>`)
+	b := parse(`<map version="1.0" orientation="orthogonal" width="1" height="1" tilewidth="16" tileheight="16">
+ <tileset firstgid="1" source="props.tsx"/>
+ <tileset firstgid="5" source="ground.tsx"/>
+ <layer name="ground" width="1" height="1">
+  <data encoding="csv">7</data>
+ </layer>
+ <objectgroup name="things">
+  <object x="4" y="4" gid="2147483650"/>
+  <object x="2" y="2">
+   <properties>
+    <property name="kind" value="fence"/>
+   </properties>
+   <polygon points="0,0 4,0 4,4"/>
+  </object>
+ </objectgroup>
+</map>`)
+	m, err := StitchMaps([]Placement{{a, 0, 0}, {b, 16, 0}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Width != 2 || m.Height != 1 || len(m.Layers) != 1 || len(m.ObjectGroups) != 1 {
+		t.Fatal("incorrect stitched map", m, len(m.Layers), len(m.ObjectGroups))
+	}
+	if len(m.Tilesets) != 2 || m.Tilesets[0].Source != "ground.tsx" || m.Tilesets[1].Source != "props.tsx" {
+		t.Fatal("incorrect stitched tilesets", m.Tilesets)
+	}
+	props := m.Tilesets[1].Firstgid
+	tiles := m.Layers[0].Tiles
+	if tiles[Coord{0, 0}] != 2 || tiles[Coord{1, 0}] != 3 {
+		t.Fatal("incorrect stitched tiles", tiles)
+	}
+	o := m.ObjectGroups[0].Objects[0]
+	if o.X != 20 || o.Gid != props+1 || !o.FlippedHorizontally {
+		t.Fatal("incorrect stitched object", o.X, o.Gid, o.FlippedHorizontally)
+	}
+
+	// The stitched map does not share properties or points with the sources.
+	o = m.ObjectGroups[0].Objects[1]
+	p := o.Value.(*Polygon)
+	if p.X != o.X || p.Y != o.Y {
+		t.Fatal("incorrect stitched polygon position", p.X, p.Y, o.X, o.Y)
+	}
+	o.Properties["kind"] = "wall"
+	p.Points[1].X = 8
+	src := b.ObjectGroups[0].Objects[1]
+	if src.Properties["kind"] != "fence" || src.Value.(*Polygon).Points[1].X != 4 || src.Value.(*Polygon).X != 2 {
+		t.Fatal("stitched map shares data with the source map", src.Properties, src.Value)
+	}
+
+	// Run-length encoded layers are stitched the same way.
+	a.Layers[0].Compact(a)
+	b.Layers[0].Compact(b)
+	rm, err := StitchMaps([]Placement{{a, 0, 0}, {b, 16, 0}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l := rm.Layers[0]; l.RLE != nil || !reflect.DeepEqual(l.Tiles, tiles) {
+		t.Fatal("incorrect stitched run-length encoded tiles", l.Tiles, l.RLE)
+	}
+
+	b.TileWidth = 8
+	if _, err := StitchMaps([]Placement{{a, 0, 0}, {b, 16, 0}}); err == nil {
+		t.Fatal("expected error for mismatched tile size")
+	}
+}
+
+func TestReplaceTileset(t *testing.T) {
+	data := []byte(`<map version="1.0" orientation="orthogonal" width="3" height="1" tilewidth="16" tileheight="16">
+ <tileset firstgid="1" name="old" tilewidth="16" tileheight="16"/>
+ <tileset firstgid="5" name="other" tilewidth="16" tileheight="16"/>
+ <layer name="a" width="3" height="1">
+  <data encoding="csv">1,2147483650,5</data>
+ </layer>
+</map>`)
+	m, err := Parse(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := m.Tilesets[0]
+	ts := &Tileset{Name: "new", Width: 16, Height: 16}
+	if err := m.ReplaceTileset(old, ts, map[int]int{1: 3}); err != nil {
+		t.Fatal(err)
+	}
+	if m.Tilesets[0] != ts || ts.Firstgid != 1 {
+		t.Fatal("tileset not replaced", m.Tilesets[0])
+	}
+	tiles := m.Layers[0].Tiles
+	if tiles[Coord{0, 0}] != 1 || tiles[Coord{1, 0}] != 4|FLIPPED_HORIZONTALLY_FLAG || tiles[Coord{2, 0}] != 5 {
+		t.Fatal("incorrect tiles", tiles)
+	}
+
+	if err := m.ReplaceTileset(ts, &Tileset{Width: 8, Height: 8}, nil); err == nil {
+		t.Fatal("expected error for mismatched tile size")
+	}
+	if err := m.ReplaceTileset(ts, &Tileset{Width: 16, Height: 16}, map[int]int{0: 4}); err == nil {
+		t.Fatal("expected error for out of range mapping")
+	}
+	if err := m.ReplaceTileset(ts, &Tileset{Width: 16, Height: 16}, map[int]int{9: 0}); err == nil {
+		t.Fatal("expected error for a mapping from outside of the old tileset")
+	}
+	if err := m.ReplaceTileset(ts, &Tileset{Width: 16, Height: 16, TileCount: 2}, map[int]int{1: 3}); err == nil {
+		t.Fatal("expected error for a mapping to outside of the new tileset")
+	}
+
+	// Run-length encoded layers are re-pointed the same way.
+	rm, err := ParseWithConfig(data, &ParseConfig{RLETiles: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rts := &Tileset{Name: "new", Width: 16, Height: 16}
+	if err := rm.ReplaceTileset(rm.Tilesets[0], rts, map[int]int{1: 3}); err != nil {
+		t.Fatal(err)
+	}
+	if rl := rm.Layers[0]; rl.RLE == nil || !reflect.DeepEqual(rl.RLE.Map(), tiles) {
+		t.Fatal("incorrect run-length encoded tiles", rl.Tiles, rl.RLE)
+	}
+
+	// The last tileset may be replaced by a larger one, and mapped into it.
+	bigger := &Tileset{Name: "bigger", Width: 16, Height: 16, TileCount: 8}
+	if err := m.ReplaceTileset(m.Tilesets[1], bigger, map[int]int{0: 6}); err != nil {
+		t.Fatal(err)
+	}
+	if tiles[Coord{2, 0}] != 11 {
+		t.Fatal("incorrect tile mapped into the larger tileset", tiles[Coord{2, 0}])
+	}
+}
+
+func TestFloodRegion(t *testing.T) {
+	m, err := Parse([]byte(`<map version="1.0" orientation="orthogonal" width="3" height="3" tilewidth="16" tileheight="16">
+ <layer name="a" width="3" height="3">
+  <data encoding="csv">1,1,2,
+2,1,2,
+1,2,1</data>
+ </layer>
+</map>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := m.Layers[0]
+	if r := l.FloodRegion(m, 0, 0, nil); len(r) != 3 {
+		t.Fatal("incorrect region", r)
+	}
+	if r := l.FloodRegion(m, 2, 0, nil); len(r) != 2 {
+		t.Fatal("incorrect region", r)
+	}
+	any := func(start, gid uint32) bool { return gid != 0 }
+	if r := l.FloodRegion(m, 0, 0, any); len(r) != 9 {
+		t.Fatal("incorrect predicate region", r)
+	}
+	if r := l.FloodRegion(m, 3, 0, nil); r != nil {
+		t.Fatal("expected nil region outside of map", r)
+	}
+}
+
+func TestRaycast(t *testing.T) {
+	m, err := Parse([]byte(`<map version="1.0" orientation="orthogonal" width="4" height="2" tilewidth="16" tileheight="16">
+ <layer name="collision" width="4" height="2">
+  <data encoding="csv">0,0,1,0,
+0,0,0,1</data>
+ </layer>
+</map>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	solid := func(gid uint32) bool { return gid != 0 }
+	c, hit, ok := m.Raycast("collision", Pixel{8, 8}, Pixel{60, 8}, solid)
+	if !ok || c != (Coord{2, 0}) || hit.X != 32 || hit.Y != 8 {
+		t.Fatal("incorrect hit", c, hit, ok)
+	}
+	c, hit, ok = m.Raycast("collision", Pixel{60, 24}, Pixel{4, 24}, solid)
+	if !ok || c != (Coord{3, 1}) || hit.X != 60 {
+		t.Fatal("incorrect hit from inside solid cell", c, hit, ok)
+	}
+	if _, _, ok = m.Raycast("collision", Pixel{8, 24}, Pixel{40, 24}, solid); ok {
+		t.Fatal("unexpected hit")
+	}
+}
+
+const wangMap = `<map version="1.9" orientation="orthogonal" width="2" height="2" tilewidth="16" tileheight="16">
+ <tileset firstgid="1" name="terrain" tilewidth="16" tileheight="16">
+  <tile id="1" probability="0.5"/>
+  <transformations hflip="1" vflip="1" rotate="0" preferuntransformed="0"/>
+  <wangsets>
+   <wangset name="ground" type="corner" tile="-1">
+    <wangcolor name="grass" color="#00ff00" tile="0" probability="2"/>
+    <wangtile tileid="0" wangid="0,1,0,1,0,1,0,1"/>
+    <wangtile tileid="1" wangid="0,1,0,0,0,0,0,0"/>
+   </wangset>
+  </wangsets>
+ </tileset>
+ <layer name="a" width="2" height="2">
+  <data encoding="csv">0,0,0,0</data>
+ </layer>
+</map>`
+
+func TestWangSets(t *testing.T) {
+	m, err := Parse([]byte(wangMap))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := m.Tilesets[0]
+	if ts.Transformations != (Transformations{HFlip: true, VFlip: true}) {
+		t.Fatal("incorrect transformations", ts.Transformations)
+	}
+	if len(ts.WangSets) != 1 {
+		t.Fatal("incorrect wang set count", len(ts.WangSets))
+	}
+	ws := ts.WangSets[0]
+	if ws.Name != "ground" || ws.Type != WangCorner || len(ws.Colors) != 1 || ws.Colors[0].Probability != 2 {
+		t.Fatal("incorrect wang set", ws)
+	}
+	if ws.Tiles[1] != (WangID{0, 1, 0, 0, 0, 0, 0, 0}) {
+		t.Fatal("incorrect wang id", ws.Tiles[1])
+	}
+	if ts.Tiles[1].Probability != 0.5 {
+		t.Fatal("incorrect tile probability", ts.Tiles[1].Probability)
+	}
+
+	m2 := roundTrip(t, m, nil)
+	if !reflect.DeepEqual(m2.Tilesets[0].WangSets, ts.WangSets) || m2.Tilesets[0].Transformations != ts.Transformations {
+		t.Fatal("wang sets not preserved by writing")
+	}
+}
+
+func TestAutoTile(t *testing.T) {
+	m, err := Parse([]byte(wangMap))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts, l := m.Tilesets[0], m.Layers[0]
+
+	// Grass only at the center corner of the map.
+	color := func(x, y int) int {
+		if x == 1 && y == 1 {
+			return 1
+		}
+		return 0
+	}
+	m.AutoTile(l, ts, ts.WangSets[0], color, nil)
+	const h, v = FLIPPED_HORIZONTALLY_FLAG, FLIPPED_VERTICALLY_FLAG
+	want := map[Coord]uint32{
+		{0, 0}: 2 | v,
+		{1, 0}: 2 | h | v,
+		{0, 1}: 2,
+		{1, 1}: 2 | h,
+	}
+	if !reflect.DeepEqual(l.Tiles, want) {
+		t.Fatal("incorrect tiles", l.Tiles)
+	}
+
+	// Grass everywhere picks the full tile, and no grass clears the layer.
+	m.AutoTile(l, ts, ts.WangSets[0], func(x, y int) int { return 1 }, rand.New(rand.NewSource(1)))
+	if l.Tiles[Coord{1, 1}]&^flipFlags != 1 {
+		t.Fatal("incorrect full tile", l.Tiles)
+	}
+	m.AutoTile(l, ts, ts.WangSets[0], func(x, y int) int { return 0 }, nil)
+	if len(l.Tiles) != 0 {
+		t.Fatal("expected cleared layer", l.Tiles)
+	}
+
+	// Run-length encoded layers are filled the same way.
+	rm, err := ParseWithConfig([]byte(wangMap), &ParseConfig{RLETiles: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rts, rl := rm.Tilesets[0], rm.Layers[0]
+	rm.AutoTile(rl, rts, rts.WangSets[0], color, nil)
+	if rl.RLE == nil || !reflect.DeepEqual(rl.RLE.Map(), want) {
+		t.Fatal("incorrect run-length encoded tiles", rl.Tiles, rl.RLE)
+	}
+}
+
+func TestRandomize(t *testing.T) {
+	m, err := Parse([]byte(`<map version="1.9" orientation="orthogonal" width="4" height="4" tilewidth="16" tileheight="16">
+ <tileset firstgid="1" name="terrain" tilewidth="16" tileheight="16">
+  <tile id="1" probability="0"/>
+  <wangsets>
+   <wangset name="ground" type="corner" tile="-1">
+    <wangcolor name="grass" color="#00ff00" tile="0" probability="1"/>
+    <wangtile tileid="0" wangid="0,1,0,1,0,1,0,1"/>
+    <wangtile tileid="1" wangid="0,1,0,1,0,1,0,1"/>
+    <wangtile tileid="2" wangid="0,1,0,1,0,1,0,1"/>
+    <wangtile tileid="3" wangid="0,1,0,0,0,0,0,0"/>
+   </wangset>
+  </wangsets>
+ </tileset>
+ <layer name="a" width="4" height="4">
+  <data encoding="csv">1,1,1,1,
+1,1,1,1,
+1,1,1,1,
+4,4,4,2147483649</data>
+ </layer>
+</map>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts, l := m.Tilesets[0], m.Layers[0]
+	rl := &Layer{RLE: NewRLETiles(m.Width, m.Height, l.Tiles)}
+	gids := ts.WangSets[0].Variants(ts, WangID{0, 1, 0, 1, 0, 1, 0, 1})
+	if !reflect.DeepEqual(gids, []uint32{1, 2, 3}) {
+		t.Fatal("incorrect variants", gids)
+	}
+	m.Randomize(l, gids, rand.New(rand.NewSource(7)))
+	counts := make(map[uint32]int)
+	for c, gid := range l.Tiles {
+		if c.Y < 3 {
+			counts[gid]++
+		}
+	}
+	if counts[2] != 0 || counts[1]+counts[3] != 12 {
+		t.Fatal("incorrect weighted choice", counts)
+	}
+	if l.Tiles[Coord{0, 3}] != 4 || l.Tiles[Coord{3, 3}]&FLIPPED_HORIZONTALLY_FLAG == 0 {
+		t.Fatal("incorrect untouched or flipped tiles", l.Tiles)
+	}
+
+	// The same seed gives the same result.
+	before := make(map[Coord]uint32, len(l.Tiles))
+	for c, gid := range l.Tiles {
+		before[c] = gid
+	}
+	m.Randomize(l, gids, rand.New(rand.NewSource(7)))
+	after := make(map[Coord]uint32)
+	for c, gid := range l.Tiles {
+		after[c] = gid
+	}
+	l.Tiles = before
+	m.Randomize(l, gids, rand.New(rand.NewSource(7)))
+	if !reflect.DeepEqual(l.Tiles, after) {
+		t.Fatal("randomize is not deterministic")
+	}
+
+	// Run-length encoded layers are randomized the same way.
+	m.Randomize(rl, gids, rand.New(rand.NewSource(7)))
+	if !reflect.DeepEqual(rl.RLE.Map(), after) {
+		t.Fatal("incorrect run-length encoded tiles", rl.RLE.Map())
+	}
+}
+
+func TestHexagonal(t *testing.T) {
+	m, err := Parse([]byte(`<map version="1.9" orientation="hexagonal" width="4" height="4" tilewidth="32" tileheight="32" hexsidelength="16" staggeraxis="y" staggerindex="odd"/>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Orientation != Hexagonal || m.HexSideLength != 16 || m.StaggerAxis != StaggerAxisY || m.StaggerIndex != StaggerIndexOdd {
+		t.Fatal("incorrect hexagonal attributes", m.Orientation, m.HexSideLength, m.StaggerAxis, m.StaggerIndex)
+	}
+	if b := m.TileBounds(0, 1); b != image.Rect(16, 24, 48, 56) {
+		t.Fatal("incorrect tile bounds", b)
+	}
+
+	want := map[Coord]bool{{0, 1}: true, {2, 1}: true, {1, 0}: true, {2, 0}: true, {1, 2}: true, {2, 2}: true}
+	for _, n := range m.HexNeighbors(Coord{1, 1}) {
+		if !want[n] {
+			t.Fatal("incorrect neighbor", n)
+		}
+	}
+	if d := m.HexDistance(Coord{0, 0}, Coord{2, 2}); d != 3 {
+		t.Fatal("incorrect distance", d)
+	}
+
+	// Conversions round trip for all stagger settings.
+	for _, axis := range []StaggerAxis{StaggerAxisX, StaggerAxisY} {
+		for _, index := range []StaggerIndex{StaggerIndexOdd, StaggerIndexEven} {
+			m.StaggerAxis, m.StaggerIndex = axis, index
+			for x := -3; x < 3; x++ {
+				for y := -3; y < 3; y++ {
+					c := Coord{x, y}
+					if got := m.FromAxial(m.ToAxial(c)); got != c {
+						t.Fatal("incorrect conversion", axis, index, c, got)
+					}
+					for _, n := range m.HexNeighbors(c) {
+						if d := m.HexDistance(c, n); d != 1 {
+							t.Fatal("neighbor is not adjacent", axis, index, c, n, d)
+						}
+					}
+				}
+			}
+		}
+	}
+	m2 := roundTrip(t, m, nil)
+	if m2.StaggerAxis != m.StaggerAxis || m2.StaggerIndex != m.StaggerIndex || m2.HexSideLength != 16 {
+		t.Fatal("hexagonal attributes not preserved by writing")
+	}
+}
+
+func TestShaderVariant(t *testing.T) {
+	if shaderVariant() != Shader {
+		t.Fatal("default shader variant is not Shader")
+	}
+	s := shaderVariant(featureVertexColor)
+	if s != shaderVariant(featureVertexColor) {
+		t.Fatal("shader variants are not cached")
+	}
+	if !strings.HasPrefix(string(s.GLSL.Fragment), "\n#version 120\n#define VERTEX_COLOR\n") {
+		t.Fatal("missing define", string(s.GLSL.Fragment))
+	}
+}
+
+func TestOccluders(t *testing.T) {
+	m, err := Parse([]byte(`<map version="1.0" orientation="orthogonal" width="3" height="2" tilewidth="10" tileheight="10">
+ <layer name="solid" width="3" height="2">
+  <data encoding="csv">1,1,0,
+1,0,0</data>
+ </layer>
+ <objectgroup name="shapes">
+  <object x="0" y="0" width="10" height="20"/>
+  <object x="0" y="0">
+   <polygon points="0,0 0,10 10,0"/>
+  </object>
+  <object x="0" y="0">
+   <polyline points="0,0 5,5 10,0"/>
+  </object>
+ </objectgroup>
+</map>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	edges := m.Occluders("solid", func(gid uint32) bool { return gid != 0 })
+	want := map[Edge]bool{
+		{Pixel{0, 0}, Pixel{20, 0}}:    true,
+		{Pixel{20, 10}, Pixel{10, 10}}: true,
+		{Pixel{10, 20}, Pixel{0, 20}}:  true,
+		{Pixel{0, 20}, Pixel{0, 0}}:    true,
+		{Pixel{20, 0}, Pixel{20, 10}}:  true,
+		{Pixel{10, 10}, Pixel{10, 20}}: true,
+	}
+	if len(edges) != len(want) {
+		t.Fatal("incorrect edge count", edges)
+	}
+	for _, e := range edges {
+		if !want[e] {
+			t.Fatal("unexpected edge", e)
+		}
+	}
+
+	g := m.ObjectGroups[0]
+	edges = g.Occluders()
+	if len(edges) != 4+3+2 {
+		t.Fatal("incorrect object edge count", len(edges))
+	}
+	// The counter-clockwise polygon is reversed.
+	if e := edges[4]; e != (Edge{Pixel{10, 0}, Pixel{0, 10}}) {
+		t.Fatal("incorrect polygon winding", e)
+	}
+}
+
+func TestBakeLightmap(t *testing.T) {
+	m, err := Parse([]byte(`<map version="1.0" orientation="orthogonal" width="4" height="2" tilewidth="16" tileheight="16">
+ <objectgroup name="lights">
+  <object x="8" y="8">
+   <properties>
+    <property name="radius" value="16"/>
+    <property name="color" value="#ff0000"/>
+   </properties>
+  </object>
+ </objectgroup>
+</map>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	img := BakeLightmap(m, m.ObjectGroups[0], &LightmapConfig{Ambient: color.RGBA{10, 10, 10, 255}, Scale: 8})
+	if img.Bounds() != image.Rect(0, 0, 8, 4) {
+		t.Fatal("incorrect lightmap size", img.Bounds())
+	}
+	near, far := img.RGBAAt(0, 0), img.RGBAAt(7, 3)
+	if near.R <= 100 || near.G != 10 || near.B != 10 {
+		t.Fatal("incorrect lit texel", near)
+	}
+	if far != (color.RGBA{10, 10, 10, 255}) {
+		t.Fatal("incorrect ambient texel", far)
+	}
+}
+
+func TestImageLayerRepeat(t *testing.T) {
+	m, err := Parse([]byte(`<map version="1.8" orientation="orthogonal" width="1" height="1" tilewidth="16" tileheight="16">
+ <imagelayer name="sky" repeatx="1">
+  <image source="sky.png" width="16" height="16"/>
+ </imagelayer>
+</map>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := m.ImageLayers[0]
+	if !l.RepeatX || l.RepeatY {
+		t.Fatal("incorrect repeat flags", l.RepeatX, l.RepeatY)
+	}
+	if l2 := roundTrip(t, m, nil).ImageLayers[0]; l2.RepeatX != l.RepeatX || l2.RepeatY != l.RepeatY {
+		t.Fatal("repeat flags not preserved by writing")
+	}
+}
+
+func TestBlendMode(t *testing.T) {
+	for name, want := range map[string]BlendMode{
+		"":         BlendNormal,
+		"normal":   BlendNormal,
+		"bogus":    BlendNormal,
+		"add":      BlendAdd,
+		"multiply": BlendMultiply,
+		"screen":   BlendScreen,
+	} {
+		if got := blendModeProperty(map[string]string{"blendmode": name}); got != want {
+			t.Fatalf("blendmode %q: got %v want %v", name, got, want)
+		}
+	}
+
+	s := gfx.NewState()
+	BlendNormal.apply(s)
+	if *s != gfx.DefaultState {
+		t.Fatal("normal blend mode modified the state")
+	}
+	BlendAdd.apply(s)
+	if s.AlphaMode != gfx.AlphaBlend || s.DepthWrite || s.Blend.DstRGB != gfx.BOne {
+		t.Fatal("incorrect additive blend state", s)
+	}
+}
+
+func TestDepthOrder(t *testing.T) {
+	m, err := Parse([]byte(`<map version="1.0" orientation="orthogonal" width="2" height="2" tilewidth="16" tileheight="16">
+ <tileset firstgid="1" name="ts" tilewidth="16" tileheight="16">
+  <image source="ts.png" width="16" height="16"/>
+ </tileset>
+ <layer name="a" width="2" height="2">
+  <data encoding="csv">1,1,1,1</data>
+ </layer>
+</map>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	images := map[string]*image.RGBA{"ts.png": image.NewRGBA(image.Rect(0, 0, 16, 16))}
+	c := DefaultConfig()
+	c.DepthOrder = true
+	obj := Load(m, c, images)["a"]["ts.png"]
+	if obj.State.DepthCmp != gfx.LessOrEqual {
+		t.Fatal("incorrect depth comparison", obj.State.DepthCmp)
+	}
+	verts := obj.Meshes[0].Vertices
+	for _, v := range verts {
+		if v.Y != verts[0].Y {
+			t.Fatal("tiles of a layer do not share it's depth", v.Y, verts[0].Y)
+		}
+	}
+}
+
+func TestDepthProperties(t *testing.T) {
+	m, err := Parse([]byte(`<map version="1.0" orientation="orthogonal" width="2" height="2" tilewidth="16" tileheight="16">
+ <tileset firstgid="1" name="ts" tilewidth="16" tileheight="16">
+  <image source="ts.png" width="16" height="16"/>
+ </tileset>
+ <layer name="a" width="2" height="2">
+  <properties>
+   <property name="z" value="2"/>
+  </properties>
+  <data encoding="csv">1,0,0,0</data>
+ </layer>
+ <objectgroup name="b">
+  <properties>
+   <property name="draworder" value="-3"/>
+  </properties>
+  <object x="16" y="32" gid="1"/>
+  <object x="0" y="16" width="32" height="32" gid="1">
+   <properties>
+    <property name="z" value="1"/>
+   </properties>
+  </object>
+ </objectgroup>
+</map>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	images := map[string]*image.RGBA{"ts.png": image.NewRGBA(image.Rect(0, 0, 16, 16))}
+	c := DefaultConfig()
+	c.LayerOffset, c.TileOffset = 1, 0
+	layers := Load(m, c, images)
+
+	// bounds returns the bounds of the given vertices on the X and Z axes, and
+	// their depth, which must be the same for all of them.
+	bounds := func(verts []gfx.Vec3) (minX, maxX, minZ, maxZ, depth float32) {
+		minX, maxX, minZ, maxZ, depth = verts[0].X, verts[0].X, verts[0].Z, verts[0].Z, verts[0].Y
+		for _, v := range verts {
+			if v.Y != depth {
+				t.Fatal("vertices of a card do not share it's depth", v.Y, depth)
+			}
+			minX = float32(math.Min(float64(minX), float64(v.X)))
+			maxX = float32(math.Max(float64(maxX), float64(v.X)))
+			minZ = float32(math.Min(float64(minZ), float64(v.Z)))
+			maxZ = float32(math.Max(float64(maxZ), float64(v.Z)))
+		}
+		return
+	}
+
+	// The "z" property of the layer moves it two layers up.
+	tiles := layers["a"]["ts.png"].Meshes[0].Vertices
+	if _, _, _, _, depth := bounds(tiles); depth != -2 {
+		t.Fatal("incorrect tile layer depth", depth)
+	}
+
+	// Tile objects are drawn at the depth of their group (half a layer above
+	// the tile layer, moved down by it's "draworder" property) and their own
+	// "z" property. They are anchored at
+	// their bottom-left corner, and default to the size of their tile.
+	objects := layers["b"]["ts.png"].Meshes[0].Vertices
+	if len(objects) != 12 {
+		t.Fatal("expected two tile object cards, got vertices", len(objects))
+	}
+	if minX, maxX, minZ, maxZ, depth := bounds(objects[:6]); minX != 16 || maxX != 32 || minZ != 0 || maxZ != 16 || depth != 2.5 {
+		t.Fatal("incorrect tile object card", minX, maxX, minZ, maxZ, depth)
+	}
+	if minX, maxX, minZ, maxZ, depth := bounds(objects[6:]); minX != 0 || maxX != 32 || minZ != 16 || maxZ != 48 || depth != 1.5 {
+		t.Fatal("incorrect sized tile object card", minX, maxX, minZ, maxZ, depth)
+	}
+
+	// An object group with the same name as a tile layer replaces it's entry.
+	m.ObjectGroups[0].Name = "a"
+	layers = Load(m, c, images)
+	if _, ok := layers["b"]; ok || len(layers["a"]["ts.png"].Meshes[0].Vertices) != 12 {
+		t.Fatal("object group did not replace the tile layer of the same name")
+	}
+}
+
+func TestLoadInstances(t *testing.T) {
+	m, err := Parse([]byte(`<map version="1.0" orientation="orthogonal" width="2" height="1" tilewidth="16" tileheight="16">
+ <tileset firstgid="1" name="ts" tilewidth="16" tileheight="16">
+  <image source="ts.png" width="32" height="16"/>
+ </tileset>
+ <layer name="a" width="2" height="1">
+  <data encoding="csv">1,2147483650</data>
+ </layer>
+</map>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	images := map[string]*image.RGBA{"ts.png": image.NewRGBA(image.Rect(0, 0, 32, 16))}
+	instances := LoadInstances(m, nil, images)["a"]["ts.png"]
+	if len(instances) != 2 {
+		t.Fatal("incorrect instance count", len(instances))
+	}
+	a, b := instances[0], instances[1]
+	if a.Pos.X != 8 || a.Pos.Z != 8 || a.Width != 16 || a.Height != 16 || a.Flip != 0 {
+		t.Fatal("incorrect first instance", a)
+	}
+	if b.Pos.X != 24 || b.Flip != 4 || b.U0 <= a.U1 {
+		t.Fatal("incorrect second instance", b)
+	}
+
+	// The instances must match the cards emitted by Load.
+	verts := Load(m, nil, images)["a"]["ts.png"].Meshes[0].Vertices
+	if verts[0].X != a.Pos.X-a.Width/2 || verts[0].Z != a.Pos.Z+a.Height/2 {
+		t.Fatal("instance does not match Load", verts[0], a)
+	}
+}
+
+const animatedMap = `<map version="1.0" orientation="orthogonal" width="2" height="1" tilewidth="16" tileheight="16">
+ <tileset firstgid="1" name="ts" tilewidth="16" tileheight="16">
+  <image source="ts.png" width="48" height="16"/>
+  <tile id="0">
+   <animation>
+    <frame tileid="1" duration="100"/>
+    <frame tileid="2" duration="300"/>
+   </animation>
+  </tile>
+ </tileset>
+ <layer name="a" width="2" height="1">
+  <data encoding="csv">1,2</data>
+ </layer>
+</map>`
+
+func TestAnimation(t *testing.T) {
+	m, err := Parse([]byte(animatedMap))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Frame{{1, 100 * time.Millisecond}, {2, 300 * time.Millisecond}}
+	if got := m.Tilesets[0].Tiles[0].Animation; !reflect.DeepEqual(got, want) {
+		t.Fatal("incorrect animation", got)
+	}
+	if got := roundTrip(t, m, nil).Tilesets[0].Tiles[0].Animation; !reflect.DeepEqual(got, want) {
+		t.Fatal("animation not preserved by writing", got)
+	}
+}
+
+func TestFlipbooks(t *testing.T) {
+	m, err := Parse([]byte(animatedMap))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rgba := image.NewRGBA(image.Rect(0, 0, 48, 16))
+	rgba.Set(16, 0, color.RGBA{255, 0, 0, 255})
+	rgba.Set(32, 0, color.RGBA{0, 255, 0, 255})
+
+	c := DefaultConfig()
+	c.Flipbooks = new(Flipbooks)
+	layer := Load(m, c, map[string]*image.RGBA{"ts.png": rgba})["a"]
+	if len(layer) != 2 {
+		t.Fatal("expected an object for the tileset and the animated tile", len(layer))
+	}
+	obj := layer["ts.png#0"]
+	strip := obj.Textures[0].Source.(*image.RGBA)
+	if strip.Bounds().Dx() != 32 || strip.RGBAAt(0, 0).R != 255 || strip.RGBAAt(16, 0).G != 255 {
+		t.Fatal("incorrect flipbook strip")
+	}
+
+	offset := func() float32 {
+		return obj.Shader.Inputs["FlipbookOffset"].(float32)
+	}
+	c.Flipbooks.Animate(150 * time.Millisecond)
+	if offset() != 0.5 {
+		t.Fatal("expected second frame", offset())
+	}
+	c.Flipbooks.Animate(300 * time.Millisecond)
+	if offset() != 0 {
+		t.Fatal("expected animation to loop", offset())
+	}
+}
+
+func TestLoadLOD(t *testing.T) {
+	m, err := Parse([]byte(`<map version="1.0" orientation="orthogonal" width="3" height="2" tilewidth="4" tileheight="4">
+ <tileset firstgid="1" name="ts" tilewidth="4" tileheight="4">
+  <image source="ts.png" width="8" height="4"/>
+ </tileset>
+ <layer name="a" width="3" height="2">
+  <data encoding="csv">1,0,2,
+0,0,2147483650</data>
+ </layer>
+</map>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The first tile is red, the second tile is green on it's left half.
+	rgba := image.NewRGBA(image.Rect(0, 0, 8, 4))
+	for y := 0; y < 4; y++ {
+		for x := 0; x < 4; x++ {
+			rgba.SetRGBA(x, y, color.RGBA{255, 0, 0, 255})
+		}
+		for x := 4; x < 6; x++ {
+			rgba.SetRGBA(x, y, color.RGBA{0, 255, 0, 255})
+		}
+	}
+	layer := LoadLOD(m, nil, map[string]*image.RGBA{"ts.png": rgba}, 2, 2)["a"]
+	if len(layer) != 2 {
+		t.Fatal("incorrect chunk count", len(layer))
+	}
+	img := layer["lod:0,0"].Textures[0].Source.(*image.RGBA)
+	if img.Bounds().Dx() != 4 || img.RGBAAt(0, 0) != (color.RGBA{255, 0, 0, 255}) || img.RGBAAt(2, 0).A != 0 {
+		t.Fatal("incorrect first chunk", img.Bounds(), img.RGBAAt(0, 0), img.RGBAAt(2, 0))
+	}
+	img = layer["lod:1,0"].Textures[0].Source.(*image.RGBA)
+	if img.RGBAAt(0, 0) != (color.RGBA{0, 255, 0, 255}) || img.RGBAAt(1, 0).A != 0 {
+		t.Fatal("incorrect second tile", img.RGBAAt(0, 0), img.RGBAAt(1, 0))
+	}
+	if img.RGBAAt(1, 2) != (color.RGBA{0, 255, 0, 255}) || img.RGBAAt(0, 2).A != 0 {
+		t.Fatal("incorrect flipped tile", img.RGBAAt(0, 2), img.RGBAAt(1, 2))
+	}
+}
+
+func TestWrap(t *testing.T) {
+	m := &Map{Width: 4, Height: 3, TileWidth: 10, TileHeight: 10}
+	if c := m.Wrap(Coord{-1, 7}); c != (Coord{3, 1}) {
+		t.Fatal("incorrect wrapped coordinates", c)
+	}
+	if p := m.WrapPixel(Pixel{41, -5}); p != (Pixel{1, 25}) {
+		t.Fatal("incorrect wrapped pixel", p)
+	}
+	if d := m.WrapDelta(Coord{0, 0}, Coord{3, 1}); d != (Coord{-1, 1}) {
+		t.Fatal("incorrect wrapped delta", d)
+	}
+}
+
+func TestRLETiles(t *testing.T) {
+	tiles := map[Coord]uint32{{0, 0}: 1, {1, 0}: 1, {3, 1}: 2}
+	r := NewRLETiles(4, 2, tiles)
+	if len(r.runs) != 3 {
+		t.Fatal("incorrect number of runs", r.runs)
+	}
+	if !reflect.DeepEqual(r.Map(), tiles) {
+		t.Fatal("incorrect tiles", r.Map())
+	}
+	if r.Get(Coord{1, 0}) != 1 || r.Get(Coord{2, 0}) != 0 || r.Get(Coord{9, 9}) != 0 {
+		t.Fatal("incorrect Get")
+	}
+
+	// Setting tiles must split and merge runs.
+	r.Set(Coord{2, 0}, 1)
+	r.Set(Coord{3, 1}, 0)
+	r.Set(Coord{0, 1}, 3)
+	want := map[Coord]uint32{{0, 0}: 1, {1, 0}: 1, {2, 0}: 1, {0, 1}: 3}
+	if !reflect.DeepEqual(r.Map(), want) {
+		t.Fatal("incorrect tiles after Set", r.Map())
+	}
+	if len(r.runs) != 4 {
+		t.Fatal("runs were not merged", r.runs)
+	}
+
+	// Random edits leave the same runs as encoding the result afresh.
+	rnd := rand.New(rand.NewSource(1))
+	r, tiles = NewRLETiles(8, 8, nil), make(map[Coord]uint32)
+	for i := 0; i < 2000; i++ {
+		c, gid := Coord{rnd.Intn(8), rnd.Intn(8)}, uint32(rnd.Intn(3))
+		r.Set(c, gid)
+		if gid == 0 {
+			delete(tiles, c)
+		} else {
+			tiles[c] = gid
+		}
+		if want := NewRLETiles(8, 8, tiles); !reflect.DeepEqual(r.runs, want.runs) {
+			t.Fatal("incorrect runs after setting", c, gid, r.runs, want.runs)
+		}
+	}
+
+	// Layers parsed with RLE storage must behave the same.
+	data, err := ioutil.ReadFile(filepath.Join("testdata", "test_csv.tmx"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := Parse(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rm, err := ParseWithConfig(data, &ParseConfig{RLETiles: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, rl := m.Layers[0], rm.Layers[0]
+	if rl.Tiles != nil || rl.RLE == nil {
+		t.Fatal("layer is not run-length encoded")
+	}
+	if !reflect.DeepEqual(l.GIDs(m), rl.GIDs(rm)) {
+		t.Fatal("run-length encoded layer differs")
+	}
+}
+
+func TestIncrementalLoader(t *testing.T) {
+	m, err := Parse([]byte(animatedMap))
+	if err != nil {
+		t.Fatal(err)
+	}
+	images := map[string]*image.RGBA{"ts.png": image.NewRGBA(image.Rect(0, 0, 48, 16))}
+	want := Load(m, nil, images)["a"]["ts.png"].Meshes[0].Vertices
+
+	l := NewIncrementalLoader(m, nil, images)
+	var steps int
+	for !l.Step(0) {
+		steps++
+	}
+	if steps != 2 {
+		t.Fatal("expected a step per column plus one to finish the layer, got", steps+1)
+	}
+	if got := l.Layers()["a"]["ts.png"].Meshes[0].Vertices; !reflect.DeepEqual(got, want) {
+		t.Fatal("incremental loading differs from Load")
+	}
+}
+
+func TestTileRect(t *testing.T) {
+	m, err := Parse([]byte(animatedMap))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := m.Tilesets[0]
+	ld := newLoader(m, DefaultConfig(), nil)
+	bounds := image.Rect(0, 0, 48, 16)
+	for gid := uint32(1); gid <= 4; gid++ {
+		want := m.TilesetRect(ts, 48, 16, true, gid)
+		if got := ld.tileRect(ts, bounds, gid|FLIPPED_VERTICALLY_FLAG); got != want {
+			t.Fatalf("gid %d: got %v want %v", gid, got, want)
+		}
+	}
+}
+
+func BenchmarkLoad(b *testing.B) {
+	m, err := Parse([]byte(animatedMap))
+	if err != nil {
+		b.Fatal(err)
+	}
+	m.Width, m.Height = 256, 256
+	l := m.Layers[0]
+	for y := 0; y < m.Height; y++ {
+		for x := 0; x < m.Width; x++ {
+			l.Tiles[Coord{x, y}] = uint32(1 + (x+y)%3)
+		}
+	}
+	images := map[string]*image.RGBA{"ts.png": image.NewRGBA(image.Rect(0, 0, 48, 16))}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		Load(m, nil, images)
+	}
+}
+
+func TestParser(t *testing.T) {
+	var p Parser
+	m := new(Map)
+	for _, name := range []string{"test_csv.tmx", "test_base64_zlib.tmx", "test_xml.tmx", "test_csv.tmx"} {
+		data, err := ioutil.ReadFile(filepath.Join("testdata", name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		want, err := Parse(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := p.Parse(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Fatal(name, "Parser.Parse differs from Parse")
+		}
+
+		var tiles map[Coord]uint32
+		if len(m.Layers) > 0 {
+			tiles = m.Layers[0].Tiles
+		}
+		if err := p.ParseInto(data, m); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(m, want) {
+			t.Fatal(name, "Parser.ParseInto differs from Parse")
+		}
+		if tiles != nil && reflect.ValueOf(m.Layers[0].Tiles).Pointer() != reflect.ValueOf(tiles).Pointer() {
+			t.Fatal(name, "tile map was not reused")
+		}
+	}
+}
+
+func TestDedupCards(t *testing.T) {
+	instances := []Instance{
+		{Pos: gfx.Vec3{8, 0, 8}, Width: 16, Height: 16, U1: 0.5, V1: 1},
+		{Pos: gfx.Vec3{24, 0, 8}, Width: 16, Height: 16, U1: 0.5, V1: 1},
+		{Pos: gfx.Vec3{40, 0, 8}, Width: 16, Height: 16, U1: 0.5, V1: 1, Flip: 4},
+	}
+	mesh, cards := DedupCards(instances)
+	if !reflect.DeepEqual(cards, []int{0, 0, 1}) {
+		t.Fatal("incorrect cards", cards)
+	}
+	if len(mesh.Vertices) != 8 || len(mesh.TexCoords[0].Slice) != 8 || len(mesh.Indices) != 12 {
+		t.Fatal("incorrect mesh size", len(mesh.Vertices), len(mesh.TexCoords[0].Slice), len(mesh.Indices))
+	}
+
+	// The horizontally flipped card has it's left and right corners swapped.
+	a, b := mesh.Vertices[0], mesh.Vertices[4]
+	if math.Abs(float64(a.X+b.X)) > 1e-4 || math.Abs(float64(a.Z-b.Z)) > 1e-4 {
+		t.Fatal("incorrect flipped card", a, b)
+	}
+}
+
+func TestMeshCache(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tmx")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	c := DefaultConfig()
+	c.MeshCache = dir
+	path := filepath.Join("testdata", "test_csv_tsx.tmx")
+	_, built, err := LoadFile(path, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	files, err := filepath.Glob(filepath.Join(dir, "*.tmxmesh"))
+	if err != nil || len(files) != 1 {
+		t.Fatal("expected a single mesh cache file", files, err)
+	}
+
+	_, cached, err := LoadFile(path, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cached) != len(built) {
+		t.Fatal("incorrect number of cached layers", len(cached), len(built))
+	}
+	for layer, objects := range built {
+		for name, obj := range objects {
+			co := cached[layer][name]
+			if co == nil || !reflect.DeepEqual(co.Meshes[0].Vertices, obj.Meshes[0].Vertices) {
+				t.Fatal("cached mesh differs for", layer, name)
+			}
+		}
+	}
+
+	// A different configuration must not use the same cache.
+	c.LayerOffset *= 2
+	if _, _, err := LoadFile(path, c); err != nil {
+		t.Fatal(err)
+	}
+	if files, _ := filepath.Glob(filepath.Join(dir, "*.tmxmesh")); len(files) != 2 {
+		t.Fatal("expected a second mesh cache file", files)
+	}
+}
+
+func TestTextObjects(t *testing.T) {
+	m, err := Parse([]byte(`<map version="1.8" orientation="orthogonal" width="4" height="4" tilewidth="16" tileheight="16">
+ <objectgroup name="signs">
+  <object x="8" y="16" width="32" height="16">
+   <text fontfamily="Serif" pixelsize="12" wrap="1" color="#ff0000" bold="1" kerning="0" halign="center">Hello, world!</text>
+  </object>
+ </objectgroup>
+</map>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &Text{
+		Text:       "Hello, world!",
+		FontFamily: "Serif",
+		PixelSize:  12,
+		Wrap:       true,
+		Color:      color.RGBA{255, 0, 0, 255},
+		Bold:       true,
+		HAlign:     "center",
+		VAlign:     "top",
+	}
+	o := m.ObjectGroups[0].Objects[0]
+	if !reflect.DeepEqual(o.Value, want) {
+		t.Fatalf("incorrect text %+v", o.Value)
+	}
+	if v := roundTrip(t, m, nil).ObjectGroups[0].Objects[0].Value; !reflect.DeepEqual(v, want) {
+		t.Fatalf("text not preserved by writing %+v", v)
+	}
+
+	c := DefaultConfig()
+	c.RasterizeText = func(o *Object, text *Text) *image.RGBA {
+		return image.NewRGBA(image.Rect(0, 0, o.Width, o.Height))
+	}
+	obj := Load(m, c, nil)["signs"]["text#0"]
+	if obj == nil {
+		t.Fatal("text object not loaded")
+	}
+	min, max := obj.Meshes[0].Vertices[0], obj.Meshes[0].Vertices[0]
+	for _, v := range obj.Meshes[0].Vertices {
+		min.X, min.Z = float32(math.Min(float64(min.X), float64(v.X))), float32(math.Min(float64(min.Z), float64(v.Z)))
+		max.X, max.Z = float32(math.Max(float64(max.X), float64(v.X))), float32(math.Max(float64(max.Z), float64(v.Z)))
+	}
+	if min.X != 8 || max.X != 40 || min.Z != 32 || max.Z != 48 {
+		t.Fatal("incorrect text card bounds", min, max)
+	}
+}
+
+func TestLoadShapes(t *testing.T) {
+	m, err := Parse([]byte(`<map version="1.8" orientation="orthogonal" width="8" height="8" tilewidth="16" tileheight="16">
+ <objectgroup name="zones" color="#ff0000" opacity="0.5">
+  <object x="0" y="0" width="16" height="8"/>
+  <object x="32" y="32">
+   <polygon points="0,0 32,0 32,32 16,32 16,16 0,16"/>
+  </object>
+  <object x="64" y="64" width="20" height="10">
+   <ellipse/>
+  </object>
+  <object x="0" y="64">
+   <polyline points="0,0 16,16"/>
+  </object>
+ </objectgroup>
+</map>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	obj := LoadShapes(m, nil, 64)["zones"]
+	if obj == nil {
+		t.Fatal("no shapes loaded")
+	}
+	mesh := obj.Meshes[0]
+	if mesh.Colors[0] != (gfx.Color{1, 0, 0, 0.5}) {
+		t.Fatal("incorrect shape color", mesh.Colors[0])
+	}
+	var area float64
+	v := mesh.Vertices
+	for i := 0; i+2 < len(v); i += 3 {
+		a, b, c := v[i], v[i+1], v[i+2]
+		area += math.Abs(float64((b.X-a.X)*(c.Z-a.Z)-(b.Z-a.Z)*(c.X-a.X))) / 2
+	}
+	want := 16*8 + (32*32 - 16*16) + math.Pi*10*5
+	if math.Abs(area-want) > 2 {
+		t.Fatalf("incorrect shape area %v want %v", area, want)
+	}
+}
+
+func TestGroupProperty(t *testing.T) {
+	m, err := Parse([]byte(`<map version="1.0" orientation="orthogonal" width="1" height="1" tilewidth="16" tileheight="16">
+ <tileset firstgid="1" name="ts" tilewidth="16" tileheight="16">
+  <image source="ts.png" width="16" height="16"/>
+ </tileset>
+ <layer name="ground" width="1" height="1">
+  <properties><property name="rendergroup" value="below"/></properties>
+  <data encoding="csv">1</data>
+ </layer>
+ <layer name="decor" width="1" height="1">
+  <properties><property name="rendergroup" value="below"/></properties>
+  <data encoding="csv">1</data>
+ </layer>
+ <layer name="roofs" width="1" height="1">
+  <data encoding="csv">1</data>
+ </layer>
+</map>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	images := map[string]*image.RGBA{"ts.png": image.NewRGBA(image.Rect(0, 0, 16, 16))}
+	c := DefaultConfig()
+	c.GroupProperty = "rendergroup"
+	layers := Load(m, c, images)
+	if len(layers) != 2 {
+		t.Fatal("expected two result sets, got", len(layers))
+	}
+	below := layers["below"]
+	if len(below) != 2 || below["ground/ts.png"] == nil || below["decor/ts.png"] == nil {
+		t.Fatal("incorrect grouped objects", below)
+	}
+	if layers["roofs"]["ts.png"] == nil {
+		t.Fatal("ungrouped layer missing")
+	}
+}
+
+func TestParseColor(t *testing.T) {
+	for s, want := range map[string]color.RGBA{
+		"#ff8000":   {255, 128, 0, 255},
+		"ff8000":    {255, 128, 0, 255},
+		"#80ff8000": {255, 128, 0, 128},
+		"#f80":      {255, 136, 0, 255},
+		" #f80 ":    {255, 136, 0, 255},
+	} {
+		got, err := ParseColor(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Fatalf("ParseColor(%q): got %v want %v", s, got, want)
+		}
+	}
+	for _, s := range []string{"", "#", "#ff80", "#gg8000", "#-f8000"} {
+		if _, err := ParseColor(s); err == nil {
+			t.Fatalf("ParseColor(%q): expected an error", s)
+		}
+		if c := hexToRGBA(s); c != (color.RGBA{0, 0, 0, 255}) {
+			t.Fatalf("hexToRGBA(%q): got %v", s, c)
+		}
+	}
+
+	m, err := Parse([]byte(`<map version="1.8" orientation="orthogonal" width="1" height="1" tilewidth="16" tileheight="16" backgroundcolor="#40102030"/>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := color.RGBA{0x10, 0x20, 0x30, 0x40}
+	if m.BackgroundColor != want {
+		t.Fatal("incorrect background color", m.BackgroundColor)
+	}
+	if c := roundTrip(t, m, nil).BackgroundColor; c != want {
+		t.Fatal("background color not preserved by writing", c)
+	}
+}
+
+func TestTileCount(t *testing.T) {
+	m, err := Parse([]byte(`<map version="1.0" orientation="orthogonal" width="2" height="1" tilewidth="16" tileheight="16">
+ <tileset firstgid="1" name="ts" tilewidth="16" tileheight="16" tilecount="6" columns="3">
+  <image source="ts.png" width="50" height="40"/>
+ </tileset>
+ <layer name="a" width="2" height="1">
+  <data encoding="csv">5,7</data>
+ </layer>
+</map>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := m.Tilesets[0]
+	if ts.TileCount != 6 || ts.Columns != 3 {
+		t.Fatal("incorrect tilecount or columns", ts.TileCount, ts.Columns)
+	}
+	if ts2 := roundTrip(t, m, nil).Tilesets[0]; ts2.TileCount != 6 || ts2.Columns != 3 {
+		t.Fatal("tilecount and columns not preserved by writing")
+	}
+	if !ts.HasGID(6) || ts.HasGID(7) || ts.HasGID(0) {
+		t.Fatal("incorrect HasGID")
+	}
+	if r := m.TilesetRect(ts, 50, 40, false, 5); r != image.Rect(16, 16, 32, 32) {
+		t.Fatal("incorrect rect", r)
+	}
+	if err := m.CheckGIDs(); err == nil {
+		t.Fatal("expected an error for the gid past the end of the tileset")
+	}
+
+	metrics := new(Metrics)
+	c := DefaultConfig()
+	c.Metrics = metrics
+	images := map[string]*image.RGBA{"ts.png": image.NewRGBA(image.Rect(0, 0, 50, 40))}
+	if obj := Load(m, c, images)["a"]["ts.png"]; len(obj.Meshes[0].Vertices) != 6 {
+		t.Fatal("expected only the valid tile to be loaded")
+	}
+	if metrics.TilesSkipped != 1 {
+		t.Fatal("expected one skipped tile, got", metrics.TilesSkipped)
+	}
+}
+
+func TestRectForGID(t *testing.T) {
+	ts := &Tileset{
+		Firstgid: 1,
+		Width:    16,
+		Height:   16,
+		Spacing:  2,
+		Margin:   1,
+		Image:    &Image{Width: 54, Height: 54},
+	}
+	want := image.Rect(19, 19, 35, 35)
+	if r := ts.RectForGID(5 | FLIPPED_HORIZONTALLY_FLAG); r != want {
+		t.Fatal("incorrect rect", r)
+	}
+	m := new(Map)
+	if r := m.TilesetRect(ts, 54, 54, true, 5); r != want {
+		t.Fatal("incorrect TilesetRect", r)
+	}
+	if r := m.TilesetRect(ts, 54, 54, false, 5); r != image.Rect(16, 16, 32, 32) {
+		t.Fatal("incorrect TilesetRect without spacing and margins", r)
+	}
+	ts.Image = &Image{}
+	if r := ts.RectForGID(5); !r.Empty() {
+		t.Fatal("expected an empty rect for an unknown image size", r)
+	}
+	ts.Columns = 3
+	if r := ts.RectForGID(5); r != want {
+		t.Fatal("incorrect rect using columns", r)
+	}
+}
+
+func TestInfiniteBounds(t *testing.T) {
+	m := parseFile(t, "test_infinite.tmx")
+	l := m.Layers[0]
+	if b := l.Bounds(m); b != image.Rect(-1, -1, 4, 2) {
+		t.Fatal("incorrect bounds", b)
+	}
+
+	// The bounds are kept up to date by SetTile.
+	l.SetTile(Coord{5, 5}, 1)
+	if b := l.Bounds(m); b != image.Rect(-1, -1, 6, 6) {
+		t.Fatal("incorrect bounds after adding a tile", b)
+	}
+	l.SetTile(Coord{0, 0}, 0)
+	if b := l.Bounds(m); b != image.Rect(-1, -1, 6, 6) {
+		t.Fatal("incorrect bounds after removing an inner tile", b)
+	}
+	l.SetTile(Coord{5, 5}, 0)
+	if b := l.Bounds(m); b != image.Rect(-1, -1, 4, 2) {
+		t.Fatal("incorrect bounds after removing an edge tile", b)
+	}
+
+	// Editing the tiles directly is only seen once the cell is marked dirty.
+	l.Tiles[Coord{-3, 0}] = 1
+	if b := l.Bounds(m); b != image.Rect(-1, -1, 4, 2) {
+		t.Fatal("bounds found again without marking the cell dirty", b)
+	}
+	l.MarkDirty(Coord{-3, 0})
+	if b := l.Bounds(m); b != image.Rect(-3, -1, 4, 2) {
+		t.Fatal("incorrect bounds after marking a cell dirty", b)
+	}
+
+	// The bounds follow the tiles moved by Crop.
+	m.Crop(image.Rect(-3, -1, 4, 2))
+	if b := l.Bounds(m); b != image.Rect(0, 0, 7, 3) {
+		t.Fatal("incorrect bounds after cropping", b)
+	}
+}
+
+func TestInfinite(t *testing.T) {
+	m := parseFile(t, "test_infinite.tmx")
+	if !m.Infinite {
+		t.Fatal("expected an infinite map")
+	}
+	want := map[Coord]uint32{{-1, -1}: 1, {0, 0}: 2, {3, 1}: 3}
+	l := m.Layers[0]
+	if !reflect.DeepEqual(l.Tiles, want) {
+		t.Fatal("incorrect tiles", l.Tiles)
+	}
+	if b := l.Bounds(m); b != image.Rect(-1, -1, 4, 2) {
+		t.Fatal("incorrect bounds", b)
+	}
+	for _, c := range []*WriteConfig{nil, {Encoding: "base64", Compression: "zlib"}, {Encoding: "csv"}} {
+		m2 := roundTrip(t, m, c)
+		if !m2.Infinite || !reflect.DeepEqual(m2.Layers[0].Tiles, want) {
+			t.Fatal("infinite map not preserved by writing", m2.Layers[0].Tiles)
+		}
+	}
+
+	_, layers, err := LoadFile("testdata/test_infinite.tmx", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mesh := layers["ground"]["tilesheet.png"].Meshes[0]
+	if len(mesh.Vertices) != 3*6 {
+		t.Fatal("expected three tiles, got vertices", len(mesh.Vertices))
+	}
+	min, max := mesh.Vertices[0], mesh.Vertices[0]
+	for _, v := range mesh.Vertices {
+		min.X, max.Z = float32(math.Min(float64(min.X), float64(v.X))), float32(math.Max(float64(max.Z), float64(v.Z)))
+	}
+	if min.X != -32 || max.Z != float32((m.Height+1)*m.TileHeight) {
+		t.Fatal("incorrect position of the tile at negative coordinates", min.X, max.Z)
+	}
+}
+
+func TestNegativeCoords(t *testing.T) {
+	m := parseFile(t, "test_infinite.tmx")
+	m.Tilesets[0].Width, m.Tilesets[0].Height = 32, 32
+	if b := m.Bounds(); b != image.Rect(-1, -1, 4, 2) {
+		t.Fatal("incorrect map bounds", b)
+	}
+	l := m.Layers[0]
+	gids := l.GIDsIn(image.Rect(-1, -1, 1, 1))
+	if !reflect.DeepEqual(gids, []uint32{1, 0, 0, 2}) {
+		t.Fatal("incorrect GIDsIn", gids)
+	}
+	if r := l.FloodRegion(m, -1, -1, nil); !reflect.DeepEqual(r, []Coord{{-1, -1}}) {
+		t.Fatal("incorrect flood region", r)
+	}
+	var found []Coord
+	m.TilesInRect("ground", image.Rect(-40, -40, -8, -8), func(c Coord, gid uint32) bool {
+		found = append(found, c)
+		return true
+	})
+	if !reflect.DeepEqual(found, []Coord{{-1, -1}}) {
+		t.Fatal("incorrect tiles in rect", found)
+	}
+	solid := func(gid uint32) bool { return true }
+	c, _, ok := m.Raycast("ground", Pixel{-48, -16}, Pixel{16, -16}, solid)
+	if !ok || c != (Coord{-1, -1}) {
+		t.Fatal("incorrect raycast", c, ok)
+	}
+	var edges int
+	for _, e := range m.Occluders("ground", solid) {
+		in := func(p Pixel) bool {
+			return p.X >= -32 && p.X <= 0 && p.Y >= -32 && p.Y <= 0
+		}
+		if in(e.A) && in(e.B) {
+			edges++
+		}
+	}
+	if edges != 4 {
+		t.Fatal("expected four edges around the tile at negative coordinates, got", edges)
+	}
+}
+
+func TestGroupLayers(t *testing.T) {
+	m, err := Parse([]byte(`<map version="1.8" orientation="orthogonal" width="4" height="4" tilewidth="16" tileheight="16">
+ <group name="outer" offsetx="10" opacity="0.5" parallaxx="0.5">
+  <group name="inner" offsety="5" opacity="0.5" visible="0">
+   <imagelayer name="bg" offsetx="1">
+    <image source="bg.png" width="8" height="8"/>
+   </imagelayer>
+  </group>
+  <objectgroup name="objects"/>
+ </group>
+ <layer name="top" width="4" height="4">
+  <data encoding="csv">0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0</data>
+ </layer>
+</map>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m.Groups) != 2 || m.Groups[1].Parent != m.Groups[0] {
+		t.Fatal("incorrect groups", m.Groups)
+	}
+	bg := m.ImageLayers[0]
+	if bg.Index != 2 || m.ObjectGroups[0].Index != 3 || m.Layers[0].Index != 4 || m.Layers[0].Parent != nil {
+		t.Fatal("incorrect draw order", bg.Index, m.ObjectGroups[0].Index, m.Layers[0].Index)
+	}
+	if x, y := bg.EffectiveOffset(); x != 11 || y != 5 {
+		t.Fatal("incorrect effective offset", x, y)
+	}
+	if o := bg.EffectiveOpacity(); o != 0.25 {
+		t.Fatal("incorrect effective opacity", o)
+	}
+	if bg.EffectiveVisible() || !m.ObjectGroups[0].EffectiveVisible() {
+		t.Fatal("incorrect effective visibility")
+	}
+	if x, y := m.ObjectGroups[0].EffectiveParallax(); x != 0.5 || y != 1 {
+		t.Fatal("incorrect effective parallax", x, y)
+	}
+
+	m2 := roundTrip(t, m, nil)
+	compareMaps(t, m, m2)
+	if len(m2.Groups) != 2 || m2.ImageLayers[0].Parent != m2.Groups[1] || m2.ObjectGroups[0].Parent != m2.Groups[0] {
+		t.Fatal("groups not preserved by writing")
+	}
+
+	img := image.NewRGBA(image.Rect(0, 0, 8, 8))
+	obj := Load(m, nil, map[string]*image.RGBA{"bg.png": img})["bg"]["bg.png"]
+	if obj == nil {
+		t.Fatal("image layer not loaded")
+	}
+	mesh := obj.Meshes[0]
+	minX := mesh.Vertices[0].X
+	for _, v := range mesh.Vertices {
+		minX = float32(math.Min(float64(minX), float64(v.X)))
+	}
+	if minX != 11 {
+		t.Fatal("incorrect image layer position", minX)
+	}
+	if len(mesh.Colors) != len(mesh.Vertices) || mesh.Colors[0].A != 0.25 || obj.State.AlphaMode != gfx.AlphaBlend {
+		t.Fatal("image layer opacity not applied", mesh.Colors)
+	}
+}
+
+func TestGroupLayersSameName(t *testing.T) {
+	m, err := Parse([]byte(`<map version="1.0" orientation="orthogonal" width="1" height="1" tilewidth="16" tileheight="16">
+ <tileset firstgid="1" name="tiles" tilewidth="16" tileheight="16">
+  <image source="tiles.png" width="16" height="16"/>
+ </tileset>
+ <group name="faded" opacity="0.5">
+  <layer name="a" width="1" height="1">
+   <data encoding="csv">1</data>
+  </layer>
+ </group>
+ <group name="solid">
+  <layer name="a" width="1" height="1">
+   <data encoding="csv">1</data>
+  </layer>
+ </group>
+</map>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The opaque layer is loaded last, and must not be made translucent by
+	// the faded layer of the same name.
+	img := image.NewRGBA(image.Rect(0, 0, 16, 16))
+	obj := Load(m, nil, map[string]*image.RGBA{"tiles.png": img})["a"]["tiles.png"]
+	if obj.State.AlphaMode == gfx.AlphaBlend || len(obj.Meshes[0].Colors) != 0 {
+		t.Fatal("opaque layer made translucent by a layer of the same name")
+	}
+}
+
+func TestMaterials(t *testing.T) {
+	tex := gfx.NewTexture()
+	shader := gfx.NewShader("custom")
+	c := DefaultConfig()
+	c.Metrics = new(Metrics)
+	c.Materials = map[string]*Material{
+		"tilesheet.png": {Texture: tex, Shader: shader},
+	}
+	_, layers, err := LoadFile("testdata/test_infinite.tmx", c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	obj := layers["ground"]["tilesheet.png"]
+	if obj.Textures[0] != tex || obj.Shader != shader {
+		t.Fatal("material not used")
+	}
+	if c.Metrics.Textures != 0 {
+		t.Fatal("expected no textures to be created, got", c.Metrics.Textures)
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	c := DefaultConfig()
+	c.Metrics = new(Metrics)
+	m, layers, err := LoadFile("testdata/test_base64_zlib.tmx", c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var objects int
+	for _, objs := range layers {
+		objects += len(objs)
+	}
+	var tiles int
+	for _, l := range m.Layers {
+		l.EachTile(func(c Coord, gid uint32) {
+			tiles++
+		})
+	}
+	got := *c.Metrics
+	if got.TilesEmitted != tiles || got.TilesSkipped != 0 {
+		t.Fatal("incorrect tile counts", got.TilesEmitted, got.TilesSkipped, tiles)
+	}
+	if got.Vertices != 6*tiles || got.Textures != objects {
+		t.Fatal("incorrect vertex or texture counts", got.Vertices, got.Textures)
+	}
+	if got.BytesDecompressed != int64(4*m.Width*m.Height*len(m.Layers)) {
+		t.Fatal("incorrect decompressed byte count", got.BytesDecompressed)
+	}
+	if got.Parse <= 0 || got.Mesh <= 0 {
+		t.Fatal("stage durations not recorded", got.Parse, got.Mesh)
+	}
+
+	// Metrics accumulate across loads, and tiles without an image are
+	// skipped.
+	Load(m, c, nil)
+	if c.Metrics.TilesEmitted != tiles || c.Metrics.TilesSkipped != tiles || c.Metrics.Textures != objects {
+		t.Fatal("incorrect accumulated metrics", c.Metrics)
+	}
+}
+
+func TestTextureCache(t *testing.T) {
+	c := DefaultConfig()
+	c.Metrics = new(Metrics)
+	c.Textures = NewTextureCache()
+	var textures []*gfx.Texture
+	for i := 0; i < 2; i++ {
+		_, layers, err := LoadFile("testdata/test_infinite.tmx", c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		textures = append(textures, layers["ground"]["tilesheet.png"].Textures[0])
+	}
+	if textures[0] != textures[1] || c.Textures.Len() != 1 {
+		t.Fatal("texture not shared between loads")
+	}
+	if c.Metrics.Textures != 1 {
+		t.Fatal("expected a single texture to be created, got", c.Metrics.Textures)
+	}
+}
+
+func TestReleaseLayers(t *testing.T) {
+	c := DefaultConfig()
+	c.Textures = NewTextureCache()
+	var loaded []map[string]map[string]*gfx.Object
+	for i := 0; i < 2; i++ {
+		_, layers, err := LoadFile("testdata/test_infinite.tmx", c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		loaded = append(loaded, layers)
+	}
+
+	ReleaseLayers(c, loaded[0])
+	obj := loaded[0]["ground"]["tilesheet.png"]
+	if obj.Meshes != nil || obj.Textures != nil {
+		t.Fatal("object not released")
+	}
+	if c.Textures.Len() != 1 {
+		t.Fatal("texture released while still in use")
+	}
+	ReleaseLayers(c, loaded[1])
+	if c.Textures.Len() != 0 {
+		t.Fatal("texture not released")
+	}
+}
+
+func TestObjectInfo(t *testing.T) {
+	m, err := Parse([]byte(`<map version="1.0" orientation="orthogonal" width="1" height="1" tilewidth="16" tileheight="16">
+ <tileset firstgid="1" name="ts" tilewidth="16" tileheight="16">
+  <image source="ts.png" width="16" height="16"/>
+ </tileset>
+ <layer name="ground" width="1" height="1">
+  <properties>
+   <property name="biome" value="desert"/>
+  </properties>
+  <data encoding="csv">1</data>
+ </layer>
+ <imagelayer name="sky">
+  <image source="sky.png" width="16" height="16"/>
+ </imagelayer>
+ <objectgroup name="things">
+  <object x="0" y="16" gid="1"/>
+ </objectgroup>
+</map>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := DefaultConfig()
+	c.Info = make(map[*gfx.Object]*ObjectInfo)
+	img := image.NewRGBA(image.Rect(0, 0, 16, 16))
+	layers := Load(m, c, map[string]*image.RGBA{"ts.png": img, "sky.png": img})
+	if len(c.Info) != 3 {
+		t.Fatal("expected info for three objects, got", len(c.Info))
+	}
+	for _, want := range []struct{ layer, image string }{
+		{"ground", "ts.png"},
+		{"sky", "sky.png"},
+		{"things", "ts.png"},
+	} {
+		obj := layers[want.layer][want.image]
+		info := c.Info[obj]
+		if info == nil {
+			t.Fatal("no info for object", want.layer, want.image)
+		}
+		if name := "tmx:" + want.layer + "/" + want.image; info.Name != name || info.String() != name {
+			t.Fatal("incorrect object name", info.Name, name)
+		}
+		if info.Layer != want.layer || info.Image != want.image {
+			t.Fatal("incorrect object source", info.Layer, info.Image)
+		}
+	}
+	if props := c.Info[layers["ground"]["ts.png"]].Properties; props["biome"] != "desert" {
+		t.Fatal("layer properties not attached", props)
+	}
+}
+
+func TestTileObjects(t *testing.T) {
+	m, err := Parse([]byte(`<map version="1.0" orientation="orthogonal" width="2" height="2" tilewidth="16" tileheight="16">
+ <tileset firstgid="1" name="ts" tilewidth="16" tileheight="16">
+  <image source="ts.png" width="32" height="16"/>
+ </tileset>
+ <layer name="a" width="2" height="2">
+  <data encoding="csv">1,0,
+0,2</data>
+ </layer>
+</map>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := DefaultConfig()
+	c.TileObjects = make(map[TileKey]*gfx.Object)
+	c.Info = make(map[*gfx.Object]*ObjectInfo)
+	layers := Load(m, c, map[string]*image.RGBA{"ts.png": image.NewRGBA(image.Rect(0, 0, 32, 16))})
+	if _, ok := layers["a"]; ok {
+		t.Fatal("tiles also loaded into the layer objects")
+	}
+
+	// One object per tile, keyed by it's coordinates, sharing one texture.
+	if len(c.TileObjects) != 2 {
+		t.Fatal("expected two tile objects, got", len(c.TileObjects))
+	}
+	first, second := c.TileObjects[TileKey{"a", Coord{0, 0}}], c.TileObjects[TileKey{"a", Coord{1, 1}}]
+	if first == nil || second == nil || first == second {
+		t.Fatal("incorrect tile object keys", c.TileObjects)
+	}
+	if first.Textures[0] != second.Textures[0] {
+		t.Fatal("tile objects do not share their texture")
+	}
+	for _, obj := range c.TileObjects {
+		if n := len(obj.Meshes[0].Vertices); n != 6 {
+			t.Fatal("expected a single card per tile object, got vertices", n)
+		}
+	}
+
+	// The tile objects are described by name, while the dropped layer object
+	// is not described at all.
+	if len(c.Info) != 2 {
+		t.Fatal("expected info for the two tile objects only, got", len(c.Info))
+	}
+	if info := c.Info[first]; info == nil || info.Name != "tmx:a/ts.png@0,0" || info.Layer != "a" || info.Image != "ts.png" {
+		t.Fatal("incorrect tile object info", info)
+	}
+	if info := c.Info[second]; info == nil || info.Name != "tmx:a/ts.png@1,1" {
+		t.Fatal("incorrect tile object info", info)
+	}
+
+	// Likewise the tile objects are registered with the effects, in place of
+	// the dropped layer object.
+	m.Layers[0].Properties = map[string]string{"scrollx": "4"}
+	c = DefaultConfig()
+	c.TileObjects = make(map[TileKey]*gfx.Object)
+	c.Faders = &Faders{}
+	c.Graders = &Graders{}
+	c.Scrollers = &Scrollers{}
+	Load(m, c, map[string]*image.RGBA{"ts.png": image.NewRGBA(image.Rect(0, 0, 32, 16))})
+	first, second = c.TileObjects[TileKey{"a", Coord{0, 0}}], c.TileObjects[TileKey{"a", Coord{1, 1}}]
+	registered := func(objs []*gfx.Object) bool {
+		return len(objs) == 2 && objs[0] == first && objs[1] == second
+	}
+	if objs := c.Faders.layers["a"].objects; !registered(objs) {
+		t.Fatal("incorrect faded objects", objs)
+	}
+	if objs := c.Graders.layers["a"].objects; !registered(objs) {
+		t.Fatal("incorrect graded objects", objs)
+	}
+	if n := len(c.Scrollers.scrollers); n != 2 || c.Scrollers.scrollers[0].obj != first || c.Scrollers.scrollers[1].obj != second {
+		t.Fatal("incorrect scrolled objects", n)
+	}
+	if first.Textures[0].WrapU != gfx.Repeat {
+		t.Fatal("scrolled texture does not repeat")
+	}
+}
+
+func TestReleaseTileObjects(t *testing.T) {
+	c := DefaultConfig()
+	c.Textures = NewTextureCache()
+	var loaded []map[TileKey]*gfx.Object
+	for i := 0; i < 2; i++ {
+		c.TileObjects = make(map[TileKey]*gfx.Object)
+		if _, _, err := LoadFile("testdata/test_infinite.tmx", c); err != nil {
+			t.Fatal(err)
+		}
+		if len(c.TileObjects) < 2 {
+			t.Fatal("expected several tile objects, got", len(c.TileObjects))
+		}
+		loaded = append(loaded, c.TileObjects)
+	}
+	if c.Textures.Len() != 1 {
+		t.Fatal("texture not shared between loads")
+	}
+
+	// Releasing the tile objects of one map must not destroy the texture used
+	// by the other, and releasing both must remove it from the cache.
+	ReleaseTileObjects(c, loaded[0])
+	for _, obj := range loaded[0] {
+		if obj.Meshes != nil || obj.Textures != nil {
+			t.Fatal("tile object not released")
+		}
+	}
+	if c.Textures.Len() != 1 {
+		t.Fatal("texture released while still in use")
+	}
+	ReleaseTileObjects(c, loaded[1])
+	if c.Textures.Len() != 0 {
+		t.Fatal("texture not released")
+	}
+
+	// Rebuilding a layer releases the tile objects it replaces.
+	c.TileObjects = make(map[TileKey]*gfx.Object)
+	m, layers, err := LoadFile("testdata/test_infinite.tmx", c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var rgba *image.RGBA
+	for _, obj := range c.TileObjects {
+		rgba = obj.Textures[0].Source.(*image.RGBA)
+		break
+	}
+	m.Layers[0].SetTile(Coord{5, 5}, 1)
+	RebuildDirty(layers, m, c, map[string]*image.RGBA{"tilesheet.png": rgba})
+	ReleaseTileObjects(c, c.TileObjects)
+	if c.Textures.Len() != 0 {
+		t.Fatal("texture not released after rebuild")
+	}
+}
+
+func TestTopLeftOrigin(t *testing.T) {
+	c := DefaultConfig()
+	c.TopLeftOrigin = true
+	_, layers, err := LoadFile("testdata/test_infinite.tmx", c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mesh := layers["ground"]["tilesheet.png"].Meshes[0]
+	min, max := mesh.Vertices[0], mesh.Vertices[0]
+	for _, v := range mesh.Vertices {
+		min.Z = float32(math.Min(float64(min.Z), float64(v.Z)))
+		max.Z = float32(math.Max(float64(max.Z), float64(v.Z)))
+	}
+	// Tiles span rows -1 through 1 of the infinite map, 32 pixels each.
+	if min.Z != -32 || max.Z != 64 {
+		t.Fatal("incorrect vertical extent with top-left origin", min.Z, max.Z)
+	}
+}
+
+func TestWorldConversion(t *testing.T) {
+	m := parseFile(t, "test_objects.tmx")
+	for _, topLeft := range []bool{false, true} {
+		c := DefaultConfig()
+		c.TopLeftOrigin = topLeft
+		p := Pixel{12.5, 40.25}
+		if p2 := WorldToPixel(m, c, PixelToWorld(m, c, p)); p2 != p {
+			t.Fatal("pixel not preserved by conversion", p, p2)
+		}
+
+		g := m.ObjectGroups[0]
+		o := g.Objects[0]
+		v := ObjectToWorld(m, c, g, o)
+		if p := WorldToPixel(m, c, v); p != (Pixel{float64(o.X), float64(o.Y)}) {
+			t.Fatal("incorrect object position", p)
+		}
+		if v.Y != -float64(g.Index)*c.LayerOffset {
+			t.Fatal("incorrect object depth", v.Y)
+		}
+
+		v = TileToWorld(m, c, m.Layers[0], Coord{1, 2})
+		if p := WorldToPixel(m, c, v); p != (Pixel{48, 80}) {
+			t.Fatal("incorrect tile center", p)
+		}
+	}
+}
+
+// topDownCamera is a stub camera looking straight down the Y axis, whose view
+// covers the world from the origin to the given size on the X and Z axes.
+type topDownCamera struct {
+	width, height float64
+}
+
+func (cam topDownCamera) Unproject(p lmath.Vec2) (near, far lmath.Vec3, ok bool) {
+	x := (p.X + 1) / 2 * cam.width
+	z := (p.Y + 1) / 2 * cam.height
+	return lmath.Vec3{x, 10, z}, lmath.Vec3{x, -10, z}, true
+}
+
+func TestPick(t *testing.T) {
+	m, err := Parse([]byte(`<map version="1.0" orientation="orthogonal" width="4" height="2" tilewidth="16" tileheight="16">
+ <objectgroup name="things">
+  <object name="crate" x="40" y="16" width="16" height="16"/>
+ </objectgroup>
+</map>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The window is offset, and one window pixel covers one map pixel.
+	cam := topDownCamera{64, 32}
+	bounds := image.Rect(100, 100, 164, 132)
+	tile, obj, ok := pick(m, nil, cam, bounds, image.Pt(120, 105))
+	if !ok || tile != (Coord{1, 0}) || obj != nil {
+		t.Fatal("incorrect pick", tile, obj, ok)
+	}
+	tile, obj, ok = pick(m, nil, cam, bounds, image.Pt(145, 120))
+	if !ok || tile != (Coord{2, 1}) || obj == nil || obj.Name != "crate" {
+		t.Fatal("incorrect object pick", tile, obj, ok)
+	}
+	m.ObjectGroups[0].Objects[0].Visible = false
+	if _, obj, _ = pick(m, nil, cam, bounds, image.Pt(145, 120)); obj != nil {
+		t.Fatal("picked invisible object", obj)
+	}
+	if _, _, ok = pick(m, nil, topDownCamera{128, 32}, bounds, image.Pt(150, 105)); ok {
+		t.Fatal("picked a cell outside of the map")
+	}
+
+	// Load lays out the cells of isometric maps orthogonally, and so does
+	// Pick.
+	m.Orientation = Isometric
+	if tile, _, ok = pick(m, nil, cam, bounds, image.Pt(120, 105)); !ok || tile != (Coord{1, 0}) {
+		t.Fatal("incorrect isometric pick", tile, ok)
+	}
+}
+
+func TestScrollers(t *testing.T) {
+	m, err := Parse([]byte(`<map version="1.0" orientation="orthogonal" width="1" height="1" tilewidth="16" tileheight="8">
+ <tileset firstgid="1" name="water" tilewidth="16" tileheight="8">
+  <image source="water.png" width="16" height="8"/>
+ </tileset>
+ <layer name="still" width="1" height="1">
+  <data encoding="csv">1</data>
+ </layer>
+ <imagelayer name="falls">
+  <properties>
+   <property name="scrollx" value="8"/>
+   <property name="scrolly" value="-4"/>
+  </properties>
+  <image source="water.png" width="16" height="8"/>
+ </imagelayer>
+</map>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := DefaultConfig()
+	c.Scrollers = new(Scrollers)
+	c.Textures = NewTextureCache()
+	images := map[string]*image.RGBA{"water.png": image.NewRGBA(image.Rect(0, 0, 16, 8))}
+	layers := Load(m, c, images)
+	still, falls := layers["still"]["water.png"], layers["falls"]["water.png"]
+
+	// The scrolled image gets a repeating texture of it's own, and must not
+	// change the wrap mode of the cached texture used by the tile layer.
+	if falls.Textures[0] == still.Textures[0] {
+		t.Fatal("scrolled object shares the cached texture")
+	}
+	if tex := falls.Textures[0]; tex.WrapU != gfx.Repeat || tex.WrapV != gfx.Repeat {
+		t.Fatal("scrolled texture does not repeat")
+	}
+	if tex := still.Textures[0]; tex.WrapU != gfx.Clamp || tex.WrapV != gfx.Clamp {
+		t.Fatal("wrap mode of the cached texture changed")
+	}
+
+	// Scrolling 8 pixels per second to the right moves the texture
+	// coordinates of the 16 pixel wide image left by a quarter in half a
+	// second, while scrolling up moves them down.
+	base := append([]gfx.TexCoord(nil), falls.Meshes[0].TexCoords[0].Slice...)
+	check := func(du, dv float32) {
+		set := falls.Meshes[0].TexCoords[0]
+		if !set.Changed {
+			t.Fatal("texture coordinates not marked as changed")
+		}
+		for i, tc := range set.Slice {
+			if tc.U != base[i].U+du || tc.V != base[i].V+dv {
+				t.Fatal("incorrect texture coordinates", tc, base[i], du, dv)
+			}
+		}
+	}
+	c.Scrollers.Animate(500 * time.Millisecond)
+	check(-0.25, 0.25)
+
+	// The offsets wrap around, such that they stay small.
+	c.Scrollers.Animate(2 * time.Second)
+	check(-0.25, 0.25)
+	if still.Meshes[0].TexCoords[0].Changed {
+		t.Fatal("unscrolled object was scrolled")
+	}
+}
+
+func TestFaders(t *testing.T) {
+	c := DefaultConfig()
+	c.Faders = new(Faders)
+	_, layers, err := LoadFile("testdata/test_infinite.tmx", c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mesh := layers["ground"]["tilesheet.png"].Meshes[0]
+	if len(mesh.Colors) != len(mesh.Vertices) {
+		t.Fatal("expected vertex colors for fading")
+	}
+
+	c.Faders.Fade("ground", 0.5, time.Second)
+	c.Faders.Animate(500 * time.Millisecond)
+	if o := c.Faders.Opacity("ground"); o != 0.75 || mesh.Colors[0].A != 0.75 {
+		t.Fatal("incorrect opacity halfway through fade", o, mesh.Colors[0].A)
+	}
+	c.Faders.Animate(time.Second)
+	if o := c.Faders.Opacity("ground"); o != 0.5 || mesh.Colors[0].A != 0.5 {
+		t.Fatal("incorrect opacity after fade", o, mesh.Colors[0].A)
+	}
+	c.Faders.Fade("ground", 1, 0)
+	if mesh.Colors[0].A != 1 {
+		t.Fatal("immediate fade not applied", mesh.Colors[0].A)
+	}
+}
+
+func TestReveals(t *testing.T) {
+	m, err := Parse([]byte(`<map version="1.8" orientation="orthogonal" width="8" height="8" tilewidth="16" tileheight="16">
+ <objectgroup name="regions">
+  <object class="reveal" x="16" y="16" width="32" height="32">
+   <properties>
+    <property name="layer" value="roof"/>
+   </properties>
+  </object>
+  <object class="reveal" x="64" y="16">
+   <properties>
+    <property name="layer" value="roof"/>
+   </properties>
+   <polygon points="0,0 32,0 32,32"/>
+  </object>
+ </objectgroup>
+</map>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := NewReveals(m)
+	if changed := r.Update(Pixel{0, 0}); len(changed) != 0 || r.Hidden("roof") {
+		t.Fatal("layer hidden outside of regions", changed)
+	}
+	if changed := r.Update(Pixel{20, 40}); !reflect.DeepEqual(changed, []string{"roof"}) || !r.Hidden("roof") {
+		t.Fatal("layer not hidden inside region", changed)
+	}
+	if changed := r.Update(Pixel{90, 20}); len(changed) != 0 || !r.Hidden("roof") {
+		t.Fatal("layer shown inside of another region", changed)
+	}
+	if changed := r.Update(Pixel{70, 40}); !reflect.DeepEqual(changed, []string{"roof"}) || r.Hidden("roof") {
+		t.Fatal("layer not shown outside of polygon", changed)
+	}
+}
+
+func TestStamp(t *testing.T) {
+	l := &Layer{Tiles: map[Coord]uint32{
+		{1, 1}: 1, {2, 1}: 2,
+		{1, 2}: 3 | FLIPPED_HORIZONTALLY_FLAG,
+	}}
+	s := l.CaptureStamp(image.Rect(1, 1, 3, 3))
+	if s.Width != 2 || s.Height != 2 || !reflect.DeepEqual(s.GIDs, []uint32{1, 2, 3 | FLIPPED_HORIZONTALLY_FLAG, 0}) {
+		t.Fatal("incorrect stamp", s)
+	}
+
+	l2 := &Layer{Tiles: map[Coord]uint32{{6, 6}: 9}}
+	l2.ApplyStamp(5, 5, s)
+	want := map[Coord]uint32{
+		{5, 5}: 1, {6, 5}: 2,
+		{5, 6}: 3 | FLIPPED_HORIZONTALLY_FLAG, {6, 6}: 9,
+	}
+	if !reflect.DeepEqual(l2.Tiles, want) {
+		t.Fatal("incorrect tiles after applying stamp", l2.Tiles)
+	}
+}
+
+func TestJournal(t *testing.T) {
+	l := &Layer{Tiles: map[Coord]uint32{{0, 0}: 1}}
+	g := &ObjectGroup{}
+	o := &Object{Name: "a", Properties: map[string]string{"k": "v"}}
+
+	var j Journal
+	j.SetTile(l, Coord{0, 0}, 2)
+	j.ApplyStamp(l, 1, 0, &Stamp{Width: 2, Height: 1, GIDs: []uint32{3, 4}})
+	j.AddObject(g, o)
+	j.EditObject(o, func(o *Object) {
+		o.Name = "b"
+		o.Properties["k"] = "w"
+	})
+
+	// Undo the object edits, then the stamp as a single step.
+	for i := 0; i < 3; i++ {
+		if !j.Undo() {
+			t.Fatal("expected step to undo")
+		}
+	}
+	if o.Name != "a" || o.Properties["k"] != "v" || len(g.Objects) != 0 {
+		t.Fatal("object edits not undone", o, g.Objects)
+	}
+	if !reflect.DeepEqual(l.Tiles, map[Coord]uint32{{0, 0}: 2}) {
+		t.Fatal("stamp not undone", l.Tiles)
+	}
+	j.Undo()
+	if j.CanUndo() || l.Tile(Coord{0, 0}) != 1 {
+		t.Fatal("tile not undone", l.Tiles)
+	}
+
+	for j.Redo() {
+	}
+	want := map[Coord]uint32{{0, 0}: 2, {1, 0}: 3, {2, 0}: 4}
+	if !reflect.DeepEqual(l.Tiles, want) || len(g.Objects) != 1 || o.Name != "b" || o.Properties["k"] != "w" {
+		t.Fatal("edits not redone", l.Tiles, g.Objects, o)
+	}
+
+	j.Undo()
+	j.SetTile(l, Coord{5, 5}, 1)
+	if j.CanRedo() {
+		t.Fatal("expected redo steps to be discarded by a new edit")
+	}
+}
+
+func TestRebuildDirty(t *testing.T) {
+	m, layers, err := LoadFile("testdata/test_infinite.tmx", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := m.Layers[0]
+	obj := layers["ground"]["tilesheet.png"]
+	if !l.Dirty().Empty() {
+		t.Fatal("expected parsed layer to be clean")
+	}
+
+	l.SetTile(Coord{5, 5}, 1)
+	l.SetTile(Coord{6, 7}, 2)
+	if d := l.Dirty(); d != image.Rect(5, 5, 7, 8) {
+		t.Fatal("incorrect dirty rectangle", d)
+	}
+
+	rgba := obj.Textures[0].Source.(*image.RGBA)
+	RebuildDirty(layers, m, nil, map[string]*image.RGBA{"tilesheet.png": rgba})
+	if !l.Dirty().Empty() {
+		t.Fatal("expected rebuilt layer to be clean")
+	}
+	if layers["ground"]["tilesheet.png"] != obj {
+		t.Fatal("expected existing object to be reused")
+	}
+	mesh := obj.Meshes[0]
+	if len(mesh.Vertices) != 5*6 || !mesh.VerticesChanged {
+		t.Fatal("expected five tiles after rebuild, got vertices", len(mesh.Vertices))
+	}
+}
+
+func TestRebuildDirtyChunks(t *testing.T) {
+	c := DefaultConfig()
+	c.ChunkSize = 8
+	m, layers, err := LoadFile("testdata/test_csv.tmx", c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := m.Layers[0]
+	objects := layers[l.Name]
+	if len(objects) != 6 || objects["tilesheet.png:7,0"] == nil {
+		t.Fatal("incorrect chunks", len(objects))
+	}
+	_, whole, err := LoadFile("testdata/test_csv.tmx", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var vertices int
+	for _, obj := range objects {
+		vertices += len(obj.Meshes[0].Vertices)
+	}
+	if n := len(whole[l.Name]["tilesheet.png"].Meshes[0].Vertices); vertices != n {
+		t.Fatal("chunks hold", vertices, "vertices, want", n)
+	}
+
+	// Only the chunk holding the changed cell is rebuilt, like it is loaded.
+	before := make(map[string][]gfx.Vec3)
+	for name, obj := range objects {
+		before[name] = obj.Meshes[0].Vertices
+	}
+	l.SetTile(Coord{9, 2}, l.Tile(Coord{9, 2})%20+1)
+	rgba := objects["tilesheet.png:0,0"].Textures[0].Source.(*image.RGBA)
+	images := map[string]*image.RGBA{"tilesheet.png": rgba}
+	RebuildDirty(layers, m, c, images)
+	want := Load(m, c, images)[l.Name]
+	for name, obj := range objects {
+		mesh := obj.Meshes[0]
+		if rebuilt := name == "tilesheet.png:1,0"; mesh.VerticesChanged != rebuilt {
+			t.Fatal("chunk", name, "rebuilt:", mesh.VerticesChanged)
+		}
+		if !reflect.DeepEqual(mesh.Vertices, want[name].Meshes[0].Vertices) {
+			t.Fatal("chunk", name, "differs from a fresh load")
+		}
+		if name != "tilesheet.png:1,0" && reflect.ValueOf(mesh.Vertices).Pointer() != reflect.ValueOf(before[name]).Pointer() {
+			t.Fatal("chunk", name, "was rebuilt")
+		}
+	}
+
+	// Chunks holding wrapped copies of the changed cell are rebuilt too.
+	wc := DefaultConfig()
+	wc.ChunkSize, wc.WrapX = 8, 2
+	wm, wrapped, err := LoadFile("testdata/test_csv.tmx", wc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wm.Layers[0].SetTile(Coord{0, 6}, 5)
+	RebuildDirty(wrapped, wm, wc, images)
+	want = Load(wm, wc, images)[l.Name]
+	for _, name := range []string{"tilesheet.png:-1,0", "tilesheet.png:0,0", "tilesheet.png:7,0"} {
+		obj := wrapped[l.Name][name]
+		if obj == nil || !reflect.DeepEqual(obj.Meshes[0].Vertices, want[name].Meshes[0].Vertices) {
+			t.Fatal("wrapped chunk", name, "not rebuilt")
+		}
+	}
+
+	// Likewise only the tile objects of the changed chunk are replaced.
+	c.TileObjects = make(map[TileKey]*gfx.Object)
+	Load(m, c, images)
+	tiles := make(map[TileKey]*gfx.Object, len(c.TileObjects))
+	for k, obj := range c.TileObjects {
+		tiles[k] = obj
+	}
+	l.SetTile(Coord{9, 2}, l.Tile(Coord{9, 2})%20+1)
+	RebuildDirty(layers, m, c, images)
+	if len(c.TileObjects) != len(tiles) {
+		t.Fatal("incorrect number of tile objects", len(c.TileObjects), len(tiles))
+	}
+	for k, obj := range c.TileObjects {
+		inChunk := k.Layer == l.Name && k.Coord.X >= 8 && k.Coord.X < 16 && k.Coord.Y < 8
+		if replaced := tiles[k] != obj; replaced != inChunk {
+			t.Fatal("tile object", k, "replaced:", replaced)
+		}
+	}
+}
+
+func TestGeoJSON(t *testing.T) {
+	m, err := Parse([]byte(`<map version="1.0" orientation="orthogonal" width="3" height="2" tilewidth="10" tileheight="10">
+ <layer name="solid" width="3" height="2">
+  <data encoding="csv">1,1,0,
+1,0,0</data>
+ </layer>
+ <objectgroup name="shapes" offsetx="5">
+  <object name="box" type="trigger" x="0" y="0" width="10" height="20">
+   <properties>
+    <property name="damage" value="3"/>
+   </properties>
+  </object>
+  <object name="spawn" x="4" y="6"/>
+  <object x="0" y="0">
+   <polyline points="0,0 5,5"/>
+  </object>
+  <object name="hidden" x="0" y="0" visible="0"/>
+ </objectgroup>
+</map>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := MarshalGeoJSON(m, &GeoJSONConfig{
+		Collision: map[string]func(gid uint32) bool{
+			"solid": func(gid uint32) bool { return true },
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var fc struct {
+		Type     string
+		Features []struct {
+			Geometry struct {
+				Type        string
+				Coordinates json.RawMessage
+			}
+			Properties map[string]interface{}
+		}
+	}
+	if err := json.Unmarshal(data, &fc); err != nil {
+		t.Fatal(err)
+	}
+	compact := func(r json.RawMessage) string {
+		var b bytes.Buffer
+		json.Compact(&b, r)
+		return b.String()
+	}
+	if fc.Type != "FeatureCollection" || len(fc.Features) != 4 {
+		t.Fatal("incorrect features", string(data))
+	}
+	box := fc.Features[0]
+	if box.Geometry.Type != "Polygon" || compact(box.Geometry.Coordinates) != "[[[5,0],[15,0],[15,20],[5,20],[5,0]]]" {
+		t.Fatal("incorrect polygon", box.Geometry.Type, compact(box.Geometry.Coordinates))
+	}
+	if box.Properties["name"] != "box" || box.Properties["class"] != "trigger" || box.Properties["layer"] != "shapes" {
+		t.Fatal("incorrect properties", box.Properties)
+	}
+	if props := box.Properties["properties"].(map[string]interface{}); props["damage"] != "3" {
+		t.Fatal("incorrect custom properties", props)
+	}
+	if g := fc.Features[1].Geometry; g.Type != "Point" || compact(g.Coordinates) != "[9,6]" {
+		t.Fatal("incorrect point", g.Type, compact(g.Coordinates))
+	}
+	if g := fc.Features[2].Geometry; g.Type != "LineString" || compact(g.Coordinates) != "[[5,0],[10,5]]" {
+		t.Fatal("incorrect line string", g.Type, compact(g.Coordinates))
+	}
+	col := fc.Features[3]
+	var lines [][][2]float64
+	if err := json.Unmarshal(col.Geometry.Coordinates, &lines); err != nil {
+		t.Fatal(err)
+	}
+	if col.Geometry.Type != "MultiLineString" || len(lines) != 6 || col.Properties["layer"] != "solid" {
+		t.Fatal("incorrect collision feature", string(data))
+	}
+}
+
+func TestOutlines(t *testing.T) {
+	m, err := Parse([]byte(`<map version="1.0" orientation="orthogonal" width="6" height="3" tilewidth="10" tileheight="10">
+ <layer name="solid" width="6" height="3">
+  <data encoding="csv">1,1,0,1,1,1,
+1,0,1,1,0,1,
+0,1,0,1,1,1</data>
+ </layer>
+</map>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	solid := func(gid uint32) bool { return true }
+	polygons := m.Outlines("solid", solid)
+	want := [][]Pixel{
+		// The L shaped region, merged into it's six corners.
+		{{0, 0}, {20, 0}, {20, 10}, {10, 10}, {10, 20}, {0, 20}},
+		// The ring, clockwise, and then it's hole, counter-clockwise.
+		{{30, 0}, {60, 0}, {60, 30}, {30, 30}, {30, 20}, {20, 20}, {20, 10}, {30, 10}},
+		{{50, 10}, {40, 10}, {40, 20}, {50, 20}},
+		// The cell touching the other regions only at their corners.
+		{{10, 20}, {20, 20}, {20, 30}, {10, 30}},
+	}
+	if !reflect.DeepEqual(polygons, want) {
+		t.Fatal("incorrect outlines", polygons)
+	}
+	if m.Outlines("missing", solid) != nil {
+		t.Fatal("expected no outlines for a missing layer")
+	}
+}
+
+func TestConvexParts(t *testing.T) {
+	// A C shaped polygon (clockwise on screen), with a collinear point.
+	c := []Pixel{{0, 0}, {15, 0}, {30, 0}, {30, 10}, {10, 10}, {10, 20}, {30, 20}, {30, 30}, {0, 30}}
+	parts := ConvexParts(c)
+	if len(parts) < 3 || len(parts) > 4 {
+		t.Fatal("incorrect part count", parts)
+	}
+	var area float64
+	for _, p := range parts {
+		var a float64
+		for i, v := range p {
+			w := p[(i+1)%len(p)]
+			a += v.X*w.Y - w.X*v.Y
+			if v == (Pixel{15, 0}) {
+				t.Fatal("collinear point kept", p)
+			}
+		}
+		if !convex(p, 1) || a <= 0 {
+			t.Fatal("part is not convex or changed winding", p)
+		}
+		area += a / 2
+	}
+	if area != 30*30-20*10 {
+		t.Fatal("incorrect total area", area)
+	}
+
+	// Convex polygons are left as they are.
+	square := []Pixel{{0, 0}, {0, 10}, {10, 10}, {10, 0}}
+	if parts := ConvexParts(square); !reflect.DeepEqual(parts, [][]Pixel{square}) {
+		t.Fatal("convex polygon was split", parts)
+	}
+	if ConvexParts([]Pixel{{0, 0}, {10, 0}, {20, 0}}) != nil {
+		t.Fatal("expected no parts for a degenerate polygon")
+	}
+}
+
+func TestZones(t *testing.T) {
+	m, err := Parse([]byte(`<map version="1.0" orientation="orthogonal" width="4" height="4" tilewidth="10" tileheight="10">
+ <objectgroup name="triggers">
+  <object name="a" type="trigger" x="0" y="0" width="20" height="20"/>
+  <object name="b" type="trigger" x="10" y="10" width="20" height="20"/>
+  <object name="decor" x="0" y="0" width="40" height="40"/>
+ </objectgroup>
+</map>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	z := NewZones(m, nil)
+	events := z.Update(map[int]Pixel{1: {5, 5}, 2: {35, 35}})
+	if len(events) != 1 || events[0].Entity != 1 || events[0].Object.Name != "a" || !events[0].Enter {
+		t.Fatal("incorrect enter events", events)
+	}
+	if z.Update(map[int]Pixel{1: {6, 6}, 2: {35, 35}}) != nil {
+		t.Fatal("expected no events when staying within zones")
+	}
+
+	// Entering b while still within a, then leaving both.
+	events = z.Update(map[int]Pixel{1: {15, 15}})
+	if len(events) != 1 || events[0].Object.Name != "b" || !events[0].Enter {
+		t.Fatal("incorrect overlapping enter events", events)
+	}
+	if in := z.Inside(1); len(in) != 2 {
+		t.Fatal("incorrect zones inside", in)
+	}
+	events = z.Update(nil)
+	if len(events) != 2 || events[0].Object.Name != "a" || events[1].Object.Name != "b" || events[0].Enter || events[1].Enter {
+		t.Fatal("incorrect leave events for a removed entity", events)
+	}
+
+	// Custom matching.
+	z = NewZones(m, func(g *ObjectGroup, o *Object) bool { return o.Name == "decor" })
+	if events := z.Update(map[int]Pixel{7: {35, 35}}); len(events) != 1 || events[0].Object.Name != "decor" {
+		t.Fatal("incorrect custom zone events", events)
+	}
+}
+
+func TestResolveLinks(t *testing.T) {
+	w, err := ParseWorld([]byte(`{
+ "maps": [
+  {"fileName": "maps/a.tmx", "x": 0, "y": 0, "width": 320, "height": 160},
+  {"fileName": "maps/b.tmx", "x": 320, "y": 0, "width": 160, "height": 160}
+ ],
+ "onlyShowAdjacentMaps": false,
+ "type": "world"
+}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(w.Maps) != 2 || w.FindMap("maps/b.tmx").Rect() != image.Rect(320, 0, 480, 160) || w.FindMap("c.tmx") != nil {
+		t.Fatal("incorrect world", w.Maps)
+	}
+
+	parse := func(objects string) *Map {
+		m, err := Parse([]byte(`<map version="1.0" orientation="orthogonal" width="1" height="1" tilewidth="10" tileheight="10">
+ <objectgroup name="doors">` + objects + `</objectgroup>
+</map>`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return m
+	}
+	maps := map[string]*Map{
+		"maps/a.tmx": parse(`
+  <object name="door" x="0" y="0">
+   <properties>
+    <property name="target_map" value="b.tmx"/>
+    <property name="target_object" value="entrance"/>
+   </properties>
+  </object>
+  <object name="stairs" x="0" y="0">
+   <properties>
+    <property name="target_object" value="door"/>
+   </properties>
+  </object>`),
+		"maps/b.tmx": parse(`
+  <object name="entrance" x="0" y="0"/>`),
+	}
+	links, err := ResolveLinks(maps)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(links) != 2 {
+		t.Fatal("incorrect link count", links)
+	}
+	if l := links[0]; l.TargetMap != "maps/b.tmx" || l.Target != maps["maps/b.tmx"] || l.TargetObject.Name != "entrance" || l.TargetGroup.Name != "doors" {
+		t.Fatal("incorrect link across maps", l)
+	}
+	if l := links[1]; l.TargetMap != "maps/a.tmx" || l.TargetObject != maps["maps/a.tmx"].ObjectGroups[0].Objects[0] {
+		t.Fatal("incorrect link within a map", l)
+	}
+
+	maps["maps/b.tmx"] = parse("")
+	if _, err := ResolveLinks(maps); err == nil {
+		t.Fatal("expected error for unknown target object")
+	}
+	delete(maps, "maps/b.tmx")
+	if _, err := ResolveLinks(maps); err == nil {
+		t.Fatal("expected error for unknown target map")
+	}
+}
+
+func TestProjectProperties(t *testing.T) {
+	p, err := ParseProject([]byte(`{
+ "propertyTypes": [
+  {
+   "id": 1,
+   "name": "Enemy",
+   "type": "class",
+   "useAs": ["object"],
+   "members": [
+    {"name": "hp", "type": "int", "value": 10},
+    {"name": "speed", "type": "float", "value": 1.5},
+    {"name": "flying", "type": "bool", "value": false},
+    {"name": "sprite", "type": "file", "value": "enemy.png"},
+    {"name": "loot", "type": "class", "propertyType": "Loot", "value": {"gold": 3}}
+   ]
+  },
+  {"id": 2, "name": "Fog", "type": "class", "useAs": ["layer"], "members": [{"name": "density", "type": "float", "value": 0.5}]}
+ ]
+}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := Parse([]byte(`<map version="1.0" orientation="orthogonal" width="1" height="1" tilewidth="10" tileheight="10">
+ <objectgroup name="enemies">
+  <object name="bat" class="Enemy" x="0" y="0">
+   <properties>
+    <property name="flying" value="true"/>
+   </properties>
+  </object>
+  <object name="fog" class="Fog" x="0" y="0"/>
+ </objectgroup>
+</map>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	bat := m.ObjectGroups[0].Objects[0]
+	want := map[string]string{"hp": "10", "speed": "1.5", "flying": "true", "sprite": "enemy.png"}
+	if props := p.ObjectProperties(bat); !reflect.DeepEqual(props, want) {
+		t.Fatal("incorrect effective properties", props)
+	}
+	if !reflect.DeepEqual(bat.Properties, map[string]string{"flying": "true"}) {
+		t.Fatal("raw properties were modified", bat.Properties)
+	}
+
+	// Classes not usable by objects are not inherited by them.
+	fog := m.ObjectGroups[0].Objects[1]
+	if props := p.ObjectProperties(fog); len(props) != 0 {
+		t.Fatal("unexpected inherited properties", props)
+	}
+	if props := p.Properties("Fog", nil); props["density"] != "0.5" {
+		t.Fatal("incorrect class properties", props)
+	}
+}
+
+type testDirection int
+
+const (
+	testNorth testDirection = iota
+	testSouth
+)
+
+type testAbility uint8
+
+const (
+	testFly testAbility = 1 << iota
+	testSwim
+	testClimb
+)
+
+func TestDecodeEnum(t *testing.T) {
+	p, err := ParseProject([]byte(`{
+ "propertyTypes": [
+  {"id": 1, "name": "Direction", "type": "enum", "storageType": "string", "values": ["north", "south"], "valuesAsFlags": false},
+  {"id": 2, "name": "Ability", "type": "enum", "storageType": "int", "values": ["fly", "swim", "climb"], "valuesAsFlags": true},
+  {"id": 3, "name": "Tags", "type": "enum", "storageType": "string", "values": ["fly", "swim", "climb"], "valuesAsFlags": true}
+ ]
+}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	directions := map[string]testDirection{"north": testNorth, "south": testSouth}
+	var d testDirection
+	if err := p.DecodeEnum("Direction", "south", directions, &d); err != nil || d != testSouth {
+		t.Fatal("incorrect string enum", d, err)
+	}
+	if err := p.DecodeEnum("Direction", "east", directions, &d); err == nil {
+		t.Fatal("expected error for unknown value")
+	}
+	if err := p.DecodeEnum("Direction", "north", map[string]testDirection{"south": testSouth}, &d); err == nil {
+		t.Fatal("expected error for missing constant")
+	}
+	if err := p.DecodeEnum("Missing", "north", directions, &d); err == nil {
+		t.Fatal("expected error for unknown enum")
+	}
+
+	abilities := map[string]testAbility{"fly": testFly, "swim": testSwim, "climb": testClimb}
+	var a testAbility
+	if err := p.DecodeEnum("Ability", "5", abilities, &a); err != nil || a != testFly|testClimb {
+		t.Fatal("incorrect integer flags", a, err)
+	}
+	if err := p.DecodeEnum("Ability", "8", abilities, &a); err == nil {
+		t.Fatal("expected error for flag out of range")
+	}
+	if err := p.DecodeEnum("Tags", "swim,climb", abilities, &a); err != nil || a != testSwim|testClimb {
+		t.Fatal("incorrect string flags", a, err)
+	}
+	if err := p.DecodeEnum("Tags", "", abilities, &a); err != nil || a != 0 {
+		t.Fatal("incorrect empty flags", a, err)
+	}
+	if err := p.DecodeEnum("Tags", "fly", abilities, &d); err == nil {
+		t.Fatal("expected error for mismatched destination type")
+	}
+}
+
+func TestDepthFunc(t *testing.T) {
+	c := DefaultConfig()
+	var calls int
+	c.Depth = func(layerIndex, x, y int, gid uint32) float64 {
+		calls++
+		if gid&flipFlags != 0 {
+			t.Fatal("gid with flip flags", gid)
+		}
+		return float64(y)
+	}
+	m, layers, err := LoadFile("testdata/test_infinite.tmx", c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if calls == 0 {
+		t.Fatal("depth function not used")
+	}
+
+	// Each card lies at the depth of it's row, which is known from it's
+	// vertical position.
+	mesh := layers["ground"]["tilesheet.png"].Meshes[0]
+	for i := 0; i+5 < len(mesh.Vertices); i += 6 {
+		var z float32
+		for _, v := range mesh.Vertices[i : i+6] {
+			z += v.Z / 6
+		}
+		row := math.Floor(float64(m.Height) - float64(z)/float64(m.TileHeight))
+		if v := mesh.Vertices[i]; float64(v.Y) != row {
+			t.Fatal("incorrect card depth", v.Y, row)
+		}
+	}
+	if v := TileToWorld(m, c, m.Layers[0], Coord{0, 1}); v.Y != 1 {
+		t.Fatal("incorrect tile depth", v.Y)
+	}
+}
+
+func TestWorldManager(t *testing.T) {
+	w, err := ParseWorld([]byte(`{
+ "maps": [
+  {"fileName": "test_csv.tmx", "x": 0, "y": 0, "width": 320, "height": 320},
+  {"fileName": "test_base64.tmx", "x": 2000, "y": 0, "width": 320, "height": 320}
+ ]
+}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	wm := NewWorldManager(w, "testdata", nil)
+	wm.Radius, wm.Margin = 100, 50
+	wm.Budget = time.Hour
+
+	loaded, released, err := wm.Update(Pixel{10, 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(loaded, []string{"test_csv.tmx"}) || released != nil {
+		t.Fatal("incorrect loaded maps", loaded, released)
+	}
+	m, layers, ok := wm.Map("test_csv.tmx")
+	if !ok || m == nil || len(layers) == 0 {
+		t.Fatal("map not loaded")
+	}
+	if _, _, ok := wm.Map("test_base64.tmx"); ok {
+		t.Fatal("distant map loaded")
+	}
+
+	// Within the margin the map stays loaded, beyond it the map is released.
+	if loaded, released, _ := wm.Update(Pixel{450, 10}); loaded != nil || released != nil {
+		t.Fatal("unexpected changes within the margin", loaded, released)
+	}
+	loaded, released, err = wm.Update(Pixel{1950, 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(loaded, []string{"test_base64.tmx"}) || !reflect.DeepEqual(released, []string{"test_csv.tmx"}) {
+		t.Fatal("incorrect changes", loaded, released)
+	}
+	if _, _, ok := wm.Map("test_csv.tmx"); ok {
+		t.Fatal("released map still loaded")
+	}
+	wm.Close()
+	if _, _, ok := wm.Map("test_base64.tmx"); ok {
+		t.Fatal("map loaded after close")
+	}
+}
+
+func TestMemoryFootprint(t *testing.T) {
+	m, layers, err := LoadFile("testdata/test_csv.tmx", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := m.MemoryFootprint()
+	var tiles int
+	for _, l := range m.Layers {
+		tiles += len(l.Tiles)
+	}
+	if f.Tiles != int64(tiles)*tileEntryBytes || f.Meshes != 0 || f.Total() < f.Tiles {
+		t.Fatal("incorrect map footprint", f)
+	}
+
+	// Storing the tiles run-length encoded changes the tile storage only.
+	l := m.Layers[0]
+	before := l.MemoryFootprint()
+	l.RLE, l.Tiles = NewRLETiles(m.Width, m.Height, l.Tiles), nil
+	if after := l.MemoryFootprint(); after.Tiles != int64(len(l.RLE.runs))*tileRunBytes || after.Properties != before.Properties {
+		t.Fatal("incorrect run-length encoded footprint", after)
+	}
+
+	lf := LayersFootprint(layers)
+	if lf.Meshes == 0 || lf.Tiles != 0 {
+		t.Fatal("incorrect layers footprint", lf)
+	}
+	// Shared textures are counted once.
+	if lf.Textures == 0 || LayersFootprint(layers, layers).Textures != lf.Textures {
+		t.Fatal("incorrect texture footprint", lf.Textures)
+	}
+}
+
+func TestParseFile(t *testing.T) {
+	path := filepath.Join("testdata", "test_base64_zlib.tmx")
+	m, err := ParseFile(path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := Parse(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(m, want) {
+		t.Fatal("parsed file differs from parsed data")
+	}
+	if _, err := ParseFile(filepath.Join("testdata", "missing.tmx"), nil); err == nil {
+		t.Fatal("expected error for missing file")
+	}
+}
+
+func TestMapHash(t *testing.T) {
+	// The same map, encoded differently, has the same hash.
+	csv := parseFile(t, "test_csv.tmx")
+	base64 := parseFile(t, "test_base64_zlib.tmx")
+	if csv.Hash() != base64.Hash() {
+		t.Fatal("hash depends on the tile data encoding")
+	}
+	a, err := Parse([]byte(`<map version="1.0" orientation="orthogonal" width="1" height="1" tilewidth="10" tileheight="10">
+ <properties><property name="a" value="1"/><property name="b" value="2"/></properties>
+</map>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := Parse([]byte(`<map tileheight="10" tilewidth="10"   height="1" width="1" orientation="orthogonal" version="1.0"><properties>
+  <property value="2" name="b"/>
+  <property value="1" name="a"/>
+ </properties></map>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.Hash() != b.Hash() {
+		t.Fatal("hash depends on attribute order or whitespace")
+	}
+	b.Properties["b"] = "3"
+	if a.Hash() == b.Hash() {
+		t.Fatal("hash does not depend on the content")
+	}
+}
+
+func TestTileChanges(t *testing.T) {
+	m := parseFile(t, "test_csv.tmx")
+	other := parseFile(t, "test_csv.tmx")
+	l := m.Layers[0]
+
+	var changes TileChanges
+	j := &Journal{Changes: &changes}
+	j.SetTile(l, Coord{1, 1}, 5)
+	j.SetTile(l, Coord{1, 1}, 6)
+	changes.SetTile(l, Coord{-3, 40}, 7)
+	j.SetTile(l, Coord{2, 2}, 8)
+	j.Undo()
+	got := changes.Changes()
+	if len(got) != 2 || got[0].Coord != (Coord{1, 1}) || got[0].New != 6 || got[1].Coord != (Coord{-3, 40}) {
+		t.Fatal("incorrect recorded changes", got)
+	}
+
+	data := EncodeTileChanges(got)
+	decoded, err := DecodeTileChanges(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(decoded, got) {
+		t.Fatal("changes not preserved by encoding", decoded, got)
+	}
+	for i := range data {
+		if _, err := DecodeTileChanges(data[:i]); err != ErrBadTileChanges {
+			t.Fatal("expected error for truncated data", i, err)
+		}
+	}
+
+	if err := ApplyTileChanges(other, decoded); err != nil {
+		t.Fatal(err)
+	}
+	ol := other.Layers[0]
+	if ol.Tile(Coord{1, 1}) != 6 || ol.Tile(Coord{-3, 40}) != 7 || ol.Dirty().Empty() {
+		t.Fatal("changes not applied")
+	}
+
+	// Applying the changes again conflicts, and changes nothing.
+	if err := ApplyTileChanges(other, append([]TileChange{{ol.Name, Coord{0, 0}, ol.Tile(Coord{0, 0}), 9}}, decoded...)); err == nil {
+		t.Fatal("expected conflict")
+	}
+	if ol.Tile(Coord{0, 0}) == 9 {
+		t.Fatal("conflicting changes were partly applied")
+	}
+	changes.Reset()
+	if len(changes.Changes()) != 0 {
+		t.Fatal("changes not reset")
+	}
+}
+
+func TestSchemaValidate(t *testing.T) {
+	m, err := Parse([]byte(`<map version="1.0" orientation="orthogonal" width="1" height="1" tilewidth="10" tileheight="10">
+ <properties><property name="music" value="theme.ogg"/></properties>
+ <layer name="ground" width="1" height="1">
+  <properties><property name="friction" value="slippery"/></properties>
+  <data encoding="csv">0</data>
+ </layer>
+ <objectgroup name="enemies">
+  <object name="bat" type="Enemy" x="0" y="0">
+   <properties>
+    <property name="hp" value="10"/>
+    <property name="tint" value="#ff00ff"/>
+   </properties>
+  </object>
+  <object name="rat" type="Enemy" x="0" y="0">
+   <properties><property name="flying" value="yes"/></properties>
+  </object>
+ </objectgroup>
+</map>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &Schema{
+		Map: []PropertySchema{{Name: "music", Type: "file", Required: true}},
+		Layers: map[string][]PropertySchema{
+			"ground": {{Name: "friction", Type: "float"}},
+		},
+		Objects: map[string][]PropertySchema{
+			"Enemy": {
+				{Name: "hp", Type: "int", Required: true},
+				{Name: "flying", Type: "bool"},
+				{Name: "tint", Type: "color"},
+			},
+		},
+	}
+	var got []string
+	for _, err := range s.Validate(m) {
+		got = append(got, err.Error())
+	}
+	want := []string{
+		`layer "ground": property "friction" is "slippery", not a float`,
+		`object group "enemies": object "rat": missing property "hp"`,
+		`object group "enemies": object "rat": property "flying" is "yes", not a bool`,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatal("incorrect errors", got)
+	}
+}
+
+func TestRenderProperties(t *testing.T) {
+	m, layers, err := LoadFile("testdata/test_csv.tmx", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tsImages := map[string]*image.RGBA{
+		"tilesheet.png": layers["ground"]["tilesheet.png"].Textures[0].Source.(*image.RGBA),
+	}
+	m.FindLayer("background2").Properties["norender"] = "true"
+	m.FindLayer("background").Properties["collision-only"] = "true"
+	m.FindLayer("ground").Properties["above-entities"] = "true"
+	m.FindLayer("flowers").Properties["opacity-override"] = "0.5"
+
+	layers = Load(m, nil, tsImages)
+	if _, ok := layers["background2"]; ok {
+		t.Fatal("norender layer was loaded")
+	}
+	if _, ok := layers["background"]; ok {
+		t.Fatal("collision-only layer was loaded")
+	}
+
+	// The ground is drawn in front of the flowers, which are drawn last
+	// otherwise.
+	depth := func(name string) float32 {
+		return layers[name]["tilesheet.png"].Meshes[0].Vertices[0].Y
+	}
+	if depth("ground") >= depth("flowers") {
+		t.Fatal("above-entities layer is not in front", depth("ground"), depth("flowers"))
+	}
+
+	mesh := layers["flowers"]["tilesheet.png"].Meshes[0]
+	if len(mesh.Colors) == 0 || mesh.Colors[0].A != 0.5 {
+		t.Fatal("opacity override not applied")
+	}
+}
+
+func TestIncludeLayers(t *testing.T) {
+	m, layers, err := LoadFile("testdata/test_csv.tmx", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tsImages := map[string]*image.RGBA{
+		"tilesheet.png": layers["ground"]["tilesheet.png"].Textures[0].Source.(*image.RGBA),
+	}
+	names := func(layers map[string]map[string]*gfx.Object) []string {
+		var n []string
+		for name := range layers {
+			n = append(n, name)
+		}
+		sort.Strings(n)
+		return n
+	}
+
+	c := DefaultConfig()
+	c.IncludeLayers = []string{"ground", "flowers"}
+	c.ExcludeLayers = []string{"flowers"}
+	if n := names(Load(m, c, tsImages)); !reflect.DeepEqual(n, []string{"ground"}) {
+		t.Fatal("incorrect included layers", n)
+	}
+
+	// Group layers include and exclude the layers within them.
+	g := &GroupLayer{Name: "decor", Opacity: 1, Visible: true, ParallaxX: 1, ParallaxY: 1}
+	m.FindLayer("flowers").Parent = g
+	m.FindLayer("foreground").Parent = g
+	c = DefaultConfig()
+	c.IncludeLayers = []string{"decor"}
+	if n := names(Load(m, c, tsImages)); !reflect.DeepEqual(n, []string{"flowers", "foreground"}) {
+		t.Fatal("incorrect layers included by group", n)
+	}
+	c = DefaultConfig()
+	c.ExcludeLayers = []string{"decor"}
+	if n := names(Load(m, c, tsImages)); !reflect.DeepEqual(n, []string{"background", "background2", "ground"}) {
+		t.Fatal("incorrect layers excluded by group", n)
+	}
+}
+
+func TestDecomposeGID(t *testing.T) {
+	m := &Map{Tilesets: []*Tileset{
+		{Name: "a", Firstgid: 1, TileCount: 10},
+		{Name: "b", Firstgid: 11, TileCount: 5},
+	}}
+	tests := []struct {
+		gid   uint32
+		ts    string
+		id    uint32
+		flips Flip
+	}{
+		{0, "", 0, 0},
+		{1, "a", 0, 0},
+		{10 | FLIPPED_HORIZONTALLY_FLAG, "a", 9, Flip(FLIPPED_HORIZONTALLY_FLAG)},
+		{13 | FLIPPED_VERTICALLY_FLAG | FLIPPED_DIAGONALLY_FLAG, "b", 2, Flip(FLIPPED_VERTICALLY_FLAG | FLIPPED_DIAGONALLY_FLAG)},
+		{16, "", 0, 0},
+	}
+	for _, tst := range tests {
+		ts, id, flips := m.DecomposeGID(tst.gid)
+		name := ""
+		if ts != nil {
+			name = ts.Name
+		}
+		if name != tst.ts || id != tst.id || flips != tst.flips {
+			t.Errorf("DecomposeGID(%#x) = %q, %d, %#x; want %q, %d, %#x", tst.gid, name, id, flips, tst.ts, tst.id, tst.flips)
+		}
+	}
+	f := Flip(FLIPPED_VERTICALLY_FLAG | FLIPPED_DIAGONALLY_FLAG)
+	if f.Horizontal() || !f.Vertical() || !f.Diagonal() {
+		t.Fatal("incorrect flip flags", f)
+	}
+}
+
+func TestWriteGLTF(t *testing.T) {
+	_, layers, err := LoadFile("testdata/test_csv.tmx", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := new(bytes.Buffer)
+	if err := WriteGLTF(buf, layers); err != nil {
+		t.Fatal(err)
+	}
+	var doc struct {
+		Asset struct{ Version string }
+		Nodes []struct {
+			Name string
+			Mesh *int
+		}
+		Meshes []struct {
+			Primitives []struct {
+				Attributes map[string]int
+			}
+		}
+		Accessors []struct{ Count int }
+		Buffers   []struct {
+			ByteLength int
+			URI        string
+		}
+		Images []struct{ URI string }
+	}
+	if err := json.Unmarshal(buf.Bytes(), &doc); err != nil {
+		t.Fatal(err)
+	}
+	if doc.Asset.Version != "2.0" {
+		t.Fatal("incorrect glTF version", doc.Asset.Version)
+	}
+	var names []string
+	for name := range layers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	if len(doc.Nodes) != len(names) {
+		t.Fatal("incorrect number of nodes", len(doc.Nodes))
+	}
+	for i, n := range doc.Nodes {
+		if n.Name != names[i] || n.Mesh == nil {
+			t.Fatal("incorrect node", i, n.Name)
+		}
+		var vertices int
+		for _, obj := range layers[n.Name] {
+			vertices += len(obj.Meshes[0].Vertices)
+		}
+		p := doc.Meshes[*n.Mesh].Primitives[0]
+		if got := doc.Accessors[p.Attributes["POSITION"]].Count; got != vertices {
+			t.Fatalf("layer %q: got %d vertices, want %d", n.Name, got, vertices)
+		}
+	}
+	data, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(doc.Buffers[0].URI, "data:application/octet-stream;base64,"))
+	if err != nil || len(data) != doc.Buffers[0].ByteLength {
+		t.Fatal("incorrect buffer", len(data), doc.Buffers[0].ByteLength, err)
+	}
+	if len(doc.Images) != 1 {
+		t.Fatal("tileset image not shared", len(doc.Images))
+	}
+}
+
+func TestWriteOBJ(t *testing.T) {
+	_, layers, err := LoadFile("testdata/test_csv.tmx", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	obj, mtl := new(bytes.Buffer), new(bytes.Buffer)
+	if err := WriteOBJ(obj, mtl, "test_csv.mtl", layers); err != nil {
+		t.Fatal(err)
+	}
+	var vertices int
+	for _, objects := range layers {
+		for _, o := range objects {
+			vertices += len(o.Meshes[0].Vertices)
+		}
+	}
+	count := map[string]int{}
+	for _, line := range strings.Split(obj.String(), "\n") {
+		count[strings.SplitN(line, " ", 2)[0]]++
+	}
+	if count["mtllib"] != 1 || count["v"] != vertices || count["vt"] != vertices || count["f"] != vertices/3 {
+		t.Fatal("incorrect OBJ", count, vertices)
+	}
+	if !strings.Contains(obj.String(), "o ground/tilesheet.png\nusemtl tilesheet.png\n") {
+		t.Fatal("missing ground object")
+	}
+	if !strings.Contains(mtl.String(), "newmtl tilesheet.png\n") || !strings.Contains(mtl.String(), "map_Kd tilesheet.png\n") {
+		t.Fatal("incorrect MTL", mtl.String())
+	}
+}
+
+func TestBakeLayers(t *testing.T) {
+	m, layers, err := LoadFile("testdata/test_csv.tmx", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rgba := layers["ground"]["tilesheet.png"].Textures[0].Source.(*image.RGBA)
+	tsImages := map[string]*image.RGBA{"tilesheet.png": rgba}
+
+	ground := m.FindLayer("ground")
+	ground.SetTile(Coord{0, 0}, 7|FLIPPED_HORIZONTALLY_FLAG)
+	img := BakeLayer(m, ground, tsImages)
+	if img.Bounds() != image.Rect(0, 0, 60*32, 10*32) {
+		t.Fatal("incorrect baked bounds", img.Bounds())
+	}
+	for y := 0; y < 32; y++ {
+		for x := 0; x < 32; x++ {
+			if got, want := img.RGBAAt(51*32+x, y), rgba.RGBAAt(192+x, y); got != want {
+				t.Fatalf("baked pixel (%d, %d) is %v, want %v", x, y, got, want)
+			}
+			if got, want := img.RGBAAt(x, y), rgba.RGBAAt(192+31-x, y); got != want {
+				t.Fatalf("flipped baked pixel (%d, %d) is %v, want %v", x, y, got, want)
+			}
+		}
+	}
+
+	c := DefaultConfig()
+	c.BakeLayers = []string{"ground"}
+	c.BakeMaxSize = 1024
+	baked := Load(m, c, tsImages)["ground"]
+	if len(baked) != 2 || baked["baked:0,0"] == nil || baked["baked:1,0"] == nil {
+		t.Fatal("incorrect baked objects", len(baked))
+	}
+	for name, obj := range baked {
+		if n := len(obj.Meshes[0].Vertices); n != 6 {
+			t.Fatalf("%s: got %d vertices, want a single card", name, n)
+		}
+	}
+	if b := baked["baked:1,0"].Textures[0].Source.Bounds(); b != image.Rect(0, 0, 60*32-1024, 10*32) {
+		t.Fatal("incorrect size of last baked texture", b)
+	}
+}
+
+func TestPalette(t *testing.T) {
+	src := image.NewRGBA(image.Rect(0, 0, 2, 2))
+	src.SetRGBA(1, 0, color.RGBA{255, 0, 0, 255})
+	src.SetRGBA(0, 1, color.RGBA{0, 255, 0, 255})
+	p := PaletteImage(src)
+	if p.Bounds() != image.Rect(0, 0, 256, 1) {
+		t.Fatal("incorrect palette bounds", p.Bounds())
+	}
+	if p.RGBAAt(1, 0) != (color.RGBA{255, 0, 0, 255}) || p.RGBAAt(2, 0) != (color.RGBA{0, 255, 0, 255}) || p.RGBAAt(4, 0) != (color.RGBA{}) {
+		t.Fatal("incorrect palette colors", p.Pix[:20])
+	}
+
+	m, layers, err := LoadFile("testdata/test_csv.tmx", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tsImages := map[string]*image.RGBA{
+		"tilesheet.png": layers["ground"]["tilesheet.png"].Textures[0].Source.(*image.RGBA),
+	}
+	c := DefaultConfig()
+	c.Palette = NewPaletteTexture(src)
+	layers = Load(m, c, tsImages)
+	obj := layers["ground"]["tilesheet.png"]
+	if len(obj.Textures) != 2 || obj.Textures[1] != c.Palette {
+		t.Fatal("palette texture not attached")
+	}
+	if !strings.Contains(obj.Shader.Name, featurePalette) {
+		t.Fatal("palette shader not used", obj.Shader.Name)
+	}
+	next := NewPaletteTexture(p)
+	SetPalette(c, next, layers)
+	if obj.Textures[1] != next || c.Palette != next {
+		t.Fatal("palette not swapped")
+	}
+}
+
+func TestGraders(t *testing.T) {
+	m, layers, err := LoadFile("testdata/test_csv.tmx", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tsImages := map[string]*image.RGBA{
+		"tilesheet.png": layers["ground"]["tilesheet.png"].Textures[0].Source.(*image.RGBA),
+	}
+	m.FindLayer("ground").Properties = map[string]string{"saturation": "0", "tint": "#ff0000"}
+
+	c := DefaultConfig()
+	c.Graders = new(Graders)
+	night := Grade{Saturation: 0.5, Brightness: 0.25, Tint: gfx.Color{0, 0, 0.1, 1}}
+	c.Graders.Set("background", night)
+	layers = Load(m, c, tsImages)
+
+	ground := layers["ground"]["tilesheet.png"].Shader
+	if !strings.Contains(ground.Name, featureGrading) {
+		t.Fatal("grading shader not used", ground.Name)
+	}
+	if ground.Inputs["Saturation"] != float32(0) || ground.Inputs["Brightness"] != float32(1) || ground.Inputs["Tint"] != (gfx.Color{1, 0, 0, 1}) {
+		t.Fatal("incorrect grade from properties", ground.Inputs)
+	}
+	if background := layers["background"]["tilesheet.png"].Shader; background == ground || background.Inputs["Brightness"] != float32(0.25) {
+		t.Fatal("incorrect grade set before loading", background.Inputs)
+	}
+
+	c.Graders.Set("ground", night)
+	if ground.Inputs["Saturation"] != float32(0.5) || c.Graders.Grade("ground") != night {
+		t.Fatal("grade not updated", ground.Inputs)
+	}
+	if c.Graders.Grade("unknown") != NoGrade {
+		t.Fatal("incorrect grade of unknown layer")
+	}
+}
+
+func TestCollisionShapes(t *testing.T) {
+	m, err := Parse([]byte(`<map version="1.0" orientation="orthogonal" width="3" height="3" tilewidth="10" tileheight="10">
+ <layer name="walls" width="3" height="3">
+  <properties>
+   <property name="collision-only" value="true"/>
+  </properties>
+  <data encoding="csv">1,1,0,
+1,1,0,
+0,1,1</data>
+ </layer>
+ <layer name="ground" width="3" height="3">
+  <data encoding="csv">1,1,1,
+1,1,1,
+1,1,1</data>
+ </layer>
+ <objectgroup name="blockers">
+  <properties>
+   <property name="collision-only" value="true"/>
+  </properties>
+  <object id="1" x="5" y="5" width="10" height="20"/>
+  <object id="2" x="0" y="0">
+   <polyline points="0,0 10,0 10,10"/>
+  </object>
+ </objectgroup>
+</map>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	shapes := m.CollisionShapes()
+	if len(shapes) != 4 {
+		t.Fatal("incorrect number of shapes", len(shapes))
+	}
+	want := [][]Pixel{
+		{{0, 0}, {20, 0}, {20, 20}, {0, 20}},
+		{{10, 20}, {30, 20}, {30, 30}, {10, 30}},
+	}
+	for i, w := range want {
+		if s := shapes[i]; s.Layer != "walls" || s.Object != nil || !s.Closed || !reflect.DeepEqual(s.Points, w) {
+			t.Fatal("incorrect tile shape", i, s)
+		}
+	}
+	if s := shapes[2]; s.Layer != "blockers" || s.Object == nil || !s.Closed || len(s.Points) != 4 {
+		t.Fatal("incorrect rectangle shape", s)
+	}
+	if s := shapes[3]; s.Closed || len(s.Points) != 3 {
+		t.Fatal("incorrect polyline shape", s)
+	}
+
+	_, layers, shapes, err := LoadWithPhysics("testdata/test_csv.tmx", nil)
+	if err != nil || len(layers) == 0 || len(shapes) != 0 {
+		t.Fatal("LoadWithPhysics", err, len(layers), len(shapes))
+	}
+}
+
+func TestDepthForTile(t *testing.T) {
+	m, layers, err := LoadFile("testdata/test_csv.tmx", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := DefaultConfig()
+	ground := m.FindLayer("ground")
+	depth, ok := DepthForLayer(m, c, "ground")
+	if !ok || depth != -float64(ground.Index)*c.LayerOffset {
+		t.Fatal("incorrect layer depth", depth, ok)
+	}
+	if _, ok := DepthForLayer(m, c, "missing"); ok {
+		t.Fatal("expected no depth for a missing layer")
+	}
+
+	// The depths of the tiles must be those of the vertices Load emitted.
+	depths := make(map[float32]bool)
+	for _, v := range layers["ground"]["tilesheet.png"].Meshes[0].Vertices {
+		depths[v.Y] = true
+	}
+	near, far, ok := DepthRange(m, c, "ground")
+	if !ok || far != depth || near >= far {
+		t.Fatal("incorrect depth range", near, far, ok)
+	}
+	ground.EachTile(func(tc Coord, gid uint32) {
+		if gid == 0 {
+			return
+		}
+		d := DepthForTile(m, c, ground, tc)
+		if !depths[float32(d)] || d < near || d > far {
+			t.Fatalf("tile %v: depth %v not emitted by Load", tc, d)
+		}
+	})
+	if DepthForTile(m, c, ground, Coord{51, 0}) == DepthForTile(m, c, ground, Coord{52, 0}) {
+		t.Fatal("tiles share a depth")
+	}
+}
+
+func TestAnalyzeSharing(t *testing.T) {
+	parse := func(tilesets string) *Map {
+		m, err := Parse([]byte(`<map version="1.0" orientation="orthogonal" width="1" height="1" tilewidth="16" tileheight="16">` + tilesets + `</map>`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return m
+	}
+	r := AnalyzeSharing(map[string]*Map{
+		"maps/a.tmx": parse(`<tileset firstgid="1" name="terrain" tilewidth="16" tileheight="16"><image source="terrain.png" width="64" height="32"/></tileset>
+<tileset firstgid="9" name="props" tilewidth="16" tileheight="16"><image source="props.png" width="16" height="16"/></tileset>`),
+		"maps/b.tmx": parse(`<tileset firstgid="1" name="ground" tilewidth="16" tileheight="16"><image source="../maps/terrain.png" width="64" height="32"/></tileset>`),
+	})
+	if len(r.Images) != 2 {
+		t.Fatal("incorrect number of images", len(r.Images))
+	}
+	terrain := r.Images[0]
+	if terrain.Path != filepath.Join("maps", "terrain.png") || terrain.Bytes != 64*32*4 ||
+		!reflect.DeepEqual(terrain.Maps, []string{"maps/a.tmx", "maps/b.tmx"}) ||
+		!reflect.DeepEqual(terrain.Users, []string{"ground", "terrain"}) {
+		t.Fatal("incorrect terrain usage", terrain)
+	}
+	if len(r.Shared()) != 1 || r.Shared()[0].Path != terrain.Path {
+		t.Fatal("incorrect shared images", r.Shared())
+	}
+	if r.UniqueBytes != 64*32*4+16*16*4 || r.TotalBytes != 2*64*32*4+16*16*4 {
+		t.Fatal("incorrect footprint", r.UniqueBytes, r.TotalBytes)
+	}
+	if !strings.Contains(r.String(), "unique bytes") {
+		t.Fatal("incorrect report", r.String())
+	}
+}
+
+func TestVisibleChunks(t *testing.T) {
+	c := DefaultConfig()
+	c.BakeLayers = []string{"ground"}
+	c.BakeMaxSize = 1024
+	_, layers, err := LoadFile("testdata/test_csv.tmx", c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	chunks := NewChunks(map[string]map[string]*gfx.Object{"ground": layers["ground"]})
+	if len(chunks.Chunks()) != 2 {
+		t.Fatal("incorrect number of chunks", len(chunks.Chunks()))
+	}
+	last, ok := chunks.Chunk(ChunkID{"ground", "baked:1,0"})
+	if !ok || last.Bounds.Min.X != 1024 || last.Bounds.Max.X != 60*32 || last.Bounds.Min.Z != 0 || last.Bounds.Max.Z != 10*32 {
+		t.Fatal("incorrect chunk bounds", last.Bounds, ok)
+	}
+	if _, ok := chunks.Chunk(ChunkID{"ground", "missing"}); ok {
+		t.Fatal("expected no missing chunk")
+	}
+
+	view := lmath.Rect3{Min: lmath.Vec3{0, -1, 0}, Max: lmath.Vec3{500, 1, 200}}
+	if ids := chunks.VisibleChunks(view); !reflect.DeepEqual(ids, []ChunkID{{"ground", "baked:0,0"}}) {
+		t.Fatal("incorrect visible chunks", ids)
+	}
+	view.Max.X = 1100
+	if ids := chunks.VisibleChunks(view); len(ids) != 2 {
+		t.Fatal("incorrect visible chunks", ids)
+	}
+}
+
+func TestTileInfos(t *testing.T) {
+	data := []byte(`<map version="1.10" orientation="orthogonal" width="1" height="1" tilewidth="32" tileheight="32">
+ <tileset firstgid="1" name="a" tilewidth="32" tileheight="32" tilecount="4" columns="2">
+  <tile id="1" class="spikes">
+   <properties>
+    <property name="damage" value="5"/>
+   </properties>
+   <objectgroup>
+    <object id="1" x="0" y="16" width="32" height="16"/>
+   </objectgroup>
+  </tile>
+  <tile id="2">
+   <animation>
+    <frame tileid="2" duration="100"/>
+    <frame tileid="3" duration="100"/>
+   </animation>
+  </tile>
+ </tileset>
+ <tileset firstgid="10" name="b" tilewidth="32" tileheight="32">
+  <tile id="0" type="water"/>
+ </tileset>
+</map>`)
+	m, err := Parse(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	infos := m.TileInfos()
+	if len(infos) != 11 {
+		t.Fatalf("got %d infos, want 11", len(infos))
+	}
+	if infos.Lookup(0) != nil || infos.Lookup(5) != nil || infos.Lookup(11) != nil {
+		t.Fatal("expected no info for gids without a tile")
+	}
+	if info := infos.Lookup(1); info == nil || info.Tileset.Name != "a" || info.Tile != nil {
+		t.Fatalf("gid 1: got %+v", info)
+	}
+	info := infos.Lookup(2 | FLIPPED_HORIZONTALLY_FLAG)
+	if info == nil || info.Class != "spikes" || info.Properties["damage"] != "5" || !info.Collision || info.Animated {
+		t.Fatalf("gid 2: got %+v", info)
+	}
+	if info := infos.Lookup(3); info == nil || !info.Animated || info.Collision {
+		t.Fatalf("gid 3: got %+v", info)
+	}
+	if info := infos.Lookup(10); info == nil || info.Class != "water" {
+		t.Fatalf("gid 10: got %+v", info)
+	}
+}
+
+func TestSnapshot(t *testing.T) {
+	m, err := ParseFile("testdata/test_csv.tmx", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ground := m.FindLayer("ground")
+	ground.SetTile(Coord{0, 0}, 1)
+
+	s := m.Snapshot()
+	sground := s.FindLayer("ground")
+	if sground == ground {
+		t.Fatal("expected the snapshot to copy layers")
+	}
+	if !ground.Dirty().Empty() || sground.Dirty() != image.Rect(0, 0, 1, 1) {
+		t.Fatalf("expected the dirty rectangle to move into the snapshot, got %v and %v", ground.Dirty(), sground.Dirty())
+	}
+
+	ground.SetTile(Coord{0, 0}, 2)
+	ground.SetTile(Coord{51, 0}, 0)
+	if gid := sground.Tile(Coord{0, 0}); gid != 1 {
+		t.Fatalf("snapshot changed by SetTile, got gid %d", gid)
+	}
+	if gid := sground.Tile(Coord{51, 0}); gid != 7 {
+		t.Fatalf("snapshot changed by SetTile, got gid %d", gid)
+	}
+	if gid := ground.Tile(Coord{0, 0}); gid != 2 {
+		t.Fatalf("got gid %d, want 2", gid)
+	}
+
+	ground.Compact(m)
+	s = m.Snapshot()
+	ground.SetTile(Coord{1, 1}, 3)
+	if gid := s.FindLayer("ground").Tile(Coord{1, 1}); gid != 0 {
+		t.Fatalf("snapshot of run-length encoded layer changed, got gid %d", gid)
+	}
+
+	ground.Expand()
+	g := m.ObjectGroups[0]
+	g.Objects = append(g.Objects, &Object{X: 10, Y: 10, Visible: true})
+	s = m.Snapshot()
+	m.Resize(m.Width+1, m.Height, TopRight)
+	for i, o := range g.Objects {
+		if so := s.ObjectGroups[0].Objects[i]; so.X == o.X {
+			t.Fatalf("snapshot object %d changed by Resize", i)
+		}
+	}
+	if s.Width == m.Width {
+		t.Fatal("snapshot size changed by Resize")
+	}
+}
+
+func TestDecorate(t *testing.T) {
+	m, err := ParseFile("testdata/test_csv.tmx", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ground := m.FindLayer("ground")
+	rules := []DecorationRule{
+		{Ground: []uint32{7}, Density: 1, Tiles: []uint32{1}},
+		{Density: 0.5, Tiles: []uint32{2, 3}},
+	}
+	a := m.Decorate(ground, rules, rand.New(rand.NewSource(1)))
+	b := m.Decorate(ground, rules, rand.New(rand.NewSource(1)))
+	if !reflect.DeepEqual(a, b) {
+		t.Fatal("expected the same seed to produce the same decorations")
+	}
+	if len(a) == 0 {
+		t.Fatal("expected decorations")
+	}
+	for _, d := range a {
+		switch gid := ground.Tile(d.Coord); {
+		case gid == 0:
+			t.Fatalf("decoration %v placed on an empty cell", d)
+		case gid&^flipFlags == 7 && d.Gid != 1:
+			t.Fatalf("decoration %v placed by the wrong rule", d)
+		case gid&^flipFlags != 7 && d.Gid != 2 && d.Gid != 3:
+			t.Fatalf("decoration %v placed by the wrong rule", d)
+		}
+	}
+
+	// Keep clear of an object on top of (51,0).
+	m.ObjectGroups[0].Objects = append(m.ObjectGroups[0].Objects, &Object{X: 51 * 32, Y: 0, Width: 32, Height: 32, Visible: true})
+	rules[0].Clearance = 8
+	for _, d := range m.Decorate(ground, rules[:1], rand.New(rand.NewSource(1))) {
+		if d.Coord == (Coord{51, 0}) {
+			t.Fatal("decoration placed too close to an object")
+		}
+		if d.Coord == (Coord{52, 0}) {
+			if o := d.Object(m); o.X != 52*32 || o.Y != 32 || o.Gid != 1 {
+				t.Fatalf("got object %v", o)
+			}
+		}
+	}
+}
+
+func TestRooms(t *testing.T) {
+	m, err := Parse([]byte(`<map version="1.10" orientation="orthogonal" width="5" height="3" tilewidth="32" tileheight="32">
+ <tileset firstgid="1" name="walls" tilewidth="32" tileheight="32" tilecount="1" columns="1"/>
+ <layer name="walls" width="5" height="3">
+  <data encoding="csv">
+0,0,1,0,0,
+0,0,0,0,0,
+0,0,1,0,0
+</data>
+ </layer>
+ <objectgroup name="rooms">
+  <object id="1" class="door" x="64" y="32" width="32" height="32"/>
+  <object id="2" name="closet" class="region" x="128" y="64" width="32" height="32"/>
+ </objectgroup>
+</map>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Rooms("missing", nil) != nil {
+		t.Fatal("expected no graph for a missing layer")
+	}
+	g := m.Rooms("walls", nil)
+	if len(g.Rooms) != 3 {
+		t.Fatalf("got %d rooms, want 3", len(g.Rooms))
+	}
+	closet, left, right := g.Rooms[0], g.Rooms[1], g.Rooms[2]
+	if closet.Name != "closet" || !reflect.DeepEqual(closet.Cells, []Coord{{4, 2}}) {
+		t.Fatalf("got closet %+v", closet)
+	}
+	if len(left.Cells) != 6 || left.Bounds != image.Rect(0, 0, 2, 3) {
+		t.Fatalf("got left room %+v", left)
+	}
+	if len(right.Cells) != 5 || right.Bounds != image.Rect(3, 0, 5, 3) {
+		t.Fatalf("got right room %+v", right)
+	}
+	if g.RoomAt(Coord{2, 1}) != nil || g.RoomAt(Coord{2, 0}) != nil || g.RoomAt(Coord{4, 2}) != closet {
+		t.Fatal("unexpected RoomAt results")
+	}
+	if len(g.Doors) != 1 || !reflect.DeepEqual(g.Doors[0].Rooms, []int{1, 2}) {
+		t.Fatalf("got doors %+v", g.Doors)
+	}
+	if n := g.Neighbors(1); !reflect.DeepEqual(n, []int{2}) {
+		t.Fatalf("got neighbors %v, want [2]", n)
+	}
+	if n := g.Neighbors(0); len(n) != 0 {
+		t.Fatalf("got neighbors %v, want none", n)
+	}
+}
+
+func TestDebugOverlay(t *testing.T) {
+	m, err := ParseFile("testdata/test_csv.tmx", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := DefaultDebugGrid
+	img := DebugOverlay(m, g)
+	if img.Bounds() != image.Rect(0, 0, 60*32, 10*32) {
+		t.Fatalf("got bounds %v", img.Bounds())
+	}
+	for _, tst := range []struct {
+		x, y int
+		want color.RGBA
+	}{
+		{0, 0, g.Grid},
+		{40, 0, g.Grid},
+		{32, 10, g.Grid},
+		{2, 2, g.Label},   // "0,0"
+		{258, 2, g.Label}, // "8,0"
+		{34, 2, color.RGBA{}},
+		{40, 10, color.RGBA{}},
+	} {
+		if c := img.RGBAAt(tst.x, tst.y); c != tst.want {
+			t.Errorf("pixel (%d,%d): got %v, want %v", tst.x, tst.y, c, tst.want)
+		}
+	}
+
+	obj := LoadDebugOverlay(m, nil, g)
+	if n := len(obj.Meshes[0].Vertices); n != 6 {
+		t.Fatalf("got %d vertices, want 6", n)
+	}
+	if obj.Textures[0].MagFilter != gfx.Nearest {
+		t.Fatal("expected nearest filtering")
+	}
+}
+
+func TestFiltering(t *testing.T) {
+	_, layers, err := LoadFile("testdata/test_csv.tmx", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	obj := layers["ground"]["tilesheet.png"]
+	if tex := obj.Textures[0]; tex.MinFilter != gfx.LinearMipmapLinear || tex.MagFilter != gfx.Linear {
+		t.Fatalf("got filters %v %v, want trilinear", tex.MinFilter, tex.MagFilter)
+	}
+
+	c := DefaultConfig()
+	c.Filtering = &TextureFiltering{Nearest: true, MaxAnisotropy: 4, LODBias: -0.5}
+	_, layers, err = LoadFile("testdata/test_csv.tmx", c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	obj = layers["ground"]["tilesheet.png"]
+	if tex := obj.Textures[0]; tex.MinFilter != gfx.Nearest || tex.MagFilter != gfx.Nearest {
+		t.Fatalf("got filters %v %v, want nearest", tex.MinFilter, tex.MagFilter)
+	}
+	frag := string(obj.Shader.GLSL.Fragment)
+	if !strings.Contains(frag, "#define ANISOTROPY 4\n") || !strings.Contains(frag, "#define LOD_BIAS -0.500000\n") {
+		t.Fatalf("expected anisotropy and LOD bias defines, got shader %q", obj.Shader.Name)
+	}
+}
+
+func TestLinearize(t *testing.T) {
+	src := image.NewRGBA(image.Rect(0, 0, 3, 1))
+	src.SetRGBA(0, 0, color.RGBA{128, 255, 0, 255})
+	src.SetRGBA(1, 0, color.RGBA{64, 0, 128, 128})
+	dst := LinearImage(src)
+	if c := dst.RGBAAt(0, 0); c != (color.RGBA{55, 255, 0, 255}) {
+		t.Fatalf("got %v, want {55 255 0 255}", c)
+	}
+	want := color.RGBA{uint8(uint32(srgbToLinear[127]) * 128 / 255), 0, 128, 128}
+	if c := dst.RGBAAt(1, 0); c != want {
+		t.Fatalf("got %v, want %v", c, want)
+	}
+	if c := dst.RGBAAt(2, 0); c != (color.RGBA{}) {
+		t.Fatalf("got %v, want transparent", c)
+	}
+
+	c := DefaultConfig()
+	c.Linearize = LinearizeShader
+	_, layers, err := LoadFile("testdata/test_csv.tmx", c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := layers["ground"]["tilesheet.png"].Shader; !strings.Contains(string(s.GLSL.Fragment), "#define SRGB\n") {
+		t.Fatalf("expected an sRGB decoding shader, got %q", s.Name)
+	}
+
+	c = DefaultConfig()
+	c.Linearize = LinearizeImages
+	_, layers, err = LoadFile("testdata/test_csv.tmx", c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	obj := layers["ground"]["tilesheet.png"]
+	if strings.Contains(string(obj.Shader.GLSL.Fragment), "#define SRGB\n") {
+		t.Fatal("expected no sRGB decoding shader")
+	}
+	_, plain, err := LoadFile("testdata/test_csv.tmx", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, b := obj.Textures[0].Source.(*image.RGBA), plain["ground"]["tilesheet.png"].Textures[0].Source.(*image.RGBA)
+	if !reflect.DeepEqual(a.Pix, LinearImage(b).Pix) {
+		t.Fatal("expected the texture to hold linear colors")
+	}
+}
+
+func TestApplyOverlay(t *testing.T) {
+	base, err := Parse([]byte(`<map version="1.10" orientation="orthogonal" width="3" height="1" tilewidth="32" tileheight="32">
+ <tileset firstgid="1" name="a" tilewidth="32" tileheight="32" tilecount="4" columns="4"/>
+ <layer name="ground" width="3" height="1">
+  <data encoding="csv">1,0,2</data>
+ </layer>
+ <objectgroup name="spawns">
+  <object id="1" name="player" x="0" y="0"/>
+ </objectgroup>
+</map>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	overlay, err := Parse([]byte(`<map version="1.10" orientation="orthogonal" width="3" height="1" tilewidth="32" tileheight="32">
+ <tileset firstgid="1" name="b" tilewidth="32" tileheight="32" tilecount="2" columns="2"/>
+ <tileset firstgid="3" name="a" tilewidth="32" tileheight="32" tilecount="4" columns="4"/>
+ <layer name="ground" width="3" height="1">
+  <data encoding="csv">0,4,3</data>
+ </layer>
+ <layer name="snow" width="3" height="1">
+  <properties>
+   <property name="overlay-precedence" value="base"/>
+  </properties>
+  <data encoding="csv">1,1,2</data>
+ </layer>
+ <objectgroup name="spawns">
+  <object id="1" name="player" x="32" y="0"/>
+  <object id="2" name="santa" x="64" y="0"/>
+ </objectgroup>
+</map>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := base.ApplyOverlay(overlay, OverlayWins); err != nil {
+		t.Fatal(err)
+	}
+	if len(base.Tilesets) != 2 || base.Tilesets[1].Name != "b" || base.Tilesets[1].Firstgid != 5 {
+		t.Fatalf("got tilesets %v", base.Tilesets)
+	}
+	ground := base.FindLayer("ground")
+	if got := ground.GIDs(base); !reflect.DeepEqual(got, []uint32{1, 2, 1}) {
+		t.Fatalf("got ground %v, want [1 2 1]", got)
+	}
+	if ground.Dirty() != image.Rect(1, 0, 3, 1) {
+		t.Fatalf("got dirty %v", ground.Dirty())
+	}
+	snow := base.FindLayer("snow")
+	if snow == nil || snow.Index != 2 {
+		t.Fatalf("got snow layer %v", snow)
+	}
+	if got := snow.GIDs(base); !reflect.DeepEqual(got, []uint32{5, 5, 6}) {
+		t.Fatalf("got snow %v, want [5 5 6]", got)
+	}
+	spawns := base.ObjectGroups[0].Objects
+	if len(spawns) != 2 || spawns[0].X != 32 || spawns[1].Name != "santa" {
+		t.Fatalf("got spawns %v", spawns)
+	}
+
+	// The base wins where both have tiles.
+	patch := &Map{Orientation: Orthogonal, TileWidth: 32, TileHeight: 32, Tilesets: base.Tilesets[:1]}
+	patch.Layers = []*Layer{{Name: "ground", Tiles: map[Coord]uint32{{0, 0}: 3}}}
+	if err := base.ApplyOverlay(patch, BaseWins); err != nil {
+		t.Fatal(err)
+	}
+	if gid := ground.Tile(Coord{0, 0}); gid != 1 {
+		t.Fatalf("got gid %d, want 1", gid)
+	}
+
+	patch.TileWidth = 16
+	if base.ApplyOverlay(patch, OverlayWins) == nil {
+		t.Fatal("expected an error for differing tile sizes")
+	}
+}
+
+func TestRuntimeState(t *testing.T) {
+	load := func() *Map {
+		m, err := ParseFile("testdata/test_csv.tmx", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		g := m.ObjectGroups[0]
+		g.Objects = append(g.Objects, &Object{Name: "a"}, &Object{Name: "b"})
+		return m
+	}
+	m := load()
+	s := NewRuntimeState(m)
+	s.SetTile(m.FindLayer("ground"), Coord{0, 0}, 3)
+	s.SetTile(m.FindLayer("ground"), Coord{51, 0}, 0)
+	if !s.SetVisible("flowers", false) || s.SetVisible("missing", false) {
+		t.Fatal("unexpected SetVisible results")
+	}
+	g := m.ObjectGroups[0]
+	n := len(g.Objects)
+	b := g.Objects[n-1]
+	s.RemoveObject(b)
+	s.RemoveObject(&Object{Name: "spawned"})
+	if len(g.Objects) != n-1 {
+		t.Fatalf("got %d objects, want %d", len(g.Objects), n-1)
+	}
+
+	data, err := s.Save().Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved, err := DecodeSavedState(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(saved.Removed, []ObjectRef{{g.Name, n - 1}}) {
+		t.Fatalf("got removed %v", saved.Removed)
+	}
+
+	restored := load()
+	rs, err := RestoreRuntimeState(restored, saved)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ground := restored.FindLayer("ground")
+	if ground.Tile(Coord{0, 0}) != 3 || ground.Tile(Coord{51, 0}) != 0 {
+		t.Fatal("tiles not restored")
+	}
+	if restored.FindLayer("flowers").Visible {
+		t.Fatal("visibility not restored")
+	}
+	rg := restored.ObjectGroups[0]
+	if len(rg.Objects) != n-1 || rg.Objects[n-2].Name != "a" {
+		t.Fatalf("objects not restored, got %v", rg.Objects)
+	}
+	if !reflect.DeepEqual(rs.Save(), saved) {
+		t.Fatalf("got %+v, want %+v", rs.Save(), saved)
+	}
+
+	// States of other maps are rejected.
+	other := load()
+	other.FindLayer("ground").SetTile(Coord{0, 0}, 9)
+	if _, err := RestoreRuntimeState(other, saved); err == nil {
+		t.Fatal("expected an error for a diverged map")
+	}
+	if !other.FindLayer("flowers").Visible {
+		t.Fatal("expected the map to be unchanged")
+	}
+}
+
+func TestRandomizeTransforms(t *testing.T) {
+	data := []byte(`<map version="1.10" orientation="orthogonal" width="8" height="8" tilewidth="32" tileheight="32">
+ <tileset firstgid="1" name="grass" tilewidth="32" tileheight="32" tilecount="2" columns="2">
+  <transformations hflip="1" vflip="0" rotate="0" preferuntransformed="0"/>
+ </tileset>
+ <tileset firstgid="3" name="walls" tilewidth="32" tileheight="32" tilecount="1" columns="1"/>
+</map>`)
+	run := func() *Layer {
+		m, err := Parse(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		l := &Layer{Name: "ground"}
+		for i := 0; i < 64; i++ {
+			gid := uint32(1)
+			if i%8 == 0 {
+				gid = 3
+			} else if i%8 == 1 {
+				gid = 2
+			}
+			l.SetTile(Coord{i % 8, i / 8}, gid)
+		}
+		m.Layers = []*Layer{l}
+		m.RandomizeTransforms(l, []uint32{1, 3}, rand.New(rand.NewSource(1)))
+		return l
+	}
+	a, b := run(), run()
+	if !reflect.DeepEqual(a.Tiles, b.Tiles) {
+		t.Fatal("expected the same seed to produce the same result")
+	}
+	flipped := 0
+	for c, gid := range a.Tiles {
+		switch c.X {
+		case 0:
+			if gid != 3 {
+				t.Fatalf("tile %v of a tileset without transformations changed to %#x", c, gid)
+			}
+		case 1:
+			if gid != 2 {
+				t.Fatalf("unselected tile %v changed to %#x", c, gid)
+			}
+		default:
+			if gid&^FLIPPED_HORIZONTALLY_FLAG != 1 {
+				t.Fatalf("tile %v got forbidden transformation %#x", c, gid)
+			}
+			if gid != 1 {
+				flipped++
+			}
+		}
+	}
+	if flipped == 0 || flipped == 48 {
+		t.Fatalf("got %d of 48 tiles flipped", flipped)
+	}
+}
+
+func TestSpawnManifest(t *testing.T) {
+	p, err := ParseProject([]byte(`{
+ "propertyTypes": [
+  {
+   "id": 1,
+   "name": "Enemy",
+   "type": "class",
+   "useAs": ["object"],
+   "members": [
+    {"name": "hp", "type": "int", "value": 10},
+    {"name": "flying", "type": "bool", "value": false}
+   ]
+  }
+ ]
+}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := Parse([]byte(`<map version="1.10" orientation="orthogonal" width="4" height="4" tilewidth="10" tileheight="10">
+ <objectgroup name="enemies" offsetx="5">
+  <object name="bat" class="Enemy" x="10" y="20" rotation="90">
+   <properties>
+    <property name="flying" value="true"/>
+    <property name="note" value="spooky"/>
+   </properties>
+  </object>
+  <object name="hidden" class="Enemy" x="0" y="0" visible="0"/>
+ </objectgroup>
+ <objectgroup name="items">
+  <object name="key" class="Item" x="30" y="30"/>
+ </objectgroup>
+</map>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	spawns := SpawnManifest(m, nil, &SpawnConfig{Project: p})
+	if len(spawns) != 2 {
+		t.Fatalf("got %d spawns, want 2", len(spawns))
+	}
+	bat := spawns[0]
+	if bat.Type != "Enemy" || bat.Group != "enemies" || bat.Position.X != 15 || bat.Position.Z != 20 || bat.Rotation != 90 {
+		t.Fatalf("got %+v", bat)
+	}
+	want := map[string]interface{}{"hp": int64(10), "flying": true, "note": "spooky"}
+	if !reflect.DeepEqual(bat.Properties, want) {
+		t.Fatalf("got properties %v, want %v", bat.Properties, want)
+	}
+	if spawns[1].Name != "key" || spawns[1].Position.Y >= bat.Position.Y {
+		t.Fatalf("got %+v", spawns[1])
+	}
+
+	spawns = SpawnManifest(m, nil, &SpawnConfig{Types: []string{"Enemy"}, Invisible: true})
+	if len(spawns) != 2 || spawns[1].Name != "hidden" || spawns[0].Properties["flying"] != "true" {
+		t.Fatalf("got %+v", spawns)
+	}
+	if spawns := SpawnManifest(m, nil, &SpawnConfig{Groups: []string{"items"}}); len(spawns) != 1 || spawns[0].Name != "key" {
+		t.Fatalf("got %+v", spawns)
+	}
+
+	var buf bytes.Buffer
+	if err := WriteSpawnManifest(&buf, SpawnManifest(m, nil, &SpawnConfig{Groups: []string{"items"}})); err != nil {
+		t.Fatal(err)
+	}
+	var decoded []Spawn
+	if err := json.Unmarshal(buf.Bytes(), &decoded); err != nil || len(decoded) != 1 || decoded[0].Type != "Item" {
+		t.Fatalf("got %v, %v", decoded, err)
+	}
+}
+
+func TestTilesetGrid(t *testing.T) {
+	// An image with trailing padding, and resized to twice it's size.
+	ts := &Tileset{
+		Firstgid:  1,
+		Width:     16,
+		Height:    16,
+		TileCount: 5,
+		Columns:   2,
+		Image:     &Image{Width: 100, Height: 100},
+	}
+	if c, r := ts.Grid(); c != 2 || r != 3 {
+		t.Fatalf("incorrect grid %dx%d", c, r)
+	}
+	if r := ts.RectForGID(4); r != image.Rect(16, 16, 32, 32) {
+		t.Fatal("incorrect rect", r)
+	}
+	if n := tileCount(ts); n != 5 {
+		t.Fatal("incorrect tile count", n)
+	}
+
+	// Without columns nor a tile count the image is used.
+	ts.TileCount, ts.Columns = 0, 0
+	if c, r := ts.Grid(); c != 6 || r != 6 {
+		t.Fatalf("incorrect grid %dx%d from image", c, r)
+	}
+	ts.Image = nil
+	if c, r := ts.Grid(); c != 0 || r != 0 {
+		t.Fatalf("expected an unknown grid, got %dx%d", c, r)
+	}
+}
+
+func TestTileDataProblems(t *testing.T) {
+	parse := func(csv string, c *ParseConfig) (*Map, error) {
+		return ParseWithConfig([]byte(`<map version="1.0" orientation="orthogonal" width="2" height="2" tilewidth="16" tileheight="16">
+ <tileset firstgid="1" name="ts" tilewidth="16" tileheight="16" tilecount="4" columns="2">
+  <image source="ts.png" width="32" height="32"/>
+ </tileset>
+ <layer name="l" width="2" height="2">
+  <data encoding="csv">`+csv+`</data>
+ </layer>
+</map>`), c)
+	}
+
+	// By default problems are ignored.
+	m, err := parse("1,2,3,4,1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m.Layers[0].Tiles) != 4 {
+		t.Fatal("expected the tile past the end to be dropped, got", m.Layers[0].Tiles)
+	}
+	if _, err := parse("1,2,3", nil); err != nil {
+		t.Fatal(err)
+	}
+	if m, err = parse("1,2,3,9", nil); err != nil || m.Layers[0].Tile(Coord{1, 1}) != 9 {
+		t.Fatal("expected the invalid gid to be kept", err)
+	}
+
+	var warnings []error
+	warn := &ParseConfig{Problems: WarnProblems, Warn: func(err error) {
+		warnings = append(warnings, err)
+	}}
+	if m, err = parse("1,2,3,9", warn); err != nil {
+		t.Fatal(err)
+	}
+	if len(warnings) != 1 || m.Layers[0].Tile(Coord{1, 1}) != 0 {
+		t.Fatal("expected the invalid gid to be warned about and cleared", warnings)
+	}
+	if _, err = parse("1,2,3", warn); err != nil || len(warnings) != 2 || !errors.Is(warnings[1], ErrBadTileCount) {
+		t.Fatal("expected a warning about short tile data", err, warnings)
+	}
+
+	// Invalid gids are reported in row-major order.
+	for i := 0; i < 10; i++ {
+		warnings = nil
+		if _, err = parse("9,1,8,7", warn); err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, w := range warnings {
+			got = append(got, w.Error())
+		}
+		want := []string{
+			`layer "l": tile {0 0} has invalid gid 9`,
+			`layer "l": tile {0 1} has invalid gid 8`,
+			`layer "l": tile {1 1} has invalid gid 7`,
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Fatal("incorrect warnings", got)
+		}
+	}
+
+	reject := &ParseConfig{Problems: RejectProblems}
+	if _, err := parse("1,2,3,4,1", reject); !errors.Is(err, ErrBadTileCount) {
+		t.Fatal("expected ErrBadTileCount, got", err)
+	}
+	if _, err := parse("1,2,3,5", reject); err == nil {
+		t.Fatal("expected an error for an invalid gid")
+	}
+	if _, err := parse("1,2,x,4", reject); err == nil {
+		t.Fatal("expected a syntax error")
+	}
+	if _, err := parse("1,2,3,2147483652", reject); err != nil {
+		t.Fatal("expected a flipped gid to be valid", err)
+	}
+}
+
+func FuzzDecodeTiles(f *testing.F) {
+	word := func(gids ...uint32) string {
+		var buf []byte
+		for _, gid := range gids {
+			buf = append(buf, byte(gid), byte(gid>>8), byte(gid>>16), byte(gid>>24))
+		}
+		return base64.StdEncoding.EncodeToString(buf)
+	}
+	f.Add("csv", "1,2,3,4", 2, 2)
+	f.Add("csv", "1,2\n3,4,5,6", 2, 2)
+	f.Add("base64", word(1, 2, 3, 4), 2, 2)
+	f.Add("base64", word(1, 2, 3), 2, 2)
+	f.Add("base64", "AQAAAA", 1, 1)
+	f.Add("csv", "", 0, 0)
+	f.Fuzz(func(t *testing.T, encoding, data string, width, height int) {
+		if width > 64 || height > 64 {
+			return
+		}
+		x := xmlData{Data: []byte(data), Encoding: Encoding(encoding)}
+		tiles, _, err := x.tiles(width, height, nil, func(err error) error {
+			return err
+		})
+		if err != nil {
+			return
+		}
+		for c := range tiles {
+			if c.X < 0 || c.Y < 0 || c.X >= width || c.Y >= height {
+				t.Fatalf("tile %v outside of a %dx%d layer", c, width, height)
+			}
+		}
+	})
+}
+
+func TestEnums(t *testing.T) {
+	for _, tst := range []struct {
+		v    interface{ String() string }
+		want string
+	}{
+		{Hexagonal, "hexagonal"},
+		{Invalid, "Orientation(0)"},
+		{StaggerAxisX, "x"},
+		{StaggerIndexEven, "even"},
+		{LeftUp, "left-up"},
+		{RenderOrder(9), "RenderOrder(9)"},
+		{XMLEncoding, "xml"},
+		{CSVEncoding, "csv"},
+		{NoCompression, "none"},
+		{GzipCompression, "gzip"},
+	} {
+		if s := tst.v.String(); s != tst.want {
+			t.Errorf("got %q, want %q", s, tst.want)
+		}
+	}
+
+	m, err := Parse([]byte(`<map version="1.0" orientation="orthogonal" renderorder="left-up" width="1" height="1" tilewidth="32" tileheight="32">
+</map>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.RenderOrder != LeftUp {
+		t.Fatal("incorrect render order", m.RenderOrder)
+	}
+	if m2 := roundTrip(t, m, nil); m2.RenderOrder != LeftUp {
+		t.Fatal("render order not written", m2.RenderOrder)
+	}
+	if _, err := Parse([]byte(`<map version="1.0" orientation="orthogonal" renderorder="up" width="1" height="1" tilewidth="32" tileheight="32">
+</map>`)); err == nil {
+		t.Fatal("expected an error for an unknown render order")
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tmx")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for _, name := range []string{"test_xml.tmx", "test_csv.tmx"} {
+		data, err := ioutil.ReadFile(filepath.Join("testdata", name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(dir, name), data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	before := parseFile(t, "test_xml.tmx")
+
+	migrated, err := MigrateDir(dir, Base64Encoding, ZlibCompression)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(migrated) != 1 || filepath.Base(migrated[0]) != "test_xml.tmx" {
+		t.Fatal("expected only the plain XML map to be migrated, got", migrated)
+	}
+	after, err := ParseFile(migrated[0], nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, l := range after.Layers {
+		if l.Encoding != Base64Encoding || l.Compression != ZlibCompression {
+			t.Fatal("incorrect encoding", l.Name, l.Encoding, l.Compression)
+		}
+		if !reflect.DeepEqual(l.Tiles, before.Layers[i].Tiles) {
+			t.Fatal("tiles changed by migration", l.Name)
+		}
+	}
+	if ok, err := MigrateFile(migrated[0], CSVEncoding, NoCompression); ok || err != nil {
+		t.Fatal("expected a migrated map to be left alone", ok, err)
+	}
+	if _, err := MigrateFile(migrated[0], XMLEncoding, NoCompression); err == nil {
+		t.Fatal("expected an error migrating to plain XML")
+	}
+}
+
+func TestTileAtPixel(t *testing.T) {
+	for _, m := range []*Map{
+		{Orientation: Orthogonal, Width: 4, Height: 4, TileWidth: 32, TileHeight: 16},
+		{Orientation: Isometric, Width: 4, Height: 4, TileWidth: 32, TileHeight: 16},
+		{Orientation: Staggered, Width: 4, Height: 4, TileWidth: 32, TileHeight: 16},
+		{Orientation: Staggered, Width: 4, Height: 4, TileWidth: 32, TileHeight: 16, StaggerAxis: StaggerAxisX, StaggerIndex: StaggerIndexEven},
+		{Orientation: Hexagonal, Width: 4, Height: 4, TileWidth: 32, TileHeight: 32, HexSideLength: 16},
+		{Orientation: Hexagonal, Width: 4, Height: 4, TileWidth: 32, TileHeight: 32, HexSideLength: 16, StaggerAxis: StaggerAxisX},
+	} {
+		for y := 0; y < m.Height; y++ {
+			for x := 0; x < m.Width; x++ {
+				shape := m.CellShape(Coord{x, y})
+				var center Pixel
+				for _, p := range shape {
+					center.X += p.X / float64(len(shape))
+					center.Y += p.Y / float64(len(shape))
+				}
+				// Just inside of each corner, and the center.
+				for _, p := range append(shape, center) {
+					p = Pixel{p.X + (center.X-p.X)*0.1, p.Y + (center.Y-p.Y)*0.1}
+					if c := m.TileAtPixel(p); c != (Coord{x, y}) {
+						t.Fatalf("%v: pixel %v picked %v, want %v", m.Orientation, p, c, Coord{x, y})
+					}
+				}
+			}
+		}
+	}
+
+	// The top-left corner of the bounds of an isometric cell is not within
+	// the cell's diamond.
+	m := &Map{Orientation: Isometric, Width: 4, Height: 4, TileWidth: 32, TileHeight: 16}
+	r := m.TileBounds(1, 1)
+	if c := m.TileAtPixel(Pixel{float64(r.Min.X) + 1, float64(r.Min.Y) + 1}); c != (Coord{0, 1}) {
+		t.Fatal("incorrect isometric cell", c)
+	}
+	if n := len(m.CellShape(Coord{0, 0})); n != 4 {
+		t.Fatal("expected a diamond, got", n, "points")
+	}
+	mesh := CellHighlightMesh(m, nil, Coord{1, 1}, gfx.Color{1, 1, 0, 0.5})
+	if len(mesh.Vertices) != 6 || len(mesh.Colors) != 6 || len(mesh.TexCoords[0].Slice) != 6 {
+		t.Fatal("expected a quad mesh, got", len(mesh.Vertices), "vertices")
+	}
+	v := PixelToWorld(m, nil, m.CellShape(Coord{1, 1})[0])
+	found := false
+	for _, mv := range mesh.Vertices {
+		if float64(mv.X) == v.X && float64(mv.Z) == v.Z {
+			found = true
+		}
+	}
+	if !found {
+		t.Fatal("highlight does not cover the cell's corner")
+	}
+}
+
+func TestReplaceTextures(t *testing.T) {
+	m, layers, err := LoadFile("testdata/test_csv.tmx", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := layers["ground"]["tilesheet.png"]
+	mesh := old.Meshes[0]
+	b := old.Textures[0].Bounds
+	rgba := image.NewRGBA(b)
+	n, err := ReplaceTextures(layers, "tilesheet.png", rgba)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != len(m.Layers) {
+		t.Fatalf("replaced %d textures, want %d", n, len(m.Layers))
+	}
+	for _, objects := range layers {
+		obj := objects["tilesheet.png"]
+		if obj.Textures[0].Source != rgba || obj.Textures[0].Loaded {
+			t.Fatal("texture not replaced")
+		}
+	}
+	if old.Meshes[0] != mesh {
+		t.Fatal("mesh was rebuilt")
+	}
+
+	// Textures shared through a cache are replaced once, and grouped objects
+	// are found too.
+	c := DefaultConfig()
+	c.Textures = NewTextureCache()
+	_, shared, err := LoadFile("testdata/test_csv.tmx", c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	grouped := map[string]map[string]*gfx.Object{"all": {}}
+	for layer, objects := range shared {
+		for name, obj := range objects {
+			grouped["all"][layer+"/"+name] = obj
+		}
+	}
+	if n, err := ReplaceTextures(grouped, "tilesheet.png", rgba); err != nil || n != 1 {
+		t.Fatal("expected a single shared texture to be replaced", n, err)
+	}
+
+	if _, err := ReplaceTextures(layers, "tilesheet.png", image.NewRGBA(image.Rect(0, 0, 1, 1))); err == nil {
+		t.Fatal("expected an error for an image of a different size")
+	}
+}
+
+var updateGolden = flag.Bool("update-golden", false, "write the golden images of TestGolden")
+
+func TestGolden(t *testing.T) {
+	for _, name := range []string{"test_csv", "test_base64_zlib", "test_infinite"} {
+		c := DefaultConfig()
+		c.TopLeftOrigin = name == "test_infinite"
+		m, layers, err := LoadFile(filepath.Join("testdata", name+".tmx"), c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		img := RasterizeLayers(m, c, layers)
+		if err := CheckGolden(filepath.Join("testdata", "golden", name+".png"), img, 2, *updateGolden); err != nil {
+			t.Error(err)
+		}
+	}
+
+	a := image.NewRGBA(image.Rect(0, 0, 2, 2))
+	b := image.NewRGBA(image.Rect(5, 5, 7, 7))
+	b.SetRGBA(6, 6, color.RGBA{3, 0, 0, 0})
+	if n := CompareImages(a, b, 2); n != 1 {
+		t.Fatal("expected one differing pixel, got", n)
+	}
+	if n := CompareImages(a, b, 3); n != 0 {
+		t.Fatal("expected no differing pixels within the tolerance, got", n)
+	}
+	if n := CompareImages(a, image.NewRGBA(image.Rect(0, 0, 3, 2)), 0); n != 2 {
+		t.Fatal("expected the extra column to differ, got", n)
+	}
+}
+
+func TestRasterizeIndexed(t *testing.T) {
+	m := &Map{Width: 2, Height: 2, TileWidth: 16, TileHeight: 16}
+	tex := image.NewRGBA(image.Rect(0, 0, 2, 1))
+	tex.SetRGBA(0, 0, color.RGBA{255, 0, 0, 255})
+	tex.SetRGBA(1, 0, color.RGBA{0, 0, 255, 255})
+	object := func(mesh *gfx.Mesh) map[string]map[string]*gfx.Object {
+		obj := gfx.NewObject()
+		obj.Meshes = []*gfx.Mesh{mesh}
+		obj.Textures = []*gfx.Texture{gfx.NewTexture()}
+		obj.Textures[0].Source = tex
+		return map[string]map[string]*gfx.Object{"a": {"a": obj}}
+	}
+
+	// An indexed mesh renders like the same triangles given consecutively.
+	indexed, _ := DedupCards([]Instance{{Width: 16, Height: 16, U1: 1, V1: 1}})
+	flat := gfx.NewMesh()
+	flat.TexCoords = []gfx.TexCoordSet{{}}
+	for _, i := range indexed.Indices {
+		flat.Vertices = append(flat.Vertices, indexed.Vertices[i])
+		flat.TexCoords[0].Slice = append(flat.TexCoords[0].Slice, indexed.TexCoords[0].Slice[i])
+	}
+	got := RasterizeLayers(m, nil, object(indexed))
+	want := RasterizeLayers(m, nil, object(flat))
+	if got.Bounds() != image.Rect(-8, 24, 8, 40) {
+		t.Fatal("incorrect bounds", got.Bounds())
+	}
+	if n := CompareImages(got, want, 0); n != 0 {
+		t.Fatal("indexed mesh differs from the flat one in", n, "pixels")
+	}
+	if got.RGBAAt(-8, 24) != (color.RGBA{255, 0, 0, 255}) || got.RGBAAt(7, 39) != (color.RGBA{0, 0, 255, 255}) {
+		t.Fatal("incorrect texels", got.RGBAAt(-8, 24), got.RGBAAt(7, 39))
+	}
+}
+
+// xorCodec is a toy compression, which flips the bits of tile data.
+type xorCodec struct {
+	r io.Reader
+	w io.Writer
+}
+
+func (x xorCodec) Read(p []byte) (int, error) {
+	n, err := x.r.Read(p)
+	for i := range p[:n] {
+		p[i] ^= 0xff
+	}
+	return n, err
+}
+
+func (x xorCodec) Write(p []byte) (int, error) {
+	q := make([]byte, len(p))
+	for i := range p {
+		q[i] = p[i] ^ 0xff
+	}
+	return x.w.Write(q)
+}
+
+func (x xorCodec) Close() error { return nil }
+
+func TestRegisterCompression(t *testing.T) {
+	const xor Compression = "xor"
+	RegisterCompression(xor, func(r io.Reader) (io.ReadCloser, error) {
+		return xorCodec{r: r}, nil
+	}, func(w io.Writer, level int) (io.WriteCloser, error) {
+		return xorCodec{w: w}, nil
+	})
+	defer RegisterCompression(xor, nil, nil)
+	if got := Compressions(); !reflect.DeepEqual(got, []Compression{GzipCompression, xor, ZlibCompression}) {
+		t.Fatal("unexpected compressions", got)
+	}
+
+	m := parseFile(t, "test_csv.tmx")
+	data, err := Marshal(m, &WriteConfig{Encoding: Base64Encoding, Compression: xor})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(data, []byte(`compression="xor"`)) {
+		t.Fatal("expected xor compressed tile data")
+	}
+	got, err := Parse(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, l := range m.Layers {
+		if !reflect.DeepEqual(got.Layers[i].GIDs(got), l.GIDs(m)) {
+			t.Error("layer", i, "tiles differ after round trip")
+		}
+	}
+
+	// Decode only codecs cannot be written.
+	RegisterCompression(xor, func(r io.Reader) (io.ReadCloser, error) {
+		return xorCodec{r: r}, nil
+	}, nil)
+	if _, err := Parse(data); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Marshal(m, &WriteConfig{Encoding: Base64Encoding, Compression: xor}); err != ErrBadCompression {
+		t.Fatal("expected ErrBadCompression, got", err)
+	}
+
+	RegisterCompression(xor, nil, nil)
+	if _, err := Parse(data); !errors.Is(err, ErrBadCompression) {
+		t.Fatal("expected ErrBadCompression, got", err)
+	}
+}
+
+func TestImageWorkers(t *testing.T) {
+	path := filepath.Join("testdata", "test_csv_tsx.tmx")
+	_, want, _, err := readMapFile(path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, got, _, err := readMapFile(path, &Config{ImageWorkers: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(want) != 2 || !reflect.DeepEqual(got, want) {
+		t.Fatal("images differ when decoded in parallel")
+	}
+
+	// With zero or one workers, images are decoded on the calling goroutine.
+	var inline []bool
+	image.RegisterFormat("tmxstack", "TMXSTACK", func(r io.Reader) (image.Image, error) {
+		buf := make([]byte, 1<<16)
+		inline = append(inline, strings.Contains(string(buf[:runtime.Stack(buf, false)]), ".TestImageWorkers("))
+		return image.NewRGBA(image.Rect(0, 0, 1, 1)), nil
+	}, nil)
+	dir, err := ioutil.TempDir("", "tmx")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	files := map[string]string{
+		"stack.tmx": `<map version="1.0" orientation="orthogonal" width="1" height="1" tilewidth="1" tileheight="1">
+ <tileset firstgid="1" name="stack" tilewidth="1" tileheight="1">
+  <image source="stack.img" width="1" height="1"/>
+ </tileset>
+</map>`,
+		"stack.img": "TMXSTACK",
+	}
+	for name, data := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, workers := range []int{0, 1} {
+		if _, _, _, err := readMapFile(filepath.Join(dir, "stack.tmx"), &Config{ImageWorkers: workers}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !reflect.DeepEqual(inline, []bool{true, true}) {
+		t.Fatal("images not decoded on the calling goroutine", inline)
+	}
+
+	// NRGBA images are premultiplied in place, like draw.Draw would.
+	src := image.NewNRGBA(image.Rect(0, 0, 16, 16))
+	for i := range src.Pix {
+		src.Pix[i] = uint8(i * 7)
+	}
+	expect := image.NewRGBA(src.Bounds())
+	draw.Draw(expect, expect.Bounds(), src, image.ZP, draw.Src)
+	buf := new(bytes.Buffer)
+	if err := png.Encode(buf, src); err != nil {
+		t.Fatal(err)
+	}
+	rgba, err := decodeRGBA(buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(rgba, expect) {
+		t.Fatal("NRGBA image converted incorrectly")
+	}
+}
+
+func TestAppendTileQuad(t *testing.T) {
+	for _, topLeft := range []bool{false, true} {
+		c := DefaultConfig()
+		c.DepthOrder = true
+		c.TopLeftOrigin = topLeft
+		m, layers, err := LoadFile(filepath.Join("testdata", "test_csv.tmx"), c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		l := m.Layers[0]
+		want := layers[l.Name]["tilesheet.png"].Meshes[0]
+
+		// Load appends the tiles of each column from top to bottom.
+		mesh := gfx.NewMesh()
+		for x := 0; x < m.Width; x++ {
+			for y := 0; y < m.Height; y++ {
+				p, src, ok := TileQuad(m, c, l, Coord{x, y})
+				if !ok {
+					continue
+				}
+				AppendTileQuad(mesh, p, src, &QuadOptions{
+					Texture:       image.Rect(0, 0, m.Tilesets[0].Image.Width, m.Tilesets[0].Image.Height),
+					TopLeftOrigin: topLeft,
+				})
+			}
+		}
+		if len(mesh.Vertices) == 0 || len(mesh.Vertices) != len(want.Vertices) {
+			t.Fatal("expected", len(want.Vertices), "vertices, got", len(mesh.Vertices))
+		}
+		for i, v := range mesh.Vertices {
+			w := want.Vertices[i]
+			if math.Abs(float64(v.X-w.X)) > 1e-3 || math.Abs(float64(v.Y-w.Y)) > 1e-6 || math.Abs(float64(v.Z-w.Z)) > 1e-3 {
+				t.Fatal("vertex", i, "is", v, "expected", w)
+			}
+		}
+		if !reflect.DeepEqual(mesh.TexCoords[0].Slice, want.TexCoords[0].Slice) {
+			t.Fatal("texture coordinates differ")
+		}
+	}
+
+	// Colors are appended per vertex.
+	mesh := gfx.NewMesh()
+	color := gfx.Color{1, 0, 0, 1}
+	AppendTileQuad(mesh, TilePlacement{Width: 32, Height: 32, Flips: Flip(FLIPPED_HORIZONTALLY_FLAG)}, image.Rect(0, 0, 32, 32), &QuadOptions{Color: &color})
+	if len(mesh.Vertices) != 6 || len(mesh.Colors) != 6 || mesh.Colors[5] != color {
+		t.Fatal("unexpected card", mesh.Vertices, mesh.Colors)
+	}
+}
@@ -0,0 +1,15 @@
+<?xml version="1.0" encoding="UTF-8"?>
+<tileset name="tilesheet" tilewidth="32" tileheight="32">
+ <image source="tilesheet.png" width="288" height="96"/>
+ <terraintypes>
+  <terrain name="myuglyterrain" tile="17"/>
+ </terraintypes>
+ <tile id="16" terrain=",,,0"/>
+ <tile id="17" terrain=",,0,0"/>
+ <tile id="25" terrain="0,0,0,0"/>
+ <tile id="26" terrain="0,0,0,0">
+  <properties>
+   <property name="mytileproperty" value="mytilepropertyvalue"/>
+  </properties>
+ </tile>
+</tileset>
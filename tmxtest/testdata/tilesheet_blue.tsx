@@ -0,0 +1,4 @@
+<?xml version="1.0" encoding="UTF-8"?>
+<tileset name="tilesheet_blue" tilewidth="32" tileheight="32">
+ <image source="tilesheet_blue.png" width="288" height="96"/>
+</tileset>
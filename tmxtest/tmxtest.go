@@ -0,0 +1,172 @@
+// Copyright 2014 Lightpoke. All rights reserved.
+// This source code is subject to the terms and
+// conditions defined in the "License.txt" file.
+
+// Package tmxtest provides a corpus of sample TMX maps, along with their
+// external tilesets, templates and images, and helpers for parsing them, such
+// that packages using the tmx package can test against realistic maps without
+// bundling maps of their own.
+//
+// The corpus is embedded in the package, maps are named by the constants
+// below. Each call parses a fresh map, so tests may freely modify them.
+package tmxtest
+
+import (
+	"bytes"
+	"embed"
+	"image"
+	"image/draw"
+	_ "image/png"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+	"testing"
+
+	"azul3d.org/tmx.v1"
+)
+
+//go:embed testdata
+var corpus embed.FS
+
+// The names of the maps of the corpus.
+const (
+	// An orthogonal map with several layers, an object group and two
+	// external tilesets.
+	Orthogonal = "orthogonal.tmx"
+
+	// An isometric map with an external tileset.
+	Isometric = "isometric.tmx"
+
+	// A staggered map, whose even rows are shifted.
+	Staggered = "staggered.tmx"
+
+	// A hexagonal map, whose odd rows are shifted.
+	Hexagonal = "hexagonal.tmx"
+
+	// An infinite map, whose tiles are stored in chunks at negative and
+	// positive coordinates.
+	Infinite = "infinite.tmx"
+
+	// An orthogonal map whose tile data is zlib compressed, with an embedded
+	// tileset.
+	Compressed = "compressed.tmx"
+
+	// An orthogonal map with an animated tile.
+	Animated = "animated.tmx"
+
+	// An orthogonal map with objects created from an object template (see
+	// the "spawn.tx" file of FS). The tmx package does not resolve templates,
+	// so the objects only hold the attributes and properties that override
+	// those of the template.
+	Template = "template.tmx"
+)
+
+// FS returns the file system holding the corpus: the maps along with their
+// external tilesets, templates and images.
+func FS() fs.FS {
+	sub, err := fs.Sub(corpus, "testdata")
+	if err != nil {
+		panic(err)
+	}
+	return sub
+}
+
+// Names returns the names of all of the maps of the corpus, sorted.
+func Names() []string {
+	entries, err := fs.ReadDir(FS(), ".")
+	if err != nil {
+		panic(err)
+	}
+	var names []string
+	for _, e := range entries {
+		if strings.HasSuffix(e.Name(), ".tmx") {
+			names = append(names, e.Name())
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Data returns the data of the named file of the corpus.
+func Data(name string) ([]byte, error) {
+	return fs.ReadFile(FS(), name)
+}
+
+// Parse parses the named map of the corpus using the given configuration (see
+// tmx.ParseWithConfig), and loads it's external tilesets.
+func Parse(name string, c *tmx.ParseConfig) (*tmx.Map, error) {
+	data, err := Data(name)
+	if err != nil {
+		return nil, err
+	}
+	m, err := tmx.ParseWithConfig(data, c)
+	if err != nil {
+		return nil, err
+	}
+	for _, ts := range m.Tilesets {
+		if len(ts.Source) == 0 {
+			continue
+		}
+		data, err := Data(path.Base(ts.Source))
+		if err != nil {
+			return nil, err
+		}
+		if err := ts.Load(data); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+// Map is like Parse, using the default configuration, except that the test
+// fails immediately if the map cannot be parsed.
+func Map(t testing.TB, name string) *tmx.Map {
+	t.Helper()
+	m, err := Parse(name, nil)
+	if err != nil {
+		t.Fatalf("tmxtest: %s: %v", name, err)
+	}
+	return m
+}
+
+// Images returns the decoded images of the given map's tilesets and image
+// layers, keyed by filename like the tsImages map given to tmx.Load. The
+// test fails immediately if an image cannot be read.
+func Images(t testing.TB, m *tmx.Map) map[string]*image.RGBA {
+	t.Helper()
+	var sources []string
+	for _, ts := range m.Tilesets {
+		if ts.Image != nil {
+			sources = append(sources, ts.Image.Source)
+		}
+	}
+	for _, l := range m.ImageLayers {
+		if l.Image != nil && len(l.Image.Source) > 0 {
+			sources = append(sources, l.Image.Source)
+		}
+	}
+	images := make(map[string]*image.RGBA)
+	for _, source := range sources {
+		name := path.Base(source)
+		if _, ok := images[name]; ok {
+			continue
+		}
+		data, err := Data(name)
+		if err != nil {
+			t.Fatalf("tmxtest: %v", err)
+		}
+		src, _, err := image.Decode(bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("tmxtest: %s: %v", name, err)
+		}
+		rgba, ok := src.(*image.RGBA)
+		if !ok {
+			b := src.Bounds()
+			rgba = image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+			draw.Draw(rgba, rgba.Bounds(), src, b.Min, draw.Src)
+		}
+		images[name] = rgba
+	}
+	return images
+}
@@ -0,0 +1,61 @@
+// Copyright 2014 Lightpoke. All rights reserved.
+// This source code is subject to the terms and
+// conditions defined in the "License.txt" file.
+
+package tmxtest
+
+import (
+	"testing"
+
+	"azul3d.org/tmx.v1"
+)
+
+func TestCorpus(t *testing.T) {
+	names := Names()
+	if len(names) != 8 {
+		t.Fatal("expected eight maps, got", names)
+	}
+	for _, name := range names {
+		m := Map(t, name)
+		if err := m.CheckGIDs(); err != nil {
+			t.Error(name, err)
+		}
+		images := Images(t, m)
+		for _, ts := range m.Tilesets {
+			if ts.Width == 0 || images[ts.Image.Source] == nil {
+				t.Error(name, "tileset not loaded", ts)
+			}
+		}
+	}
+
+	orientations := map[string]tmx.Orientation{
+		Orthogonal: tmx.Orthogonal,
+		Isometric:  tmx.Isometric,
+		Staggered:  tmx.Staggered,
+		Hexagonal:  tmx.Hexagonal,
+	}
+	for name, o := range orientations {
+		if m := Map(t, name); m.Orientation != o {
+			t.Error(name, "incorrect orientation", m.Orientation)
+		}
+	}
+	if !Map(t, Infinite).Infinite {
+		t.Error("expected an infinite map")
+	}
+	if l := Map(t, Compressed).Layers[0]; l.Compression != tmx.ZlibCompression {
+		t.Error("expected compressed tile data, got", l.Compression)
+	}
+	if ts := Map(t, Animated).Tilesets[0]; len(ts.Tiles[6].Animation) != 2 {
+		t.Error("expected an animated tile")
+	}
+	if _, err := Data("spawn.tx"); err != nil {
+		t.Error(err)
+	}
+
+	// Each call parses a fresh map.
+	a, b := Map(t, Orthogonal), Map(t, Orthogonal)
+	a.Layers[0].SetTile(tmx.Coord{X: 0, Y: 0}, 1)
+	if b.Layers[0].Tile(tmx.Coord{X: 0, Y: 0}) == 1 {
+		t.Error("maps share tiles")
+	}
+}
@@ -0,0 +1,159 @@
+// Copyright 2014 Lightpoke. All rights reserved.
+// This source code is subject to the terms and
+// conditions defined in the "License.txt" file.
+
+package tmx
+
+import (
+	"math/rand"
+	"sort"
+)
+
+// tileProbability returns the probability of the tile with the given gid, as
+// specified by it's tile definition, or one if it has none.
+func (m *Map) tileProbability(gid uint32) float64 {
+	ts := m.FindTileset(gid)
+	if ts == nil {
+		return 1
+	}
+	if t := m.TilesetTile(ts, gid); t != nil {
+		return t.Probability
+	}
+	return 1
+}
+
+// Randomize rewrites the layer, l, replacing every tile that is one of the
+// given interchangeable tiles with a random choice among them, weighted by the
+// probability of each tile (see Tile.Probability) just like Tiled's random
+// brush does. Flipping flags of the replaced tiles are kept.
+//
+// Tiles are visited in row-major order, so the same seed always produces the
+// same result.
+func (m *Map) Randomize(l *Layer, gids []uint32, r *rand.Rand) {
+	if len(gids) == 0 {
+		return
+	}
+	var (
+		set     = make(map[uint32]bool, len(gids))
+		weights = make([]float64, len(gids))
+		total   float64
+	)
+	for i, gid := range gids {
+		gid &^= flipFlags
+		set[gid] = true
+		weights[i] = m.tileProbability(gid)
+		total += weights[i]
+	}
+
+	var coords []Coord
+	l.EachTile(func(c Coord, gid uint32) {
+		if set[gid&^flipFlags] {
+			coords = append(coords, c)
+		}
+	})
+	sort.Slice(coords, func(i, j int) bool {
+		a, b := coords[i], coords[j]
+		return a.Y < b.Y || (a.Y == b.Y && a.X < b.X)
+	})
+	for _, c := range coords {
+		choice := len(gids) - 1
+		if total > 0 {
+			pick := r.Float64() * total
+			for i, w := range weights {
+				pick -= w
+				if pick < 0 {
+					choice = i
+					break
+				}
+			}
+		} else {
+			choice = r.Intn(len(gids))
+		}
+		gid := l.Tile(c)
+		l.SetTile(c, gids[choice]&^flipFlags|gid&flipFlags)
+	}
+}
+
+// Variants returns the global tile IDs of all the tiles in this wang set,
+// which belongs to the tileset ts, whose wang ID is equal to the given one.
+// Such tiles are interchangeable, and are suitable for use with
+// Map.Randomize.
+func (ws *WangSet) Variants(ts *Tileset, id WangID) []uint32 {
+	var gids []uint32
+	for tile, tid := range ws.Tiles {
+		if tid == id {
+			gids = append(gids, ts.Firstgid+uint32(tile))
+		}
+	}
+	sort.Slice(gids, func(i, j int) bool {
+		return gids[i] < gids[j]
+	})
+	return gids
+}
+
+// RandomizeTransforms rewrites the layer, l, giving every tile that is one of
+// the given tiles (for instance grass or floor tiles) a random flip and
+// rotation among those permitted by it's tileset (see Tileset.Transformations),
+// which breaks up visible repetition without authoring extra tiles. Tiles of
+// tilesets permitting no transformations are left as-is, flipping flags of
+// the given tiles are ignored.
+//
+// Tiles are visited in row-major order, so the same seed always produces the
+// same result. The tiles are set using SetTile, so they are marked as dirty.
+func (m *Map) RandomizeTransforms(l *Layer, gids []uint32, r *rand.Rand) {
+	set := make(map[uint32]bool, len(gids))
+	for _, gid := range gids {
+		set[gid&^flipFlags] = true
+	}
+
+	// The flipping flags permitted by each tileset.
+	permitted := make(map[*Tileset][]uint32)
+	allowed := func(ts *Tileset) []uint32 {
+		flags, ok := permitted[ts]
+		if ok {
+			return flags
+		}
+		for i := uint32(0); i < 8; i++ {
+			d, h, v := i&4 != 0, i&2 != 0, i&1 != 0
+			if !ts.Transformations.allows(d, h, v) {
+				continue
+			}
+			var f uint32
+			if d {
+				f |= FLIPPED_DIAGONALLY_FLAG
+			}
+			if h {
+				f |= FLIPPED_HORIZONTALLY_FLAG
+			}
+			if v {
+				f |= FLIPPED_VERTICALLY_FLAG
+			}
+			flags = append(flags, f)
+		}
+		permitted[ts] = flags
+		return flags
+	}
+
+	var coords []Coord
+	l.EachTile(func(c Coord, gid uint32) {
+		if set[gid&^flipFlags] {
+			coords = append(coords, c)
+		}
+	})
+	sort.Slice(coords, func(i, j int) bool {
+		a, b := coords[i], coords[j]
+		return a.Y < b.Y || (a.Y == b.Y && a.X < b.X)
+	})
+	for _, c := range coords {
+		gid := l.Tile(c) &^ flipFlags
+		ts := m.FindTileset(gid)
+		if ts == nil {
+			continue
+		}
+		flags := allowed(ts)
+		if len(flags) < 2 {
+			continue
+		}
+		l.SetTile(c, gid|flags[r.Intn(len(flags))])
+	}
+}
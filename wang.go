@@ -0,0 +1,414 @@
+// Copyright 2014 Lightpoke. All rights reserved.
+// This source code is subject to the terms and
+// conditions defined in the "License.txt" file.
+
+package tmx
+
+import (
+	"fmt"
+	"image/color"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type xmlTransformations struct {
+	HFlip               int `xml:"hflip,attr"`
+	VFlip               int `xml:"vflip,attr"`
+	Rotate              int `xml:"rotate,attr"`
+	PreferUntransformed int `xml:"preferuntransformed,attr"`
+}
+
+func (x xmlTransformations) toTransformations() Transformations {
+	return Transformations{
+		HFlip:               x.HFlip == 1,
+		VFlip:               x.VFlip == 1,
+		Rotate:              x.Rotate == 1,
+		PreferUntransformed: x.PreferUntransformed == 1,
+	}
+}
+
+type xmlWangColor struct {
+	Name        string        `xml:"name,attr"`
+	Class       string        `xml:"class,attr"`
+	Color       string        `xml:"color,attr"`
+	Tile        int           `xml:"tile,attr"`
+	Probability *float64      `xml:"probability,attr"`
+	Properties  xmlProperties `xml:"properties"`
+}
+
+type xmlWangTile struct {
+	TileID int    `xml:"tileid,attr"`
+	WangID string `xml:"wangid,attr"`
+}
+
+type xmlWangSet struct {
+	Name       string         `xml:"name,attr"`
+	Class      string         `xml:"class,attr"`
+	Type       string         `xml:"type,attr"`
+	Tile       int            `xml:"tile,attr"`
+	Properties xmlProperties  `xml:"properties"`
+	Colors     []xmlWangColor `xml:"wangcolor"`
+	Tiles      []xmlWangTile  `xml:"wangtile"`
+}
+
+type xmlWangSets struct {
+	WangSet []xmlWangSet `xml:"wangset"`
+}
+
+func (x xmlWangSet) toWangSet() (*WangSet, error) {
+	ws := &WangSet{
+		Name:       x.Name,
+		Class:      x.Class,
+		Tile:       x.Tile,
+		Properties: x.Properties.toMap(),
+		Tiles:      make(map[int]WangID, len(x.Tiles)),
+	}
+	switch x.Type {
+	case "corner":
+		ws.Type = WangCorner
+	case "edge":
+		ws.Type = WangEdge
+	case "", "mixed":
+		ws.Type = WangMixed
+	default:
+		return nil, fmt.Errorf("unknown wangset type %q.", x.Type)
+	}
+	for _, c := range x.Colors {
+		ws.Colors = append(ws.Colors, &WangColor{
+			Name:        c.Name,
+			Class:       c.Class,
+			Color:       hexToRGBA(c.Color),
+			Tile:        c.Tile,
+			Probability: floatAttr(c.Probability, 1),
+			Properties:  c.Properties.toMap(),
+		})
+	}
+	for _, t := range x.Tiles {
+		id, err := parseWangID(t.WangID)
+		if err != nil {
+			return nil, err
+		}
+		ws.Tiles[t.TileID] = id
+	}
+	return ws, nil
+}
+
+func (x xmlWangSets) toWangSets() ([]*WangSet, error) {
+	sets := make([]*WangSet, 0, len(x.WangSet))
+	for _, xs := range x.WangSet {
+		ws, err := xs.toWangSet()
+		if err != nil {
+			return nil, err
+		}
+		sets = append(sets, ws)
+	}
+	return sets, nil
+}
+
+// parseWangID parses a wang ID in either the comma separated format of Tiled
+// 1.5 and later, or the older 32-bit hexadecimal format.
+func parseWangID(s string) (id WangID, err error) {
+	if strings.HasPrefix(s, "0x") {
+		v, err := strconv.ParseUint(s[2:], 16, 32)
+		if err != nil {
+			return id, err
+		}
+		for i := range id {
+			id[i] = int(v >> (4 * uint(i)) & 0xF)
+		}
+		return id, nil
+	}
+	split := strings.Split(s, ",")
+	if len(split) != len(id) {
+		return id, fmt.Errorf("invalid wangid %q.", s)
+	}
+	for i, v := range split {
+		id[i], err = strconv.Atoi(strings.TrimSpace(v))
+		if err != nil {
+			return id, err
+		}
+	}
+	return id, nil
+}
+
+// Transformations describes which transformations of tiles are allowed when
+// choosing tiles from a tileset, for instance by the auto-tiler.
+type Transformations struct {
+	// Whether tiles may be flipped horizontally, vertically or rotated in 90
+	// degree steps.
+	HFlip, VFlip, Rotate bool
+
+	// Whether untransformed tiles are preferred over transformed ones.
+	PreferUntransformed bool
+}
+
+// allows tells if the transformation described by the given tile flipping
+// flags (applied diagonally first, then horizontally, then vertically as Tiled
+// does) is allowed.
+func (t Transformations) allows(d, h, v bool) bool {
+	rotation := d == (h != v)
+	switch {
+	case !d && !h && !v:
+		return true
+	case t.Rotate && (t.HFlip || t.VFlip):
+		return true
+	case t.Rotate:
+		return rotation
+	case d:
+		return false
+	}
+	return (!h || t.HFlip) && (!v || t.VFlip)
+}
+
+// WangSetType represents the type of a wang set.
+type WangSetType int
+
+const (
+	// Wang set whose tiles match on their corners.
+	WangCorner WangSetType = iota
+
+	// Wang set whose tiles match on their edges.
+	WangEdge
+
+	// Wang set whose tiles match on both their corners and edges.
+	WangMixed
+)
+
+// WangID describes the wang colors of a tile, in the order of: top, top right,
+// right, bottom right, bottom, bottom left, left, top left.
+//
+// Colors are one-based indices into the wang set's colors, where zero means
+// no color.
+type WangID [8]int
+
+// transform returns the wang ID of a tile after it is transformed with the
+// given tile flipping flags.
+func (id WangID) transform(d, h, v bool) WangID {
+	remap := func(m [8]int) {
+		old := id
+		for i := range id {
+			id[i] = old[m[i]]
+		}
+	}
+	if d {
+		remap([8]int{6, 5, 4, 3, 2, 1, 0, 7})
+	}
+	if h {
+		remap([8]int{0, 7, 6, 5, 4, 3, 2, 1})
+	}
+	if v {
+		remap([8]int{4, 3, 2, 1, 0, 7, 6, 5})
+	}
+	return id
+}
+
+// WangColor is a single color (I.e. terrain) of a wang set.
+type WangColor struct {
+	// The name and class of the color.
+	Name, Class string
+
+	// The color used to display the color in editors.
+	Color color.RGBA
+
+	// The local ID of the tile representing this color, or -1.
+	Tile int
+
+	// The relative probability of this color being chosen.
+	Probability float64
+
+	// Map of property names and values for all properties set on the color.
+	Properties map[string]string
+}
+
+// WangSet represents a set of tiles which are matched by the colors of their
+// corners and/or edges, as used for terrain painting and auto-tiling.
+type WangSet struct {
+	// The name and class of the wang set.
+	Name, Class string
+
+	// The type of the wang set.
+	Type WangSetType
+
+	// The local ID of the tile representing this wang set, or -1.
+	Tile int
+
+	// Map of property names and values for all properties set on the set.
+	Properties map[string]string
+
+	// The colors of this wang set, WangID values are one-based indices into
+	// this slice.
+	Colors []*WangColor
+
+	// Map of local tile IDs and their wang IDs.
+	Tiles map[int]WangID
+}
+
+// mask returns the given wang ID with the positions not used by this wang set
+// type set to the given value.
+func (ws *WangSet) mask(id WangID, unused int) WangID {
+	for i := range id {
+		corner := i%2 == 1
+		if (ws.Type == WangCorner && !corner) || (ws.Type == WangEdge && corner) {
+			id[i] = unused
+		}
+	}
+	return id
+}
+
+// wangCandidate is a single (possibly transformed) tile of a wang set.
+type wangCandidate struct {
+	gid    uint32
+	id     WangID
+	weight float64
+	plain  bool
+}
+
+// candidates returns all of the tiles of the wang set, including all of the
+// transformations of them allowed by the tileset.
+func (ws *WangSet) candidates(ts *Tileset) []wangCandidate {
+	ids := make([]int, 0, len(ws.Tiles))
+	for id := range ws.Tiles {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+
+	var c []wangCandidate
+	for _, tile := range ids {
+		id := ws.mask(ws.Tiles[tile], 0)
+		weight := 1.0
+		if t, ok := ts.Tiles[tile]; ok {
+			weight = t.Probability
+		}
+		for _, color := range id {
+			if color > 0 && color <= len(ws.Colors) {
+				weight *= ws.Colors[color-1].Probability
+			}
+		}
+		for flags := 0; flags < 8; flags++ {
+			d, h, v := flags&4 != 0, flags&1 != 0, flags&2 != 0
+			if !ts.Transformations.allows(d, h, v) {
+				continue
+			}
+			gid := ts.Firstgid + uint32(tile)
+			if d {
+				gid |= FLIPPED_DIAGONALLY_FLAG
+			}
+			if h {
+				gid |= FLIPPED_HORIZONTALLY_FLAG
+			}
+			if v {
+				gid |= FLIPPED_VERTICALLY_FLAG
+			}
+			c = append(c, wangCandidate{gid, id.transform(d, h, v), weight, flags == 0})
+		}
+	}
+	return c
+}
+
+// AutoTile fills the layer, l, with tiles from the given wang set of the
+// tileset, ts, such that the colors of neighbouring tiles match.
+//
+// The color function returns the wang color (a one-based index into the wang
+// set's colors, or zero for none) at the given corner of the map's grid, where
+// x is in the range [0, m.Width] and y in the range [0, m.Height]. Edges are
+// given the color of their two corners when those are equal, or any color
+// otherwise. Sampling a grid of per-cell terrain at the corners (the so-called
+// dual grid) works well.
+//
+// Tiles are chosen among those that match best, including the flipped and
+// rotated variants allowed by the tileset's Transformations. If r is non-nil
+// the choice is random and weighted by the probability of the tiles and their
+// colors, otherwise the first matching tile is chosen. Cells whose corners
+// have no color are cleared.
+func (m *Map) AutoTile(l *Layer, ts *Tileset, ws *WangSet, color func(x, y int) int, r *rand.Rand) {
+	candidates := ws.candidates(ts)
+	if len(candidates) == 0 {
+		return
+	}
+	var best []wangCandidate
+	for y := 0; y < m.Height; y++ {
+		for x := 0; x < m.Width; x++ {
+			// Find the desired wang ID of the cell, where -1 means any color
+			// is acceptable.
+			tl, tr := color(x, y), color(x+1, y)
+			bl, br := color(x, y+1), color(x+1, y+1)
+			edge := func(a, b int) int {
+				if a == b {
+					return a
+				}
+				return -1
+			}
+			want := ws.mask(WangID{
+				edge(tl, tr), tr, edge(tr, br), br,
+				edge(bl, br), bl, edge(tl, bl), tl,
+			}, -1)
+			empty := true
+			for _, color := range want {
+				if color > 0 {
+					empty = false
+				}
+			}
+			if empty {
+				l.SetTile(Coord{x, y}, 0)
+				continue
+			}
+
+			// Find the best matching candidates.
+			best = best[:0]
+			fewest := len(want) + 1
+			for _, c := range candidates {
+				var mismatches int
+				for i, color := range want {
+					if color != -1 && c.id[i] != color {
+						mismatches++
+					}
+				}
+				if mismatches < fewest {
+					fewest = mismatches
+					best = best[:0]
+				}
+				if mismatches == fewest {
+					best = append(best, c)
+				}
+			}
+			if ts.Transformations.PreferUntransformed {
+				var n int
+				for _, c := range best {
+					if c.plain {
+						best[n] = c
+						n++
+					}
+				}
+				if n > 0 {
+					best = best[:n]
+				}
+			}
+			l.SetTile(Coord{x, y}, chooseWang(best, r))
+		}
+	}
+}
+
+// chooseWang chooses one of the candidates, at random weighted by their
+// weights if r is non-nil, or the first one otherwise.
+func chooseWang(c []wangCandidate, r *rand.Rand) uint32 {
+	if r == nil {
+		return c[0].gid
+	}
+	var total float64
+	for _, v := range c {
+		total += v.weight
+	}
+	if total <= 0 {
+		return c[r.Intn(len(c))].gid
+	}
+	pick := r.Float64() * total
+	for _, v := range c {
+		pick -= v.weight
+		if pick < 0 {
+			return v.gid
+		}
+	}
+	return c[len(c)-1].gid
+}
@@ -0,0 +1,230 @@
+// Copyright 2014 The Azul3D Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tmx
+
+import (
+	"math"
+
+	"azul3d.org/lmath.v1"
+)
+
+// PixelToWorld returns the point in world space at which Load, with the
+// configuration c (or the default one, if nil), places the given pixel of the
+// map (where +Y is down), at a depth of zero.
+//
+// Pixels may be fractional, such that entities spawned at sub-tile positions
+// line up exactly with the rendered tiles.
+func PixelToWorld(m *Map, c *Config, p Pixel) lmath.Vec3 {
+	if c != nil && c.TopLeftOrigin {
+		return lmath.Vec3{p.X, 0, p.Y}
+	}
+	return lmath.Vec3{p.X, 0, float64(m.Height*m.TileHeight) - p.Y}
+}
+
+// WorldToPixel is the inverse of PixelToWorld, it returns the pixel of the map
+// at the given point in world space, ignoring it's depth.
+func WorldToPixel(m *Map, c *Config, v lmath.Vec3) Pixel {
+	if c != nil && c.TopLeftOrigin {
+		return Pixel{v.X, v.Z}
+	}
+	return Pixel{v.X, float64(m.Height*m.TileHeight) - v.Z}
+}
+
+// ObjectToWorld returns the point in world space at which Load, with the
+// configuration c (or the default one, if nil), places the position of the
+// given object of the object group g. The effective offset of the group is
+// applied, and the depth is that of the group's objects (ignoring the tiny
+// TileOffset between the tile objects of a group), or that given by
+// Config.Depth for tile objects.
+func ObjectToWorld(m *Map, c *Config, g *ObjectGroup, o *Object) lmath.Vec3 {
+	if c == nil {
+		c = DefaultConfig()
+	}
+	ld := &loader{m: m, c: c}
+	ox, oy := g.EffectiveOffset()
+	v := PixelToWorld(m, c, Pixel{float64(o.X) + ox, float64(o.Y) + oy})
+	v.Y = ld.layerDepth(g.Index, g.Properties) - depthProperty(o.Properties)*c.LayerOffset
+	if c.Depth != nil && o.Gid != 0 {
+		v.Y = c.Depth(g.Index, pixelFloor(o.X, ox), pixelFloor(o.Y, oy), o.Gid)
+	}
+	return v
+}
+
+// TileToWorld returns the point in world space of the center of the given cell
+// of the tile layer l, as placed by Load with the configuration c (or the
+// default one, if nil), at the depth of the layer (or that of the cell's tile
+// given by Config.Depth). The effective offset of the layer is applied.
+//
+// Note that tiles whose tileset has a different tile size than the map are
+// aligned to the top-left of their cell (see TileRenderSize), and are thus not
+// centered at this point.
+func TileToWorld(m *Map, c *Config, l *Layer, tc Coord) lmath.Vec3 {
+	if c == nil {
+		c = DefaultConfig()
+	}
+	ld := &loader{m: m, c: c}
+	ox, oy := l.EffectiveOffset()
+	v := PixelToWorld(m, c, Pixel{
+		float64(tc.X*m.TileWidth) + ox + float64(m.TileWidth)/2,
+		float64(tc.Y*m.TileHeight) + oy + float64(m.TileHeight)/2,
+	})
+	v.Y = ld.layerDepth(l.Index, l.Properties)
+	if c.Depth != nil {
+		v.Y = c.Depth(l.Index, tc.X, tc.Y, l.Tile(tc)&^flipFlags)
+	}
+	return v
+}
+
+// eachTileDepth calls f with the coordinates (within the wrapped bounds, see
+// Config.WrapX) and depth of each tile of the tile layer l, in the order that
+// Load places them in, until f returns false. All of the tileset images are
+// assumed to be given to Load.
+func (ld *loader) eachTileDepth(l *Layer, f func(tc Coord, depth float64) bool) {
+	m := ld.m
+	depth := ld.layerDepth(l.Index, l.Properties)
+	bounds := l.Bounds(m)
+	wrapX, wrapY := ld.c.WrapX, ld.c.WrapY
+	if m.Infinite {
+		wrapX, wrapY = 0, 0
+	}
+	var tileOffset float64
+	for x := bounds.Min.X - wrapX; x < bounds.Max.X+wrapX; x++ {
+		for y := bounds.Min.Y - wrapY; y < bounds.Max.Y+wrapY; y++ {
+			c := Coord{x, y}
+			if !m.Infinite {
+				c = m.Wrap(c)
+			}
+			gid := l.Tile(c)
+			if ts, _, _ := m.DecomposeGID(gid); ts == nil {
+				continue
+			}
+			d := depth + tileOffset
+			if ld.c.Depth != nil {
+				d = ld.c.Depth(l.Index, x, y, gid&^flipFlags)
+			}
+			tileOffset -= ld.tileOffset()
+			if !f(Coord{x, y}, d) {
+				return
+			}
+		}
+	}
+}
+
+// DepthForLayer returns the depth (along the Y axis, where smaller depths are
+// closer to the camera) at which Load, with the configuration c (or the
+// default one, if nil), places the named tile layer, image layer or object
+// group, such that games can place their own sprites at depths which
+// interleave correctly with the layers of the map. The tiles of a layer are
+// placed slightly closer than this, see DepthForTile and DepthRange.
+//
+// If there is no such layer, false is returned.
+func DepthForLayer(m *Map, c *Config, name string) (float64, bool) {
+	if c == nil {
+		c = DefaultConfig()
+	}
+	ld := &loader{m: m, c: c}
+	for _, it := range drawOrder(m) {
+		switch {
+		case it.layer != nil && it.layer.Name == name:
+			return ld.layerDepth(it.layer.Index, it.layer.Properties), true
+		case it.group != nil && it.group.Name == name:
+			return ld.layerDepth(it.group.Index, it.group.Properties), true
+		case it.image != nil && it.image.Name == name:
+			return ld.layerDepth(it.image.Index, it.image.Properties), true
+		}
+	}
+	return 0, false
+}
+
+// DepthForTile returns the exact depth at which Load, with the configuration c
+// (or the default one, if nil), places the tile at the given cell of the tile
+// layer l, including the tiny Config.TileOffset between the tiles of a layer
+// (or the depth given by Config.Depth). Cells without a tile are given the
+// depth that the next tile of the layer would have.
+//
+// Finding the depth takes time proportional to the number of tiles placed
+// before the cell, so games should cache it when placing many sprites.
+func DepthForTile(m *Map, c *Config, l *Layer, tc Coord) float64 {
+	if c == nil {
+		c = DefaultConfig()
+	}
+	ld := &loader{m: m, c: c}
+	depth := ld.layerDepth(l.Index, l.Properties)
+	n := 0
+	found := false
+	ld.eachTileDepth(l, func(at Coord, d float64) bool {
+		if at == tc {
+			depth, found = d, true
+			return false
+		}
+		if at.X < tc.X || at.X == tc.X && at.Y < tc.Y {
+			n++
+			return true
+		}
+		return false
+	})
+	if found {
+		return depth
+	}
+	if c.Depth != nil {
+		return c.Depth(l.Index, tc.X, tc.Y, 0)
+	}
+	return depth - float64(n)*ld.tileOffset()
+}
+
+// DepthRange returns the range of depths (near being the smallest one, as
+// it is closest to the camera) within which Load, with the configuration c (or
+// the default one, if nil), places everything of the named tile layer, image
+// layer or object group (including objects of object groups which carry the
+// conventional "z" custom property). A sprite drawn in front of the layer but
+// behind the next one may be placed at any depth between the nearest depth of
+// the layer and the farthest depth of the next one.
+//
+// If there is no such layer, false is returned.
+func DepthRange(m *Map, c *Config, name string) (near, far float64, ok bool) {
+	if c == nil {
+		c = DefaultConfig()
+	}
+	ld := &loader{m: m, c: c}
+	depth, ok := DepthForLayer(m, c, name)
+	if !ok {
+		return 0, 0, false
+	}
+	near, far = depth, depth
+	include := func(d float64) {
+		near, far = math.Min(near, d), math.Max(far, d)
+	}
+	if l := m.FindLayer(name); l != nil {
+		ld.eachTileDepth(l, func(_ Coord, d float64) bool {
+			include(d)
+			return true
+		})
+		return near, far, true
+	}
+	for _, g := range m.ObjectGroups {
+		if g.Name != name {
+			continue
+		}
+		ox, oy := g.EffectiveOffset()
+		var tileOffset float64
+		for _, o := range g.Objects {
+			// Only tile objects and rasterized text are placed, see
+			// objectGroup.
+			_, text := o.Value.(*Text)
+			text = text && c.RasterizeText != nil
+			if ts, _, _ := m.DecomposeGID(o.Gid); ts == nil && !text {
+				continue
+			}
+			d := depth - depthProperty(o.Properties)*c.LayerOffset + tileOffset
+			if c.Depth != nil && !text {
+				d = c.Depth(g.Index, pixelFloor(o.X, ox), pixelFloor(o.Y, oy), o.Gid)
+			}
+			include(d)
+			tileOffset -= ld.tileOffset()
+		}
+		break
+	}
+	return near, far, true
+}
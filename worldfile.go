@@ -0,0 +1,60 @@
+// Copyright 2014 Lightpoke. All rights reserved.
+// This source code is subject to the terms and
+// conditions defined in the "License.txt" file.
+
+package tmx
+
+import (
+	"encoding/json"
+	"image"
+)
+
+// WorldMap represents a single map of a world.
+type WorldMap struct {
+	// The file name of the map, relative to the world file.
+	FileName string `json:"fileName"`
+
+	// The position of the map within the world, and it's size, in pixels.
+	X      int `json:"x"`
+	Y      int `json:"y"`
+	Width  int `json:"width"`
+	Height int `json:"height"`
+}
+
+// Rect returns the rectangle covered by the map within the world, in pixels.
+func (w WorldMap) Rect() image.Rectangle {
+	return image.Rect(w.X, w.Y, w.X+w.Width, w.Y+w.Height)
+}
+
+// World represents a Tiled world file (.world), which places several maps
+// next to each other.
+//
+// Only the maps listed explicitly are parsed, the patterns matching map files
+// by their names are not.
+type World struct {
+	// The maps of the world.
+	Maps []WorldMap `json:"maps"`
+
+	// Whether Tiled only shows the maps next to the one being edited.
+	OnlyShowAdjacentMaps bool `json:"onlyShowAdjacentMaps"`
+}
+
+// ParseWorld parses the given world file data.
+func ParseWorld(data []byte) (*World, error) {
+	w := new(World)
+	if err := json.Unmarshal(data, w); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// FindMap returns the map of the world with the given file name, or nil if
+// there is none.
+func (w *World) FindMap(fileName string) *WorldMap {
+	for i := range w.Maps {
+		if w.Maps[i].FileName == fileName {
+			return &w.Maps[i]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,151 @@
+// Copyright 2014 The Azul3D Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tmx
+
+import (
+	"image"
+	"math"
+	"path/filepath"
+	"time"
+
+	"azul3d.org/gfx.v2-unstable"
+)
+
+// WorldManager keeps the maps of a world that are near the camera loaded, for
+// seamless open worlds. Maps are loaded incrementally (see IncrementalLoader)
+// as the camera approaches them, and released (see ReleaseLayers) once it moves
+// away from them again.
+//
+// The objects of each map are placed in the map's own space, the application
+// moves them to the map's position within the world (see WorldMap).
+//
+// A WorldManager is not safe for use by multiple goroutines at once.
+type WorldManager struct {
+	// The distance from the camera, in pixels, within which maps are loaded,
+	// and the additional distance that the camera must move away from a map
+	// before it is released, such that moving back and forth along the edge
+	// of the radius does not repeatedly load the same map.
+	Radius, Margin float64
+
+	// The amount of time that each call to Update spends loading maps.
+	Budget time.Duration
+
+	world *World
+	dir   string
+	c     *Config
+	maps  map[string]*residentMap
+}
+
+// residentMap is a single map of a world manager, which is either being loaded
+// or loaded.
+type residentMap struct {
+	m      *Map
+	loader *IncrementalLoader
+	layers map[string]map[string]*gfx.Object
+}
+
+// NewWorldManager returns a new world manager for the given world, whose map
+// files are relative to the given directory (I.e. the one containing the world
+// file), which loads maps using the configuration c (or the default one, if
+// nil). No maps are loaded until Update is called.
+func NewWorldManager(w *World, dir string, c *Config) *WorldManager {
+	return &WorldManager{
+		Radius: 512,
+		Margin: 128,
+		Budget: 2 * time.Millisecond,
+		world:  w,
+		dir:    dir,
+		c:      c,
+		maps:   make(map[string]*residentMap),
+	}
+}
+
+// rectDistance returns the distance from the given point to the rectangle, or
+// zero if it is within it.
+func rectDistance(r image.Rectangle, p Pixel) float64 {
+	dx := math.Max(math.Max(float64(r.Min.X)-p.X, p.X-float64(r.Max.X)), 0)
+	dy := math.Max(math.Max(float64(r.Min.Y)-p.Y, p.Y-float64(r.Max.Y)), 0)
+	return math.Hypot(dx, dy)
+}
+
+// Update updates the maps that are loaded, given the position of the camera
+// in pixels within the world (where +Y is down), and continues loading maps
+// for roughly the manager's budget. It returns the file names of the maps that
+// finished loading and of those that were released by this call, in the order
+// of the world.
+//
+// Map files (and their tilesets and images) are read when the camera first
+// comes within range of them, which may take some time. If that fails, the
+// error is returned and the map is tried again on the next call.
+func (wm *WorldManager) Update(cam Pixel) (loaded, released []string, err error) {
+	for _, wmap := range wm.world.Maps {
+		dist := rectDistance(wmap.Rect(), cam)
+		rm, resident := wm.maps[wmap.FileName]
+		switch {
+		case !resident && dist <= wm.Radius:
+			path := filepath.Join(wm.dir, filepath.FromSlash(wmap.FileName))
+			m, tsImages, _, err := readMapFile(path, wm.c)
+			if err != nil {
+				return loaded, released, err
+			}
+			wm.maps[wmap.FileName] = &residentMap{
+				m:      m,
+				loader: NewIncrementalLoader(m, wm.c, tsImages),
+			}
+		case resident && dist > wm.Radius+wm.Margin:
+			layers := rm.layers
+			if rm.loader != nil {
+				layers = rm.loader.Layers()
+			} else {
+				released = append(released, wmap.FileName)
+			}
+			ReleaseLayers(wm.c, layers)
+			delete(wm.maps, wmap.FileName)
+		}
+	}
+
+	// Step the maps being loaded, at least once per call.
+	start := time.Now()
+	stepped := false
+	for _, wmap := range wm.world.Maps {
+		rm, ok := wm.maps[wmap.FileName]
+		if !ok || rm.loader == nil {
+			continue
+		}
+		remaining := wm.Budget - time.Since(start)
+		if stepped && remaining <= 0 {
+			break
+		}
+		stepped = true
+		if rm.loader.Step(remaining) {
+			rm.layers = rm.loader.Layers()
+			rm.loader = nil
+			loaded = append(loaded, wmap.FileName)
+		}
+	}
+	return loaded, released, nil
+}
+
+// Map returns the map with the given file name and it's loaded objects (in the
+// same form as Load returns them), or false if it is not loaded (yet).
+func (wm *WorldManager) Map(fileName string) (*Map, map[string]map[string]*gfx.Object, bool) {
+	rm, ok := wm.maps[fileName]
+	if !ok || rm.loader != nil {
+		return nil, nil, false
+	}
+	return rm.m, rm.layers, true
+}
+
+// Close releases all of the maps of the manager.
+func (wm *WorldManager) Close() {
+	for name, rm := range wm.maps {
+		layers := rm.layers
+		if rm.loader != nil {
+			layers = rm.loader.Layers()
+		}
+		ReleaseLayers(wm.c, layers)
+		delete(wm.maps, name)
+	}
+}
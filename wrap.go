@@ -0,0 +1,43 @@
+// Copyright 2014 Lightpoke. All rights reserved.
+// This source code is subject to the terms and
+// conditions defined in the "License.txt" file.
+
+package tmx
+
+import "math"
+
+// Wrap returns the given tile coordinates wrapped around the edges of the map,
+// such that they lie within it, as used by wrap-around (I.e. toroidal) worlds.
+func (m *Map) Wrap(c Coord) Coord {
+	if m.Width <= 0 || m.Height <= 0 {
+		return c
+	}
+	return Coord{wrap(c.X, m.Width), wrap(c.Y, m.Height)}
+}
+
+// WrapPixel returns the given pixel position wrapped around the edges of the
+// map (I.e. into the range of m.Width*m.TileWidth by m.Height*m.TileHeight).
+func (m *Map) WrapPixel(p Pixel) Pixel {
+	w, h := float64(m.Width*m.TileWidth), float64(m.Height*m.TileHeight)
+	if w <= 0 || h <= 0 {
+		return p
+	}
+	return Pixel{p.X - math.Floor(p.X/w)*w, p.Y - math.Floor(p.Y/h)*h}
+}
+
+// WrapDelta returns the shortest offset, in tiles, from a to b on a map that
+// wraps around it's edges. Adding it to a gives b, or one of the positions
+// that b is duplicated at across the map's edges.
+func (m *Map) WrapDelta(a, b Coord) Coord {
+	shortest := func(d, n int) int {
+		if n <= 0 {
+			return d
+		}
+		d = wrap(d, n)
+		if d > n/2 {
+			d -= n
+		}
+		return d
+	}
+	return Coord{shortest(b.X-a.X, m.Width), shortest(b.Y-a.Y, m.Height)}
+}
@@ -0,0 +1,795 @@
+// Copyright 2014 Lightpoke. All rights reserved.
+// This source code is subject to the terms and
+// conditions defined in the "License.txt" file.
+
+package tmx
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/xml"
+	"fmt"
+	"image/color"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// WriteConfig represents a configuration used when writing TMX map files.
+type WriteConfig struct {
+	// The encoding and compression to use for tile layer data.
+	//
+	// If Encoding is empty, each layer is written using it's own Encoding and
+	// Compression fields (I.e. the way it was parsed), which allows choosing
+	// the encoding per layer.
+	Encoding    Encoding
+	Compression Compression
+
+	// If non-nil, this function chooses the encoding and compression (see
+	// above) of each tile layer, overriding Encoding and Compression. For
+	// instance CSV may be used for small layers, such that they produce
+	// readable diffs in version control, and compressed base64 for large ones,
+	// see EncodingBySize.
+	LayerEncoding func(l *Layer) (Encoding, Compression)
+
+	// The compression level to use for compressed tile layer data, if nil then
+	// the map's CompressionLevel is used.
+	CompressionLevel *int
+}
+
+// EncodingBySize returns a function for use as WriteConfig.LayerEncoding which
+// chooses CSV encoding for layers with at most the given number of non-empty
+// tiles, and zlib compressed base64 encoding for layers with more.
+func EncodingBySize(tiles int) func(l *Layer) (Encoding, Compression) {
+	return func(l *Layer) (Encoding, Compression) {
+		var n int
+		l.EachTile(func(c Coord, gid uint32) {
+			if gid != 0 {
+				n++
+			}
+		})
+		if n <= tiles {
+			return CSVEncoding, NoCompression
+		}
+		return Base64Encoding, ZlibCompression
+	}
+}
+
+// Write writes the given map, m, to w as a TMX map file.
+//
+// If the configuration, c, is nil then the default configuration is used (the
+// default configuration is simply the zero value of WriteConfig).
+//
+// External tilesets (those with a Source) are written as references only, the
+// tsx files themselves are not written.
+func Write(w io.Writer, m *Map, c *WriteConfig) error {
+	if c == nil {
+		c = new(WriteConfig)
+	}
+	level := -1
+	switch {
+	case c.CompressionLevel != nil:
+		level = *c.CompressionLevel
+	case m.CompressionLevel != nil:
+		level = *m.CompressionLevel
+	}
+	mw := &mapWriter{
+		e:     xml.NewEncoder(w),
+		m:     m,
+		c:     c,
+		level: level,
+	}
+	mw.e.Indent("", " ")
+
+	_, err := io.WriteString(w, xml.Header)
+	if err != nil {
+		return err
+	}
+	mw.writeMap()
+	if mw.err != nil {
+		return mw.err
+	}
+	if err := mw.e.Flush(); err != nil {
+		return err
+	}
+	_, err = io.WriteString(w, "\n")
+	return err
+}
+
+// Marshal works just like Write except it returns the TMX map file data.
+func Marshal(m *Map, c *WriteConfig) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	if err := Write(buf, m, c); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// rgbaToHex converts the color to a "#rrggbb" hex string, or "#aarrggbb" if it
+// is not opaque.
+func rgbaToHex(c color.RGBA) string {
+	if c.A != 255 {
+		return fmt.Sprintf("#%02x%02x%02x%02x", c.A, c.R, c.G, c.B)
+	}
+	return fmt.Sprintf("#%02x%02x%02x", c.R, c.G, c.B)
+}
+
+// isBlack tells if the color is the one returned by hexToRGBA for strings that
+// are empty (I.e. for absent attributes).
+func isBlack(c color.RGBA) bool {
+	return c == color.RGBA{0, 0, 0, 255}
+}
+
+// boolInt returns 1 if b is true, or 0 otherwise.
+func boolInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+type mapWriter struct {
+	e     *xml.Encoder
+	m     *Map
+	c     *WriteConfig
+	level int
+	err   error
+}
+
+// attrs is a helper for building lists of attributes.
+type attrs []xml.Attr
+
+func (a *attrs) str(name, value string) {
+	*a = append(*a, xml.Attr{Name: xml.Name{Local: name}, Value: value})
+}
+
+func (a *attrs) int(name string, value int) {
+	a.str(name, strconv.Itoa(value))
+}
+
+func (a *attrs) float(name string, value float64) {
+	a.str(name, strconv.FormatFloat(value, 'g', -1, 64))
+}
+
+// class writes the class attribute, if there is one.
+func (a *attrs) class(class string) {
+	if len(class) > 0 {
+		a.str("class", class)
+	}
+}
+
+// common writes the attributes shared by all layer types that are not their
+// default values.
+func (a *attrs) common(opacity float64, visible bool, offsetX, offsetY, parallaxX, parallaxY float64) {
+	if opacity != 1 {
+		a.float("opacity", opacity)
+	}
+	if !visible {
+		a.int("visible", 0)
+	}
+	if offsetX != 0 {
+		a.float("offsetx", offsetX)
+	}
+	if offsetY != 0 {
+		a.float("offsety", offsetY)
+	}
+	if parallaxX != 1 {
+		a.float("parallaxx", parallaxX)
+	}
+	if parallaxY != 1 {
+		a.float("parallaxy", parallaxY)
+	}
+}
+
+func (w *mapWriter) token(t xml.Token) {
+	if w.err == nil {
+		w.err = w.e.EncodeToken(t)
+	}
+}
+
+func (w *mapWriter) start(name string, a attrs) {
+	w.token(xml.StartElement{Name: xml.Name{Local: name}, Attr: a})
+}
+
+func (w *mapWriter) end(name string) {
+	w.token(xml.EndElement{Name: xml.Name{Local: name}})
+}
+
+// empty writes an element with no children.
+func (w *mapWriter) empty(name string, a attrs) {
+	w.start(name, a)
+	w.end(name)
+}
+
+func (w *mapWriter) writeMap() {
+	m := w.m
+	var a attrs
+	a.str("version", fmt.Sprintf("%d.%d", m.VersionMajor, m.VersionMinor))
+	a.class(m.Class)
+	switch m.Orientation {
+	case Isometric, Staggered, Hexagonal:
+		a.str("orientation", m.Orientation.String())
+	default:
+		a.str("orientation", Orthogonal.String())
+	}
+	if m.Orientation == Orthogonal && m.RenderOrder != RightDown {
+		a.str("renderorder", m.RenderOrder.String())
+	}
+	a.int("width", m.Width)
+	a.int("height", m.Height)
+	if m.Infinite {
+		a.int("infinite", 1)
+	}
+	a.int("tilewidth", m.TileWidth)
+	a.int("tileheight", m.TileHeight)
+	if m.Orientation == Hexagonal {
+		a.int("hexsidelength", m.HexSideLength)
+	}
+	if m.Orientation == Staggered || m.Orientation == Hexagonal {
+		a.str("staggeraxis", m.StaggerAxis.String())
+		a.str("staggerindex", m.StaggerIndex.String())
+	}
+	if !isBlack(m.BackgroundColor) {
+		a.str("backgroundcolor", rgbaToHex(m.BackgroundColor))
+	}
+	if m.ParallaxOriginX != 0 {
+		a.float("parallaxoriginx", m.ParallaxOriginX)
+	}
+	if m.ParallaxOriginY != 0 {
+		a.float("parallaxoriginy", m.ParallaxOriginY)
+	}
+	if m.CompressionLevel != nil && *m.CompressionLevel != -1 {
+		a.int("compressionlevel", *m.CompressionLevel)
+	}
+	w.start("map", a)
+	w.properties(m.Properties)
+	for _, ts := range m.Tilesets {
+		w.tileset(ts)
+	}
+
+	// Layers, object groups and image layers are written in draw order, each
+	// within the group layers that are its ancestors.
+	var open []*GroupLayer
+	for _, item := range drawOrder(m) {
+		var parent *GroupLayer
+		switch {
+		case item.layer != nil:
+			parent = item.layer.Parent
+		case item.group != nil:
+			parent = item.group.Parent
+		case item.image != nil:
+			parent = item.image.Parent
+		case item.groupLayer != nil:
+			parent = item.groupLayer.Parent
+		}
+		var chain []*GroupLayer
+		for g := parent; g != nil; g = g.Parent {
+			chain = append([]*GroupLayer{g}, chain...)
+		}
+
+		// Close group layers that are not ancestors of the item, and open the
+		// ones that are but are not opened yet.
+		n := 0
+		for n < len(open) && n < len(chain) && open[n] == chain[n] {
+			n++
+		}
+		for len(open) > n {
+			open = open[:len(open)-1]
+			w.end("group")
+		}
+		for _, g := range chain[n:] {
+			w.groupLayer(g)
+			open = append(open, g)
+		}
+
+		switch {
+		case item.layer != nil:
+			w.layer(item.layer)
+		case item.group != nil:
+			w.objectGroup(item.group)
+		case item.image != nil:
+			w.imageLayer(item.image)
+		case item.groupLayer != nil:
+			w.groupLayer(item.groupLayer)
+			open = append(open, item.groupLayer)
+		}
+	}
+	for range open {
+		w.end("group")
+	}
+	w.end("map")
+}
+
+func (w *mapWriter) properties(props map[string]string) {
+	if len(props) == 0 {
+		return
+	}
+
+	// Sort property names such that output is deterministic.
+	names := make([]string, 0, len(props))
+	for name := range props {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	w.start("properties", nil)
+	for _, name := range names {
+		var a attrs
+		a.str("name", name)
+		a.str("value", props[name])
+		w.empty("property", a)
+	}
+	w.end("properties")
+}
+
+func (w *mapWriter) image(img *Image) {
+	if img == nil || len(img.Source) == 0 {
+		return
+	}
+	var a attrs
+	if len(img.Format) > 0 {
+		a.str("format", img.Format)
+	}
+	a.str("source", img.Source)
+	if !isBlack(img.Trans) {
+		a.str("trans", rgbaToHex(img.Trans)[1:])
+	}
+	if img.Width != 0 {
+		a.int("width", img.Width)
+	}
+	if img.Height != 0 {
+		a.int("height", img.Height)
+	}
+	w.empty("image", a)
+}
+
+func (w *mapWriter) tileset(ts *Tileset) {
+	var a attrs
+	a.str("firstgid", strconv.FormatUint(uint64(ts.Firstgid), 10))
+	if len(ts.Source) > 0 {
+		a.str("source", ts.Source)
+		w.empty("tileset", a)
+		return
+	}
+	a.str("name", ts.Name)
+	a.class(ts.Class)
+	a.int("tilewidth", ts.Width)
+	a.int("tileheight", ts.Height)
+	if ts.Spacing != 0 {
+		a.int("spacing", ts.Spacing)
+	}
+	if ts.Margin != 0 {
+		a.int("margin", ts.Margin)
+	}
+	if ts.TileCount != 0 {
+		a.int("tilecount", ts.TileCount)
+	}
+	if ts.Columns != 0 {
+		a.int("columns", ts.Columns)
+	}
+	if ts.RenderSize == TileRenderSizeGrid {
+		a.str("tilerendersize", "grid")
+	}
+	if ts.FillMode == FillModePreserveAspectFit {
+		a.str("fillmode", "preserve-aspect-fit")
+	}
+	w.start("tileset", a)
+	if ts.OffsetX != 0 || ts.OffsetY != 0 {
+		var a attrs
+		a.int("x", ts.OffsetX)
+		a.int("y", ts.OffsetY)
+		w.empty("tileoffset", a)
+	}
+	w.properties(ts.Properties)
+	w.image(ts.Image)
+	if len(ts.Terrain) > 0 {
+		w.start("terraintypes", nil)
+		for _, t := range ts.Terrain {
+			var a attrs
+			a.str("name", t.Name)
+			a.int("tile", t.Tile)
+			w.empty("terrain", a)
+		}
+		w.end("terraintypes")
+	}
+	if tr := ts.Transformations; tr != (Transformations{}) {
+		var a attrs
+		a.int("hflip", boolInt(tr.HFlip))
+		a.int("vflip", boolInt(tr.VFlip))
+		a.int("rotate", boolInt(tr.Rotate))
+		a.int("preferuntransformed", boolInt(tr.PreferUntransformed))
+		w.empty("transformations", a)
+	}
+	if len(ts.WangSets) > 0 {
+		w.start("wangsets", nil)
+		for _, ws := range ts.WangSets {
+			w.wangSet(ws)
+		}
+		w.end("wangsets")
+	}
+
+	// Sort tile IDs such that output is deterministic.
+	ids := make([]int, 0, len(ts.Tiles))
+	for id := range ts.Tiles {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	for _, id := range ids {
+		w.tile(ts.Tiles[id])
+	}
+	w.end("tileset")
+}
+
+func (w *mapWriter) wangSet(ws *WangSet) {
+	var a attrs
+	a.str("name", ws.Name)
+	a.class(ws.Class)
+	a.str("type", [...]string{"corner", "edge", "mixed"}[ws.Type])
+	a.int("tile", ws.Tile)
+	w.start("wangset", a)
+	w.properties(ws.Properties)
+	for _, c := range ws.Colors {
+		var a attrs
+		a.str("name", c.Name)
+		a.class(c.Class)
+		a.str("color", rgbaToHex(c.Color))
+		a.int("tile", c.Tile)
+		a.float("probability", c.Probability)
+		if len(c.Properties) > 0 {
+			w.start("wangcolor", a)
+			w.properties(c.Properties)
+			w.end("wangcolor")
+		} else {
+			w.empty("wangcolor", a)
+		}
+	}
+
+	// Sort tile IDs such that output is deterministic.
+	ids := make([]int, 0, len(ws.Tiles))
+	for id := range ws.Tiles {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	for _, id := range ids {
+		var wangid [8]string
+		for i, c := range ws.Tiles[id] {
+			wangid[i] = strconv.Itoa(c)
+		}
+		var a attrs
+		a.int("tileid", id)
+		a.str("wangid", strings.Join(wangid[:], ","))
+		w.empty("wangtile", a)
+	}
+	w.end("wangset")
+}
+
+func (w *mapWriter) tile(t *Tile) {
+	var a attrs
+	a.int("id", t.ID)
+	if len(t.Class) > 0 {
+		// Tiled 1.9 renamed the tile type attribute to class.
+		if w.m.VersionMajor > 1 || (w.m.VersionMajor == 1 && w.m.VersionMinor >= 9) {
+			a.str("class", t.Class)
+		} else {
+			a.str("type", t.Class)
+		}
+	}
+	if t.Terrain != [4]int{-1, -1, -1, -1} {
+		var corners [4]string
+		for i, c := range t.Terrain {
+			if c >= 0 {
+				corners[i] = strconv.Itoa(c)
+			}
+		}
+		a.str("terrain", strings.Join(corners[:], ","))
+	}
+	if t.Probability != 1 {
+		a.float("probability", t.Probability)
+	}
+	w.start("tile", a)
+	w.properties(t.Properties)
+	w.image(t.Image)
+	if len(t.Animation) > 0 {
+		w.start("animation", nil)
+		for _, f := range t.Animation {
+			var a attrs
+			a.int("tileid", f.TileID)
+			a.int("duration", int(f.Duration/time.Millisecond))
+			w.empty("frame", a)
+		}
+		w.end("animation")
+	}
+	if t.Collision != nil {
+		w.objectGroup(t.Collision)
+	}
+	w.end("tile")
+}
+
+func (w *mapWriter) layer(l *Layer) {
+	var a attrs
+	a.str("name", l.Name)
+	a.class(l.Class)
+	a.int("width", w.m.Width)
+	a.int("height", w.m.Height)
+	a.common(l.Opacity, l.Visible, l.OffsetX, l.OffsetY, l.ParallaxX, l.ParallaxY)
+	w.start("layer", a)
+	w.properties(l.Properties)
+	w.data(l)
+	w.end("layer")
+}
+
+// data writes the tile data of the given layer.
+func (w *mapWriter) data(l *Layer) {
+	encoding, compression := l.Encoding, l.Compression
+	if w.c.LayerEncoding != nil {
+		encoding, compression = w.c.LayerEncoding(l)
+	} else if w.c.Encoding != XMLEncoding {
+		encoding, compression = w.c.Encoding, w.c.Compression
+	}
+	if encoding != Base64Encoding {
+		compression = NoCompression
+	}
+
+	var a attrs
+	if encoding != XMLEncoding {
+		a.str("encoding", string(encoding))
+	}
+	if compression != NoCompression {
+		a.str("compression", string(compression))
+	}
+	w.start("data", a)
+	if !w.m.Infinite {
+		w.encode(encoding, compression, l.GIDs(w.m), w.m.Width)
+		w.end("data")
+		return
+	}
+
+	// Infinite maps are written in chunks (of the size that Tiled uses) which
+	// contain tiles.
+	const size = 16
+	chunks := make(map[Coord]bool)
+	l.EachTile(func(c Coord, gid uint32) {
+		if gid != 0 {
+			chunks[Coord{floorDiv(c.X, size) * size, floorDiv(c.Y, size) * size}] = true
+		}
+	})
+	sorted := make([]Coord, 0, len(chunks))
+	for c := range chunks {
+		sorted = append(sorted, c)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		a, b := sorted[i], sorted[j]
+		return a.Y < b.Y || (a.Y == b.Y && a.X < b.X)
+	})
+	gids := make([]uint32, size*size)
+	for _, c := range sorted {
+		for y := 0; y < size; y++ {
+			for x := 0; x < size; x++ {
+				gids[y*size+x] = l.Tile(Coord{c.X + x, c.Y + y})
+			}
+		}
+		var a attrs
+		a.int("x", c.X)
+		a.int("y", c.Y)
+		a.int("width", size)
+		a.int("height", size)
+		w.start("chunk", a)
+		w.encode(encoding, compression, gids, size)
+		w.end("chunk")
+	}
+	w.end("data")
+}
+
+// encode writes the given gids, of a layer or chunk with the given width in
+// tiles, using the given encoding and compression.
+func (w *mapWriter) encode(encoding Encoding, compression Compression, gids []uint32, width int) {
+	switch encoding {
+	case XMLEncoding:
+		for _, gid := range gids {
+			var a attrs
+			a.str("gid", strconv.FormatUint(uint64(gid), 10))
+			w.empty("tile", a)
+		}
+
+	case CSVEncoding:
+		buf := new(bytes.Buffer)
+		buf.WriteString("\n")
+		for i, gid := range gids {
+			buf.WriteString(strconv.FormatUint(uint64(gid), 10))
+			if i != len(gids)-1 {
+				buf.WriteString(",")
+			}
+			if (i+1)%width == 0 {
+				buf.WriteString("\n")
+			}
+		}
+		w.token(xml.CharData(buf.Bytes()))
+
+	case Base64Encoding:
+		raw := new(bytes.Buffer)
+		var (
+			enc io.WriteCloser
+			err error
+		)
+		if compression != NoCompression {
+			if e := lookupCodec(compression).enc; e != nil {
+				enc, err = e(raw, w.level)
+			} else {
+				err = ErrBadCompression
+			}
+		}
+		if err != nil {
+			if w.err == nil {
+				w.err = err
+			}
+			return
+		}
+		var dst io.Writer = raw
+		if enc != nil {
+			dst = enc
+		}
+		if err := binary.Write(dst, binary.LittleEndian, gids); err != nil && w.err == nil {
+			w.err = err
+		}
+		if enc != nil {
+			if err := enc.Close(); err != nil && w.err == nil {
+				w.err = err
+			}
+		}
+		w.token(xml.CharData("\n   " + base64.StdEncoding.EncodeToString(raw.Bytes()) + "\n  "))
+
+	default:
+		if w.err == nil {
+			w.err = ErrBadEncoding
+		}
+	}
+}
+
+func (w *mapWriter) objectGroup(g *ObjectGroup) {
+	var a attrs
+	a.str("name", g.Name)
+	a.class(g.Class)
+	if !isBlack(g.Color) {
+		a.str("color", rgbaToHex(g.Color))
+	}
+	a.common(g.Opacity, g.Visible, g.OffsetX, g.OffsetY, g.ParallaxX, g.ParallaxY)
+	w.start("objectgroup", a)
+	w.properties(g.Properties)
+	for _, o := range g.Objects {
+		w.object(o)
+	}
+	w.end("objectgroup")
+}
+
+// points converts the points to a TMX points string, like "0,0 10,5".
+func points(p []Point) string {
+	s := make([]string, len(p))
+	for i, pt := range p {
+		s[i] = fmt.Sprintf("%d,%d", pt.X, pt.Y)
+	}
+	return strings.Join(s, " ")
+}
+
+func (w *mapWriter) object(o *Object) {
+	var a attrs
+	if len(o.Name) > 0 {
+		a.str("name", o.Name)
+	}
+	class := o.Class
+	if len(class) == 0 {
+		class = o.Type
+	}
+	if len(class) > 0 {
+		// Tiled 1.9 renamed the object type attribute to class.
+		if w.m.VersionMajor > 1 || (w.m.VersionMajor == 1 && w.m.VersionMinor >= 9) {
+			a.str("class", class)
+		} else {
+			a.str("type", class)
+		}
+	}
+	if gid := o.flaggedGid(); gid != 0 {
+		a.str("gid", strconv.FormatUint(uint64(gid), 10))
+	}
+	a.int("x", o.X)
+	a.int("y", o.Y)
+	if o.Width != 0 {
+		a.int("width", o.Width)
+	}
+	if o.Height != 0 {
+		a.int("height", o.Height)
+	}
+	if o.Rotation != 0 {
+		a.float("rotation", o.Rotation)
+	}
+	if !o.Visible {
+		a.int("visible", 0)
+	}
+	w.start("object", a)
+	w.properties(o.Properties)
+	switch v := o.Value.(type) {
+	case *Ellipse:
+		w.empty("ellipse", nil)
+	case *Polygon:
+		var a attrs
+		a.str("points", points(v.Points))
+		w.empty("polygon", a)
+	case *Polyline:
+		var a attrs
+		a.str("points", points(v.Points))
+		w.empty("polyline", a)
+	case *Text:
+		w.text(v)
+	}
+	w.end("object")
+}
+
+func (w *mapWriter) text(t *Text) {
+	var a attrs
+	if len(t.FontFamily) > 0 {
+		a.str("fontfamily", t.FontFamily)
+	}
+	if t.PixelSize != 16 {
+		a.int("pixelsize", t.PixelSize)
+	}
+	if t.Wrap {
+		a.int("wrap", 1)
+	}
+	if !isBlack(t.Color) {
+		a.str("color", rgbaToHex(t.Color))
+	}
+	if t.Bold {
+		a.int("bold", 1)
+	}
+	if t.Italic {
+		a.int("italic", 1)
+	}
+	if t.Underline {
+		a.int("underline", 1)
+	}
+	if t.Strikeout {
+		a.int("strikeout", 1)
+	}
+	if !t.Kerning {
+		a.int("kerning", 0)
+	}
+	if len(t.HAlign) > 0 && t.HAlign != "left" {
+		a.str("halign", t.HAlign)
+	}
+	if len(t.VAlign) > 0 && t.VAlign != "top" {
+		a.str("valign", t.VAlign)
+	}
+	w.start("text", a)
+	w.token(xml.CharData(t.Text))
+	w.end("text")
+}
+
+func (w *mapWriter) imageLayer(l *ImageLayer) {
+	var a attrs
+	a.str("name", l.Name)
+	a.class(l.Class)
+	a.common(l.Opacity, l.Visible, l.OffsetX, l.OffsetY, l.ParallaxX, l.ParallaxY)
+	if l.RepeatX {
+		a.int("repeatx", 1)
+	}
+	if l.RepeatY {
+		a.int("repeaty", 1)
+	}
+	w.start("imagelayer", a)
+	w.properties(l.Properties)
+	w.image(l.Image)
+	w.end("imagelayer")
+}
+
+// groupLayer starts the element of the given group layer, which the caller
+// must end after writing the layers within it.
+func (w *mapWriter) groupLayer(g *GroupLayer) {
+	var a attrs
+	a.str("name", g.Name)
+	a.class(g.Class)
+	a.common(g.Opacity, g.Visible, g.OffsetX, g.OffsetY, g.ParallaxX, g.ParallaxY)
+	w.start("group", a)
+	w.properties(g.Properties)
+}
@@ -0,0 +1,181 @@
+// Copyright 2014 Lightpoke. All rights reserved.
+// This source code is subject to the terms and
+// conditions defined in the "License.txt" file.
+
+package tmx
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func parseFile(t *testing.T, name string) *Map {
+	data, err := ioutil.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := Parse(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+// roundTrip writes the map with the given configuration and parses it again.
+func roundTrip(t *testing.T, m *Map, c *WriteConfig) *Map {
+	data, err := Marshal(m, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m2, err := Parse(data)
+	if err != nil {
+		t.Log(string(data))
+		t.Fatal(err)
+	}
+	return m2
+}
+
+func compareMaps(t *testing.T, a, b *Map) {
+	if a.String() != b.String() {
+		t.Fatal("map mismatch", a, b)
+	}
+	if !reflect.DeepEqual(a.Properties, b.Properties) {
+		t.Fatal("map properties mismatch", a.Properties, b.Properties)
+	}
+	if len(a.Tilesets) != len(b.Tilesets) {
+		t.Fatal("tileset count mismatch")
+	}
+	for i, ts := range a.Tilesets {
+		if ts.String() != b.Tilesets[i].String() {
+			t.Fatal("tileset mismatch", ts, b.Tilesets[i])
+		}
+	}
+	if len(a.Layers) != len(b.Layers) {
+		t.Fatal("layer count mismatch")
+	}
+	for i, l := range a.Layers {
+		l2 := b.Layers[i]
+		if l.String() != l2.String() || l.Index != l2.Index {
+			t.Fatal("layer mismatch", l, l2)
+		}
+		if !reflect.DeepEqual(l.Tiles, l2.Tiles) {
+			t.Fatal("layer tiles mismatch", l.Name)
+		}
+	}
+	if len(a.ObjectGroups) != len(b.ObjectGroups) {
+		t.Fatal("object group count mismatch")
+	}
+	for i, g := range a.ObjectGroups {
+		g2 := b.ObjectGroups[i]
+		if g.String() != g2.String() {
+			t.Fatal("object group mismatch", g, g2)
+		}
+		for j, o := range g.Objects {
+			if !reflect.DeepEqual(o, g2.Objects[j]) {
+				t.Fatal("object mismatch", o, g2.Objects[j])
+			}
+		}
+	}
+}
+
+func TestWriteRoundTrip(t *testing.T) {
+	for _, name := range []string{"test_xml.tmx", "test_csv.tmx", "test_csv_tsx.tmx", "test_base64.tmx", "test_base64_gzip.tmx", "test_base64_zlib.tmx", "test_objects.tmx", "test_infinite.tmx"} {
+		m := parseFile(t, name)
+		compareMaps(t, m, roundTrip(t, m, nil))
+	}
+}
+
+func TestWriteEncodings(t *testing.T) {
+	m := parseFile(t, "test_csv.tmx")
+	for _, c := range []WriteConfig{
+		{Encoding: "csv"},
+		{Encoding: "base64"},
+		{Encoding: "base64", Compression: "zlib"},
+		{Encoding: "base64", Compression: "gzip"},
+	} {
+		m2 := roundTrip(t, m, &c)
+		compareMaps(t, m, m2)
+		for _, l := range m2.Layers {
+			if l.Encoding != c.Encoding || l.Compression != c.Compression {
+				t.Fatal("incorrect encoding", l.Encoding, l.Compression)
+			}
+		}
+	}
+}
+
+func TestWriteCompressionLevel(t *testing.T) {
+	m := parseFile(t, "test_base64_zlib.tmx")
+	if m.CompressionLevel != nil {
+		t.Fatal("expected default compression level, got", *m.CompressionLevel)
+	}
+
+	// The zero value of the map's compression level is the default level,
+	// not level zero.
+	def, err := Marshal(m, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	explicit := -1
+	if data, err := Marshal(m, &WriteConfig{CompressionLevel: &explicit}); err != nil || !bytes.Equal(data, def) {
+		t.Fatal("nil compression level is not the default level", err)
+	}
+	if bytes.Contains(def, []byte("compressionlevel")) {
+		t.Fatal("unexpected compressionlevel attribute")
+	}
+
+	store := 0
+	m.CompressionLevel = &store
+	fast, err := Marshal(m, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	best := 9
+	small, err := Marshal(m, &WriteConfig{CompressionLevel: &best})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(small) >= len(fast) {
+		t.Fatal("expected best compression to be smaller", len(small), len(fast))
+	}
+	if !bytes.Contains(fast, []byte(`compressionlevel="0"`)) {
+		t.Fatal("expected compressionlevel attribute")
+	}
+	m2, err := Parse(fast)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m2.CompressionLevel == nil || *m2.CompressionLevel != 0 {
+		t.Fatal("incorrect compression level", m2.CompressionLevel)
+	}
+	compareMaps(t, m, m2)
+}
+
+func TestWriteLayerEncoding(t *testing.T) {
+	m := parseFile(t, "test_csv.tmx")
+	m.Layers = append(m.Layers, &Layer{
+		Name:       "tiny",
+		Index:      len(m.Layers) + len(m.ObjectGroups) + len(m.ImageLayers),
+		Tiles:      map[Coord]uint32{{0, 0}: 1},
+		Opacity:    1,
+		Visible:    true,
+		ParallaxX:  1,
+		ParallaxY:  1,
+		Properties: map[string]string{},
+	})
+	m2 := roundTrip(t, m, &WriteConfig{
+		Encoding:      "base64",
+		LayerEncoding: EncodingBySize(1),
+	})
+	for _, l := range m2.Layers {
+		encoding, compression := Base64Encoding, ZlibCompression
+		if l.Name == "tiny" {
+			encoding, compression = CSVEncoding, NoCompression
+		}
+		if l.Encoding != encoding || l.Compression != compression {
+			t.Fatal("incorrect encoding", l.Name, l.Encoding, l.Compression)
+		}
+	}
+}
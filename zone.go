@@ -0,0 +1,127 @@
+// Copyright 2014 Lightpoke. All rights reserved.
+// This source code is subject to the terms and
+// conditions defined in the "License.txt" file.
+
+package tmx
+
+import "sort"
+
+// ZoneEvent is an event of an entity entering or leaving a zone, see
+// Zones.Update.
+type ZoneEvent struct {
+	// The entity, as identified in the positions given to Zones.Update.
+	Entity int
+
+	// The object group and object of the zone.
+	Group  *ObjectGroup
+	Object *Object
+
+	// Whether the entity entered (true) or left (false) the zone.
+	Enter bool
+}
+
+// Zones tracks entities (like the player, or enemies) entering and leaving the
+// trigger or sensor zones of a map, such that game logic may react to them.
+//
+// Zones are objects of the map's object groups, the areas within their
+// outlines (see ObjectGroup.Contains). Polylines and point objects thus never
+// contain any entities.
+//
+// A Zones is not safe for use by multiple goroutines at once.
+type Zones struct {
+	zones  []zone
+	inside map[zoneEntity]bool
+}
+
+// zone is a single zone of a Zones.
+type zone struct {
+	group *ObjectGroup
+	obj   *Object
+}
+
+// zoneEntity identifies an entity within a zone.
+type zoneEntity struct {
+	zone, entity int
+}
+
+// NewZones returns a new set of zones for the visible objects of the given
+// map for which the match function returns true. If match is nil then objects
+// whose class is "trigger" are zones. No entities are within any zone until
+// Update is called.
+func NewZones(m *Map, match func(g *ObjectGroup, o *Object) bool) *Zones {
+	if match == nil {
+		match = func(g *ObjectGroup, o *Object) bool {
+			return o.Class == "trigger"
+		}
+	}
+	z := &Zones{
+		inside: make(map[zoneEntity]bool),
+	}
+	for _, g := range m.ObjectGroups {
+		for _, o := range g.Objects {
+			if o.Visible && match(g, o) {
+				z.zones = append(z.zones, zone{g, o})
+			}
+		}
+	}
+	return z
+}
+
+// Update updates the zones that each entity is within, given the current
+// positions (in pixels, where +Y is down) of the entities by their identifier,
+// and returns the resulting events. Entities that are not given (for instance
+// because they were removed) leave all of their zones.
+//
+// Events are ordered by the zones, in the order of their objects in the map,
+// and then by entity.
+func (z *Zones) Update(positions map[int]Pixel) []ZoneEvent {
+	entities := make([]int, 0, len(positions))
+	for e := range positions {
+		entities = append(entities, e)
+	}
+	for k := range z.inside {
+		if _, ok := positions[k.entity]; !ok {
+			entities = append(entities, k.entity)
+		}
+	}
+	sort.Ints(entities)
+
+	var events []ZoneEvent
+	for i, zn := range z.zones {
+		for j, e := range entities {
+			if j > 0 && entities[j-1] == e {
+				continue
+			}
+			k := zoneEntity{i, e}
+			p, ok := positions[e]
+			in := ok && zn.group.Contains(zn.obj, p)
+			if in == z.inside[k] {
+				continue
+			}
+			if in {
+				z.inside[k] = true
+			} else {
+				delete(z.inside, k)
+			}
+			events = append(events, ZoneEvent{
+				Entity: e,
+				Group:  zn.group,
+				Object: zn.obj,
+				Enter:  in,
+			})
+		}
+	}
+	return events
+}
+
+// Inside returns the objects of the zones that the given entity is currently
+// within, in the order of the map.
+func (z *Zones) Inside(entity int) []*Object {
+	var objects []*Object
+	for i, zn := range z.zones {
+		if z.inside[zoneEntity{i, entity}] {
+			objects = append(objects, zn.obj)
+		}
+	}
+	return objects
+}